@@ -17,7 +17,6 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"regexp"
@@ -44,7 +43,7 @@ var auditCmd = &cobra.Command{
 		}
 		repo := args[0]
 		ref := args[1]
-		ctx := context.Background()
+		ctx := commandContext(cmd.Context())
 
 		rs := git.NewRepoSpec(repo, nil)
 
@@ -65,7 +64,12 @@ var auditCmd = &cobra.Command{
 				},
 			},
 		}
-		differ := diff.NewDiffer(rs, rs, epds)
+		crdSchemaDir, err := cmd.Flags().GetString("crd-schemas")
+		if err != nil {
+			return fmt.Errorf("unable to get crd schema directory - %w", err)
+		}
+
+		differ := diff.NewDiffer(rs, rs, epds).WithCrdSchemaDir(crdSchemaDir).WithPipeline(pipelineOptions())
 		diff, err := differ.Extract(ctx, auditRef)
 		if err != nil {
 			fmt.Printf("Got errors diffing resources:\n\n%s\n", err.Error())
@@ -73,6 +77,9 @@ var auditCmd = &cobra.Command{
 
 		for _, ep := range diff {
 			fmt.Printf("Entrypoint %q was changed:\n", ep.Entrypoint.Directory)
+			for _, epErr := range ep.Errors {
+				fmt.Printf("	%s\n", describeEntrypointError(epErr))
+			}
 			for _, res := range ep.Diff {
 				fmt.Printf("Detected changes in resource %s\n", res.String())
 				if res.Type == resource.DiffTypeCreate {
@@ -91,9 +98,28 @@ var auditCmd = &cobra.Command{
 						}
 					}
 				}
+				if len(res.ReplacedBy) > 0 {
+					fmt.Printf("	Resource will be replaced because of changes to %s\n", strings.Join(res.ReplacedBy, ", "))
+				}
+				if res.Breaking {
+					fmt.Printf("	Breaking change for dependents outside this entrypoint\n")
+				}
+				for _, note := range res.Notes {
+					fmt.Printf("	Note: %s\n", note)
+				}
 				fmt.Printf("\n")
 			}
 
+			for _, res := range ep.All {
+				if kr, ok := res.(*resource.KubernetesResource); ok && len(kr.SchemaErrors) > 0 {
+					fmt.Printf("Resource %s does not match its CustomResourceDefinition:\n", kr.Identifier())
+					for _, schemaErr := range kr.SchemaErrors {
+						fmt.Printf("	%s\n", schemaErr)
+					}
+					fmt.Printf("\n")
+				}
+			}
+
 			fmt.Print("\n\n")
 		}
 
@@ -138,4 +164,6 @@ var auditCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(auditCmd)
+
+	auditCmd.Flags().String("crd-schemas", "", "Directory of extra CustomResourceDefinitions and OpenAPI schemas used to diff and validate custom resources")
 }
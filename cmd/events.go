@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/codingninja/gitops-repo-api/events"
+	"golang.org/x/exp/slog"
+)
+
+// slogSink logs events, warnings and failures are logged at warn and routine progress at info or debug
+type slogSink struct {
+	logger *slog.Logger
+}
+
+func (ss *slogSink) Emit(e events.Event) {
+	level := slog.LevelInfo
+	switch e.Type {
+	case events.Warning:
+		level = slog.LevelWarn
+	case events.EntrypointDiscovered, events.RenderStarted, events.ResourceDiffed, events.CheckoutStarted:
+		level = slog.LevelDebug
+	}
+	if e.Error != "" {
+		level = slog.LevelWarn
+	}
+
+	attrs := []slog.Attr{}
+	for _, attr := range []struct{ key, value string }{
+		{"revision", e.Revision},
+		{"reference", e.Reference},
+		{"entrypoint", e.Entrypoint},
+		{"entrypointType", e.EntrypointType},
+		{"resource", e.Resource},
+		{"diffType", e.DiffType},
+		{"file", e.File},
+		{"error", e.Error},
+	} {
+		if attr.value != "" {
+			attrs = append(attrs, slog.String(attr.key, attr.value))
+		}
+	}
+	if e.Duration > 0 {
+		attrs = append(attrs, slog.Duration("duration", e.Duration))
+	}
+
+	msg := string(e.Type)
+	if e.Message != "" {
+		msg = e.Message
+	}
+	ss.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// progressBarWidth is how many characters the bar itself takes
+const progressBarWidth = 30
+
+// progressSink draws a bar of the entrypoints which have been diffed, redrawing it in place on w. Anything else
+// written to w while the bar is drawn should be written through the sink, so the bar is moved below it.
+type progressSink struct {
+	lock       sync.Mutex
+	w          io.Writer
+	drawn      bool
+	discovered int
+	finished   int
+	failed     int
+}
+
+func (ps *progressSink) Emit(e events.Event) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	switch e.Type {
+	case events.EntrypointDiscovered:
+		ps.discovered++
+	case events.RenderFinished:
+		ps.finished++
+		if e.Error != "" {
+			ps.failed++
+		}
+	default:
+		return
+	}
+
+	ps.draw()
+	if ps.finished == ps.discovered {
+		fmt.Fprintln(ps.w)
+		ps.drawn = false
+	}
+}
+
+// Write clears the bar, writes p and draws the bar again below it
+func (ps *progressSink) Write(p []byte) (int, error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if !ps.drawn {
+		return ps.w.Write(p)
+	}
+	fmt.Fprint(ps.w, "\r\x1b[K")
+	n, err := ps.w.Write(p)
+	ps.draw()
+	return n, err
+}
+
+func (ps *progressSink) draw() {
+	done := 0
+	if ps.discovered > 0 {
+		done = progressBarWidth * ps.finished / ps.discovered
+	}
+	bar := strings.Repeat("=", done) + strings.Repeat(" ", progressBarWidth-done)
+	fmt.Fprintf(ps.w, "\r[%s] %d/%d entrypoints diffed", bar, ps.finished, ps.discovered)
+	if ps.failed > 0 {
+		fmt.Fprintf(ps.w, ", %d failed", ps.failed)
+	}
+	ps.drawn = true
+}
+
+// multiSink sends each event to every sink
+type multiSink []events.Sink
+
+func (ms multiSink) Emit(e events.Event) {
+	for _, sink := range ms {
+		sink.Emit(e)
+	}
+}
@@ -17,11 +17,21 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
-
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/codingninja/gitops-repo-api/diff"
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/events"
+	"github.com/codingninja/gitops-repo-api/resource"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/exp/slog"
 )
 
 var cfgFile string
@@ -39,20 +49,40 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	// Interrupting cancels the renders in progress rather than waiting for them
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, registerPlugins)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gitops-repo-api.yaml)")
+	rootCmd.PersistentFlags().Bool("no-redact", false, "show secret values in diffs, only for trusted local use")
+	rootCmd.PersistentFlags().StringSlice("redact", nil, "extra JSONPaths to redact in every resource")
+	rootCmd.PersistentFlags().String("redact-salt", "", "salt for redacted value hashes (default is random per run)")
+	rootCmd.PersistentFlags().String("terraform-binary", "", "terraform binary used to plan (default is to download terraform)")
+	rootCmd.PersistentFlags().String("terraform-provider-mirror", "", "filesystem mirror terraform providers are installed from")
+	rootCmd.PersistentFlags().String("sops-age-key-file", "", "age key file SOPS encrypted files are decrypted with (default is the key sops finds)")
+	rootCmd.PersistentFlags().StringToString("plugin", nil, "renderer plugins as entrypoint type=executable")
+	rootCmd.PersistentFlags().String("render-cache", "", "directory rendered entrypoints are cached in (default is the user cache directory)")
+	rootCmd.PersistentFlags().Bool("no-render-cache", false, "render every entrypoint even when its inputs were rendered before")
+	rootCmd.PersistentFlags().Int("parallelism", 0, "most entrypoints diffed at once (default is the number of CPUs)")
+	rootCmd.PersistentFlags().StringToString("type-parallelism", nil, "most entrypoints of a type diffed at once as entrypoint type=count")
+	rootCmd.PersistentFlags().Duration("entrypoint-timeout", 0, "cancel rendering an entrypoint after this long (default is no timeout)")
+	rootCmd.PersistentFlags().String("log-level", "warn", "level of progress logged to stderr, one of debug, info, warn or error")
+	rootCmd.PersistentFlags().Bool("progress", false, "draw a progress bar of the entrypoints diffed on stderr")
+	for _, flag := range []string{"no-redact", "redact", "redact-salt", "terraform-binary", "terraform-provider-mirror", "sops-age-key-file", "plugin", "render-cache", "no-render-cache", "parallelism", "type-parallelism", "entrypoint-timeout", "log-level", "progress"} {
+		cobra.CheckErr(viper.BindPFlag(flag, rootCmd.PersistentFlags().Lookup(flag)))
+	}
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -82,3 +112,110 @@ func initConfig() {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+// registerPlugins makes the renderer plugins from the flags, or the config file, available as entrypoint types
+func registerPlugins() {
+	for epType, executable := range viper.GetStringMapString("plugin") {
+		resource.RegisterDiffer(entrypoint.EntrypointType(epType), resource.NewPluginDiffer(executable))
+		entrypoint.RegisterEntrypointType(entrypoint.EntrypointType(epType), func(epPath string) bool {
+			_, err := os.Stat(epPath)
+			return err == nil
+		})
+	}
+}
+
+// redactionOptions reads the redaction flags, which may also be set in the config file
+func redactionOptions() resource.RedactionOptions {
+	return resource.RedactionOptions{
+		Disabled: viper.GetBool("no-redact"),
+		Salt:     viper.GetString("redact-salt"),
+		Paths:    viper.GetStringSlice("redact"),
+	}
+}
+
+// terraformOptions reads the terraform flags, which may also be set in the config file
+func terraformOptions() resource.TerraformOptions {
+	return resource.TerraformOptions{
+		ExecPath:       viper.GetString("terraform-binary"),
+		ProviderMirror: viper.GetString("terraform-provider-mirror"),
+	}
+}
+
+// sopsOptions reads the SOPS flags, which may also be set in the config file
+func sopsOptions() resource.SopsOptions {
+	return resource.SopsOptions{
+		AgeKeyFile: viper.GetString("sops-age-key-file"),
+	}
+}
+
+// renderCacheOptions reads the render cache flags, which may also be set in the config file
+func renderCacheOptions() resource.RenderCacheOptions {
+	if viper.GetBool("no-render-cache") {
+		return resource.RenderCacheOptions{}
+	}
+	dir := viper.GetString("render-cache")
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return resource.RenderCacheOptions{}
+		}
+		dir = filepath.Join(cacheDir, "gitops-repo-api", "render")
+	}
+	return resource.RenderCacheOptions{Dir: dir}
+}
+
+// pipelineOptions reads the flags which bound how entrypoints are diffed, which may also be set in the config file
+func pipelineOptions() diff.PipelineOptions {
+	typeParallelism := map[entrypoint.EntrypointType]int{}
+	for epType, n := range viper.GetStringMapString("type-parallelism") {
+		limit, err := strconv.Atoi(n)
+		if err != nil {
+			cobra.CheckErr(fmt.Errorf("invalid parallelism %q for entrypoint type %q - %w", n, epType, err))
+		}
+		typeParallelism[entrypoint.EntrypointType(epType)] = limit
+	}
+	return diff.PipelineOptions{
+		Parallelism:     viper.GetInt("parallelism"),
+		TypeParallelism: typeParallelism,
+		Timeout:         viper.GetDuration("entrypoint-timeout"),
+	}
+}
+
+// eventSink logs the progress of a command at the configured level, and draws a progress bar if it's enabled
+func eventSink() events.Sink {
+	level := slog.LevelWarn
+	if err := level.UnmarshalText([]byte(viper.GetString("log-level"))); err != nil {
+		cobra.CheckErr(fmt.Errorf("invalid log level %q - %w", viper.GetString("log-level"), err))
+	}
+	if !viper.GetBool("progress") {
+		return &slogSink{logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))}
+	}
+	progress := &progressSink{w: os.Stderr}
+	return multiSink{
+		&slogSink{logger: slog.New(slog.NewTextHandler(progress, &slog.HandlerOptions{Level: level}))},
+		progress,
+	}
+}
+
+// commandContext carries the options every command which diffs entrypoints is run with, it is cancelled when
+// the command is interrupted
+func commandContext(ctx context.Context) context.Context {
+	ctx = events.WithSink(ctx, eventSink())
+	ctx = resource.WithRedaction(ctx, redactionOptions())
+	ctx = resource.WithSops(ctx, sopsOptions())
+	ctx = resource.WithRenderCache(ctx, renderCacheOptions())
+	return resource.WithTerraform(ctx, terraformOptions())
+}
+
+// describeEntrypointError says which revision of an entrypoint failed, how and where
+func describeEntrypointError(epErr *resource.EntrypointError) string {
+	failed := "Entrypoint failed"
+	if epErr.Revision != "" {
+		failed = fmt.Sprintf("The %s revision failed", epErr.Revision)
+	}
+	description := fmt.Sprintf("%s (%s) - %s", failed, epErr.Kind, epErr.Message)
+	if epErr.File != "" {
+		description += fmt.Sprintf(" at %s:%d", epErr.File, epErr.Line)
+	}
+	return description
+}
@@ -17,7 +17,6 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"regexp"
@@ -45,7 +44,7 @@ var updateCmd = &cobra.Command{
 		repo := args[0]
 		from := args[1]
 		to := args[2]
-		ctx := context.Background()
+		ctx := commandContext(cmd.Context())
 
 		rs := git.NewRepoSpec(repo, nil)
 
@@ -65,7 +64,7 @@ var updateCmd = &cobra.Command{
 				},
 			},
 		}
-		differ := diff.NewDiffer(rs, rs, epds)
+		differ := diff.NewDiffer(rs, rs, epds).WithPipeline(pipelineOptions())
 		diff, err := differ.Diff(ctx, preRef, postRef)
 		if err != nil {
 			fmt.Printf("Got errors diffing resources:\n\n%s\n", err.Error())
@@ -73,6 +72,9 @@ var updateCmd = &cobra.Command{
 
 		for _, ep := range diff {
 			fmt.Printf("Entrypoint %q was changed:\n", ep.Entrypoint.Directory)
+			for _, epErr := range ep.Errors {
+				fmt.Printf("	%s\n", describeEntrypointError(epErr))
+			}
 			for _, res := range ep.Diff {
 				fmt.Printf("Detected changes in resource %s\n", res.String())
 				if res.Type == resource.DiffTypeCreate {
@@ -91,6 +93,15 @@ var updateCmd = &cobra.Command{
 						}
 					}
 				}
+				if len(res.ReplacedBy) > 0 {
+					fmt.Printf("	Resource will be replaced because of changes to %s\n", strings.Join(res.ReplacedBy, ", "))
+				}
+				if res.Breaking {
+					fmt.Printf("	Breaking change for dependents outside this entrypoint\n")
+				}
+				for _, note := range res.Notes {
+					fmt.Printf("	Note: %s\n", note)
+				}
 				fmt.Printf("\n")
 			}
 
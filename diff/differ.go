@@ -2,12 +2,13 @@ package diff
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"os"
 	"path"
-	"sync"
+	"time"
 
 	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/events"
 	"github.com/codingninja/gitops-repo-api/git"
 	"github.com/codingninja/gitops-repo-api/resource"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -22,67 +23,60 @@ func NewDiffer(preRs *git.RepoSpec, postRs *git.RepoSpec, epds []entrypoint.Entr
 }
 
 type repoDiffer struct {
-	preRs  *git.RepoSpec
-	postRs *git.RepoSpec
-	epds   []entrypoint.EntrypointFactory
+	preRs        *git.RepoSpec
+	postRs       *git.RepoSpec
+	epds         []entrypoint.EntrypointFactory
+	crdSchemaDir string
+	pipeline     PipelineOptions
+}
+
+// WithCrdSchemaDir loads additional CustomResourceDefinitions and OpenAPI schemas from dir, for custom
+// resources whose definitions aren't rendered by any entrypoint in the repository
+func (rd *repoDiffer) WithCrdSchemaDir(dir string) *repoDiffer {
+	rd.crdSchemaDir = dir
+	return rd
 }
 
 type EntrypointDiff struct {
-	Entrypoint entrypoint.Entrypoint   `json:"entrypoint"`
-	Error      error                   `json:"error"`
-	Diff       []resource.ResourceDiff `json:"diff"`
-	All        []resource.Resource     `json:"all"`
+	Entrypoint entrypoint.Entrypoint `json:"entrypoint"`
+	// Errors are why the entrypoint, or one revision of it, couldn't be rendered or diffed. An entrypoint is only
+	// diffed when both revisions rendered, one which doesn't exist in a revision is diffed against nothing.
+	Errors []*resource.EntrypointError `json:"errors,omitempty"`
+	Diff   []resource.ResourceDiff     `json:"diff"`
+	All    []resource.Resource         `json:"all"`
 }
 
 // Diff will return either an EntrypointDiff, or an Error for every Entrypoint that is discovered in the
-// pre
+// pre. The returned error is an *resource.EntrypointError when nothing could be diffed, failures of single
+// entrypoints are in their EntrypointDiff.
 func (rd *repoDiffer) Extract(ctx context.Context, ref plumbing.ReferenceName) ([]EntrypointDiff, error) {
-	_, dir, err := rd.preRs.Checkout(ctx, ref)
+	dir, err := checkout(ctx, rd.preRs, ref, resource.RevisionPre)
 	if err != nil {
-		return nil, fmt.Errorf("unable to pre change dir - %w", err)
+		return nil, revisionError(resource.ErrorKindCheckout, resource.RevisionPre, fmt.Errorf("unable to pre change dir - %w", err))
 	}
 
 	eps, err := discoverEntrypoints(ctx, "", dir, rd.epds)
 	if err != nil {
-		return nil, err
-	}
-	var errs error
-	allDiff := []EntrypointDiff{}
-	wg := sync.WaitGroup{}
-	for _, ep := range eps {
-		ep := ep
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-
-			diff, all, _, err := rd.diffEntrypoint(ctx, ep.ep, "", dir)
-			if err != nil {
-				errs = errors.Join(errs, err)
-			}
-
-			allDiff = append(allDiff, EntrypointDiff{
-				Entrypoint: ep.ep,
-				Diff:       diff,
-				Error:      err,
-				All:        all,
-			})
-		}()
+		return nil, resource.NewEntrypointError(resource.ErrorKindDiscovery, err)
 	}
+	allDiff, allPre, allPost := rd.diffEntrypoints(ctx, eps, "", dir)
 
-	wg.Wait()
+	errs := rd.applyCrdSchemas(ctx, allDiff, allPre, allPost)
+	finishEntrypoints(ctx, allDiff, allPost)
 
 	return allDiff, errs
 }
 
+// Diff returns an EntrypointDiff for every entrypoint discovered in either revision, like Extract
 func (rd *repoDiffer) Diff(ctx context.Context, pre, post plumbing.ReferenceName) ([]EntrypointDiff, error) {
-	_, preDir, err := rd.preRs.Checkout(ctx, pre)
+	preDir, err := checkout(ctx, rd.preRs, pre, resource.RevisionPre)
 	if err != nil {
-		return nil, fmt.Errorf("unable to pre change dir - %w", err)
+		return nil, revisionError(resource.ErrorKindCheckout, resource.RevisionPre, fmt.Errorf("unable to pre change dir - %w", err))
 	}
 
-	_, postDir, err := rd.postRs.Checkout(ctx, post)
+	postDir, err := checkout(ctx, rd.postRs, post, resource.RevisionPost)
 	if err != nil {
-		return nil, fmt.Errorf("unable to checkout post change dir - %w", err)
+		return nil, revisionError(resource.ErrorKindCheckout, resource.RevisionPost, fmt.Errorf("unable to checkout post change dir - %w", err))
 	}
 
 	defer func() {
@@ -100,37 +94,49 @@ func (rd *repoDiffer) Diff(ctx context.Context, pre, post plumbing.ReferenceName
 
 	eps, err := discoverEntrypoints(ctx, preDir, postDir, rd.epds)
 	if err != nil {
-		return nil, err
-	}
-
-	var errs error
-	allDiff := []EntrypointDiff{}
-	wg := sync.WaitGroup{}
-	for _, ep := range eps {
-		ep := ep
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-
-			diff, _, post, err := rd.diffEntrypoint(ctx, ep.ep, preDir, postDir)
-			if err != nil {
-				errs = errors.Join(errs, err)
-			}
-
-			allDiff = append(allDiff, EntrypointDiff{
-				Entrypoint: ep.ep,
-				Diff:       diff,
-				Error:      err,
-				All:        post,
-			})
-		}()
+		return nil, resource.NewEntrypointError(resource.ErrorKindDiscovery, err)
 	}
 
-	wg.Wait()
+	allDiff, allPre, allPost := rd.diffEntrypoints(ctx, eps, preDir, postDir)
+
+	errs := rd.applyCrdSchemas(ctx, allDiff, allPre, allPost)
+	finishEntrypoints(ctx, allDiff, allPost)
 
 	return allDiff, errs
 }
 
+// checkout checks out ref, reporting how long it took
+func checkout(ctx context.Context, rs *git.RepoSpec, ref plumbing.ReferenceName, rev resource.Revision) (string, error) {
+	events.Emit(ctx, events.Event{Type: events.CheckoutStarted, Revision: string(rev), Reference: ref.String()})
+	start := time.Now()
+	_, dir, err := rs.Checkout(ctx, ref)
+	finished := events.Event{Type: events.CheckoutFinished, Revision: string(rev), Reference: ref.String(), Duration: time.Since(start)}
+	if err != nil {
+		finished.Error = err.Error()
+	}
+	events.Emit(ctx, finished)
+	return dir, err
+}
+
+// finishEntrypoints sets the resources reported for each entrypoint, sorting them and the changes so they're in
+// the same order every run, and reports each changed resource
+func finishEntrypoints(ctx context.Context, diffs []EntrypointDiff, all [][]resource.Resource) {
+	for i := range diffs {
+		sortDiff(diffs[i].Diff)
+		sortResources(all[i])
+		diffs[i].All = all[i]
+		for j := range diffs[i].Diff {
+			events.Emit(ctx, events.Event{
+				Type:           events.ResourceDiffed,
+				Entrypoint:     diffs[i].Entrypoint.Name,
+				EntrypointType: string(diffs[i].Entrypoint.Type),
+				Resource:       diffs[i].Diff[j].Identifier(),
+				DiffType:       string(diffs[i].Diff[j].Type),
+			})
+		}
+	}
+}
+
 type internalentrypoint struct {
 	t      string
 	ep     entrypoint.Entrypoint
@@ -142,7 +148,7 @@ func discoverEntrypoints(ctx context.Context, preDir, postDir string, epds []ent
 	// This should be re-implemented to use channels
 	var preEps []entrypoint.Entrypoint
 	if preDir != "" {
-		preEpss, err := entrypoint.DiscoverEntrypoints(preDir, epds)
+		preEpss, err := entrypoint.DiscoverEntrypoints(ctx, preDir, epds)
 		if err != nil {
 			return nil, err
 		}
@@ -151,26 +157,38 @@ func discoverEntrypoints(ctx context.Context, preDir, postDir string, epds []ent
 	}
 	var postEps []entrypoint.Entrypoint
 	if postDir != "" {
-		postEpss, err := entrypoint.DiscoverEntrypoints(postDir, epds)
+		postEpss, err := entrypoint.DiscoverEntrypoints(ctx, postDir, epds)
 		if err != nil {
 			return nil, err
 		}
 
 		postEps = postEpss
 	}
-	eps := map[string]bool{}
+	// Several entrypoints may share a directory, such as one per environment, so they're told apart by name too
+	type entrypointKey struct{ directory, name string }
+	eps := map[entrypointKey]bool{}
 	eplist := []internalentrypoint{}
 	for _, ep := range preEps {
-		eps[ep.Directory] = true
+		eps[entrypointKey{ep.Directory, ep.Name}] = true
 		eplist = append(eplist, internalentrypoint{t: "existing", ep: ep})
 	}
 	for _, ep := range postEps {
-		if _, ok := eps[ep.Directory]; ok {
+		key := entrypointKey{ep.Directory, ep.Name}
+		if _, ok := eps[key]; ok {
 			continue
 		}
-		eps[ep.Directory] = true
+		eps[key] = true
 		eplist = append(eplist, internalentrypoint{t: "new", ep: ep})
 	}
+	sortEntrypoints(eplist)
+	for _, iep := range eplist {
+		events.Emit(ctx, events.Event{
+			Type:           events.EntrypointDiscovered,
+			Entrypoint:     iep.ep.Name,
+			EntrypointType: string(iep.ep.Type),
+			File:           iep.ep.Directory,
+		})
+	}
 
 	return eplist, nil
 }
@@ -180,12 +198,8 @@ func (rd *repoDiffer) diffEntrypoint(ctx context.Context, ep entrypoint.Entrypoi
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("unable to get differ for entrypoint - %w", err)
 	}
-	if preDir != "" {
-		preDir = path.Join(preDir, ep.Directory)
-	}
-	if postDir != "" {
-		postDir = path.Join(postDir, ep.Directory)
-	}
+	preDir = revisionDir(preDir, ep)
+	postDir = revisionDir(postDir, ep)
 
 	diff, pre, post, err := differ.Diff(ctx, rd.preRs, ep, preDir, postDir)
 	if err != nil {
@@ -194,3 +208,65 @@ func (rd *repoDiffer) diffEntrypoint(ctx context.Context, ep entrypoint.Entrypoi
 
 	return diff, pre, post, nil
 }
+
+// revisionDir is where ep is in a revision checked out to dir, it's empty when the revision doesn't have the
+// entrypoint so that it's diffed as added or removed
+func revisionDir(dir string, ep entrypoint.Entrypoint) string {
+	if dir == "" {
+		return ""
+	}
+	epDir := path.Join(dir, ep.Directory)
+	if _, err := os.Stat(epDir); err != nil {
+		return ""
+	}
+	return epDir
+}
+
+// applyCrdSchemas diffs the kubernetes entrypoints again once the CustomResourceDefinitions rendered by every
+// entrypoint are known, so custom resources are diffed and validated against the schema from their own revision.
+// The pre and post resources of each entrypoint are replaced with the validated resources. Only a failure to load
+// the schemas is returned, failures to diff an entrypoint are added to its errors.
+func (rd *repoDiffer) applyCrdSchemas(ctx context.Context, diffs []EntrypointDiff, pre, post [][]resource.Resource) error {
+	flatPre := []resource.Resource{}
+	flatPost := []resource.Resource{}
+	for i := range diffs {
+		flatPre = append(flatPre, pre[i]...)
+		flatPost = append(flatPost, post[i]...)
+	}
+
+	schemas, errs := resource.CollectCrdSchemas(flatPre, flatPost, rd.crdSchemaDir)
+	if errs != nil {
+		errs = resource.NewEntrypointError(resource.ErrorKindDiff, errs)
+	}
+	if schemas == nil {
+		return errs
+	}
+
+	ctx = resource.WithCrdSchemas(ctx, schemas)
+	for i := range diffs {
+		// Neither revision rendered, or the differ failed
+		if pre[i] == nil && post[i] == nil {
+			continue
+		}
+		diff, newPre, newPost, ok, err := resource.RediffKubernetes(ctx, rd.preRs, diffs[i].Entrypoint, pre[i], post[i])
+		if !ok {
+			continue
+		}
+		if err != nil {
+			epErr := resource.NewEntrypointError(resource.ErrorKindDiff, fmt.Errorf("unable to diff entrypoint %q with crd schemas - %w", diffs[i].Entrypoint.Name, err))
+			epErr.Entrypoint = diffs[i].Entrypoint.Name
+			diffs[i].Errors = append(diffs[i].Errors, epErr)
+			continue
+		}
+		diffs[i].Diff = diff
+		pre[i], post[i] = newPre, newPost
+	}
+
+	return errs
+}
+
+func revisionError(kind resource.ErrorKind, rev resource.Revision, err error) *resource.EntrypointError {
+	epErr := resource.NewEntrypointError(kind, err)
+	epErr.Revision = rev
+	return epErr
+}
@@ -0,0 +1,86 @@
+package diff
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+)
+
+// testEnvFactory makes an entrypoint for its environment from every directory holding a file named after it
+type testEnvFactory struct {
+	env string
+}
+
+func (tf testEnvFactory) MakeEntrypoint(basedir, realpath string, isFile bool) (*entrypoint.Entrypoint, error) {
+	if !isFile || filepath.Base(realpath) != tf.env {
+		return nil, nil
+	}
+	return &entrypoint.Entrypoint{
+		Name:      tf.env,
+		Directory: filepath.Dir(realpath),
+		Type:      entrypoint.EntrypointTypeTerraform,
+	}, nil
+}
+
+func TestDiscoverEntrypoints(t *testing.T) {
+	tests := []struct {
+		name string
+		pre  []string
+		post []string
+		// want are the entrypoints discovered as directory/name and whether they're new
+		want []string
+	}{
+		{
+			name: "entrypoints sharing a directory",
+			pre:  []string{"infra/dev", "infra/staging"},
+			post: []string{"infra/dev", "infra/staging", "infra/prod"},
+			want: []string{"infra/dev existing", "infra/prod new", "infra/staging existing"},
+		},
+		{
+			name: "removed entrypoint",
+			pre:  []string{"infra/dev", "infra/prod"},
+			post: []string{"infra/dev"},
+			want: []string{"infra/dev existing", "infra/prod existing"},
+		},
+		{
+			name: "same name in different directories",
+			pre:  []string{"app/dev"},
+			post: []string{"app/dev", "infra/dev"},
+			want: []string{"app/dev existing", "infra/dev new"},
+		},
+	}
+
+	epds := []entrypoint.EntrypointFactory{testEnvFactory{"dev"}, testEnvFactory{"staging"}, testEnvFactory{"prod"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preDir, postDir := t.TempDir(), t.TempDir()
+			for dir, files := range map[string][]string{preDir: tt.pre, postDir: tt.post} {
+				for _, f := range files {
+					p := filepath.Join(dir, f)
+					if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(p, nil, 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			eps, err := discoverEntrypoints(context.Background(), preDir, postDir, epds)
+			if err != nil {
+				t.Fatalf("unable to discover entrypoints - %s", err)
+			}
+			got := []string{}
+			for _, iep := range eps {
+				got = append(got, filepath.Join(iep.ep.Directory, iep.ep.Name)+" "+iep.t)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package diff
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/events"
+	"github.com/codingninja/gitops-repo-api/resource"
+)
+
+// PipelineOptions bound how many entrypoints are diffed at once and for how long
+type PipelineOptions struct {
+	// Parallelism is the most entrypoints diffed at once, defaults to the number of CPUs
+	Parallelism int
+	// TypeParallelism further limits how many entrypoints of a type are diffed at once, such as running one
+	// terraform plan at a time
+	TypeParallelism map[entrypoint.EntrypointType]int
+	// Timeout cancels the render of an entrypoint which takes longer, zero is no timeout
+	Timeout time.Duration
+}
+
+// WithPipeline sets how many entrypoints are diffed at once and how long each may take
+func (rd *repoDiffer) WithPipeline(opts PipelineOptions) *repoDiffer {
+	rd.pipeline = opts
+	return rd
+}
+
+type entrypointResult struct {
+	diff []resource.ResourceDiff
+	pre  []resource.Resource
+	post []resource.Resource
+	errs []*resource.EntrypointError
+}
+
+// diffEntrypoints diffs every entrypoint on a bounded pool of workers, stopping early when ctx is cancelled.
+// Entrypoints of each type are started in order once a slot for their type is free, and only then take a worker
+// from the pool, so a limited type never holds workers which other types could use. Results are in the same order
+// as eps regardless of which entrypoints finish first.
+func (rd *repoDiffer) diffEntrypoints(ctx context.Context, eps []internalentrypoint, preDir, postDir string) ([]EntrypointDiff, [][]resource.Resource, [][]resource.Resource) {
+	parallelism := rd.pipeline.Parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+	typeSlots := map[entrypoint.EntrypointType]chan struct{}{}
+	for epType, n := range rd.pipeline.TypeParallelism {
+		if n > 0 {
+			typeSlots[epType] = make(chan struct{}, n)
+		}
+	}
+
+	pool := make(chan struct{}, parallelism)
+
+	types := []entrypoint.EntrypointType{}
+	byType := map[entrypoint.EntrypointType][]int{}
+	for i, ep := range eps {
+		if _, ok := byType[ep.ep.Type]; !ok {
+			types = append(types, ep.ep.Type)
+		}
+		byType[ep.ep.Type] = append(byType[ep.ep.Type], i)
+	}
+
+	results := make([]entrypointResult, len(eps))
+	wg := sync.WaitGroup{}
+	for _, epType := range types {
+		wg.Add(1)
+		go func(indexes []int, slots chan struct{}) {
+			defer wg.Done()
+			for _, i := range indexes {
+				release, ok := acquireSlots(ctx, slots, pool)
+				if !ok {
+					// Entrypoints still queued once ctx is cancelled are reported as not diffed
+					results[i] = rd.runEntrypoint(ctx, eps[i].ep, preDir, postDir)
+					continue
+				}
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					defer release()
+					results[i] = rd.runEntrypoint(ctx, eps[i].ep, preDir, postDir)
+				}(i)
+			}
+		}(byType[epType], typeSlots[epType])
+	}
+	wg.Wait()
+
+	allDiff := make([]EntrypointDiff, len(eps))
+	allPre := make([][]resource.Resource, len(eps))
+	allPost := make([][]resource.Resource, len(eps))
+	for i, res := range results {
+		allPre[i], allPost[i] = res.pre, res.post
+		allDiff[i] = EntrypointDiff{
+			Entrypoint: eps[i].ep,
+			Diff:       res.diff,
+			Errors:     res.errs,
+		}
+	}
+
+	return allDiff, allPre, allPost
+}
+
+// acquireSlots takes a slot from each of slots in turn, skipping those which are nil. ok is false if ctx is done
+// first, when none are held. release frees every slot taken.
+func acquireSlots(ctx context.Context, slots ...chan struct{}) (release func(), ok bool) {
+	held := []chan struct{}{}
+	release = func() {
+		for _, s := range held {
+			<-s
+		}
+	}
+	for _, s := range slots {
+		if s == nil {
+			continue
+		}
+		select {
+		case s <- struct{}{}:
+			held = append(held, s)
+		case <-ctx.Done():
+			release()
+			return nil, false
+		}
+	}
+	return release, true
+}
+
+// runEntrypoint diffs a single entrypoint, reporting when it starts and finishes
+func (rd *repoDiffer) runEntrypoint(ctx context.Context, ep entrypoint.Entrypoint, preDir, postDir string) entrypointResult {
+	start := time.Now()
+	res := rd.renderEntrypoint(ctx, ep, preDir, postDir)
+	finished := events.Event{
+		Type:           events.RenderFinished,
+		Entrypoint:     ep.Name,
+		EntrypointType: string(ep.Type),
+		Duration:       time.Since(start),
+	}
+	if len(res.errs) > 0 {
+		finished.Error = res.errs[0].Message
+	}
+	events.Emit(ctx, finished)
+	return res
+}
+
+// renderEntrypoint renders and diffs an entrypoint, unless ctx was cancelled while it was queued
+func (rd *repoDiffer) renderEntrypoint(ctx context.Context, ep entrypoint.Entrypoint, preDir, postDir string) entrypointResult {
+	if err := ctx.Err(); err != nil {
+		epErr := resource.NewEntrypointError(resource.ErrorKindCancelled, fmt.Errorf("entrypoint %q was not diffed - %w", ep.Name, err))
+		epErr.Entrypoint = ep.Name
+		return entrypointResult{errs: []*resource.EntrypointError{epErr}}
+	}
+
+	if rd.pipeline.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rd.pipeline.Timeout)
+		defer cancel()
+	}
+	ctx, renderErrs := resource.WithRenderErrors(ctx)
+	events.Emit(ctx, events.Event{Type: events.RenderStarted, Entrypoint: ep.Name, EntrypointType: string(ep.Type)})
+	diff, pre, post, err := rd.diffEntrypoint(ctx, ep, preDir, postDir)
+
+	// Differs return the revisions which failed to render, those recorded are kept in case a differ didn't
+	errs := resource.AsEntrypointErrors(err, resource.ErrorKindDiff)
+	for _, renderErr := range renderErrs() {
+		if !containsError(errs, renderErr) {
+			errs = append(errs, renderErr)
+		}
+	}
+	for _, epErr := range errs {
+		epErr.Entrypoint = ep.Name
+		// Revisions which failed to render were classified when they failed
+		if epErr.Revision == "" {
+			switch ctx.Err() {
+			case context.DeadlineExceeded:
+				epErr.Kind = resource.ErrorKindTimeout
+			case context.Canceled:
+				epErr.Kind = resource.ErrorKindCancelled
+			}
+		}
+		if epErr.Kind == resource.ErrorKindTimeout {
+			epErr.Message = fmt.Sprintf("entrypoint %q timed out after %s - %s", ep.Name, rd.pipeline.Timeout, epErr.Message)
+		}
+	}
+
+	return entrypointResult{diff: diff, pre: pre, post: post, errs: errs}
+}
+
+func containsError(errs []*resource.EntrypointError, target *resource.EntrypointError) bool {
+	for _, epErr := range errs {
+		if epErr == target {
+			return true
+		}
+	}
+	return false
+}
+
+// sortEntrypoints orders entrypoints by directory so they are diffed and reported in the same order every run
+func sortEntrypoints(eps []internalentrypoint) {
+	sort.SliceStable(eps, func(i, j int) bool {
+		a, b := eps[i].ep, eps[j].ep
+		if a.Directory != b.Directory {
+			return a.Directory < b.Directory
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Name < b.Name
+	})
+}
+
+// sortDiff orders the changes by resource type and identifier, differs which build them from maps don't
+// return them in a stable order
+func sortDiff(diff []resource.ResourceDiff) {
+	sort.SliceStable(diff, func(i, j int) bool {
+		a, b := diffResourceType(diff[i]), diffResourceType(diff[j])
+		if a != b {
+			return a < b
+		}
+		return diff[i].Identifier() < diff[j].Identifier()
+	})
+}
+
+func diffResourceType(rd resource.ResourceDiff) string {
+	if rd.Pre != nil {
+		return rd.Pre.Type()
+	}
+	if rd.Post != nil {
+		return rd.Post.Type()
+	}
+	return ""
+}
+
+// sortResources orders resources by type and identifier
+func sortResources(resources []resource.Resource) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		if resources[i].Type() != resources[j].Type() {
+			return resources[i].Type() < resources[j].Type()
+		}
+		return resources[i].Identifier() < resources[j].Identifier()
+	})
+}
@@ -0,0 +1,234 @@
+package diff
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/events"
+	"github.com/codingninja/gitops-repo-api/git"
+	"github.com/codingninja/gitops-repo-api/resource"
+)
+
+const (
+	// testContextDelay is how long the test differ takes to diff an entrypoint
+	testContextDelay = "delay"
+	// testContextStarted is closed by the test differ once it starts diffing the entrypoint
+	testContextStarted = "started"
+)
+
+type testResource struct {
+	name string
+}
+
+func (tr *testResource) Type() string       { return "test" }
+func (tr *testResource) Identifier() string { return tr.name }
+func (tr *testResource) Name() string       { return tr.name }
+
+// testDiffer returns a single resource named after the entrypoint after its delay, or once ctx is done when it
+// has no delay. It records the most entrypoints it diffed at once.
+type testDiffer struct {
+	active int32
+	peak   int32
+	calls  int32
+}
+
+func (td *testDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldDir, newDir string) ([]resource.ResourceDiff, []resource.Resource, []resource.Resource, error) {
+	atomic.AddInt32(&td.calls, 1)
+	active := atomic.AddInt32(&td.active, 1)
+	defer atomic.AddInt32(&td.active, -1)
+	for {
+		peak := atomic.LoadInt32(&td.peak)
+		if active <= peak || atomic.CompareAndSwapInt32(&td.peak, peak, active) {
+			break
+		}
+	}
+	if started, ok := ep.Context[testContextStarted].(chan struct{}); ok {
+		close(started)
+	}
+
+	delay, _ := ep.Context[testContextDelay].(time.Duration)
+	if delay == 0 {
+		<-ctx.Done()
+		return nil, nil, nil, ctx.Err()
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, nil, nil, ctx.Err()
+	}
+	return nil, nil, []resource.Resource{&testResource{name: ep.Name}}, nil
+}
+
+// registerTestDiffer registers a new test differ for an entrypoint type only used by a single test
+func registerTestDiffer(name string) (entrypoint.EntrypointType, *testDiffer) {
+	epType := entrypoint.EntrypointType("test-" + name)
+	differ := &testDiffer{}
+	resource.RegisterDiffer(epType, differ)
+	return epType, differ
+}
+
+func testEntrypoint(epType entrypoint.EntrypointType, name string, context map[string]interface{}) internalentrypoint {
+	return internalentrypoint{
+		t:  "post",
+		ep: entrypoint.Entrypoint{Name: name, Directory: name, Type: epType, Context: context},
+	}
+}
+
+func TestDiffEntrypointsKeepsOrder(t *testing.T) {
+	epType, _ := registerTestDiffer("order")
+	eps := []internalentrypoint{}
+	for i := 0; i < 8; i++ {
+		// Later entrypoints finish first
+		delay := time.Duration(8-i) * 10 * time.Millisecond
+		eps = append(eps, testEntrypoint(epType, fmt.Sprintf("ep-%d", i), map[string]interface{}{testContextDelay: delay}))
+	}
+
+	rd := NewDiffer(nil, nil, nil).WithPipeline(PipelineOptions{Parallelism: len(eps)})
+	allDiff, _, allPost := rd.diffEntrypoints(context.Background(), eps, "", "")
+
+	for i, ep := range eps {
+		if allDiff[i].Entrypoint.Name != ep.ep.Name {
+			t.Errorf("result %d is for entrypoint %q, expected %q", i, allDiff[i].Entrypoint.Name, ep.ep.Name)
+		}
+		if len(allDiff[i].Errors) > 0 {
+			t.Errorf("entrypoint %q failed - %s", ep.ep.Name, allDiff[i].Errors[0].Message)
+		}
+		if len(allPost[i]) != 1 || allPost[i][0].Name() != ep.ep.Name {
+			t.Errorf("resources of result %d aren't from entrypoint %q", i, ep.ep.Name)
+		}
+	}
+}
+
+func TestDiffEntrypointsTypeParallelism(t *testing.T) {
+	limitedType, limited := registerTestDiffer("limited")
+	otherType, other := registerTestDiffer("unlimited")
+	eps := []internalentrypoint{}
+	for i := 0; i < 6; i++ {
+		ctx := map[string]interface{}{testContextDelay: 20 * time.Millisecond}
+		eps = append(eps, testEntrypoint(limitedType, fmt.Sprintf("limited-%d", i), ctx))
+		eps = append(eps, testEntrypoint(otherType, fmt.Sprintf("other-%d", i), ctx))
+	}
+
+	rd := NewDiffer(nil, nil, nil).WithPipeline(PipelineOptions{
+		Parallelism:     len(eps),
+		TypeParallelism: map[entrypoint.EntrypointType]int{limitedType: 2},
+	})
+	allDiff, _, _ := rd.diffEntrypoints(context.Background(), eps, "", "")
+
+	for _, epDiff := range allDiff {
+		if len(epDiff.Errors) > 0 {
+			t.Errorf("entrypoint %q failed - %s", epDiff.Entrypoint.Name, epDiff.Errors[0].Message)
+		}
+	}
+	if peak := atomic.LoadInt32(&limited.peak); peak > 2 {
+		t.Errorf("diffed %d entrypoints of the limited type at once, expected at most 2", peak)
+	}
+	if peak := atomic.LoadInt32(&other.peak); peak <= 2 {
+		t.Errorf("diffed %d entrypoints of the unlimited type at once, expected more than 2", peak)
+	}
+}
+
+func TestDiffEntrypointsTypeParallelismStarvation(t *testing.T) {
+	limitedType, _ := registerTestDiffer("starved-limited")
+	otherType, _ := registerTestDiffer("starved-other")
+	eps := []internalentrypoint{}
+	// The limited entrypoints come first, so they'd fill the pool if they took workers before their type's slot
+	for i := 0; i < 6; i++ {
+		eps = append(eps, testEntrypoint(limitedType, fmt.Sprintf("limited-%d", i), map[string]interface{}{testContextDelay: 50 * time.Millisecond}))
+	}
+	for i := 0; i < 6; i++ {
+		eps = append(eps, testEntrypoint(otherType, fmt.Sprintf("other-%d", i), map[string]interface{}{testContextDelay: time.Millisecond}))
+	}
+
+	lock := sync.Mutex{}
+	finished := []string{}
+	ctx := events.WithSink(context.Background(), events.SinkFunc(func(e events.Event) {
+		if e.Type == events.RenderFinished {
+			lock.Lock()
+			defer lock.Unlock()
+			finished = append(finished, e.Entrypoint)
+		}
+	}))
+	rd := NewDiffer(nil, nil, nil).WithPipeline(PipelineOptions{
+		Parallelism:     2,
+		TypeParallelism: map[entrypoint.EntrypointType]int{limitedType: 1},
+	})
+	allDiff, _, _ := rd.diffEntrypoints(ctx, eps, "", "")
+
+	for _, epDiff := range allDiff {
+		if len(epDiff.Errors) > 0 {
+			t.Errorf("entrypoint %q failed - %s", epDiff.Entrypoint.Name, epDiff.Errors[0].Message)
+		}
+	}
+	if len(finished) != len(eps) {
+		t.Fatalf("got %d entrypoints finished, expected %d", len(finished), len(eps))
+	}
+	// The other type has the rest of the pool to itself, so it finishes before the first limited entrypoint
+	for _, name := range finished[:6] {
+		if !strings.HasPrefix(name, "other-") {
+			t.Errorf("got %q finishing before the other type, expected the other type to use the rest of the pool - %v", name, finished)
+			break
+		}
+	}
+}
+
+func TestDiffEntrypointsTimeout(t *testing.T) {
+	epType, _ := registerTestDiffer("timeout")
+	eps := []internalentrypoint{testEntrypoint(epType, "slow", map[string]interface{}{})}
+
+	rd := NewDiffer(nil, nil, nil).WithPipeline(PipelineOptions{Timeout: 20 * time.Millisecond})
+	allDiff, _, _ := rd.diffEntrypoints(context.Background(), eps, "", "")
+
+	errs := allDiff[0].Errors
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, expected 1", len(errs))
+	}
+	if errs[0].Kind != resource.ErrorKindTimeout {
+		t.Errorf("got error kind %q, expected %q", errs[0].Kind, resource.ErrorKindTimeout)
+	}
+	if errs[0].Entrypoint != "slow" {
+		t.Errorf("error is for entrypoint %q, expected %q", errs[0].Entrypoint, "slow")
+	}
+}
+
+func TestDiffEntrypointsCancelled(t *testing.T) {
+	epType, differ := registerTestDiffer("cancelled")
+	started := make(chan struct{})
+	eps := []internalentrypoint{testEntrypoint(epType, "running", map[string]interface{}{testContextStarted: started})}
+	for i := 0; i < 4; i++ {
+		eps = append(eps, testEntrypoint(epType, fmt.Sprintf("queued-%d", i), map[string]interface{}{testContextDelay: time.Millisecond}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-started
+		cancel()
+	}()
+
+	rd := NewDiffer(nil, nil, nil).WithPipeline(PipelineOptions{Parallelism: 1})
+	allDiff, _, _ := rd.diffEntrypoints(ctx, eps, "", "")
+	wg.Wait()
+
+	for _, epDiff := range allDiff {
+		if len(epDiff.Errors) != 1 {
+			t.Errorf("entrypoint %q got %d errors, expected 1", epDiff.Entrypoint.Name, len(epDiff.Errors))
+			continue
+		}
+		if epDiff.Errors[0].Kind != resource.ErrorKindCancelled {
+			t.Errorf("entrypoint %q got error kind %q, expected %q", epDiff.Entrypoint.Name, epDiff.Errors[0].Kind, resource.ErrorKindCancelled)
+		}
+	}
+	if calls := atomic.LoadInt32(&differ.calls); calls != 1 {
+		t.Errorf("differ was called %d times, queued entrypoints should not be diffed", calls)
+	}
+}
@@ -0,0 +1,95 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/codingninja/gitops-repo-api/generated/api"
+	"github.com/codingninja/gitops-repo-api/resource"
+)
+
+// Proto converts the diff of an entrypoint into its API message, Error is the first of its errors for clients
+// which don't read Errors
+func (ed EntrypointDiff) Proto() *api.DiffResponse_Diff {
+	pd := &api.DiffResponse_Diff{
+		Entrypoint: &api.Entrypoint{
+			Name:      ed.Entrypoint.Name,
+			Directory: ed.Entrypoint.Directory,
+			Type:      string(ed.Entrypoint.Type),
+		},
+	}
+	for _, epErr := range ed.Errors {
+		pd.Errors = append(pd.Errors, protoError(epErr))
+	}
+	if len(ed.Errors) > 0 {
+		pd.Error = ed.Errors[0].Message
+	}
+	for _, rd := range ed.Diff {
+		change := &api.DiffResponse_Diff_EntrypointDiff{
+			Type: protoDiffType(rd.Type),
+			Pre:  protoResource(rd.Pre),
+			Post: protoResource(rd.Post),
+		}
+		for _, c := range rd.Diff {
+			change.Diffs = append(change.Diffs, &api.DiffResponse_Diff_EntrypointDiff_Change{
+				Type: c.Type,
+				Path: c.Path,
+				From: protoValue(c.From),
+				To:   protoValue(c.To),
+			})
+		}
+		pd.Changes = append(pd.Changes, change)
+	}
+	return pd
+}
+
+// protoError converts an entrypoint error into its API message
+func protoError(ee *resource.EntrypointError) *api.EntrypointError {
+	return &api.EntrypointError{
+		Kind:       string(ee.Kind),
+		Entrypoint: ee.Entrypoint,
+		Revision:   string(ee.Revision),
+		Message:    ee.Message,
+		Tool:       ee.Tool,
+		Output:     ee.Output,
+		File:       ee.File,
+		Line:       int32(ee.Line),
+	}
+}
+
+// protoResource identifies a resource in the API, it's nil for the missing side of a create or delete
+func protoResource(r resource.Resource) *api.Resource {
+	if r == nil {
+		return nil
+	}
+	return &api.Resource{
+		Type:       r.Type(),
+		Identifier: r.Identifier(),
+		Name:       r.Name(),
+	}
+}
+
+// protoDiffType maps a change onto the API's diff types
+func protoDiffType(dt resource.DiffType) api.DiffType {
+	switch dt {
+	case resource.DiffTypeCreate:
+		return api.DiffType_CREATE
+	case resource.DiffTypeDelete:
+		return api.DiffType_DELETE
+	case resource.DiffTypeReplace:
+		return api.DiffType_REPLACE
+	}
+	return api.DiffType_UPDATE
+}
+
+// protoValue encodes a changed value as JSON, falling back to its default format
+func protoValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,70 @@
+package diff
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/generated/api"
+	"github.com/codingninja/gitops-repo-api/resource"
+	r3diff "github.com/r3labs/diff/v3"
+)
+
+func TestEntrypointDiffProto(t *testing.T) {
+	epErr := resource.NewEntrypointError(resource.ErrorKindRender, errors.New("unable to render"))
+	epErr.Entrypoint = "web"
+	epErr.Revision = resource.RevisionPost
+	epErr.File = "main.tf"
+	epErr.Line = 3
+
+	pd := EntrypointDiff{
+		Entrypoint: entrypoint.Entrypoint{Name: "web", Directory: "apps/web", Type: entrypoint.EntrypointTypeTerraform},
+		Errors:     []*resource.EntrypointError{epErr},
+		Diff: []resource.ResourceDiff{{
+			Type: resource.DiffTypeUpdate,
+			Pre:  &testResource{name: "web"},
+			Post: &testResource{name: "web"},
+			Diff: r3diff.Changelog{{Type: r3diff.UPDATE, Path: []string{"spec", "replicas"}, From: 1, To: 2}},
+		}, {
+			Type: resource.DiffTypeReplace,
+			Pre:  &testResource{name: "db"},
+			Post: &testResource{name: "db"},
+		}, {
+			Type: resource.DiffTypeCreate,
+			Post: &testResource{name: "cache"},
+		}},
+	}.Proto()
+
+	if ep := pd.Entrypoint; ep.Name != "web" || ep.Directory != "apps/web" || ep.Type != string(entrypoint.EntrypointTypeTerraform) {
+		t.Errorf("entrypoint wasn't converted, got %v", ep)
+	}
+
+	if len(pd.Errors) != 1 {
+		t.Fatalf("got %d errors, expected 1", len(pd.Errors))
+	}
+	got := pd.Errors[0]
+	if got.Kind != string(resource.ErrorKindRender) || got.Entrypoint != "web" || got.Revision != string(resource.RevisionPost) || got.File != "main.tf" || got.Line != 3 {
+		t.Errorf("error wasn't converted, got %v", got)
+	}
+	if pd.Error != epErr.Message {
+		t.Errorf("got error %q, expected %q", pd.Error, epErr.Message)
+	}
+
+	if len(pd.Changes) != 3 || len(pd.Changes[0].Diffs) != 1 {
+		t.Fatalf("changes weren't converted, got %v", pd.Changes)
+	}
+	if change := pd.Changes[0].Diffs[0]; change.From != "1" || change.To != "2" {
+		t.Errorf("got change from %q to %q, expected from \"1\" to \"2\"", change.From, change.To)
+	}
+	for i, expected := range []api.DiffType{api.DiffType_UPDATE, api.DiffType_REPLACE, api.DiffType_CREATE} {
+		if got := pd.Changes[i].Type; got != expected {
+			t.Errorf("change %d got type %v, expected %v", i, got, expected)
+		}
+	}
+	if pre := pd.Changes[0].Pre; pre == nil || pre.Type != "test" || pre.Identifier != "web" || pre.Name != "web" {
+		t.Errorf("pre resource wasn't converted, got %v", pre)
+	}
+	if created := pd.Changes[2]; created.Pre != nil || created.Post == nil || created.Post.Identifier != "cache" {
+		t.Errorf("created resource wasn't converted, got pre %v and post %v", created.Pre, created.Post)
+	}
+}
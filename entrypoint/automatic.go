@@ -29,6 +29,9 @@ var DefaultSupportedTypes = map[EntrypointType]bool{
 	EntrypointTypeKubernetes:     true,
 	EntrypointTypeKustomize:      true,
 	EntrypointTypeTerraform:      true,
+	EntrypointTypeCue:            false,
+	EntrypointTypeCompose:        false,
+	EntrypointTypeConfig:         false,
 }
 
 func (epds EntrypointAutomaticDiscovery) MakeEntrypoint(basedir, repoPath string, isFile bool) (*Entrypoint, error) {
@@ -83,6 +86,36 @@ func (epds EntrypointAutomaticDiscovery) MakeEntrypoint(basedir, repoPath string
 			}, nil
 		}
 	}
+	if epds.SupportedTypes[EntrypointTypeCue] && !isFile {
+		if isValidCueEntrypoint(abs) {
+			return &Entrypoint{
+				Type:      EntrypointTypeCue,
+				Name:      slug.Make(repoPath),
+				Directory: repoPath,
+				Context:   copyMap(epds.Context),
+			}, nil
+		}
+	}
+	if epds.SupportedTypes[EntrypointTypeCompose] && !isFile {
+		if isValidComposeEntrypoint(abs) {
+			return &Entrypoint{
+				Type:      EntrypointTypeCompose,
+				Name:      slug.Make(repoPath),
+				Directory: repoPath,
+				Context:   copyMap(epds.Context),
+			}, nil
+		}
+	}
+	if epds.SupportedTypes[EntrypointTypeConfig] && isFile {
+		if isValidConfigEntrypoint(abs) {
+			return &Entrypoint{
+				Type:      EntrypointTypeConfig,
+				Name:      slug.Make(repoPath),
+				Directory: repoPath,
+				Context:   copyMap(epds.Context),
+			}, nil
+		}
+	}
 
 	return nil, nil
 }
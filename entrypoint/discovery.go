@@ -1,6 +1,8 @@
 package entrypoint
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"path"
@@ -8,6 +10,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/codingninja/gitops-repo-api/events"
 	"github.com/gosimple/slug"
 )
 
@@ -16,6 +19,17 @@ type EntrypointFactory interface {
 	MakeEntrypoint(basedir, realpath string, isFile bool) (*Entrypoint, error)
 }
 
+// InvalidEntrypointError is returned by a factory when a path matched but isn't a valid entrypoint of its type,
+// discovery reports it as a warning and carries on
+type InvalidEntrypointError struct {
+	Path string
+	Type EntrypointType
+}
+
+func (ie *InvalidEntrypointError) Error() string {
+	return fmt.Sprintf("%s is not a valid %q entrypoint", ie.Path, ie.Type)
+}
+
 // EntrypointDiscoverySpec represents a specification for discovering Entrypoint directories in a repository
 type EntrypointDiscoverySpec struct {
 	Type    EntrypointType         `json:"type"`
@@ -59,12 +73,9 @@ func (epds EntrypointDiscoverySpec) MakeEntrypoint(basedir, repoPath string, isF
 
 		epd := path.Join(basedir, repoPath)
 		if !isValidEntrypoint(epd, epType) {
-			fmt.Printf("%s is not a valid %q entrypoint\n", repoPath, epType)
-			return nil, nil
+			return nil, &InvalidEntrypointError{Path: repoPath, Type: epType}
 		}
 
-		fmt.Printf("got entrypoint %q with regex %q\n", name, epds.Regex.String())
-
 		ep := Entrypoint{
 			Name:      name,
 			Directory: repoPath,
@@ -82,7 +93,7 @@ var _ EntrypointFactory = EntrypointDiscoverySpec{}
 
 // TODO: Make this more performant, add a flag to only check dir names, include a basedir prop to limit search context
 // DiscoverEntrypoints walks a directory and returns a list of Entrypoints matching the supplied specs
-func DiscoverEntrypoints(directory string, specs []EntrypointFactory) ([]Entrypoint, error) {
+func DiscoverEntrypoints(ctx context.Context, directory string, specs []EntrypointFactory) ([]Entrypoint, error) {
 	directory = path.Clean(directory)
 	entrypoints := []Entrypoint{}
 	err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
@@ -94,6 +105,16 @@ func DiscoverEntrypoints(directory string, specs []EntrypointFactory) ([]Entrypo
 		for _, s := range specs {
 
 			ep, err := s.MakeEntrypoint(directory, realpath, !d.IsDir())
+			var invalid *InvalidEntrypointError
+			if errors.As(err, &invalid) {
+				events.Emit(ctx, events.Event{
+					Type:           events.Warning,
+					EntrypointType: string(invalid.Type),
+					File:           invalid.Path,
+					Message:        invalid.Error(),
+				})
+				continue
+			}
 			if err != nil {
 				return err
 			}
@@ -9,6 +9,9 @@ const (
 	EntrypointTypeCloudformation EntrypointType = "cloudformation"
 	EntrypointTypeCdk            EntrypointType = "cdk"
 	EntrypointTypeTerraform      EntrypointType = "terraform"
+	EntrypointTypeCue            EntrypointType = "cue"
+	EntrypointTypeCompose        EntrypointType = "compose"
+	EntrypointTypeConfig         EntrypointType = "config"
 	EntrypointTypeHclV1          EntrypointType = "hclv1"
 	EntrypointTypeHclV2          EntrypointType = "hclv2"
 )
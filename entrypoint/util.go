@@ -4,13 +4,27 @@ import (
 	"encoding/json"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/codingninja/gitops-repo-api/util"
 	"gopkg.in/yaml.v3"
 )
 
+// customTypes validates entrypoints of the types registered with RegisterEntrypointType
+var customTypes = map[EntrypointType]func(epPath string) bool{}
+var customTypesLock sync.RWMutex
+
+// RegisterEntrypointType lets discovery specs create entrypoints of a type this package doesn't implement, such as
+// one rendered by a plugin. valid reports whether the path is an entrypoint of the type.
+func RegisterEntrypointType(epType EntrypointType, valid func(epPath string) bool) {
+	customTypesLock.Lock()
+	defer customTypesLock.Unlock()
+	customTypes[epType] = valid
+}
+
 func isValidCloudformationEntrypoint(epPath string) bool {
 	content, err := os.ReadFile(epPath)
 	tpl := map[string]interface{}{}
@@ -87,6 +101,72 @@ func isValidTerraformEntrypoint(epPath string) bool {
 	return false
 }
 
+func isValidCueEntrypoint(epPath string) bool {
+	// Modules vendor their dependencies under cue.mod, these are never entrypoints in their own right
+	for _, segment := range strings.Split(filepath.ToSlash(epPath), "/") {
+		if segment == "cue.mod" {
+			return false
+		}
+	}
+	files, err := os.ReadDir(epPath)
+	if err != nil {
+		return false
+	}
+
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".cue") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ComposeFileNames are the file names docker compose looks for, in order of preference
+var ComposeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+func isValidComposeEntrypoint(epPath string) bool {
+	for _, name := range ComposeFileNames {
+		if stat, err := os.Stat(path.Join(epPath, name)); err == nil && !stat.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigFileExtensions are the structured config formats supported by config entrypoints
+var ConfigFileExtensions = []string{".yaml", ".yml", ".json", ".toml"}
+
+func IsConfigFile(file string) bool {
+	for _, ext := range ConfigFileExtensions {
+		if strings.HasSuffix(file, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidConfigEntrypoint(epPath string) bool {
+	stat, err := os.Stat(epPath)
+	if err != nil {
+		return false
+	}
+	if !stat.IsDir() {
+		return IsConfigFile(epPath)
+	}
+
+	files, err := os.ReadDir(epPath)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if !f.IsDir() && IsConfigFile(f.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
 func isValidEntrypoint(epPath string, epType EntrypointType) bool {
 	switch epType {
 	case EntrypointTypeCloudformation:
@@ -99,9 +179,21 @@ func isValidEntrypoint(epPath string, epType EntrypointType) bool {
 		return isValidKustomizeEntrypoint(epPath)
 	case EntrypointTypeTerraform:
 		return isValidTerraformEntrypoint(epPath)
+	case EntrypointTypeCue:
+		return isValidCueEntrypoint(epPath)
+	case EntrypointTypeCompose:
+		return isValidComposeEntrypoint(epPath)
+	case EntrypointTypeConfig:
+		return isValidConfigEntrypoint(epPath)
 	case EntrypointTypeHclV1:
 		return isValidCdkEntrypoint(epPath)
 	}
+
+	customTypesLock.RLock()
+	defer customTypesLock.RUnlock()
+	if valid, ok := customTypes[epType]; ok {
+		return valid(epPath)
+	}
 	return false
 }
 
@@ -0,0 +1,40 @@
+package entrypoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsValidCueEntrypoint(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"app", "cue.mod/pkg/example.com/lib", "my-cue.mod", "empty"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if dir != "empty" {
+			if err := os.WriteFile(filepath.Join(root, dir, "main.cue"), []byte("package x\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{dir: "app", want: true},
+		{dir: "cue.mod/pkg/example.com/lib", want: false},
+		{dir: "my-cue.mod", want: true},
+		{dir: "empty", want: false},
+		{dir: "missing", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			if got := isValidCueEntrypoint(filepath.Join(root, tt.dir)); got != tt.want {
+				t.Errorf("got %t, expected %t", got, tt.want)
+			}
+		})
+	}
+}
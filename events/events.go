@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Type names what happened
+type Type string
+
+const (
+	CheckoutStarted      Type = "checkoutStarted"
+	CheckoutFinished     Type = "checkoutFinished"
+	EntrypointDiscovered Type = "entrypointDiscovered"
+	RenderStarted        Type = "renderStarted"
+	RenderFinished       Type = "renderFinished"
+	ResourceDiffed       Type = "resourceDiffed"
+	Warning              Type = "warning"
+)
+
+// Event is emitted as revisions are checked out and their entrypoints discovered, rendered and diffed. Only the
+// fields relevant to the type are set.
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// Revision is pre or post, for events about one side of the change
+	Revision  string `json:"revision,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	// Entrypoint is the name of the entrypoint
+	Entrypoint     string `json:"entrypoint,omitempty"`
+	EntrypointType string `json:"entrypointType,omitempty"`
+	// Resource is the identifier of the resource, and DiffType how it changed
+	Resource string `json:"resource,omitempty"`
+	DiffType string `json:"diffType,omitempty"`
+	// File is relative to the entrypoint for warnings about a file, and to the repository for discovery
+	File    string `json:"file,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Duration is how long a finished step took
+	Duration time.Duration `json:"duration,omitempty"`
+	// Error is set when a finished step failed
+	Error string `json:"error,omitempty"`
+}
+
+// Sink receives events, it's called from several goroutines at once and shouldn't block
+type Sink interface {
+	Emit(Event)
+}
+
+// SinkFunc is a function which receives events
+type SinkFunc func(Event)
+
+func (sf SinkFunc) Emit(e Event) {
+	sf(e)
+}
+
+type sinkKey struct{}
+
+// WithSink sends the events emitted with the returned context to sink. Nothing is reported without one.
+func WithSink(ctx context.Context, sink Sink) context.Context {
+	return context.WithValue(ctx, sinkKey{}, sink)
+}
+
+// Emit sends e to the sink of ctx, if it has one
+func Emit(ctx context.Context, e Event) {
+	sink, ok := ctx.Value(sinkKey{}).(Sink)
+	if !ok || sink == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	sink.Emit(e)
+}
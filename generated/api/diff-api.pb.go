@@ -23,9 +23,10 @@ const (
 type DiffType int32
 
 const (
-	DiffType_CREATE DiffType = 0
-	DiffType_UPDATE DiffType = 1
-	DiffType_DELETE DiffType = 2
+	DiffType_CREATE  DiffType = 0
+	DiffType_UPDATE  DiffType = 1
+	DiffType_DELETE  DiffType = 2
+	DiffType_REPLACE DiffType = 3
 )
 
 // Enum value maps for DiffType.
@@ -34,11 +35,13 @@ var (
 		0: "CREATE",
 		1: "UPDATE",
 		2: "DELETE",
+		3: "REPLACE",
 	}
 	DiffType_value = map[string]int32{
-		"CREATE": 0,
-		"UPDATE": 1,
-		"DELETE": 2,
+		"CREATE":  0,
+		"UPDATE":  1,
+		"DELETE":  2,
+		"REPLACE": 3,
 	}
 )
 
@@ -191,6 +194,10 @@ type Entrypoint struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
+
+	Name      string `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	Directory string `protobuf:"bytes,2,opt,name=Directory,proto3" json:"Directory,omitempty"`
+	Type      string `protobuf:"bytes,3,opt,name=Type,proto3" json:"Type,omitempty"`
 }
 
 func (x *Entrypoint) Reset() {
@@ -225,10 +232,35 @@ func (*Entrypoint) Descriptor() ([]byte, []int) {
 	return file_api_diff_api_proto_rawDescGZIP(), []int{2}
 }
 
+func (x *Entrypoint) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Entrypoint) GetDirectory() string {
+	if x != nil {
+		return x.Directory
+	}
+	return ""
+}
+
+func (x *Entrypoint) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
 type Resource struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
+
+	Type       string `protobuf:"bytes,1,opt,name=Type,proto3" json:"Type,omitempty"`
+	Identifier string `protobuf:"bytes,2,opt,name=Identifier,proto3" json:"Identifier,omitempty"`
+	Name       string `protobuf:"bytes,3,opt,name=Name,proto3" json:"Name,omitempty"`
 }
 
 func (x *Resource) Reset() {
@@ -263,6 +295,130 @@ func (*Resource) Descriptor() ([]byte, []int) {
 	return file_api_diff_api_proto_rawDescGZIP(), []int{3}
 }
 
+func (x *Resource) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Resource) GetIdentifier() string {
+	if x != nil {
+		return x.Identifier
+	}
+	return ""
+}
+
+func (x *Resource) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type EntrypointError struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Kind       string `protobuf:"bytes,1,opt,name=Kind,proto3" json:"Kind,omitempty"`
+	Entrypoint string `protobuf:"bytes,2,opt,name=Entrypoint,proto3" json:"Entrypoint,omitempty"`
+	Revision   string `protobuf:"bytes,3,opt,name=Revision,proto3" json:"Revision,omitempty"`
+	Message    string `protobuf:"bytes,4,opt,name=Message,proto3" json:"Message,omitempty"`
+	Tool       string `protobuf:"bytes,5,opt,name=Tool,proto3" json:"Tool,omitempty"`
+	Output     string `protobuf:"bytes,6,opt,name=Output,proto3" json:"Output,omitempty"`
+	File       string `protobuf:"bytes,7,opt,name=File,proto3" json:"File,omitempty"`
+	Line       int32  `protobuf:"varint,8,opt,name=Line,proto3" json:"Line,omitempty"`
+}
+
+func (x *EntrypointError) Reset() {
+	*x = EntrypointError{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_diff_api_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EntrypointError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EntrypointError) ProtoMessage() {}
+
+func (x *EntrypointError) ProtoReflect() protoreflect.Message {
+	mi := &file_api_diff_api_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EntrypointError.ProtoReflect.Descriptor instead.
+func (*EntrypointError) Descriptor() ([]byte, []int) {
+	return file_api_diff_api_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *EntrypointError) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *EntrypointError) GetEntrypoint() string {
+	if x != nil {
+		return x.Entrypoint
+	}
+	return ""
+}
+
+func (x *EntrypointError) GetRevision() string {
+	if x != nil {
+		return x.Revision
+	}
+	return ""
+}
+
+func (x *EntrypointError) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *EntrypointError) GetTool() string {
+	if x != nil {
+		return x.Tool
+	}
+	return ""
+}
+
+func (x *EntrypointError) GetOutput() string {
+	if x != nil {
+		return x.Output
+	}
+	return ""
+}
+
+func (x *EntrypointError) GetFile() string {
+	if x != nil {
+		return x.File
+	}
+	return ""
+}
+
+func (x *EntrypointError) GetLine() int32 {
+	if x != nil {
+		return x.Line
+	}
+	return 0
+}
+
 type DiffResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -274,7 +430,7 @@ type DiffResponse struct {
 func (x *DiffResponse) Reset() {
 	*x = DiffResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_api_diff_api_proto_msgTypes[4]
+		mi := &file_api_diff_api_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -287,7 +443,7 @@ func (x *DiffResponse) String() string {
 func (*DiffResponse) ProtoMessage() {}
 
 func (x *DiffResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_api_diff_api_proto_msgTypes[4]
+	mi := &file_api_diff_api_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -300,7 +456,7 @@ func (x *DiffResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DiffResponse.ProtoReflect.Descriptor instead.
 func (*DiffResponse) Descriptor() ([]byte, []int) {
-	return file_api_diff_api_proto_rawDescGZIP(), []int{4}
+	return file_api_diff_api_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *DiffResponse) GetDiffs() []*DiffResponse_Diff {
@@ -319,7 +475,7 @@ type DiffRequest_Filter struct {
 func (x *DiffRequest_Filter) Reset() {
 	*x = DiffRequest_Filter{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_api_diff_api_proto_msgTypes[5]
+		mi := &file_api_diff_api_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -332,7 +488,7 @@ func (x *DiffRequest_Filter) String() string {
 func (*DiffRequest_Filter) ProtoMessage() {}
 
 func (x *DiffRequest_Filter) ProtoReflect() protoreflect.Message {
-	mi := &file_api_diff_api_proto_msgTypes[5]
+	mi := &file_api_diff_api_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -356,12 +512,13 @@ type DiffResponse_Diff struct {
 	Entrypoint *Entrypoint                         `protobuf:"bytes,1,opt,name=Entrypoint,proto3" json:"Entrypoint,omitempty"`
 	Error      string                              `protobuf:"bytes,2,opt,name=Error,proto3" json:"Error,omitempty"`
 	Changes    []*DiffResponse_Diff_EntrypointDiff `protobuf:"bytes,3,rep,name=Changes,proto3" json:"Changes,omitempty"`
+	Errors     []*EntrypointError                  `protobuf:"bytes,4,rep,name=Errors,proto3" json:"Errors,omitempty"`
 }
 
 func (x *DiffResponse_Diff) Reset() {
 	*x = DiffResponse_Diff{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_api_diff_api_proto_msgTypes[6]
+		mi := &file_api_diff_api_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -374,7 +531,7 @@ func (x *DiffResponse_Diff) String() string {
 func (*DiffResponse_Diff) ProtoMessage() {}
 
 func (x *DiffResponse_Diff) ProtoReflect() protoreflect.Message {
-	mi := &file_api_diff_api_proto_msgTypes[6]
+	mi := &file_api_diff_api_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -387,7 +544,7 @@ func (x *DiffResponse_Diff) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DiffResponse_Diff.ProtoReflect.Descriptor instead.
 func (*DiffResponse_Diff) Descriptor() ([]byte, []int) {
-	return file_api_diff_api_proto_rawDescGZIP(), []int{4, 0}
+	return file_api_diff_api_proto_rawDescGZIP(), []int{5, 0}
 }
 
 func (x *DiffResponse_Diff) GetEntrypoint() *Entrypoint {
@@ -411,6 +568,13 @@ func (x *DiffResponse_Diff) GetChanges() []*DiffResponse_Diff_EntrypointDiff {
 	return nil
 }
 
+func (x *DiffResponse_Diff) GetErrors() []*EntrypointError {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
 type DiffResponse_Diff_EntrypointDiff struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -425,7 +589,7 @@ type DiffResponse_Diff_EntrypointDiff struct {
 func (x *DiffResponse_Diff_EntrypointDiff) Reset() {
 	*x = DiffResponse_Diff_EntrypointDiff{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_api_diff_api_proto_msgTypes[7]
+		mi := &file_api_diff_api_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -438,7 +602,7 @@ func (x *DiffResponse_Diff_EntrypointDiff) String() string {
 func (*DiffResponse_Diff_EntrypointDiff) ProtoMessage() {}
 
 func (x *DiffResponse_Diff_EntrypointDiff) ProtoReflect() protoreflect.Message {
-	mi := &file_api_diff_api_proto_msgTypes[7]
+	mi := &file_api_diff_api_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -451,7 +615,7 @@ func (x *DiffResponse_Diff_EntrypointDiff) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DiffResponse_Diff_EntrypointDiff.ProtoReflect.Descriptor instead.
 func (*DiffResponse_Diff_EntrypointDiff) Descriptor() ([]byte, []int) {
-	return file_api_diff_api_proto_rawDescGZIP(), []int{4, 0, 0}
+	return file_api_diff_api_proto_rawDescGZIP(), []int{5, 0, 0}
 }
 
 func (x *DiffResponse_Diff_EntrypointDiff) GetType() DiffType {
@@ -497,7 +661,7 @@ type DiffResponse_Diff_EntrypointDiff_Change struct {
 func (x *DiffResponse_Diff_EntrypointDiff_Change) Reset() {
 	*x = DiffResponse_Diff_EntrypointDiff_Change{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_api_diff_api_proto_msgTypes[8]
+		mi := &file_api_diff_api_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -510,7 +674,7 @@ func (x *DiffResponse_Diff_EntrypointDiff_Change) String() string {
 func (*DiffResponse_Diff_EntrypointDiff_Change) ProtoMessage() {}
 
 func (x *DiffResponse_Diff_EntrypointDiff_Change) ProtoReflect() protoreflect.Message {
-	mi := &file_api_diff_api_proto_msgTypes[8]
+	mi := &file_api_diff_api_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -523,7 +687,7 @@ func (x *DiffResponse_Diff_EntrypointDiff_Change) ProtoReflect() protoreflect.Me
 
 // Deprecated: Use DiffResponse_Diff_EntrypointDiff_Change.ProtoReflect.Descriptor instead.
 func (*DiffResponse_Diff_EntrypointDiff_Change) Descriptor() ([]byte, []int) {
-	return file_api_diff_api_proto_rawDescGZIP(), []int{4, 0, 0, 0}
+	return file_api_diff_api_proto_rawDescGZIP(), []int{5, 0, 0, 0}
 }
 
 func (x *DiffResponse_Diff_EntrypointDiff_Change) GetType() string {
@@ -579,13 +743,35 @@ var file_api_diff_api_proto_rawDesc = []byte{
 	0x1e, 0x0a, 0x0a, 0x52, 0x65, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x6f, 0x72, 0x79, 0x18, 0x01, 0x20,
 	0x01, 0x28, 0x09, 0x52, 0x0a, 0x52, 0x65, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x6f, 0x72, 0x79, 0x12,
 	0x16, 0x0a, 0x06, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x06, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x22, 0x0c, 0x0a, 0x0a, 0x45, 0x6e, 0x74, 0x72, 0x79,
-	0x70, 0x6f, 0x69, 0x6e, 0x74, 0x22, 0x0a, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63,
-	0x65, 0x22, 0x87, 0x04, 0x0a, 0x0c, 0x44, 0x69, 0x66, 0x66, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x06, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x22, 0x52, 0x0a, 0x0a, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x70, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x44, 0x69, 0x72,
+	0x65, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x44, 0x69,
+	0x72, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x22, 0x52, 0x0a, 0x08, 0x52,
+	0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x49,
+	0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0a, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x4e,
+	0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x4e, 0x61, 0x6d, 0x65, 0x22,
+	0xcf, 0x01, 0x0a, 0x0f, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x45, 0x72,
+	0x72, 0x6f, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x4b, 0x69, 0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x4b, 0x69, 0x6e, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x70, 0x6f, 0x69, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x52, 0x65, 0x76, 0x69, 0x73,
+	0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x52, 0x65, 0x76, 0x69, 0x73,
+	0x69, 0x6f, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x12, 0x0a,
+	0x04, 0x54, 0x6f, 0x6f, 0x6c, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x54, 0x6f, 0x6f,
+	0x6c, 0x12, 0x16, 0x0a, 0x06, 0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x46, 0x69, 0x6c,
+	0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x12, 0x0a,
+	0x04, 0x4c, 0x69, 0x6e, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x4c, 0x69, 0x6e,
+	0x65, 0x22, 0xb7, 0x04, 0x0a, 0x0c, 0x44, 0x69, 0x66, 0x66, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
 	0x73, 0x65, 0x12, 0x2e, 0x0a, 0x05, 0x44, 0x69, 0x66, 0x66, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28,
 	0x0b, 0x32, 0x18, 0x2e, 0x61, 0x70, 0x69, 0x70, 0x62, 0x2e, 0x44, 0x69, 0x66, 0x66, 0x52, 0x65,
 	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e, 0x44, 0x69, 0x66, 0x66, 0x52, 0x05, 0x44, 0x69, 0x66,
-	0x66, 0x73, 0x1a, 0xc6, 0x03, 0x0a, 0x04, 0x44, 0x69, 0x66, 0x66, 0x12, 0x31, 0x0a, 0x0a, 0x45,
+	0x66, 0x73, 0x1a, 0xf6, 0x03, 0x0a, 0x04, 0x44, 0x69, 0x66, 0x66, 0x12, 0x31, 0x0a, 0x0a, 0x45,
 	0x6e, 0x74, 0x72, 0x79, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
 	0x11, 0x2e, 0x61, 0x70, 0x69, 0x70, 0x62, 0x2e, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x70, 0x6f, 0x69,
 	0x6e, 0x74, 0x52, 0x0a, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x14,
@@ -594,7 +780,10 @@ var file_api_diff_api_proto_rawDesc = []byte{
 	0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x27, 0x2e, 0x61, 0x70, 0x69, 0x70, 0x62, 0x2e, 0x44, 0x69,
 	0x66, 0x66, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e, 0x44, 0x69, 0x66, 0x66, 0x2e,
 	0x45, 0x6e, 0x74, 0x72, 0x79, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x44, 0x69, 0x66, 0x66, 0x52, 0x07,
-	0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x1a, 0xb1, 0x02, 0x0a, 0x0e, 0x45, 0x6e, 0x74, 0x72,
+	0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x12, 0x2e, 0x0a, 0x06, 0x45, 0x72, 0x72, 0x6f, 0x72,
+	0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x61, 0x70, 0x69, 0x70, 0x62, 0x2e,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x52,
+	0x06, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x73, 0x1a, 0xb1, 0x02, 0x0a, 0x0e, 0x45, 0x6e, 0x74, 0x72,
 	0x79, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x44, 0x69, 0x66, 0x66, 0x12, 0x23, 0x0a, 0x04, 0x54, 0x79,
 	0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0f, 0x2e, 0x61, 0x70, 0x69, 0x70, 0x62,
 	0x2e, 0x44, 0x69, 0x66, 0x66, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12,
@@ -613,17 +802,18 @@ var file_api_diff_api_proto_rawDesc = []byte{
 	0x12, 0x0a, 0x04, 0x46, 0x72, 0x6f, 0x6d, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x46,
 	0x72, 0x6f, 0x6d, 0x12, 0x0e, 0x0a, 0x02, 0x54, 0x6f, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52,
 	0x02, 0x54, 0x6f, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x18, 0x05, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x2a, 0x2e, 0x0a, 0x08, 0x44,
+	0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x2a, 0x3b, 0x0a, 0x08, 0x44,
 	0x69, 0x66, 0x66, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0a, 0x0a, 0x06, 0x43, 0x52, 0x45, 0x41, 0x54,
 	0x45, 0x10, 0x00, 0x12, 0x0a, 0x0a, 0x06, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x10, 0x01, 0x12,
-	0x0a, 0x0a, 0x06, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x10, 0x02, 0x32, 0x3a, 0x0a, 0x07, 0x44,
-	0x69, 0x66, 0x66, 0x41, 0x70, 0x69, 0x12, 0x2f, 0x0a, 0x04, 0x44, 0x69, 0x66, 0x66, 0x12, 0x12,
-	0x2e, 0x61, 0x70, 0x69, 0x70, 0x62, 0x2e, 0x44, 0x69, 0x66, 0x66, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x1a, 0x13, 0x2e, 0x61, 0x70, 0x69, 0x70, 0x62, 0x2e, 0x44, 0x69, 0x66, 0x66, 0x52,
-	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x2c, 0x5a, 0x2a, 0x67, 0x69, 0x74, 0x68, 0x75,
-	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x6e, 0x69, 0x6e, 0x6a,
-	0x61, 0x2f, 0x67, 0x69, 0x74, 0x6f, 0x70, 0x73, 0x2d, 0x72, 0x65, 0x70, 0x6f, 0x2d, 0x61, 0x70,
-	0x69, 0x2f, 0x61, 0x70, 0x69, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x0a, 0x0a, 0x06, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x10, 0x02, 0x12, 0x0b, 0x0a, 0x07, 0x52,
+	0x45, 0x50, 0x4c, 0x41, 0x43, 0x45, 0x10, 0x03, 0x32, 0x3a, 0x0a, 0x07, 0x44, 0x69, 0x66, 0x66,
+	0x41, 0x70, 0x69, 0x12, 0x2f, 0x0a, 0x04, 0x44, 0x69, 0x66, 0x66, 0x12, 0x12, 0x2e, 0x61, 0x70,
+	0x69, 0x70, 0x62, 0x2e, 0x44, 0x69, 0x66, 0x66, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x13, 0x2e, 0x61, 0x70, 0x69, 0x70, 0x62, 0x2e, 0x44, 0x69, 0x66, 0x66, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x42, 0x2c, 0x5a, 0x2a, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
+	0x6f, 0x6d, 0x2f, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x6e, 0x69, 0x6e, 0x6a, 0x61, 0x2f, 0x67,
+	0x69, 0x74, 0x6f, 0x70, 0x73, 0x2d, 0x72, 0x65, 0x70, 0x6f, 0x2d, 0x61, 0x70, 0x69, 0x2f, 0x61,
+	0x70, 0x69, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -639,37 +829,39 @@ func file_api_diff_api_proto_rawDescGZIP() []byte {
 }
 
 var file_api_diff_api_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_api_diff_api_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_api_diff_api_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_api_diff_api_proto_goTypes = []interface{}{
 	(DiffType)(0),                                   // 0: apipb.DiffType
 	(*DiffRequest)(nil),                             // 1: apipb.DiffRequest
 	(*Reference)(nil),                               // 2: apipb.Reference
 	(*Entrypoint)(nil),                              // 3: apipb.Entrypoint
 	(*Resource)(nil),                                // 4: apipb.Resource
-	(*DiffResponse)(nil),                            // 5: apipb.DiffResponse
-	(*DiffRequest_Filter)(nil),                      // 6: apipb.DiffRequest.Filter
-	(*DiffResponse_Diff)(nil),                       // 7: apipb.DiffResponse.Diff
-	(*DiffResponse_Diff_EntrypointDiff)(nil),        // 8: apipb.DiffResponse.Diff.EntrypointDiff
-	(*DiffResponse_Diff_EntrypointDiff_Change)(nil), // 9: apipb.DiffResponse.Diff.EntrypointDiff.Change
+	(*EntrypointError)(nil),                         // 5: apipb.EntrypointError
+	(*DiffResponse)(nil),                            // 6: apipb.DiffResponse
+	(*DiffRequest_Filter)(nil),                      // 7: apipb.DiffRequest.Filter
+	(*DiffResponse_Diff)(nil),                       // 8: apipb.DiffResponse.Diff
+	(*DiffResponse_Diff_EntrypointDiff)(nil),        // 9: apipb.DiffResponse.Diff.EntrypointDiff
+	(*DiffResponse_Diff_EntrypointDiff_Change)(nil), // 10: apipb.DiffResponse.Diff.EntrypointDiff.Change
 }
 var file_api_diff_api_proto_depIdxs = []int32{
 	2,  // 0: apipb.DiffRequest.From:type_name -> apipb.Reference
 	2,  // 1: apipb.DiffRequest.To:type_name -> apipb.Reference
-	6,  // 2: apipb.DiffRequest.Filters:type_name -> apipb.DiffRequest.Filter
-	7,  // 3: apipb.DiffResponse.Diffs:type_name -> apipb.DiffResponse.Diff
+	7,  // 2: apipb.DiffRequest.Filters:type_name -> apipb.DiffRequest.Filter
+	8,  // 3: apipb.DiffResponse.Diffs:type_name -> apipb.DiffResponse.Diff
 	3,  // 4: apipb.DiffResponse.Diff.Entrypoint:type_name -> apipb.Entrypoint
-	8,  // 5: apipb.DiffResponse.Diff.Changes:type_name -> apipb.DiffResponse.Diff.EntrypointDiff
-	0,  // 6: apipb.DiffResponse.Diff.EntrypointDiff.Type:type_name -> apipb.DiffType
-	4,  // 7: apipb.DiffResponse.Diff.EntrypointDiff.Pre:type_name -> apipb.Resource
-	4,  // 8: apipb.DiffResponse.Diff.EntrypointDiff.Post:type_name -> apipb.Resource
-	9,  // 9: apipb.DiffResponse.Diff.EntrypointDiff.Diffs:type_name -> apipb.DiffResponse.Diff.EntrypointDiff.Change
-	1,  // 10: apipb.DiffApi.Diff:input_type -> apipb.DiffRequest
-	5,  // 11: apipb.DiffApi.Diff:output_type -> apipb.DiffResponse
-	11, // [11:12] is the sub-list for method output_type
-	10, // [10:11] is the sub-list for method input_type
-	10, // [10:10] is the sub-list for extension type_name
-	10, // [10:10] is the sub-list for extension extendee
-	0,  // [0:10] is the sub-list for field type_name
+	9,  // 5: apipb.DiffResponse.Diff.Changes:type_name -> apipb.DiffResponse.Diff.EntrypointDiff
+	5,  // 6: apipb.DiffResponse.Diff.Errors:type_name -> apipb.EntrypointError
+	0,  // 7: apipb.DiffResponse.Diff.EntrypointDiff.Type:type_name -> apipb.DiffType
+	4,  // 8: apipb.DiffResponse.Diff.EntrypointDiff.Pre:type_name -> apipb.Resource
+	4,  // 9: apipb.DiffResponse.Diff.EntrypointDiff.Post:type_name -> apipb.Resource
+	10, // 10: apipb.DiffResponse.Diff.EntrypointDiff.Diffs:type_name -> apipb.DiffResponse.Diff.EntrypointDiff.Change
+	1,  // 11: apipb.DiffApi.Diff:input_type -> apipb.DiffRequest
+	6,  // 12: apipb.DiffApi.Diff:output_type -> apipb.DiffResponse
+	12, // [12:13] is the sub-list for method output_type
+	11, // [11:12] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
 }
 
 func init() { file_api_diff_api_proto_init() }
@@ -727,7 +919,7 @@ func file_api_diff_api_proto_init() {
 			}
 		}
 		file_api_diff_api_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DiffResponse); i {
+			switch v := v.(*EntrypointError); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -739,7 +931,7 @@ func file_api_diff_api_proto_init() {
 			}
 		}
 		file_api_diff_api_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DiffRequest_Filter); i {
+			switch v := v.(*DiffResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -751,7 +943,7 @@ func file_api_diff_api_proto_init() {
 			}
 		}
 		file_api_diff_api_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DiffResponse_Diff); i {
+			switch v := v.(*DiffRequest_Filter); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -763,7 +955,7 @@ func file_api_diff_api_proto_init() {
 			}
 		}
 		file_api_diff_api_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DiffResponse_Diff_EntrypointDiff); i {
+			switch v := v.(*DiffResponse_Diff); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -775,6 +967,18 @@ func file_api_diff_api_proto_init() {
 			}
 		}
 		file_api_diff_api_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DiffResponse_Diff_EntrypointDiff); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_diff_api_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*DiffResponse_Diff_EntrypointDiff_Change); i {
 			case 0:
 				return &v.state
@@ -793,7 +997,7 @@ func file_api_diff_api_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_api_diff_api_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   9,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
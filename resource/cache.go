@@ -0,0 +1,377 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	gogit "github.com/go-git/go-git/v5"
+	tfjson "github.com/hashicorp/terraform-json"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+// RenderCacheContextInputs are the paths in the repository an entrypoint renders from, which key its render cache
+// entries. Defaults to the inputs its differ finds, such as kustomize bases and local terraform modules. Entrypoints
+// whose differ can't find its inputs, or which read something it can't hash, are only cached when they're listed.
+const RenderCacheContextInputs = "inputs"
+
+// renderCacheVersion is part of every cache key, it must change whenever a renderer's output changes shape
+const renderCacheVersion = "3"
+
+func init() {
+	// Rendered values hold decoded YAML and JSON, whose dynamic types gob needs to know about
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+type RenderCacheOptions struct {
+	// Dir is where rendered entrypoints are kept between runs, nothing is cached when it's empty
+	Dir string
+}
+
+type renderCacheContextKey struct{}
+
+// WithRenderCache returns a context which caches the output of every renderer on disk
+func WithRenderCache(ctx context.Context, opts RenderCacheOptions) context.Context {
+	return context.WithValue(ctx, renderCacheContextKey{}, opts)
+}
+
+func renderCacheFromContext(ctx context.Context) RenderCacheOptions {
+	if ctx != nil {
+		if opts, ok := ctx.Value(renderCacheContextKey{}).(RenderCacheOptions); ok {
+			return opts
+		}
+	}
+	return RenderCacheOptions{}
+}
+
+// renderVersioned is implemented by differs whose output depends on a tool outside the repository
+type renderVersioned interface {
+	renderVersion(ctx context.Context) string
+}
+
+// renderInputLister is implemented by differs which can find every file the entrypoint at dir renders from. ok is
+// false when it reads something which can't be hashed, such as a remote kustomize base or terraform module.
+type renderInputLister interface {
+	renderInputs(ep entrypoint.Entrypoint, dir string) ([]string, bool)
+}
+
+type renderCacheKey struct {
+	Version   string                    `json:"version"`
+	Renderer  string                    `json:"renderer"`
+	Type      entrypoint.EntrypointType `json:"type"`
+	Directory string                    `json:"directory"`
+	Context   map[string]interface{}    `json:"context"`
+	Inputs    map[string]string         `json:"inputs"`
+	// SopsKey is whether SOPS encrypted files could be decrypted, they render with placeholders when they can't
+	SopsKey bool `json:"sopsKey"`
+}
+
+// renderInputKey hashes everything the entrypoint at dir renders from. The key is empty when the render cache is
+// disabled or the inputs can't be known, such as when dir isn't in a git checkout, so that it's always rendered.
+func renderInputKey[T any](ctx context.Context, ep entrypoint.Entrypoint, dir string) string {
+	if dir == "" || renderCacheFromContext(ctx).Dir == "" {
+		return ""
+	}
+
+	var zero T
+	renderer := fmt.Sprintf("%T", zero)
+	differRegistryLock.RLock()
+	differ := differRegistry[ep.Type]
+	differRegistryLock.RUnlock()
+	if rv, ok := differ.(renderVersioned); ok {
+		renderer += " " + rv.renderVersion(ctx)
+	}
+
+	paths := contextStringList(ep.Context[RenderCacheContextInputs])
+	if len(paths) == 0 {
+		lister, ok := differ.(renderInputLister)
+		if !ok {
+			return ""
+		}
+		if paths, ok = renderRepositoryPaths(lister, ep, dir); !ok {
+			return ""
+		}
+	}
+	inputs, err := renderInputs(dir, paths)
+	if err != nil {
+		return ""
+	}
+
+	b, err := json.Marshal(renderCacheKey{
+		Version:   renderCacheVersion,
+		Renderer:  renderer,
+		Type:      ep.Type,
+		Directory: ep.Directory,
+		Context:   ep.Context,
+		Inputs:    inputs,
+		SopsKey:   sopsKeyAvailable(ctx),
+	})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// renderRepositoryPaths returns the inputs found by lister as paths in the repository dir is checked out in, ok is
+// false when any of them are outside of it
+func renderRepositoryPaths(lister renderInputLister, ep entrypoint.Entrypoint, dir string) ([]string, bool) {
+	files, ok := lister.renderInputs(ep, dir)
+	if !ok {
+		return nil, false
+	}
+	root := renderRepositoryRoot(dir)
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	paths := []string{}
+	for _, file := range files {
+		if resolved, err := filepath.EvalSymlinks(file); err == nil {
+			file = resolved
+		} else if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return nil, false
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, false
+		}
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	return paths, true
+}
+
+// renderInputs returns the git object hash of each of paths in the repository, from the commit checked out in dir
+func renderInputs(dir string, paths []string) (map[string]string, error) {
+	repo, err := gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open repository of %q - %w", dir, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve HEAD of %q - %w", dir, err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("unable to load commit %s - %w", head.Hash(), err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load tree of commit %s - %w", head.Hash(), err)
+	}
+
+	inputs := map[string]string{}
+	for _, p := range paths {
+		p = strings.Trim(path.Clean("/"+p), "/")
+		if p == "" {
+			inputs["/"] = tree.Hash.String()
+			continue
+		}
+		// A missing input is recorded as such, so adding it later changes the key
+		entry, err := tree.FindEntry(p)
+		if err != nil {
+			inputs[p] = ""
+			continue
+		}
+		inputs[p] = entry.Hash.String()
+	}
+	return inputs, nil
+}
+
+func encodeRender[T any](v T) ([]byte, error) {
+	switch val := any(v).(type) {
+	case resmap.ResMap:
+		return val.AsYaml()
+	case *tfjson.Plan:
+		// Plans hold cty types gob can't encode, they are terraform's own JSON format anyway
+		return json.Marshal(val)
+	}
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRender[T any](b []byte) (T, error) {
+	var v T
+	switch ptr := any(&v).(type) {
+	case *resmap.ResMap:
+		decoded, err := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory()).NewResMapFromBytes(b)
+		if err != nil {
+			return v, err
+		}
+		*ptr = decoded
+		return v, nil
+	case **tfjson.Plan:
+		err := json.Unmarshal(b, ptr)
+		return v, err
+	}
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+// extractCached renders the entrypoint at dir unless the cache already holds its output for the same inputs.
+// Failing to read or write the cache never fails the render.
+func extractCached[T any](ctx context.Context, key string, dir string, ep entrypoint.Entrypoint, extract ResourceExtractor[T]) (T, error) {
+	cacheDir := renderCacheFromContext(ctx).Dir
+	if cacheDir == "" || key == "" {
+		return extract(dir, ep)
+	}
+
+	file := filepath.Join(cacheDir, key[:2], key)
+	if b, err := os.ReadFile(file); err == nil {
+		if v, err := decodeRender[T](b); err == nil {
+			return v, nil
+		}
+	}
+
+	v, err := extract(dir, ep)
+	if err != nil {
+		return v, err
+	}
+	// Renders are cached before they're redacted, so those holding secrets are never written to disk
+	if renderHoldsSecrets(v) {
+		return v, nil
+	}
+	if b, err := encodeRender(v); err == nil {
+		writeRenderCache(file, b)
+	}
+	return v, nil
+}
+
+// renderHoldsSecrets reports whether a render holds values which are redacted, such as kubernetes Secrets, values
+// decrypted from SOPS files and sensitive terraform values
+func renderHoldsSecrets[T any](v T) bool {
+	switch val := any(v).(type) {
+	case resmap.ResMap:
+		if val == nil {
+			return false
+		}
+		for _, r := range val.Resources() {
+			if r.GetKind() == "Secret" || r.GetAnnotations()[sopsDecryptedAnnotation] == "true" {
+				return true
+			}
+		}
+	case *tfjson.Plan:
+		if val == nil {
+			return false
+		}
+		for _, rc := range val.ResourceChanges {
+			if rc.Change != nil && (containsTrue(rc.Change.BeforeSensitive) || containsTrue(rc.Change.AfterSensitive)) {
+				return true
+			}
+		}
+		for _, oc := range val.OutputChanges {
+			if oc != nil && (containsTrue(oc.BeforeSensitive) || containsTrue(oc.AfterSensitive)) {
+				return true
+			}
+		}
+		if val.Config != nil && val.Config.RootModule != nil {
+			for _, variable := range val.Config.RootModule.Variables {
+				if variable != nil && variable.Sensitive {
+					return true
+				}
+			}
+		}
+	case map[string]*TerraformResource:
+		for _, r := range val {
+			if containsTrue(r.Sensitive) {
+				return true
+			}
+		}
+	case *ComposeProject:
+		return val != nil && len(val.SopsEncrypted) > 0
+	case map[string]*ConfigResource:
+		for _, r := range val {
+			if len(r.SopsEncrypted) > 0 {
+				return true
+			}
+		}
+	case map[string]*PluginResource:
+		for _, r := range val {
+			if len(r.Sensitive) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsTrue reports whether a terraform sensitivity marker marks anything as sensitive
+func containsTrue(v interface{}) bool {
+	switch val := v.(type) {
+	case bool:
+		return val
+	case map[string]interface{}:
+		for _, child := range val {
+			if containsTrue(child) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range val {
+			if containsTrue(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toolVersion identifies the executables a renderer runs by their path, size and modification time, so that
+// installing another version changes the cache key without having to run them
+func toolVersion(executables ...string) string {
+	versions := []string{}
+	for _, executable := range executables {
+		p, err := exec.LookPath(executable)
+		if err != nil {
+			versions = append(versions, executable+" missing")
+			continue
+		}
+		version := p
+		if info, err := os.Stat(p); err == nil {
+			version += fmt.Sprintf(" %d %d", info.Size(), info.ModTime().UnixNano())
+		}
+		versions = append(versions, version)
+	}
+	return strings.Join(versions, ", ")
+}
+
+// writeRenderCache writes through a temporary file, so concurrent runs never read a partial entry
+func writeRenderCache(file string, b []byte) {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".render-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,240 @@
+package resource
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/git"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+const testOverlayKustomization = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - ../base
+namePrefix: prod-
+`
+
+const testBaseKustomization = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - configmap.yaml
+`
+
+// commitFixture writes files to a new repository and commits them, returning the checkout
+func commitFixture(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	writeFixture(t, dir, files)
+	repo, err := gogit.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("unable to create repository - %s", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("unable to open worktree - %s", err)
+	}
+	if err := wt.AddGlob("."); err != nil {
+		t.Fatalf("unable to add fixture - %s", err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(0, 0)}
+	if _, err := wt.Commit("fixture", &gogit.CommitOptions{Author: sig, Committer: sig}); err != nil {
+		t.Fatalf("unable to commit fixture - %s", err)
+	}
+	return dir
+}
+
+func TestRenderCacheInputs(t *testing.T) {
+	changedConfigMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  key: changed
+`
+	pre := commitFixture(t, map[string]string{
+		"overlay/kustomization.yaml": testOverlayKustomization,
+		"base/kustomization.yaml":    testBaseKustomization,
+		"base/configmap.yaml":        testConfigMap,
+	})
+	post := commitFixture(t, map[string]string{
+		"overlay/kustomization.yaml": testOverlayKustomization,
+		"base/kustomization.yaml":    testBaseKustomization,
+		"base/configmap.yaml":        changedConfigMap,
+	})
+	same := commitFixture(t, map[string]string{
+		"overlay/kustomization.yaml": testOverlayKustomization,
+		"base/kustomization.yaml":    testBaseKustomization,
+		"base/configmap.yaml":        testConfigMap,
+	})
+	remote := commitFixture(t, map[string]string{
+		"overlay/kustomization.yaml": "resources:\n  - https://github.com/example/repo//base?ref=main\n",
+	})
+
+	ep := entrypoint.Entrypoint{Name: "overlay", Directory: "overlay", Type: entrypoint.EntrypointTypeKustomize}
+	rs := git.NewRepoSpec("https://example.com/repo.git", nil)
+	cached := WithRenderCache(context.Background(), RenderCacheOptions{Dir: t.TempDir()})
+
+	tcs := []struct {
+		name    string
+		ctx     context.Context
+		post    string
+		changes int
+	}{
+		{name: "base changed without cache", ctx: context.Background(), post: post, changes: 1},
+		{name: "base changed with cache", ctx: cached, post: post, changes: 1},
+		{name: "unchanged without cache", ctx: context.Background(), post: same, changes: 0},
+		{name: "unchanged with cache", ctx: cached, post: same, changes: 0},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			diff, _, _, err := (&kustomizeDiffer{}).Diff(tc.ctx, rs, ep, filepath.Join(pre, "overlay"), filepath.Join(tc.post, "overlay"))
+			if err != nil {
+				t.Fatalf("unable to diff - %s", err)
+			}
+			if len(diff) != tc.changes {
+				t.Errorf("got %d changes, expected %d", len(diff), tc.changes)
+			}
+		})
+	}
+
+	keyTcs := []struct {
+		name  string
+		ctx   context.Context
+		dir   string
+		equal bool
+	}{
+		{name: "no cache", ctx: context.Background(), dir: same, equal: false},
+		{name: "base changed", ctx: cached, dir: post, equal: false},
+		{name: "unchanged", ctx: cached, dir: same, equal: true},
+	}
+	for _, tc := range keyTcs {
+		t.Run("key "+tc.name, func(t *testing.T) {
+			preKey := renderInputKey[resmap.ResMap](tc.ctx, ep, filepath.Join(pre, "overlay"))
+			postKey := renderInputKey[resmap.ResMap](tc.ctx, ep, filepath.Join(tc.dir, "overlay"))
+			if equal := preKey != "" && preKey == postKey; equal != tc.equal {
+				t.Errorf("got keys %q and %q, expected equal to be %v", preKey, postKey, tc.equal)
+			}
+		})
+	}
+
+	if key := renderInputKey[resmap.ResMap](cached, ep, filepath.Join(remote, "overlay")); key != "" {
+		t.Errorf("got key %q for a remote base, expected none", key)
+	}
+}
+
+func TestRenderRepositoryPaths(t *testing.T) {
+	tcs := []struct {
+		name     string
+		lister   renderInputLister
+		files    map[string]string
+		dir      string
+		epctx    map[string]interface{}
+		expected []string
+		ok       bool
+	}{
+		{
+			name:     "kubernetes",
+			lister:   &kubeDiffer{},
+			files:    map[string]string{"app/configmap.yaml": testConfigMap},
+			dir:      "app",
+			expected: []string{"app"},
+			ok:       true,
+		},
+		{
+			name:   "kustomize overlay",
+			lister: &kustomizeDiffer{},
+			files: map[string]string{
+				"overlay/kustomization.yaml": testOverlayKustomization + "patches:\n  - path: ../patches/replicas.yaml\nconfigMapGenerator:\n  - name: env\n    files:\n      - key=../config/app.properties\n",
+				"base/kustomization.yaml":    testBaseKustomization,
+				"base/configmap.yaml":        testConfigMap,
+				"patches/replicas.yaml":      testDeployment,
+				"config/app.properties":      "key=value\n",
+			},
+			dir:      "overlay",
+			expected: []string{"base", "base/configmap.yaml", "config/app.properties", "overlay", "patches/replicas.yaml"},
+			ok:       true,
+		},
+		{
+			name:   "kustomize remote base",
+			lister: &kustomizeDiffer{},
+			files:  map[string]string{"overlay/kustomization.yaml": "resources:\n  - github.com/example/repo//base?ref=main\n"},
+			dir:    "overlay",
+		},
+		{
+			name:   "kustomize chart without version",
+			lister: &kustomizeDiffer{},
+			files:  map[string]string{"app/kustomization.yaml": testHelmKustomization},
+			dir:    "app",
+		},
+		{
+			name:   "terraform local modules",
+			lister: &tfDiffer{},
+			files: map[string]string{
+				"envs/prod/main.tf":     "module \"app\" {\n  source = \"../../modules/app\"\n}\n",
+				"modules/app/main.tf":   "module \"db\" {\n  source = \"../db\"\n}\n",
+				"modules/db/main.tf":    "resource \"null_resource\" \"db\" {}\n",
+				"envs/prod/prod.tfvars": "size = 1\n",
+			},
+			dir:      "envs/prod",
+			epctx:    map[string]interface{}{TerraformContextVarFiles: []interface{}{"prod.tfvars"}},
+			expected: []string{"envs/prod", "envs/prod/prod.tfvars", "modules/app", "modules/db"},
+			ok:       true,
+		},
+		{
+			name:   "terraform registry module",
+			lister: &tfDiffer{},
+			files:  map[string]string{"main.tf": "module \"vpc\" {\n  source = \"terraform-aws-modules/vpc/aws\"\n}\n"},
+			dir:    ".",
+		},
+		{
+			name:   "terraform state",
+			lister: &tfDiffer{},
+			files:  map[string]string{"main.tf": "resource \"null_resource\" \"a\" {}\n"},
+			dir:    ".",
+			epctx:  map[string]interface{}{TerraformContextState: "terraform.tfstate"},
+		},
+		{
+			name:   "cloudformation nested stacks",
+			lister: &cfnDiffer{},
+			files: map[string]string{
+				"stacks/parent.yaml": "Resources:\n  Child:\n    Type: AWS::CloudFormation::Stack\n    Properties:\n      TemplateURL: ../nested/child.yaml\n",
+				"nested/child.yaml":  "Resources:\n  Bucket:\n    Type: AWS::S3::Bucket\n",
+				"params/prod.json":   "{}\n",
+			},
+			dir:      "stacks/parent.yaml",
+			epctx:    map[string]interface{}{CloudformationContextParameters: "../params/prod.json"},
+			expected: []string{"nested/child.yaml", "params/prod.json", "stacks/parent.yaml"},
+			ok:       true,
+		},
+		{
+			name:   "cue module",
+			lister: &cueDiffer{},
+			files: map[string]string{
+				"cue.mod/module.cue": "module: \"example.com/app\"\n",
+				"apps/web/web.cue":   "package web\n",
+			},
+			dir:      "apps/web",
+			expected: []string{"."},
+			ok:       true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := commitFixture(t, tc.files)
+			ep := entrypoint.Entrypoint{Name: tc.name, Directory: tc.dir, Context: tc.epctx}
+			paths, ok := renderRepositoryPaths(tc.lister, ep, filepath.Join(repo, tc.dir))
+			if ok != tc.ok {
+				t.Fatalf("got ok %v, expected %v", ok, tc.ok)
+			}
+			if !reflect.DeepEqual(paths, tc.expected) {
+				t.Errorf("got inputs %v, expected %v", paths, tc.expected)
+			}
+		})
+	}
+}
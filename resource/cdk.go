@@ -2,70 +2,260 @@ package resource
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/codingninja/gitops-repo-api/entrypoint"
 	"github.com/codingninja/gitops-repo-api/git"
+)
+
+const (
+	// CdkContextOutput is an existing cloud assembly relative to the app, which is used instead of running synth
+	// when it has a manifest.json. Defaults to cdk.out.
+	CdkContextOutput = "output"
+	// CdkContextValues are passed to synth as -c key=value, complex values are written as JSON
+	CdkContextValues = "context"
+)
 
-	"gopkg.in/yaml.v3"
+const (
+	cdkDefaultOutput     = "cdk.out"
+	cdkStackArtifact     = "aws:cloudformation:stack"
+	cdkAssemblyArtifact  = "cdk:cloud-assembly"
+	cdkAssetPathMetadata = "aws:asset:path"
 )
 
+// The tools are looked up when a CDK entrypoint is first rendered, unless their path has already been set
 var NpxExecutable = "npx"
 var NpmExecutable = "npm"
 var NpxExecutablePath = ""
 var NpmExecutablePath = ""
 
-func init() {
-	npxPath, err := exec.LookPath(NpxExecutable)
-	if err != nil {
-		panic(fmt.Errorf("unable to locate %s executable - %w", NpxExecutable, err))
-	}
-	NpxExecutablePath = npxPath
+type cdkManifest struct {
+	Artifacts map[string]cdkArtifact `json:"artifacts"`
+}
+
+type cdkArtifact struct {
+	Type        string `json:"type"`
+	Environment string `json:"environment"`
+	DisplayName string `json:"displayName"`
+	Properties  struct {
+		TemplateFile  string `json:"templateFile"`
+		StackName     string `json:"stackName"`
+		DirectoryName string `json:"directoryName"`
+	} `json:"properties"`
+}
 
-	npmPathd, err := exec.LookPath(NpmExecutable)
+func cdkTool(path, name string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	found, err := exec.LookPath(name)
 	if err != nil {
-		panic(fmt.Errorf("unable to locate %s executable - %w", NpxExecutable, err))
+		return "", toolMissingError(name, err)
 	}
-	NpmExecutablePath = npmPathd
+	return found, nil
 }
-func RenderCdk(cdkDir string) (*CloudformationTemplate, error) {
+
+// RenderCdk loads every stack of the CDK app's cloud assembly, synthesizing it into a temporary directory when
+// the app doesn't already have one. Each stack is resolved with the entrypoint context and recorded in Nested
+// of the returned template against its display name, so resources are identified as Stack/LogicalId.
+func RenderCdk(ctx context.Context, cdkDir string, epctx map[string]interface{}) (*CloudformationTemplate, error) {
 	cdkDir = strings.TrimSuffix(cdkDir, "cdk.json")
-	// Open a template from file (can be JSON or YAML)
-	ciCmd := exec.Command(NpmExecutablePath, "ci")
-	ciCmd.Dir = cdkDir
-	npmCiRes, err := ciCmd.CombinedOutput()
+
+	output, _ := epctx[CdkContextOutput].(string)
+	if output == "" {
+		output = cdkDefaultOutput
+	}
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(cdkDir, output)
+	}
+	if _, err := os.Stat(filepath.Join(output, "manifest.json")); err != nil {
+		synthDir, err := os.MkdirTemp("", "gitops-repo-api-cdk")
+		if err != nil {
+			return nil, fmt.Errorf("unable to create cloud assembly directory - %w", err)
+		}
+		defer os.RemoveAll(synthDir)
+		if err := synthCdk(ctx, cdkDir, synthDir, epctx); err != nil {
+			return nil, err
+		}
+		output = synthDir
+	}
+
+	app := &CloudformationTemplate{Nested: map[string]*CloudformationTemplate{}}
+	if err := cdkLoadAssembly(app, output, cdkDir, epctx); err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// synthCdk synthesizes the app into output using only the packages already installed or in the npm cache. The app
+// is synthesized from a working copy, as its packages are installed and it may write files such as
+// cdk.context.json while synthesizing.
+func synthCdk(ctx context.Context, cdkDir, output string, epctx map[string]interface{}) error {
+	npx, err := cdkTool(NpxExecutablePath, NpxExecutable)
+	if err != nil {
+		return err
+	}
+	cdkDir, err = resolveRenderDir(cdkDir)
 	if err != nil {
-		return nil, fmt.Errorf("unable to run `%s` - %w - %s", ciCmd.String(), err, npmCiRes)
+		return fmt.Errorf("unable to resolve CDK app directory - %w", err)
+	}
+	appDir, cleanup, err := renderWorkingCopy(cdkDir, true, "node_modules", cdkDefaultOutput)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	// Packages already installed in the checkout are linked rather than copied
+	if _, err := os.Stat(filepath.Join(cdkDir, "node_modules")); err == nil {
+		if err := os.Symlink(filepath.Join(cdkDir, "node_modules"), filepath.Join(appDir, "node_modules")); err != nil {
+			return fmt.Errorf("unable to link node_modules into working copy - %w", err)
+		}
+	}
+	cdkDir = appDir
+	if _, err := os.Stat(filepath.Join(cdkDir, "node_modules")); err != nil {
+		npm, err := cdkTool(NpmExecutablePath, NpmExecutable)
+		if err != nil {
+			return err
+		}
+		ciCmd := renderCommand(ctx, npm, "ci", "--offline")
+		ciCmd.Dir = cdkDir
+		npmCiRes, err := ciCmd.CombinedOutput()
+		if err != nil {
+			return commandError(ciCmd, err, npmCiRes)
+		}
+	}
+
+	args := []string{"--no-install", "aws-cdk", "synth", "--quiet", "--output", output}
+	values, _ := epctx[CdkContextValues].(map[string]interface{})
+	keys := []string{}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch v := values[k].(type) {
+		case string:
+			args = append(args, "-c", fmt.Sprintf("%s=%s", k, v))
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("unable to encode context %q - %w", k, err)
+			}
+			args = append(args, "-c", fmt.Sprintf("%s=%s", k, b))
+		}
 	}
-	synthCmd := exec.Command(NpxExecutablePath, "aws-cdk", "synth")
+
+	synthCmd := renderCommand(ctx, npx, args...)
 	synthCmd.Env = append(os.Environ(), "JSII_SILENCE_WARNING_DEPRECATED_NODE_VERSION=1")
 	synthCmd.Dir = cdkDir
-	tpl, err := synthCmd.CombinedOutput()
+	synthRes, err := synthCmd.CombinedOutput()
+	if err != nil {
+		return commandError(synthCmd, err, synthRes)
+	}
+	return nil
+}
+
+// cdkLoadAssembly adds every stack in the cloud assembly to app, descending into the assemblies of stages
+func cdkLoadAssembly(app *CloudformationTemplate, assemblyDir, cdkDir string, epctx map[string]interface{}) error {
+	content, err := os.ReadFile(filepath.Join(assemblyDir, "manifest.json"))
 	if err != nil {
-		return nil, fmt.Errorf("unable to run `%s` - %w - %s", synthCmd.String(), err, tpl)
+		return fmt.Errorf("unable to read cloud assembly manifest - %w", err)
+	}
+	manifest := cdkManifest{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return fmt.Errorf("unable to parse cloud assembly manifest %q - %w", assemblyDir, err)
+	}
+
+	ids := []string{}
+	for id := range manifest.Artifacts {
+		ids = append(ids, id)
 	}
-	cft := &CloudformationTemplate{}
-	if err := yaml.Unmarshal([]byte(tpl), &cft); err != nil {
+	sort.Strings(ids)
+	for _, id := range ids {
+		artifact := manifest.Artifacts[id]
+		switch artifact.Type {
+		case cdkAssemblyArtifact:
+			if err := cdkLoadAssembly(app, filepath.Join(assemblyDir, artifact.Properties.DirectoryName), cdkDir, epctx); err != nil {
+				return err
+			}
+		case cdkStackArtifact:
+			name := artifact.DisplayName
+			if name == "" {
+				name = id
+			}
+			tpl, err := cdkLoadStack(artifact, id, assemblyDir, cdkDir, epctx)
+			if err != nil {
+				return fmt.Errorf("unable to load stack %q - %w", name, err)
+			}
+			app.Nested[name] = tpl
+		}
+	}
+
+	return nil
+}
+
+func cdkLoadStack(artifact cdkArtifact, id, assemblyDir, cdkDir string, epctx map[string]interface{}) (*CloudformationTemplate, error) {
+	templatePath := filepath.Join(assemblyDir, artifact.Properties.TemplateFile)
+	tpl, err := RenderCloudformation(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err = ExpandServerless(tpl)
+	if err != nil {
 		return nil, err
 	}
 
-	return cft, nil
+	// The stack's environment takes precedence over the entrypoint's
+	stackCtx := map[string]interface{}{}
+	for k, v := range epctx {
+		stackCtx[k] = v
+	}
+	stackCtx[CloudformationContextStackName] = id
+	if artifact.Properties.StackName != "" {
+		stackCtx[CloudformationContextStackName] = artifact.Properties.StackName
+	}
+	if account, region, ok := strings.Cut(strings.TrimPrefix(artifact.Environment, "aws://"), "/"); ok {
+		if account != "unknown-account" {
+			stackCtx[CloudformationContextAccountId] = account
+		}
+		if region != "unknown-region" {
+			stackCtx[CloudformationContextRegion] = region
+		}
+	}
+
+	// Parameter files are relative to the app rather than the assembly
+	tpl, err = ResolveCloudformation(tpl, stackCtx, cdkDir)
+	if err != nil {
+		return nil, err
+	}
+	return ResolveNestedStacks(tpl, stackCtx, templatePath)
 }
 
 type cdkDiffer struct {
 }
 
-func (td *cdkDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
-	// Won't actually run concurrently because we block during CFN builds currently due to a concurrent map read/write related to intrinsic funcs in cfn library
-	old, new, err := extractConcurrent(ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (*CloudformationTemplate, error) {
-		return RenderCdk(dir)
-	})
-	if err != nil && old == nil && new == nil {
-		return nil, nil, nil, fmt.Errorf("error extracting cloudformation from CDK - %w", err)
+// renderVersion changes with the node tooling apps are installed and synthesized with
+func (td *cdkDiffer) renderVersion(ctx context.Context) string {
+	npx, npm := NpxExecutable, NpmExecutable
+	if NpxExecutablePath != "" {
+		npx = NpxExecutablePath
+	}
+	if NpmExecutablePath != "" {
+		npm = NpmExecutablePath
 	}
+	return toolVersion(npx, npm, "node")
+}
 
-	return doCfnDiff(ctx, old, new)
+func (td *cdkDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
+	return diffConcurrent(ctx, ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (*CloudformationTemplate, error) {
+		return RenderCdk(ctx, dir, ep.Context)
+	}, func(old, new *CloudformationTemplate) ([]ResourceDiff, []Resource, []Resource, error) {
+		return doCfnDiff(ctx, old, new)
+	})
 }
@@ -0,0 +1,103 @@
+package resource
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const cfnStackType = "AWS::CloudFormation::Stack"
+
+// ResolveNestedStacks loads the template of every AWS::CloudFormation::Stack whose TemplateURL is a path in the
+// repo, including those expanded from AWS::Serverless::Application. Each nested template is resolved with the
+// parameters its stack passes it and recorded in Nested against the stack's logical id, recursively.
+func ResolveNestedStacks(tpl *CloudformationTemplate, epctx map[string]interface{}, templatePath string) (*CloudformationTemplate, error) {
+	return cfnResolveNested(tpl, epctx, templatePath, map[string]bool{})
+}
+
+func cfnResolveNested(tpl *CloudformationTemplate, epctx map[string]interface{}, templatePath string, ancestors map[string]bool) (*CloudformationTemplate, error) {
+	if tpl == nil {
+		return nil, nil
+	}
+	abs, err := filepath.Abs(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to locate template %q - %w", templatePath, err)
+	}
+	chain := map[string]bool{abs: true}
+	for path := range ancestors {
+		chain[path] = true
+	}
+
+	// Nested stacks get a generated name, so AWS::StackName is only known for the entrypoint's stack
+	childCtx := map[string]interface{}{}
+	for k, v := range epctx {
+		if k != CloudformationContextStackName && k != CloudformationContextParameters {
+			childCtx[k] = v
+		}
+	}
+
+	names := []string{}
+	for name, res := range tpl.Resources {
+		if res.Type == cfnStackType {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := *tpl
+	out.Nested = map[string]*CloudformationTemplate{}
+	for _, name := range names {
+		res := tpl.Resources[name]
+		childPath, ok := cfnLocalTemplate(templatePath, res.Properties["TemplateURL"])
+		if !ok {
+			// CDK uploads nested templates as assets, the metadata names the file in the cloud assembly
+			childPath, ok = cfnLocalTemplate(templatePath, res.Metadata[cdkAssetPathMetadata])
+		}
+		if !ok {
+			continue
+		}
+		childAbs, err := filepath.Abs(childPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to locate template of nested stack %q - %w", name, err)
+		}
+		if chain[childAbs] {
+			return nil, fmt.Errorf("nested stack %q includes template %q which is already being loaded", name, childPath)
+		}
+
+		child, err := RenderCloudformation(childPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load nested stack %q - %w", name, err)
+		}
+		child, err = ExpandServerless(child)
+		if err != nil {
+			return nil, fmt.Errorf("unable to expand nested stack %q - %w", name, err)
+		}
+		params, _ := res.Properties["Parameters"].(map[string]interface{})
+		child = resolveCloudformation(child, childCtx, params)
+		child, err = cfnResolveNested(child, childCtx, childPath, chain)
+		if err != nil {
+			return nil, err
+		}
+		out.Nested[name] = child
+	}
+
+	return &out, nil
+}
+
+// cfnLocalTemplate returns the path of a TemplateURL which refers to a file, relative to the parent template
+func cfnLocalTemplate(templatePath string, templateUrl interface{}) (string, bool) {
+	url, ok := templateUrl.(string)
+	if !ok || url == "" || strings.Contains(url, "://") || strings.Contains(url, "${") {
+		return "", false
+	}
+	if filepath.IsAbs(url) {
+		return url, true
+	}
+	base := templatePath
+	if info, err := os.Stat(templatePath); err != nil || !info.IsDir() {
+		base = filepath.Dir(templatePath)
+	}
+	return filepath.Join(base, url), true
+}
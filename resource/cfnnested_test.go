@@ -0,0 +1,183 @@
+package resource
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const testParentTemplate = `Parameters:
+  Env:
+    Type: String
+    Default: dev
+Resources:
+  Child:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: stacks/child.yaml
+      Parameters:
+        Env: !Ref Env
+`
+
+const testChildTemplate = `Parameters:
+  Env:
+    Type: String
+Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketName: !Sub "app-${Env}"
+`
+
+func TestResolveNestedStacks(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		// want are the resources of the template and its nested stacks, and the name of the bucket
+		want    []string
+		bucket  string
+		wantErr bool
+	}{
+		{
+			name:   "nested stack with parameters",
+			files:  map[string]string{"template.yaml": testParentTemplate, "stacks/child.yaml": testChildTemplate},
+			want:   []string{"Child", "Child/Bucket", "Child/Parameters/Env", "Parameters/Env"},
+			bucket: "app-dev",
+		},
+		{
+			name: "nested stacks within nested stacks",
+			files: map[string]string{
+				"template.yaml": testParentTemplate,
+				"stacks/child.yaml": `Parameters:
+  Env:
+    Type: String
+Resources:
+  Grandchild:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: grandchild.yaml
+      Parameters:
+        Env: !Sub "${Env}-nested"
+`,
+				"stacks/grandchild.yaml": testChildTemplate,
+			},
+			want:   []string{"Child", "Child/Grandchild", "Child/Grandchild/Bucket", "Child/Grandchild/Parameters/Env", "Child/Parameters/Env", "Parameters/Env"},
+			bucket: "app-dev-nested",
+		},
+		{
+			name: "serverless application",
+			files: map[string]string{
+				"template.yaml": `Transform: AWS::Serverless-2016-10-31
+Resources:
+  App:
+    Type: AWS::Serverless::Application
+    Properties:
+      Location: stacks/child.yaml
+      Parameters:
+        Env: prod
+`,
+				"stacks/child.yaml": testChildTemplate,
+			},
+			want:   []string{"App", "App/Bucket", "App/Parameters/Env", "Transform"},
+			bucket: "app-prod",
+		},
+		{
+			name: "remote template",
+			files: map[string]string{"template.yaml": `Resources:
+  Child:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: https://example.com/child.yaml
+`},
+			want: []string{"Child"},
+		},
+		{
+			name:    "missing template",
+			files:   map[string]string{"template.yaml": testParentTemplate},
+			wantErr: true,
+		},
+		{
+			name: "template includes itself",
+			files: map[string]string{"template.yaml": `Resources:
+  Child:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: ./template.yaml
+`},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFixture(t, dir, tt.files)
+			templatePath := filepath.Join(dir, "template.yaml")
+			tpl, err := ExpandServerless(loadTestTemplate(t, tt.files["template.yaml"]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tpl, err = ResolveCloudformation(tpl, nil, templatePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tpl, err = ResolveNestedStacks(tpl, nil, templatePath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, expected an error: %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			resources := cfnTemplateResources(tpl)
+			got := []string{}
+			for key := range resources {
+				got = append(got, key)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got resources %v, expected %v", got, tt.want)
+			}
+			for key, res := range resources {
+				if res.Resource.Type != "AWS::S3::Bucket" {
+					continue
+				}
+				if res.Name() != key {
+					t.Errorf("bucket is named %q, expected %q", res.Name(), key)
+				}
+				if name := res.Resource.Properties["BucketName"]; name != tt.bucket {
+					t.Errorf("got bucket name %v, expected %q", name, tt.bucket)
+				}
+			}
+		})
+	}
+}
+
+func TestCfnLocalTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		url  interface{}
+		want string
+		ok   bool
+	}{
+		{name: "relative path", url: "stacks/child.yaml", want: "/repo/infra/stacks/child.yaml", ok: true},
+		{name: "parent directory", url: "../shared/child.yaml", want: "/repo/shared/child.yaml", ok: true},
+		{name: "absolute path", url: "/templates/child.yaml", want: "/templates/child.yaml", ok: true},
+		{name: "url", url: "https://bucket.s3.amazonaws.com/child.yaml"},
+		{name: "s3 url", url: "s3://bucket/child.yaml"},
+		{name: "substitution", url: "${AssetsBucket}/child.yaml"},
+		{name: "intrinsic function", url: map[string]interface{}{"Fn::Sub": "child.yaml"}},
+		{name: "empty", url: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := cfnLocalTemplate("/repo/infra/template.yaml", tt.url)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %q %t, expected %q %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// cfnCdkPathMetadata is the metadata CDK records the construct path of each resource in
+const cfnCdkPathMetadata = "aws:cdk:path"
+
+// cfnRenameSimilarity is how much of their properties a deleted and a created resource must share to be paired
+const cfnRenameSimilarity = 0.8
+
+type cfnRenamePairs struct {
+	// byNew maps the key of a created resource to the key of the deleted resource it was renamed from
+	byNew map[string]string
+	// pairedOld is the set of deleted resources which have been paired with a created resource
+	pairedOld map[string]bool
+}
+
+// cfnPairRenamed pairs resources which were deleted with resources of the same type which were created, either
+// because CDK built them from the same construct path or because their properties are nearly identical. Changing
+// a logical id still replaces the resource, but the reviewer sees one replacement rather than unrelated churn.
+func cfnPairRenamed(oldRes, newRes map[string]*CloudformationResource) *cfnRenamePairs {
+	pairs := &cfnRenamePairs{
+		byNew:     map[string]string{},
+		pairedOld: map[string]bool{},
+	}
+
+	deleted := []string{}
+	for key, res := range oldRes {
+		if _, ok := newRes[key]; !ok && !strings.HasPrefix(res.Resource.Type, "Template::") {
+			deleted = append(deleted, key)
+		}
+	}
+	created := []string{}
+	for key, res := range newRes {
+		if _, ok := oldRes[key]; !ok && !strings.HasPrefix(res.Resource.Type, "Template::") {
+			created = append(created, key)
+		}
+	}
+	sort.Strings(deleted)
+	sort.Strings(created)
+
+	type candidate struct {
+		oldKey, newKey string
+		score          float64
+	}
+	candidates := []candidate{}
+	for _, oldKey := range deleted {
+		pre := oldRes[oldKey].Resource
+		for _, newKey := range created {
+			post := newRes[newKey].Resource
+			if pre.Type != post.Type {
+				continue
+			}
+			prePath, _ := pre.Metadata[cfnCdkPathMetadata].(string)
+			postPath, _ := post.Metadata[cfnCdkPathMetadata].(string)
+			if prePath != "" && prePath == postPath {
+				// A matching construct path outranks any similarity score
+				candidates = append(candidates, candidate{oldKey, newKey, 2})
+				continue
+			}
+			if score := cfnSimilarity(pre.Properties, post.Properties); score >= cfnRenameSimilarity {
+				candidates = append(candidates, candidate{oldKey, newKey, score})
+			}
+		}
+	}
+	// Best matches are paired first, the sort is stable so ties go to the lowest keys
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	pairedNew := map[string]bool{}
+	for _, c := range candidates {
+		if pairs.pairedOld[c.oldKey] || pairedNew[c.newKey] {
+			continue
+		}
+		pairs.byNew[c.newKey] = c.oldKey
+		pairs.pairedOld[c.oldKey] = true
+		pairedNew[c.newKey] = true
+	}
+
+	return pairs
+}
+
+// cfnSimilarity is the proportion of leaf values the two sets of properties have in common, from 0 to 1
+func cfnSimilarity(a, b map[string]interface{}) float64 {
+	aLeaves := map[string]string{}
+	bLeaves := map[string]string{}
+	cfnLeaves(a, "", aLeaves)
+	cfnLeaves(b, "", bLeaves)
+	if len(aLeaves) == 0 || len(bLeaves) == 0 {
+		return 0
+	}
+
+	common := 0
+	for path, value := range aLeaves {
+		if other, ok := bLeaves[path]; ok && other == value {
+			common++
+		}
+	}
+	return float64(2*common) / float64(len(aLeaves)+len(bLeaves))
+}
+
+// cfnLeaves flattens v into the JSON of each scalar keyed by its path
+func cfnLeaves(v interface{}, path string, leaves map[string]string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			cfnLeaves(child, path+"/"+k, leaves)
+		}
+	case []interface{}:
+		for i, child := range val {
+			cfnLeaves(child, fmt.Sprintf("%s/%d", path, i), leaves)
+		}
+	default:
+		b, _ := json.Marshal(val)
+		leaves[path] = string(b)
+	}
+}
+
+// renamed builds the diff of a resource which moved to a new logical id
+func (rp *cfnRenamePairs) renamed(pre, post *CloudformationResource) (ResourceDiff, error) {
+	rDiff, err := cfnDiffResource(pre.Resource, post.Resource)
+	if err != nil {
+		return ResourceDiff{}, err
+	}
+
+	return ResourceDiff{
+		Type:       DiffTypeReplace,
+		Pre:        pre,
+		Post:       post,
+		Diff:       rDiff,
+		Notes:      []string{fmt.Sprintf("renamed from %q (will be replaced)", pre.Name())},
+		Impact:     UpdateImpactReplacement,
+		ReplacedBy: []string{"LogicalId"},
+	}, nil
+}
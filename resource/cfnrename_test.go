@@ -0,0 +1,173 @@
+package resource
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCfnSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a    map[string]interface{}
+		b    map[string]interface{}
+		want float64
+	}{
+		{
+			name: "identical",
+			a:    map[string]interface{}{"BucketName": "app", "Tags": []interface{}{map[string]interface{}{"Key": "team", "Value": "web"}}},
+			b:    map[string]interface{}{"BucketName": "app", "Tags": []interface{}{map[string]interface{}{"Key": "team", "Value": "web"}}},
+			want: 1,
+		},
+		{
+			name: "one of four values changed",
+			a:    map[string]interface{}{"A": "1", "B": "2", "C": "3", "D": "4"},
+			b:    map[string]interface{}{"A": "1", "B": "2", "C": "3", "D": "5"},
+			want: 0.75,
+		},
+		{
+			name: "value added",
+			a:    map[string]interface{}{"A": "1"},
+			b:    map[string]interface{}{"A": "1", "B": "2"},
+			want: 2.0 / 3.0,
+		},
+		{
+			name: "values compared by type",
+			a:    map[string]interface{}{"Port": 80},
+			b:    map[string]interface{}{"Port": "80"},
+			want: 0,
+		},
+		{
+			name: "no properties",
+			a:    map[string]interface{}{},
+			b:    map[string]interface{}{},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfnSimilarity(tt.a, tt.b); got != tt.want {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testCfnResource(name, resType, cdkPath string, props map[string]interface{}) *CloudformationResource {
+	res := &CloudformationResource{ResName: name, Resource: cfnResource{Type: resType, Properties: props}}
+	if cdkPath != "" {
+		res.Resource.Metadata = map[string]interface{}{cfnCdkPathMetadata: cdkPath}
+	}
+	return res
+}
+
+func TestCfnPairRenamed(t *testing.T) {
+	queue := map[string]interface{}{"QueueName": "jobs", "VisibilityTimeout": 30, "DelaySeconds": 0, "MessageRetentionPeriod": 60, "ReceiveMessageWaitTimeSeconds": 20}
+	otherQueue := map[string]interface{}{"QueueName": "other", "VisibilityTimeout": 60, "DelaySeconds": 5, "MessageRetentionPeriod": 120, "ReceiveMessageWaitTimeSeconds": 0}
+	tests := []struct {
+		name string
+		old  []*CloudformationResource
+		new  []*CloudformationResource
+		// want maps created resources to the deleted resource they were renamed from
+		want map[string]string
+	}{
+		{
+			name: "same properties",
+			old:  []*CloudformationResource{testCfnResource("JobsQueue1A2B", "AWS::SQS::Queue", "", queue)},
+			new:  []*CloudformationResource{testCfnResource("JobsQueue3C4D", "AWS::SQS::Queue", "", queue)},
+			want: map[string]string{"JobsQueue3C4D": "JobsQueue1A2B"},
+		},
+		{
+			name: "same cdk path",
+			old:  []*CloudformationResource{testCfnResource("JobsQueue1A2B", "AWS::SQS::Queue", "App/Jobs/Resource", queue)},
+			new:  []*CloudformationResource{testCfnResource("JobsQueue3C4D", "AWS::SQS::Queue", "App/Jobs/Resource", otherQueue)},
+			want: map[string]string{"JobsQueue3C4D": "JobsQueue1A2B"},
+		},
+		{
+			name: "different properties",
+			old:  []*CloudformationResource{testCfnResource("JobsQueue", "AWS::SQS::Queue", "", queue)},
+			new:  []*CloudformationResource{testCfnResource("OtherQueue", "AWS::SQS::Queue", "", otherQueue)},
+			want: map[string]string{},
+		},
+		{
+			name: "different types",
+			old:  []*CloudformationResource{testCfnResource("Jobs", "AWS::SQS::Queue", "", map[string]interface{}{"Name": "jobs"})},
+			new:  []*CloudformationResource{testCfnResource("JobsTopic", "AWS::SNS::Topic", "", map[string]interface{}{"Name": "jobs"})},
+			want: map[string]string{},
+		},
+		{
+			name: "best match is paired",
+			old: []*CloudformationResource{
+				testCfnResource("A", "AWS::SQS::Queue", "", map[string]interface{}{"QueueName": "jobs", "VisibilityTimeout": 30, "DelaySeconds": 0, "MessageRetentionPeriod": 60, "ReceiveMessageWaitTimeSeconds": 10}),
+				testCfnResource("B", "AWS::SQS::Queue", "", queue),
+			},
+			new:  []*CloudformationResource{testCfnResource("C", "AWS::SQS::Queue", "", queue)},
+			want: map[string]string{"C": "B"},
+		},
+		{
+			name: "resources kept are never paired",
+			old:  []*CloudformationResource{testCfnResource("Jobs", "AWS::SQS::Queue", "", queue)},
+			new:  []*CloudformationResource{testCfnResource("Jobs", "AWS::SQS::Queue", "", queue), testCfnResource("JobsCopy", "AWS::SQS::Queue", "", queue)},
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldRes, newRes := map[string]*CloudformationResource{}, map[string]*CloudformationResource{}
+			for _, res := range tt.old {
+				oldRes[res.ResName] = res
+			}
+			for _, res := range tt.new {
+				newRes[res.ResName] = res
+			}
+			pairs := cfnPairRenamed(oldRes, newRes)
+			if !reflect.DeepEqual(pairs.byNew, tt.want) {
+				t.Errorf("got pairs %v, expected %v", pairs.byNew, tt.want)
+			}
+			for _, oldKey := range tt.want {
+				if !pairs.pairedOld[oldKey] {
+					t.Errorf("%s was paired but isn't marked as paired", oldKey)
+				}
+			}
+		})
+	}
+}
+
+func TestCfnDiffRenamed(t *testing.T) {
+	old := loadTestTemplate(t, `Resources:
+  JobsQueue1A2B:
+    Type: AWS::SQS::Queue
+    Metadata:
+      aws:cdk:path: App/Jobs/Resource
+    Properties:
+      VisibilityTimeout: 30
+`)
+	new := loadTestTemplate(t, `Resources:
+  JobsQueue3C4D:
+    Type: AWS::SQS::Queue
+    Metadata:
+      aws:cdk:path: App/Jobs/Resource
+    Properties:
+      VisibilityTimeout: 60
+`)
+
+	diff, _, _, err := doCfnDiff(context.Background(), old, new)
+	if err != nil {
+		t.Fatalf("unable to diff - %s", err)
+	}
+	if len(diff) != 1 {
+		t.Fatalf("got %d changes, expected the rename only", len(diff))
+	}
+	rd := diff[0]
+	if rd.Type != DiffTypeReplace || rd.Pre.Name() != "JobsQueue1A2B" || rd.Post.Name() != "JobsQueue3C4D" {
+		t.Errorf("got %s of %v to %v, expected a replacement of JobsQueue1A2B with JobsQueue3C4D", rd.Type, rd.Pre, rd.Post)
+	}
+	if want := []string{`renamed from "JobsQueue1A2B" (will be replaced)`}; !reflect.DeepEqual(rd.Notes, want) {
+		t.Errorf("got notes %v, expected %v", rd.Notes, want)
+	}
+	if len(rd.Diff) != 1 {
+		t.Errorf("got changes %v, expected only the visibility timeout", rd.Diff)
+	}
+}
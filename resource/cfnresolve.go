@@ -0,0 +1,733 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// CloudformationContextParameters is a parameter file supplying the stack's parameter values, relative to the
+	// template. CLI style ([{"ParameterKey": .., "ParameterValue": ..}]), CodePipeline template configuration
+	// ({"Parameters": {..}}) and plain maps are accepted, as JSON or YAML.
+	CloudformationContextParameters = "parameters"
+	// CloudformationContextRegion is the region the stack is deployed to, AWS::Region is left as a placeholder without it
+	CloudformationContextRegion = "region"
+	// CloudformationContextAccountId is the account the stack is deployed to, AWS::AccountId is left as a placeholder without it
+	CloudformationContextAccountId = "accountId"
+	// CloudformationContextStackName is the name of the stack, AWS::StackName is left as a placeholder without it
+	CloudformationContextStackName = "stackName"
+)
+
+// cfnShortFormKeys are the YAML short form tags which don't become Fn::<tag>
+var cfnShortFormKeys = map[string]string{
+	"Ref":       "Ref",
+	"Condition": "Condition",
+}
+
+var cfnSubVariable = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// cfnNoValue stands in for a Ref to AWS::NoValue, the property or list item holding it is removed
+type cfnNoValue struct{}
+
+type cfnCondition struct {
+	value bool
+	known bool
+}
+
+type cfnResolver struct {
+	tpl          *CloudformationTemplate
+	params       map[string]interface{}
+	noEchoParams map[string]bool
+	conditions   map[string]cfnCondition
+	evaluating   map[string]bool
+	// noEcho collects the paths of values derived from NoEcho parameters in the resource being resolved
+	noEcho [][]string
+}
+
+// cfnExpandShortForm rewrites YAML short form intrinsics such as !Ref and !Sub into their long form, so they
+// survive being decoded into plain maps
+func cfnExpandShortForm(node *yaml.Node) {
+	for _, child := range node.Content {
+		cfnExpandShortForm(child)
+	}
+	if !strings.HasPrefix(node.Tag, "!") || strings.HasPrefix(node.Tag, "!!") {
+		return
+	}
+
+	name := strings.TrimPrefix(node.Tag, "!")
+	key, ok := cfnShortFormKeys[name]
+	if !ok {
+		key = "Fn::" + name
+	}
+
+	value := *node
+	value.Tag = ""
+	value.Style &^= yaml.TaggedStyle
+	if name == "GetAtt" && value.Kind == yaml.ScalarNode {
+		parts := strings.SplitN(value.Value, ".", 2)
+		seq := yaml.Node{Kind: yaml.SequenceNode, Line: value.Line, Column: value.Column}
+		for _, part := range parts {
+			seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: part})
+		}
+		value = seq
+	}
+
+	*node = yaml.Node{
+		Kind:    yaml.MappingNode,
+		Tag:     "!!map",
+		Line:    value.Line,
+		Column:  value.Column,
+		Content: []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, &value},
+	}
+}
+
+// ResolveCloudformation evaluates the intrinsic functions in the template's resources using the parameter values
+// from the parameter file in the entrypoint context, falling back to the parameter defaults. Resources whose
+// Condition is false are dropped. References which can only be known once the stack is deployed, such as
+// Fn::GetAtt or a Ref to another resource, are left as ${LogicalId.Attribute} placeholders.
+func ResolveCloudformation(tpl *CloudformationTemplate, epctx map[string]interface{}, templatePath string) (*CloudformationTemplate, error) {
+	if tpl == nil {
+		return nil, nil
+	}
+
+	values := map[string]interface{}{}
+	if paramFile, ok := epctx[CloudformationContextParameters].(string); ok && paramFile != "" {
+		if !filepath.IsAbs(paramFile) {
+			base := templatePath
+			if info, err := os.Stat(templatePath); err != nil || !info.IsDir() {
+				base = filepath.Dir(templatePath)
+			}
+			paramFile = filepath.Join(base, paramFile)
+		}
+		loaded, err := cfnLoadParameters(paramFile)
+		if err != nil {
+			return nil, err
+		}
+		values = loaded
+	}
+
+	return resolveCloudformation(tpl, epctx, values), nil
+}
+
+// resolveCloudformation resolves the template with the parameter values the stack is deployed with
+func resolveCloudformation(tpl *CloudformationTemplate, epctx map[string]interface{}, values map[string]interface{}) *CloudformationTemplate {
+	r := &cfnResolver{
+		tpl:          tpl,
+		params:       cfnPseudoParameters(epctx),
+		noEchoParams: map[string]bool{},
+		conditions:   map[string]cfnCondition{},
+		evaluating:   map[string]bool{},
+	}
+	for name, p := range tpl.Parameters {
+		param, _ := p.(map[string]interface{})
+		if v, ok := param["NoEcho"]; ok && fmt.Sprintf("%v", v) == "true" {
+			r.noEchoParams[name] = true
+		}
+		value, ok := values[name]
+		if !ok {
+			value, ok = param["Default"]
+		}
+		if !ok {
+			continue
+		}
+		paramType, _ := param["Type"].(string)
+		r.params[name] = cfnParameterValue(paramType, value)
+	}
+
+	out := *tpl
+	out.Resources = map[string]cfnResource{}
+	out.NoEcho = map[string][][]string{}
+	names := make([]string, 0, len(tpl.Resources))
+	for name := range tpl.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		res := tpl.Resources[name]
+		if res.Condition != "" {
+			if cond := r.namedCondition(res.Condition); cond.known && !cond.value {
+				continue
+			}
+		}
+
+		r.noEcho = nil
+		if res.Properties != nil {
+			props, _ := r.resolve(res.Properties, []string{"Properties"})
+			res.Properties, _ = props.(map[string]interface{})
+		}
+		out.Resources[name] = res
+		if len(r.noEcho) > 0 {
+			out.NoEcho[name] = r.noEcho
+		}
+	}
+
+	if tpl.Outputs != nil {
+		out.Outputs = map[string]interface{}{}
+		for name, o := range tpl.Outputs {
+			output, _ := o.(map[string]interface{})
+			if condName, ok := output["Condition"].(string); ok {
+				if cond := r.namedCondition(condName); cond.known && !cond.value {
+					continue
+				}
+			}
+			r.noEcho = nil
+			// Outputs are diffed as pseudo-resources with the output as their properties
+			out.Outputs[name], _ = r.resolve(o, []string{"Properties"})
+			if len(r.noEcho) > 0 {
+				out.NoEcho[cfnSectionKey(cfnSectionOutputs, name)] = r.noEcho
+			}
+		}
+	}
+
+	out.ParameterValues = map[string]interface{}{}
+	for name := range tpl.Parameters {
+		if value, ok := r.params[name]; ok {
+			out.ParameterValues[name] = value
+		}
+	}
+	out.ConditionValues = map[string]bool{}
+	for name := range tpl.Conditions {
+		if cond := r.namedCondition(name); cond.known {
+			out.ConditionValues[name] = cond.value
+		}
+	}
+
+	return &out
+}
+
+// cfnLoadParameters reads a parameter file in any of the formats accepted by CloudformationContextParameters
+func cfnLoadParameters(paramFile string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(paramFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read parameter file %q - %w", paramFile, err)
+	}
+	// YAML is a superset of JSON, so this reads either
+	var doc interface{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("unable to parse parameter file %q - %w", paramFile, err)
+	}
+
+	values := map[string]interface{}{}
+	switch d := doc.(type) {
+	case []interface{}:
+		for _, item := range d {
+			p, _ := item.(map[string]interface{})
+			key, ok := p["ParameterKey"].(string)
+			if !ok {
+				return nil, fmt.Errorf("parameter file %q has an item without a ParameterKey", paramFile)
+			}
+			if v, ok := p["ParameterValue"]; ok {
+				values[key] = v
+			}
+		}
+	case map[string]interface{}:
+		if params, ok := d["Parameters"].(map[string]interface{}); ok {
+			d = params
+		}
+		for k, v := range d {
+			values[k] = v
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("parameter file %q is neither a list nor a map of parameters", paramFile)
+	}
+
+	return values, nil
+}
+
+// cfnParameterValue converts a parameter value to what a Ref to it returns, a string or a list of strings
+func cfnParameterValue(paramType string, value interface{}) interface{} {
+	isList := paramType == "CommaDelimitedList" || strings.HasPrefix(paramType, "List<")
+	if list, ok := value.([]interface{}); ok {
+		out := make([]interface{}, 0, len(list))
+		for _, item := range list {
+			s, _ := cfnString(item)
+			out = append(out, s)
+		}
+		return out
+	}
+	s, ok := cfnString(value)
+	if !ok {
+		return value
+	}
+	if isList {
+		out := []interface{}{}
+		for _, item := range strings.Split(s, ",") {
+			out = append(out, strings.TrimSpace(item))
+		}
+		return out
+	}
+	return s
+}
+
+// cfnPseudoParameters are the AWS:: parameters which can be known from the entrypoint context
+func cfnPseudoParameters(epctx map[string]interface{}) map[string]interface{} {
+	params := map[string]interface{}{
+		"AWS::Partition":        "aws",
+		"AWS::URLSuffix":        "amazonaws.com",
+		"AWS::NotificationARNs": []interface{}{},
+	}
+	if region, ok := epctx[CloudformationContextRegion].(string); ok && region != "" {
+		params["AWS::Region"] = region
+		if strings.HasPrefix(region, "cn-") {
+			params["AWS::Partition"] = "aws-cn"
+			params["AWS::URLSuffix"] = "amazonaws.com.cn"
+		} else if strings.HasPrefix(region, "us-gov-") {
+			params["AWS::Partition"] = "aws-us-gov"
+		}
+	}
+	if account, ok := epctx[CloudformationContextAccountId]; ok {
+		if s, ok := cfnString(account); ok && s != "" {
+			params["AWS::AccountId"] = s
+		}
+	}
+	if stackName, ok := epctx[CloudformationContextStackName].(string); ok && stackName != "" {
+		params["AWS::StackName"] = stackName
+	}
+	return params
+}
+
+// cfnString converts a scalar to the string CloudFormation would use for it
+func cfnString(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case bool:
+		return strconv.FormatBool(val), true
+	case int:
+		return strconv.Itoa(val), true
+	case int64:
+		return strconv.FormatInt(val, 10), true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// cfnChildPath extends p, values resolved without a path (such as intrinsic arguments) keep having none
+func cfnChildPath(p []string, el string) []string {
+	if p == nil {
+		return nil
+	}
+	return append(append([]string{}, p...), el)
+}
+
+func (r *cfnResolver) markNoEcho(p []string) {
+	if p != nil {
+		r.noEcho = append(r.noEcho, append([]string{}, p...))
+	}
+}
+
+// resolve evaluates every intrinsic function within v, which is found at p in the resource. known is false if
+// any part of the result is a placeholder or an intrinsic which couldn't be evaluated.
+func (r *cfnResolver) resolve(v interface{}, p []string) (interface{}, bool) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) == 1 {
+			for k, args := range val {
+				if k == "Ref" || strings.HasPrefix(k, "Fn::") {
+					return r.intrinsic(k, args, p)
+				}
+			}
+		}
+		out := make(map[string]interface{}, len(val))
+		known := true
+		for k, child := range val {
+			resolved, ok := r.resolve(child, cfnChildPath(p, k))
+			known = known && ok
+			if _, noValue := resolved.(cfnNoValue); noValue {
+				continue
+			}
+			out[k] = resolved
+		}
+		return out, known
+	case []interface{}:
+		out := make([]interface{}, 0, len(val))
+		known := true
+		for _, child := range val {
+			resolved, ok := r.resolve(child, cfnChildPath(p, strconv.Itoa(len(out))))
+			known = known && ok
+			if _, noValue := resolved.(cfnNoValue); noValue {
+				continue
+			}
+			out = append(out, resolved)
+		}
+		return out, known
+	}
+	return v, true
+}
+
+// resolveArgs resolves the arguments of an intrinsic, the intrinsic's result is derived from a NoEcho parameter
+// if any of its arguments refer to one
+func (r *cfnResolver) resolveArgs(args interface{}, p []string) ([]interface{}, bool) {
+	resolved, known := r.resolve(args, nil)
+	if r.usesNoEcho(args) {
+		r.markNoEcho(p)
+	}
+	list, ok := resolved.([]interface{})
+	if !ok {
+		return []interface{}{resolved}, known
+	}
+	return list, known
+}
+
+// usesNoEcho reports whether v refers to a NoEcho parameter anywhere within it
+func (r *cfnResolver) usesNoEcho(v interface{}) bool {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if ref, ok := val["Ref"].(string); ok && len(val) == 1 {
+			return r.noEchoParams[ref]
+		}
+		if sub, ok := val["Fn::Sub"]; ok && len(val) == 1 {
+			return r.subUsesNoEcho(sub)
+		}
+		for _, child := range val {
+			if r.usesNoEcho(child) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range val {
+			if r.usesNoEcho(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// subUsesNoEcho reports whether the arguments of a Fn::Sub refer to a NoEcho parameter, either in the string or its variables
+func (r *cfnResolver) subUsesNoEcho(args interface{}) bool {
+	template := args
+	if list, ok := args.([]interface{}); ok && len(list) > 0 {
+		template = list[0]
+		if len(list) > 1 && r.usesNoEcho(list[1]) {
+			return true
+		}
+	}
+	if s, ok := template.(string); ok {
+		for _, m := range cfnSubVariable.FindAllStringSubmatch(s, -1) {
+			if r.noEchoParams[m[1]] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *cfnResolver) intrinsic(fn string, args interface{}, p []string) (interface{}, bool) {
+	unresolved := func(resolvedArgs interface{}) (interface{}, bool) {
+		return map[string]interface{}{fn: resolvedArgs}, false
+	}
+
+	switch fn {
+	case "Ref":
+		name, ok := args.(string)
+		if !ok {
+			return unresolved(args)
+		}
+		return r.ref(name, p)
+
+	case "Fn::GetAtt":
+		switch a := args.(type) {
+		case string:
+			return "${" + a + "}", false
+		case []interface{}:
+			parts := []string{}
+			for _, part := range a {
+				resolved, _ := r.resolve(part, nil)
+				s, ok := cfnString(resolved)
+				if !ok {
+					return unresolved(a)
+				}
+				parts = append(parts, s)
+			}
+			return "${" + strings.Join(parts, ".") + "}", false
+		}
+		return unresolved(args)
+
+	case "Fn::Sub":
+		return r.sub(args, p)
+
+	case "Fn::Join":
+		list, known := r.resolveArgs(args, p)
+		if len(list) != 2 {
+			return unresolved(list)
+		}
+		delim, ok := cfnString(list[0])
+		items, isList := list[1].([]interface{})
+		if !ok || !isList {
+			return unresolved(list)
+		}
+		parts := make([]string, 0, len(items))
+		for _, item := range items {
+			s, ok := cfnString(item)
+			if !ok {
+				return unresolved(list)
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, delim), known
+
+	case "Fn::Select":
+		list, known := r.resolveArgs(args, p)
+		if len(list) != 2 || !known {
+			return unresolved(list)
+		}
+		idxStr, ok := cfnString(list[0])
+		items, isList := list[1].([]interface{})
+		idx, err := strconv.Atoi(idxStr)
+		if !ok || !isList || err != nil || idx < 0 || idx >= len(items) {
+			return unresolved(list)
+		}
+		return items[idx], true
+
+	case "Fn::Split":
+		list, known := r.resolveArgs(args, p)
+		if len(list) != 2 || !known {
+			return unresolved(list)
+		}
+		delim, ok := cfnString(list[0])
+		source, isStr := cfnString(list[1])
+		if !ok || !isStr {
+			return unresolved(list)
+		}
+		out := []interface{}{}
+		for _, s := range strings.Split(source, delim) {
+			out = append(out, s)
+		}
+		return out, true
+
+	case "Fn::If":
+		list, ok := args.([]interface{})
+		if !ok || len(list) != 3 {
+			return unresolved(args)
+		}
+		name, _ := list[0].(string)
+		cond := r.namedCondition(name)
+		if cond.known {
+			if cond.value {
+				return r.resolve(list[1], p)
+			}
+			return r.resolve(list[2], p)
+		}
+		whenTrue, _ := r.resolve(list[1], p)
+		whenFalse, _ := r.resolve(list[2], p)
+		return unresolved([]interface{}{list[0], whenTrue, whenFalse})
+
+	case "Fn::FindInMap":
+		list, known := r.resolveArgs(args, p)
+		if len(list) < 3 || !known {
+			return unresolved(list)
+		}
+		keys := make([]string, 3)
+		for i := range keys {
+			s, ok := cfnString(list[i])
+			if !ok {
+				return unresolved(list)
+			}
+			keys[i] = s
+		}
+		mapping, _ := r.tpl.Mappings[keys[0]].(map[string]interface{})
+		top, _ := mapping[keys[1]].(map[string]interface{})
+		if value, ok := top[keys[2]]; ok {
+			return deepCopyValue(value), true
+		}
+		// The optional fourth argument is a DefaultValue
+		if len(list) == 4 {
+			if def, ok := list[3].(map[string]interface{}); ok {
+				if value, ok := def["DefaultValue"]; ok {
+					return value, true
+				}
+			}
+		}
+		return unresolved(list)
+
+	case "Fn::Length":
+		list, known := r.resolveArgs(args, p)
+		if len(list) == 1 {
+			if items, ok := list[0].([]interface{}); ok && known {
+				return len(items), true
+			}
+		}
+		if known {
+			return len(list), true
+		}
+		return unresolved(list)
+
+	case "Fn::ToJsonString":
+		resolved, known := r.resolve(args, nil)
+		if r.usesNoEcho(args) {
+			r.markNoEcho(p)
+		}
+		if !known {
+			return unresolved(resolved)
+		}
+		b, err := json.Marshal(resolved)
+		if err != nil {
+			return unresolved(resolved)
+		}
+		return string(b), true
+
+	case "Fn::Base64":
+		// The content is kept readable rather than encoded, so changes to user data can be seen line by line
+		resolved, known := r.resolve(args, p)
+		return map[string]interface{}{fn: resolved}, known
+	}
+
+	// Everything else, such as Fn::GetAZs, Fn::ImportValue and Fn::Cidr, can only be known once deployed
+	resolved, _ := r.resolve(args, nil)
+	if r.usesNoEcho(args) {
+		r.markNoEcho(p)
+	}
+	return unresolved(resolved)
+}
+
+func (r *cfnResolver) ref(name string, p []string) (interface{}, bool) {
+	if name == "AWS::NoValue" {
+		return cfnNoValue{}, true
+	}
+	if value, ok := r.params[name]; ok {
+		if r.noEchoParams[name] {
+			r.markNoEcho(p)
+		}
+		return deepCopyValue(value), true
+	}
+	return "${" + name + "}", false
+}
+
+func (r *cfnResolver) sub(args interface{}, p []string) (interface{}, bool) {
+	template := args
+	vars := map[string]interface{}{}
+	varsKnown := map[string]bool{}
+	if list, ok := args.([]interface{}); ok && len(list) == 2 {
+		template = list[0]
+		if m, ok := list[1].(map[string]interface{}); ok {
+			for k, v := range m {
+				vars[k], varsKnown[k] = r.resolve(v, nil)
+			}
+		}
+	}
+	s, ok := template.(string)
+	if !ok {
+		resolved, _ := r.resolve(args, nil)
+		return map[string]interface{}{"Fn::Sub": resolved}, false
+	}
+	if r.subUsesNoEcho(args) {
+		r.markNoEcho(p)
+	}
+
+	known := true
+	out := cfnSubVariable.ReplaceAllStringFunc(s, func(match string) string {
+		name := match[2 : len(match)-1]
+		if strings.HasPrefix(name, "!") {
+			return "${" + name[1:] + "}"
+		}
+		if v, ok := vars[name]; ok {
+			if str, ok := cfnString(v); ok {
+				known = known && varsKnown[name]
+				return str
+			}
+			known = false
+			return match
+		}
+		if v, ok := r.params[name]; ok {
+			if str, ok := cfnString(v); ok {
+				return str
+			}
+		}
+		known = false
+		return match
+	})
+
+	return out, known
+}
+
+// namedCondition evaluates a condition from the Conditions section, known is false if it depends on a
+// parameter without a value
+func (r *cfnResolver) namedCondition(name string) cfnCondition {
+	if cond, ok := r.conditions[name]; ok {
+		return cond
+	}
+	expr, ok := r.tpl.Conditions[name]
+	if !ok || r.evaluating[name] {
+		return cfnCondition{}
+	}
+	r.evaluating[name] = true
+	cond := r.condition(expr)
+	delete(r.evaluating, name)
+	r.conditions[name] = cond
+	return cond
+}
+
+func (r *cfnResolver) condition(expr interface{}) cfnCondition {
+	if b, ok := expr.(bool); ok {
+		return cfnCondition{value: b, known: true}
+	}
+	m, ok := expr.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return cfnCondition{}
+	}
+
+	for fn, args := range m {
+		list, _ := args.([]interface{})
+		switch fn {
+		case "Condition":
+			name, _ := args.(string)
+			return r.namedCondition(name)
+		case "Fn::Equals":
+			if len(list) != 2 {
+				return cfnCondition{}
+			}
+			a, aKnown := r.resolve(list[0], nil)
+			b, bKnown := r.resolve(list[1], nil)
+			if !aKnown || !bKnown {
+				return cfnCondition{}
+			}
+			aStr, aScalar := cfnString(a)
+			bStr, bScalar := cfnString(b)
+			if aScalar && bScalar {
+				return cfnCondition{value: aStr == bStr, known: true}
+			}
+			aJson, _ := json.Marshal(a)
+			bJson, _ := json.Marshal(b)
+			return cfnCondition{value: string(aJson) == string(bJson), known: true}
+		case "Fn::Not":
+			if len(list) != 1 {
+				return cfnCondition{}
+			}
+			cond := r.condition(list[0])
+			return cfnCondition{value: !cond.value, known: cond.known}
+		case "Fn::And":
+			result := cfnCondition{value: true, known: true}
+			for _, item := range list {
+				cond := r.condition(item)
+				if cond.known && !cond.value {
+					return cond
+				}
+				result.known = result.known && cond.known
+			}
+			return result
+		case "Fn::Or":
+			result := cfnCondition{value: false, known: true}
+			for _, item := range list {
+				cond := r.condition(item)
+				if cond.known && cond.value {
+					return cond
+				}
+				result.known = result.known && cond.known
+			}
+			return result
+		}
+	}
+
+	return cfnCondition{}
+}
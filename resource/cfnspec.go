@@ -0,0 +1,91 @@
+package resource
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	r3diff "github.com/r3labs/diff/v3"
+)
+
+// cfnSpecJson is an offline extract of the update behaviours in the CloudFormation resource specification. It only
+// covers commonly used resource types, updates to any other type are reported with an unknown impact rather than
+// assumed to be safe.
+//
+//go:embed cfnspec.json
+var cfnSpecJson []byte
+
+// cfnUpdateSpec lists the properties of a resource type which can't be updated without interruption, every
+// other property of a type in the specification is updated without interruption
+type cfnUpdateSpec struct {
+	Replacement      []string `json:"Replacement"`
+	SomeInterruption []string `json:"SomeInterruption"`
+	// Conditional properties only cause replacement for some changes, such as a major engine version upgrade
+	Conditional []string `json:"Conditional"`
+}
+
+var cfnSpecOnce sync.Once
+var cfnSpec map[string]cfnUpdateSpec
+
+func cfnUpdateSpecFor(resType string) (cfnUpdateSpec, bool) {
+	cfnSpecOnce.Do(func() {
+		if err := json.Unmarshal(cfnSpecJson, &cfnSpec); err != nil {
+			panic(fmt.Errorf("unable to parse bundled cloudformation specification - %w", err))
+		}
+	})
+	spec, ok := cfnSpec[resType]
+	return spec, ok
+}
+
+// cfnUpdateImpact predicts how CloudFormation will apply an update to a resource from the properties which changed.
+// The impact is unknown when the resource type isn't in the bundled specification.
+func cfnUpdateImpact(pre, post cfnResource, changelog r3diff.Changelog) (UpdateImpact, []string, []string) {
+	if pre.Type != post.Type {
+		return UpdateImpactReplacement, []string{"Type"}, nil
+	}
+	spec, ok := cfnUpdateSpecFor(post.Type)
+	if !ok {
+		return UpdateImpactUnknown, nil, []string{fmt.Sprintf("%s isn't in the bundled CloudFormation specification, the update may replace the resource", post.Type)}
+	}
+
+	changed := map[string]bool{}
+	for _, change := range changelog {
+		if len(change.Path) > 1 && change.Path[0] == "Properties" {
+			changed[change.Path[1]] = true
+		}
+	}
+
+	replacedBy := []string{}
+	for _, prop := range spec.Replacement {
+		if changed[prop] {
+			replacedBy = append(replacedBy, prop)
+		}
+	}
+	if len(replacedBy) > 0 {
+		return UpdateImpactReplacement, replacedBy, nil
+	}
+
+	impact := UpdateImpactNoInterruption
+	for _, prop := range spec.SomeInterruption {
+		if changed[prop] {
+			impact = UpdateImpactSomeInterruption
+		}
+	}
+	conditional := []string{}
+	for _, prop := range spec.Conditional {
+		if changed[prop] {
+			conditional = append(conditional, prop)
+		}
+	}
+	notes := []string{}
+	if len(conditional) > 0 {
+		sort.Strings(conditional)
+		impact = UpdateImpactSomeInterruption
+		notes = append(notes, fmt.Sprintf("changes to %s may require replacement", strings.Join(conditional, ", ")))
+	}
+
+	return impact, nil, notes
+}
@@ -0,0 +1,90 @@
+package resource
+
+import (
+	"reflect"
+	"testing"
+
+	r3diff "github.com/r3labs/diff/v3"
+)
+
+func TestCfnUpdateImpact(t *testing.T) {
+	changed := func(props ...string) r3diff.Changelog {
+		changelog := r3diff.Changelog{}
+		for _, prop := range props {
+			changelog = append(changelog, r3diff.Change{Type: r3diff.UPDATE, Path: []string{"Properties", prop}, From: "a", To: "b"})
+		}
+		return changelog
+	}
+	tests := []struct {
+		name       string
+		preType    string
+		postType   string
+		changelog  r3diff.Changelog
+		impact     UpdateImpact
+		replacedBy []string
+		notes      []string
+	}{
+		{
+			name:      "no interruption",
+			preType:   "AWS::EC2::Instance",
+			changelog: changed("Tags"),
+			impact:    UpdateImpactNoInterruption,
+			notes:     []string{},
+		},
+		{
+			name:      "some interruption",
+			preType:   "AWS::EC2::Instance",
+			changelog: changed("InstanceType", "Tags"),
+			impact:    UpdateImpactSomeInterruption,
+			notes:     []string{},
+		},
+		{
+			name:       "replacement",
+			preType:    "AWS::EC2::Instance",
+			changelog:  changed("InstanceType", "SubnetId", "ImageId"),
+			impact:     UpdateImpactReplacement,
+			replacedBy: []string{"ImageId", "SubnetId"},
+		},
+		{
+			name:      "conditional replacement",
+			preType:   "AWS::EC2::Instance",
+			changelog: changed("BlockDeviceMappings"),
+			impact:    UpdateImpactSomeInterruption,
+			notes:     []string{"changes to BlockDeviceMappings may require replacement"},
+		},
+		{
+			name:       "type changed",
+			preType:    "AWS::SQS::Queue",
+			postType:   "AWS::SNS::Topic",
+			changelog:  changed("Tags"),
+			impact:     UpdateImpactReplacement,
+			replacedBy: []string{"Type"},
+		},
+		{
+			name:      "type missing from the specification",
+			preType:   "AWS::Example::Widget",
+			changelog: changed("Name"),
+			impact:    UpdateImpactUnknown,
+			notes:     []string{"AWS::Example::Widget isn't in the bundled CloudFormation specification, the update may replace the resource"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postType := tt.postType
+			if postType == "" {
+				postType = tt.preType
+			}
+			impact, replacedBy, notes := cfnUpdateImpact(cfnResource{Type: tt.preType}, cfnResource{Type: postType}, tt.changelog)
+			if impact != tt.impact {
+				t.Errorf("got impact %q, expected %q", impact, tt.impact)
+			}
+			if !reflect.DeepEqual(replacedBy, tt.replacedBy) {
+				t.Errorf("got replaced by %v, expected %v", replacedBy, tt.replacedBy)
+			}
+			if !reflect.DeepEqual(notes, tt.notes) {
+				t.Errorf("got notes %v, expected %v", notes, tt.notes)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Template sections other than Resources are diffed as pseudo-resources of these types, named after their entry
+const (
+	CloudformationTypeParameter = "Template::Parameter"
+	CloudformationTypeOutput    = "Template::Output"
+	CloudformationTypeCondition = "Template::Condition"
+	CloudformationTypeMapping   = "Template::Mapping"
+	CloudformationTypeTransform = "Template::Transform"
+)
+
+const (
+	cfnSectionParameters = "Parameters"
+	cfnSectionOutputs    = "Outputs"
+	cfnSectionConditions = "Conditions"
+	cfnSectionMappings   = "Mappings"
+	cfnSectionTransform  = "Transform"
+)
+
+// cfnSectionKey identifies an entry of a template section other than Resources. Logical ids are alphanumeric,
+// so the key never collides with a resource.
+func cfnSectionKey(section, name string) string {
+	return section + "/" + name
+}
+
+// cfnTemplateResources returns every resource and pseudo-resource of the template and its nested stacks, keyed by
+// logical id for resources and by cfnSectionKey for everything else. Anything from a nested stack is named and keyed
+// by its path through the stacks, such as Parent/Child/LogicalId.
+func cfnTemplateResources(tpl *CloudformationTemplate) map[string]*CloudformationResource {
+	out := map[string]*CloudformationResource{}
+	cfnAddTemplateResources(out, tpl, "")
+	return out
+}
+
+func cfnAddTemplateResources(all map[string]*CloudformationResource, tpl *CloudformationTemplate, prefix string) {
+	if tpl == nil {
+		return
+	}
+
+	out := map[string]*CloudformationResource{}
+	for name, res := range tpl.Resources {
+		out[name] = cfnTemplateResource(tpl, name, res)
+	}
+	for name, p := range tpl.Parameters {
+		param, _ := deepCopyValue(p).(map[string]interface{})
+		if param == nil {
+			param = map[string]interface{}{}
+		}
+		// The value the stack is deployed with matters more than the default
+		if value, ok := tpl.ParameterValues[name]; ok {
+			param["Value"] = value
+		}
+		res := cfnPseudoResource(CloudformationTypeParameter, name, param)
+		if v, ok := param["NoEcho"]; ok && fmt.Sprintf("%v", v) == "true" {
+			res.NoEcho = [][]string{{"Properties", "Default"}, {"Properties", "Value"}}
+		}
+		out[cfnSectionKey(cfnSectionParameters, name)] = res
+	}
+	for name, o := range tpl.Outputs {
+		output, _ := o.(map[string]interface{})
+		res := cfnPseudoResource(CloudformationTypeOutput, name, output)
+		res.NoEcho = tpl.NoEcho[cfnSectionKey(cfnSectionOutputs, name)]
+		out[cfnSectionKey(cfnSectionOutputs, name)] = res
+	}
+	for name, expr := range tpl.Conditions {
+		props := map[string]interface{}{"Expression": expr}
+		if value, ok := tpl.ConditionValues[name]; ok {
+			props["Value"] = value
+		}
+		out[cfnSectionKey(cfnSectionConditions, name)] = cfnPseudoResource(CloudformationTypeCondition, name, props)
+	}
+	for name, m := range tpl.Mappings {
+		mapping, _ := m.(map[string]interface{})
+		out[cfnSectionKey(cfnSectionMappings, name)] = cfnPseudoResource(CloudformationTypeMapping, name, mapping)
+	}
+	if tpl.Transform != nil {
+		props := map[string]interface{}{"Transform": tpl.Transform}
+		out[cfnSectionTransform] = cfnPseudoResource(CloudformationTypeTransform, cfnSectionTransform, props)
+	}
+
+	for key, res := range out {
+		res.ResName = prefix + res.ResName
+		if res.GeneratedFrom != "" {
+			res.GeneratedFrom = prefix + res.GeneratedFrom
+		}
+		all[prefix+key] = res
+	}
+	for name, nested := range tpl.Nested {
+		cfnAddTemplateResources(all, nested, prefix+name+"/")
+	}
+}
+
+func cfnPseudoResource(resType, name string, props map[string]interface{}) *CloudformationResource {
+	return &CloudformationResource{
+		ResName:  name,
+		Resource: cfnResource{Type: resType, Properties: props},
+	}
+}
+
+// cfnExportName returns the name an output is exported as, if it is exported
+func cfnExportName(res *CloudformationResource) (string, bool) {
+	if res == nil || res.Resource.Type != CloudformationTypeOutput {
+		return "", false
+	}
+	export, _ := res.Resource.Properties["Export"].(map[string]interface{})
+	name, ok := export["Name"]
+	if !ok {
+		return "", false
+	}
+	if s, ok := cfnString(name); ok {
+		return s, true
+	}
+	// Names which couldn't be resolved are still compared by their expression
+	b, _ := json.Marshal(name)
+	return string(b), true
+}
+
+// cfnExportNotes flags outputs which stop exporting a name, other stacks may import it and CloudFormation refuses
+// to remove an export while it's imported
+func cfnExportNotes(rd *ResourceDiff, exported map[string]bool) {
+	pre, _ := rd.Pre.(*CloudformationResource)
+	post, _ := rd.Post.(*CloudformationResource)
+	preName, preOk := cfnExportName(pre)
+	if !preOk {
+		return
+	}
+	postName, postOk := cfnExportName(post)
+	if !exported[preName] {
+		rd.Breaking = true
+		if postOk {
+			rd.Notes = append(rd.Notes, fmt.Sprintf("export %q is renamed to %q, stacks importing it will block the update", preName, postName))
+		} else {
+			rd.Notes = append(rd.Notes, fmt.Sprintf("export %q is removed, stacks importing it will block the update", preName))
+		}
+		return
+	}
+	if postOk && preName == postName && !reflect.DeepEqual(pre.Resource.Properties["Value"], post.Resource.Properties["Value"]) {
+		rd.Notes = append(rd.Notes, fmt.Sprintf("export %q changes value, the update fails while other stacks import it", preName))
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/awslabs/goformation/v7/cloudformation"
 	"github.com/codingninja/gitops-repo-api/entrypoint"
 	"github.com/codingninja/gitops-repo-api/git"
 	r3diff "github.com/r3labs/diff/v3"
@@ -17,6 +19,7 @@ import (
 
 type cfnResource struct {
 	Type       string                 `json:"Type" yaml:"Type"`
+	Condition  string                 `json:"Condition,omitempty" yaml:"Condition,omitempty"`
 	Properties map[string]interface{} `json:"Properties" yaml:"Properties"`
 	Metadata   map[string]interface{} `json:"Metadata" yaml:"Metadata"`
 }
@@ -24,6 +27,10 @@ type cfnResource struct {
 type CloudformationResource struct {
 	ResName  string      `json:"resName"`
 	Resource cfnResource `json:"resource"`
+	// GeneratedFrom is the logical id of the AWS::Serverless::* resource this resource was expanded from
+	GeneratedFrom string `json:"generatedFrom,omitempty"`
+	// NoEcho are the paths within the resource which take their value from a NoEcho parameter
+	NoEcho [][]string `json:"-"`
 }
 
 func (kr *CloudformationResource) Type() string {
@@ -40,16 +47,28 @@ func (kr *CloudformationResource) Name() string {
 
 // Unfortunately, the CFN golang library can't be used because we don't want to bork on version mismatch
 type CloudformationTemplate struct {
-	AWSTemplateFormatVersion string                    `json:"AWSTemplateFormatVersion,omitempty" yaml:"AWSTemplateFormatVersion"`
-	Transform                *cloudformation.Transform `json:"Transform,omitempty"`
-	Description              string                    `json:"Description,omitempty" yaml:"Description"`
-	Metadata                 map[string]interface{}    `json:"Metadata,omitempty" yaml:"Metadata"`
-	Parameters               map[string]interface{}    `json:"Parameters,omitempty" yaml:"Parameters"`
-	Mappings                 map[string]interface{}    `json:"Mappings,omitempty" yaml:"Mappings"`
-	Conditions               map[string]interface{}    `json:"Conditions,omitempty" yaml:"Conditions"`
-	Resources                map[string]cfnResource    `json:"Resources,omitempty" yaml:"Resources"`
-	Outputs                  cloudformation.Outputs    `json:"Outputs,omitempty" yaml:"Outputs"`
-	Globals                  map[string]interface{}    `json:"Globals,omitempty" yaml:"Globals"`
+	AWSTemplateFormatVersion string `json:"AWSTemplateFormatVersion,omitempty" yaml:"AWSTemplateFormatVersion"`
+	// Transform is either a single transform name or a list of them
+	Transform   interface{}            `json:"Transform,omitempty" yaml:"Transform"`
+	Description string                 `json:"Description,omitempty" yaml:"Description"`
+	Metadata    map[string]interface{} `json:"Metadata,omitempty" yaml:"Metadata"`
+	Parameters  map[string]interface{} `json:"Parameters,omitempty" yaml:"Parameters"`
+	Mappings    map[string]interface{} `json:"Mappings,omitempty" yaml:"Mappings"`
+	Conditions  map[string]interface{} `json:"Conditions,omitempty" yaml:"Conditions"`
+	Resources   map[string]cfnResource `json:"Resources,omitempty" yaml:"Resources"`
+	Outputs     map[string]interface{} `json:"Outputs,omitempty" yaml:"Outputs"`
+	Globals     map[string]interface{} `json:"Globals,omitempty" yaml:"Globals"`
+	// GeneratedFrom maps resources created by ExpandServerless to the serverless resource they came from
+	GeneratedFrom map[string]string `json:"-" yaml:"-"`
+	// NoEcho maps resources, and cfnSectionKey of outputs, to the paths of values ResolveCloudformation derived
+	// from NoEcho parameters
+	NoEcho map[string][][]string `json:"-" yaml:"-"`
+	// ParameterValues are the values ResolveCloudformation deployed each parameter with
+	ParameterValues map[string]interface{} `json:"-" yaml:"-"`
+	// ConditionValues are the conditions ResolveCloudformation could evaluate
+	ConditionValues map[string]bool `json:"-" yaml:"-"`
+	// Nested are the templates ResolveNestedStacks loaded, keyed by the logical id of their stack
+	Nested map[string]*CloudformationTemplate `json:"-" yaml:"-"`
 }
 
 func RenderCloudformation(cfnFile string) (*CloudformationTemplate, error) {
@@ -66,8 +85,13 @@ func RenderCloudformation(cfnFile string) (*CloudformationTemplate, error) {
 		}
 		return &tpl, nil
 	}
+	node := yaml.Node{}
+	if err := yaml.Unmarshal(template, &node); err != nil {
+		return nil, err
+	}
+	cfnExpandShortForm(&node)
 	tpl := CloudformationTemplate{}
-	if err := yaml.Unmarshal(template, &tpl); err != nil {
+	if err := node.Decode(&tpl); err != nil {
 		return nil, err
 	}
 	return &tpl, nil
@@ -75,103 +99,246 @@ func RenderCloudformation(cfnFile string) (*CloudformationTemplate, error) {
 
 type cfnDiffer struct{}
 
+// renderInputs are the template at dir, its parameter file and the templates of its nested stacks
+func (td *cfnDiffer) renderInputs(ep entrypoint.Entrypoint, dir string) ([]string, bool) {
+	inputs := []string{}
+	if paramFile, ok := ep.Context[CloudformationContextParameters].(string); ok && paramFile != "" {
+		if !filepath.IsAbs(paramFile) {
+			base := dir
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				base = filepath.Dir(dir)
+			}
+			paramFile = filepath.Join(base, paramFile)
+		}
+		inputs = append(inputs, paramFile)
+	}
+	if !cfnTemplateInputs(dir, map[string]bool{}, &inputs) {
+		return nil, false
+	}
+	return inputs, true
+}
+
+// cfnTemplateInputs adds the template and the local templates of its nested stacks to inputs, it's false if any of
+// them can't be loaded
+func cfnTemplateInputs(templatePath string, seen map[string]bool, inputs *[]string) bool {
+	if seen[templatePath] {
+		return true
+	}
+	seen[templatePath] = true
+	*inputs = append(*inputs, templatePath)
+
+	tpl, err := RenderCloudformation(templatePath)
+	if err != nil {
+		return false
+	}
+	if tpl, err = ExpandServerless(tpl); err != nil {
+		return false
+	}
+	for _, res := range tpl.Resources {
+		if res.Type != cfnStackType {
+			continue
+		}
+		childPath, ok := cfnLocalTemplate(templatePath, res.Properties["TemplateURL"])
+		if !ok {
+			childPath, ok = cfnLocalTemplate(templatePath, res.Metadata[cdkAssetPathMetadata])
+		}
+		if ok && !cfnTemplateInputs(childPath, seen, inputs) {
+			return false
+		}
+	}
+	return true
+}
+
 func (td *cfnDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
 	// Won't actually run concurrently because we block during CFN builds currently due to a concurrent map read/write related to intrinsic funcs in cfn library
-	old, new, err := extractConcurrent(ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (*CloudformationTemplate, error) {
-		return RenderCloudformation(dir)
+	return diffConcurrent(ctx, ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (*CloudformationTemplate, error) {
+		tpl, err := RenderCloudformation(dir)
+		if err != nil {
+			return nil, err
+		}
+		tpl, err = ExpandServerless(tpl)
+		if err != nil {
+			return nil, err
+		}
+		tpl, err = ResolveCloudformation(tpl, ep.Context, dir)
+		if err != nil {
+			return nil, err
+		}
+		return ResolveNestedStacks(tpl, ep.Context, dir)
+	}, func(old, new *CloudformationTemplate) ([]ResourceDiff, []Resource, []Resource, error) {
+		return doCfnDiff(ctx, old, new)
 	})
+}
 
-	if err != nil && old == nil && new == nil {
-		return nil, nil, nil, fmt.Errorf("unable to concurrently render cfn resources - %w", err)
+func cfnTemplateResource(tpl *CloudformationTemplate, name string, res cfnResource) *CloudformationResource {
+	return &CloudformationResource{
+		ResName:       name,
+		Resource:      res,
+		GeneratedFrom: tpl.GeneratedFrom[name],
+		NoEcho:        append(cfnNoEchoPaths(tpl, res), tpl.NoEcho[name]...),
 	}
+}
 
-	return doCfnDiff(ctx, old, new)
+// cfnNoEchoPaths finds the properties of res which reference a NoEcho parameter
+func cfnNoEchoPaths(tpl *CloudformationTemplate, res cfnResource) [][]string {
+	noEcho := map[string]bool{}
+	for name, param := range tpl.Parameters {
+		p, _ := param.(map[string]interface{})
+		if v, ok := p["NoEcho"]; ok && fmt.Sprintf("%v", v) == "true" {
+			noEcho[name] = true
+		}
+	}
+	if len(noEcho) == 0 {
+		return nil
+	}
+
+	paths := [][]string{}
+	var walk func(v interface{}, p []string)
+	walk = func(v interface{}, p []string) {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := val["Ref"].(string); ok && len(val) == 1 {
+				if noEcho[ref] {
+					paths = append(paths, append([]string{}, p...))
+				}
+				return
+			}
+			for k, child := range val {
+				walk(child, append(p, k))
+			}
+		case []interface{}:
+			for i, child := range val {
+				walk(child, append(p, fmt.Sprintf("%d", i)))
+			}
+		}
+	}
+	walk(res.Properties, []string{"Properties"})
+
+	return paths
 }
 
+// cfnIsRef reports whether v is an unresolved {"Ref": ...}
+func cfnIsRef(v interface{}) bool {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return false
+	}
+	_, ok = m["Ref"]
+	return ok
+}
+
+// cfnValueAt returns the value at path within v, or nil if there is nothing there
+func cfnValueAt(v interface{}, path []string) interface{} {
+	for _, el := range path {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			v = val[el]
+		case []interface{}:
+			i, err := strconv.Atoi(el)
+			if err != nil || i < 0 || i >= len(val) {
+				return nil
+			}
+			v = val[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// doCfnDiff diffs the resources of two templates, a nil template is one which doesn't exist in that revision
 func doCfnDiff(ctx context.Context, old *CloudformationTemplate, new *CloudformationTemplate) ([]ResourceDiff, []Resource, []Resource, error) {
 	diff := []ResourceDiff{}
 	allNew := []Resource{}
 	allOld := []Resource{}
-	if new != nil {
-		for name, res := range new.Resources {
-			var oldRes cfnResource
-			allNew = append(allNew, &CloudformationResource{
-				ResName:  name,
-				Resource: res,
-			})
-			ok := false
-			if old != nil {
-				r, has := old.Resources[name]
-				if has {
-					oldRes = r
-				}
-				ok = has
-			}
-			if !ok {
-				rDiff, err := cfnDiffResource(nil, res)
-				if err != nil {
-					return nil, nil, nil, fmt.Errorf("unable to diff resources - %w", err)
-				}
+	oldRes := cfnTemplateResources(old)
+	newRes := cfnTemplateResources(new)
 
-				rd := ResourceDiff{
-					Type: DiffTypeCreate,
-					Pre:  nil,
-					Post: &CloudformationResource{
-						ResName:  name,
-						Resource: res,
-					},
-					Diff: rDiff,
-				}
-				diff = append(diff, rd)
-			} else {
-				rDiff, err := cfnDiffResource(oldRes, res)
-				if err != nil {
-					return nil, nil, nil, fmt.Errorf("unable to diff resources - %w", err)
-				}
-				if len(rDiff) > 0 {
-					rd := ResourceDiff{
-						Type: DiffTypeUpdate,
-						Pre: &CloudformationResource{
-							ResName:  name,
-							Resource: oldRes,
-						},
-						Post: &CloudformationResource{
-							ResName:  name,
-							Resource: res,
-						},
-						Diff: rDiff,
-					}
-					diff = append(diff, rd)
-				}
-			}
+	renames := cfnPairRenamed(oldRes, newRes)
+
+	exported := map[string]bool{}
+	for _, res := range newRes {
+		if name, ok := cfnExportName(res); ok {
+			exported[name] = true
 		}
 	}
 
-	if old != nil {
-		for name, res := range old.Resources {
-			allOld = append(allNew, &CloudformationResource{
-				ResName:  name,
-				Resource: res,
-			})
-			if new != nil {
-				if _, ok := new.Resources[name]; !ok {
-					rDiff, err := cfnDiffResource(res, nil)
-					if err != nil {
-						return nil, nil, nil, fmt.Errorf("unable to diff resources - %w", err)
-					}
-
-					rd := ResourceDiff{
-						Type: DiffTypeDelete,
-						Pre: &CloudformationResource{
-							ResName:  name,
-							Resource: res,
-						},
-						Diff: rDiff,
-					}
-					diff = append(diff, rd)
-				}
+	keys := []string{}
+	for key := range oldRes {
+		keys = append(keys, key)
+	}
+	for key := range newRes {
+		if _, ok := oldRes[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		pre, hasOld := oldRes[key]
+		post, hasNew := newRes[key]
+		if hasOld {
+			allOld = append(allOld, pre)
+		}
+		if hasNew {
+			allNew = append(allNew, post)
+		}
+		var rd ResourceDiff
+		switch {
+		case !hasNew && renames.pairedOld[key]:
+			// Reported against the resource it was renamed to
+			continue
+		case !hasOld && renames.byNew[key] != "":
+			renamed, err := renames.renamed(oldRes[renames.byNew[key]], post)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("unable to diff resources - %w", err)
+			}
+			rd = renamed
+		case !hasOld:
+			rDiff, err := cfnDiffResource(nil, post.Resource)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("unable to diff resources - %w", err)
+			}
+			rd = ResourceDiff{
+				Type: DiffTypeCreate,
+				Pre:  nil,
+				Post: post,
+				Diff: rDiff,
+			}
+		case !hasNew:
+			rDiff, err := cfnDiffResource(pre.Resource, nil)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("unable to diff resources - %w", err)
+			}
+			rd = ResourceDiff{
+				Type: DiffTypeDelete,
+				Pre:  pre,
+				Diff: rDiff,
+			}
+		default:
+			rDiff, err := cfnDiffResource(pre.Resource, post.Resource)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("unable to diff resources - %w", err)
+			}
+			if len(rDiff) == 0 {
+				continue
+			}
+			impact, replacedBy, notes := cfnUpdateImpact(pre.Resource, post.Resource, rDiff)
+			rd = ResourceDiff{
+				Type:       DiffTypeUpdate,
+				Pre:        pre,
+				Post:       post,
+				Diff:       rDiff,
+				Notes:      notes,
+				Impact:     impact,
+				ReplacedBy: replacedBy,
+			}
+			if impact == UpdateImpactReplacement {
+				rd.Type = DiffTypeReplace
 			}
 		}
+		cfnExportNotes(&rd, exported)
+		diff = append(diff, rd)
 	}
 
 	return diff, allOld, allNew, nil
@@ -195,5 +362,5 @@ func cfnDiffResource(aRes, bRes interface{}) (r3diff.Changelog, error) {
 		return nil, fmt.Errorf("unable to parse yaml for item b - %w", err)
 	}
 
-	return r3diff.Diff(aObj, bObj)
+	return r3diff.Diff(aObj, bObj, r3diff.AllowTypeMismatch(true))
 }
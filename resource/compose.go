@@ -0,0 +1,814 @@
+package resource
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/git"
+	r3diff "github.com/r3labs/diff/v3"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ComposeContextFiles overrides the compose files loaded, in merge order, relative to the entrypoint
+	ComposeContextFiles = "composeFiles"
+	// ComposeContextEnvFiles lists the env files used for interpolation, defaults to .env
+	ComposeContextEnvFiles = "composeEnvFiles"
+	// ComposeContextEnv is a map of variables used for interpolation, these win over env files
+	ComposeContextEnv = "composeEnv"
+	// ComposeContextProfiles lists the active profiles, falls back to COMPOSE_PROFILES from the interpolation variables
+	ComposeContextProfiles = "composeProfiles"
+)
+
+// composeOverrideFileNames are loaded on top of the main file when no files are explicitly configured
+var composeOverrideFileNames = []string{"compose.override.yaml", "compose.override.yml", "docker-compose.override.yaml", "docker-compose.override.yml"}
+
+// composeSections are the top level sections which become resources, keyed by the kind they are reported as
+var composeSections = map[string]string{
+	"services": "service",
+	"networks": "network",
+	"volumes":  "volume",
+	"secrets":  "secret",
+	"configs":  "config",
+}
+
+// composeUnionSequences are service fields where compose appends override entries rather than replacing them
+var composeUnionSequences = map[string]bool{
+	"ports":          true,
+	"expose":         true,
+	"external_links": true,
+	"dns":            true,
+	"dns_search":     true,
+	"tmpfs":          true,
+	"volumes":        true,
+	"secrets":        true,
+	"configs":        true,
+	"devices":        true,
+	"cap_add":        true,
+	"cap_drop":       true,
+}
+
+// ComposeProject is a fully resolved compose project, with overrides merged, extends resolved, variables
+// interpolated and services outside the active profiles removed
+type ComposeProject struct {
+	Name     string                            `json:"name,omitempty" yaml:"name,omitempty"`
+	Services map[string]map[string]interface{} `json:"services,omitempty" yaml:"services,omitempty"`
+	Networks map[string]map[string]interface{} `json:"networks,omitempty" yaml:"networks,omitempty"`
+	Volumes  map[string]map[string]interface{} `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+	Secrets  map[string]map[string]interface{} `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	Configs  map[string]map[string]interface{} `json:"configs,omitempty" yaml:"configs,omitempty"`
+	// SopsEncrypted are the paths of each entry, by section and name, whose values were decrypted from SOPS files
+	SopsEncrypted map[string]map[string][][]string `json:"-" yaml:"-"`
+}
+
+func (cp *ComposeProject) section(name string) map[string]map[string]interface{} {
+	switch name {
+	case "services":
+		return cp.Services
+	case "networks":
+		return cp.Networks
+	case "volumes":
+		return cp.Volumes
+	case "secrets":
+		return cp.Secrets
+	case "configs":
+		return cp.Configs
+	}
+	return nil
+}
+
+type ComposeResource struct {
+	Kind    string                 `json:"kind"`
+	ResName string                 `json:"resName"`
+	Spec    map[string]interface{} `json:"spec"`
+	// SopsEncrypted are the paths in Spec which hold values decrypted from SOPS files
+	SopsEncrypted [][]string `json:"-"`
+}
+
+func (cr *ComposeResource) Type() string {
+	return string(entrypoint.EntrypointTypeCompose)
+}
+
+func (cr *ComposeResource) Identifier() string {
+	return fmt.Sprintf("%s[%s]", cr.Kind, cr.Name())
+}
+
+func (cr *ComposeResource) Name() string {
+	return cr.ResName
+}
+
+// RenderCompose loads and resolves the compose project in composeDir the same way `docker compose config` would.
+// SOPS encrypted compose and env files are decrypted when a key is available.
+func RenderCompose(ctx context.Context, composeDir string, epctx map[string]interface{}) (*ComposeProject, error) {
+	files, err := composeFiles(composeDir, epctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cl := &composeLoader{
+		ctx:     ctx,
+		dir:     composeDir,
+		files:   map[string]map[string]interface{}{},
+		secrets: map[string]bool{},
+	}
+	cl.vars, err = cl.variables(epctx)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]interface{}{}
+	for _, f := range files {
+		doc, err := cl.load(f)
+		if err != nil {
+			return nil, err
+		}
+		merged = composeMerge(merged, deepCopyValue(doc), "").(map[string]interface{})
+	}
+
+	services, _ := merged["services"].(map[string]interface{})
+	for name := range services {
+		svc, err := cl.resolveExtends(files[0], name, services, map[string]bool{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve extends for service %q - %w", name, err)
+		}
+		services[name] = svc
+	}
+
+	profiles := composeActiveProfiles(epctx, cl.vars)
+	for name, svc := range services {
+		if !composeServiceEnabled(svc, profiles) {
+			delete(services, name)
+		}
+	}
+
+	project := &ComposeProject{
+		Services: map[string]map[string]interface{}{},
+		Networks: map[string]map[string]interface{}{},
+		Volumes:  map[string]map[string]interface{}{},
+		Secrets:  map[string]map[string]interface{}{},
+		Configs:  map[string]map[string]interface{}{},
+	}
+	if n, ok := merged["name"].(string); ok {
+		project.Name = n
+	}
+	for section := range composeSections {
+		entries, _ := merged[section].(map[string]interface{})
+		for name, spec := range entries {
+			m, _ := spec.(map[string]interface{})
+			if m == nil {
+				// Entries such as `volumes: {data: }` are valid and mean "all defaults"
+				m = map[string]interface{}{}
+			}
+			if section == "services" {
+				if err := cl.applyEnvFiles(m); err != nil {
+					return nil, fmt.Errorf("unable to load env_file for service %q - %w", name, err)
+				}
+			}
+			project.section(section)[name] = m
+		}
+	}
+	project.SopsEncrypted = cl.secretPaths(project)
+
+	return project, nil
+}
+
+func composeFiles(composeDir string, epctx map[string]interface{}) ([]string, error) {
+	if configured := contextStringList(epctx[ComposeContextFiles]); len(configured) > 0 {
+		return configured, nil
+	}
+
+	files := []string{}
+	for _, name := range entrypoint.ComposeFileNames {
+		if _, err := os.Stat(path.Join(composeDir, name)); err == nil {
+			files = append(files, name)
+			break
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no compose file found in %q", composeDir)
+	}
+	for _, name := range composeOverrideFileNames {
+		if _, err := os.Stat(path.Join(composeDir, name)); err == nil {
+			files = append(files, name)
+			break
+		}
+	}
+
+	return files, nil
+}
+
+// variables reads the variables compose files are interpolated with
+func (cl *composeLoader) variables(epctx map[string]interface{}) (map[string]string, error) {
+	envFiles := contextStringList(epctx[ComposeContextEnvFiles])
+	explicit := len(envFiles) > 0
+	if !explicit {
+		envFiles = []string{".env"}
+	}
+
+	vars := map[string]string{}
+	for _, f := range envFiles {
+		fileVars, err := cl.envFile(f)
+		if err != nil {
+			if !explicit && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("unable to read env file %q - %w", f, err)
+		}
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+	if env, ok := epctx[ComposeContextEnv].(map[string]interface{}); ok {
+		for k, v := range env {
+			vars[k] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	return vars, nil
+}
+
+func composeActiveProfiles(epctx map[string]interface{}, vars map[string]string) map[string]bool {
+	profiles := contextStringList(epctx[ComposeContextProfiles])
+	if len(profiles) == 0 {
+		profiles = contextStringList(vars["COMPOSE_PROFILES"])
+	}
+	active := map[string]bool{}
+	for _, p := range profiles {
+		active[p] = true
+	}
+	return active
+}
+
+func composeServiceEnabled(svc interface{}, active map[string]bool) bool {
+	m, _ := svc.(map[string]interface{})
+	profiles, ok := m["profiles"].([]interface{})
+	if !ok || len(profiles) == 0 {
+		return true
+	}
+	for _, p := range profiles {
+		if ps, ok := p.(string); ok && (active[ps] || active["*"]) {
+			return true
+		}
+	}
+	return false
+}
+
+type composeLoader struct {
+	ctx   context.Context
+	dir   string
+	vars  map[string]string
+	files map[string]map[string]interface{}
+	// secrets are the values which were decrypted from SOPS files
+	secrets map[string]bool
+}
+
+// load reads, interpolates and normalises a compose file, files are cached as extends may refer to them repeatedly
+func (cl *composeLoader) load(file string) (map[string]interface{}, error) {
+	if doc, ok := cl.files[file]; ok {
+		return doc, nil
+	}
+	content, err := os.ReadFile(path.Join(cl.dir, file))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read compose file %q - %w", file, err)
+	}
+	doc := map[string]interface{}{}
+	sopsDocs, decrypted, err := sopsOpen(cl.ctx, path.Join(cl.dir, file), content)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt compose file %q - %w", file, err)
+	}
+	if len(sopsDocs) > 0 {
+		doc = sopsDocs[0].content
+		if decrypted {
+			for _, p := range sopsDocs[0].paths {
+				cl.addSecret(sopsGetPath(doc, p))
+			}
+		}
+	} else if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("unable to parse compose file %q - %w", file, err)
+	}
+	interpolated, err := composeInterpolate(doc, cl.vars)
+	if err != nil {
+		return nil, fmt.Errorf("unable to interpolate compose file %q - %w", file, err)
+	}
+	doc = interpolated.(map[string]interface{})
+	// Extension fields are only used as YAML anchors and aren't part of the project
+	for k := range doc {
+		if strings.HasPrefix(k, "x-") {
+			delete(doc, k)
+		}
+	}
+	if services, ok := doc["services"].(map[string]interface{}); ok {
+		for name, svc := range services {
+			if m, ok := svc.(map[string]interface{}); ok {
+				services[name] = composeNormaliseService(m)
+			}
+		}
+	}
+	cl.files[file] = doc
+
+	return doc, nil
+}
+
+func (cl *composeLoader) resolveExtends(file, name string, services map[string]interface{}, seen map[string]bool) (map[string]interface{}, error) {
+	svc, ok := services[name].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("service %q not found in %q", name, file)
+	}
+	ext, ok := svc["extends"]
+	if !ok {
+		return svc, nil
+	}
+
+	key := file + "#" + name
+	if seen[key] {
+		return nil, fmt.Errorf("circular extends through %q", key)
+	}
+	seen[key] = true
+
+	baseFile, baseName := file, ""
+	switch e := ext.(type) {
+	case string:
+		baseName = e
+	case map[string]interface{}:
+		baseName, _ = e["service"].(string)
+		if f, ok := e["file"].(string); ok && f != "" {
+			baseFile = filepath.Clean(path.Join(path.Dir(file), f))
+		}
+	}
+
+	baseServices := services
+	if baseFile != file {
+		doc, err := cl.load(baseFile)
+		if err != nil {
+			return nil, err
+		}
+		baseServices, _ = doc["services"].(map[string]interface{})
+	}
+
+	base, err := cl.resolveExtends(baseFile, baseName, baseServices, seen)
+	if err != nil {
+		return nil, err
+	}
+
+	child := map[string]interface{}{}
+	for k, v := range svc {
+		if k != "extends" {
+			child[k] = v
+		}
+	}
+	resolved := composeMerge(deepCopyValue(base), child, "").(map[string]interface{})
+	// depends_on and links are never inherited through extends
+	if _, ok := child["depends_on"]; !ok {
+		delete(resolved, "depends_on")
+	}
+	if _, ok := child["links"]; !ok {
+		delete(resolved, "links")
+	}
+
+	return resolved, nil
+}
+
+// applyEnvFiles folds a service's env_file entries into its environment, explicit environment entries win
+func (cl *composeLoader) applyEnvFiles(svc map[string]interface{}) error {
+	envFiles := []string{}
+	for _, ef := range samList(svc["env_file"]) {
+		switch e := ef.(type) {
+		case string:
+			envFiles = append(envFiles, e)
+		case map[string]interface{}:
+			if p, ok := e["path"].(string); ok {
+				if required, ok := e["required"].(bool); ok && !required {
+					if _, err := os.Stat(path.Join(cl.dir, p)); err != nil {
+						continue
+					}
+				}
+				envFiles = append(envFiles, p)
+			}
+		}
+	}
+	if len(envFiles) == 0 {
+		return nil
+	}
+
+	env := map[string]interface{}{}
+	for _, f := range envFiles {
+		vars, err := cl.envFile(f)
+		if err != nil {
+			return err
+		}
+		for k, v := range vars {
+			env[k] = v
+		}
+	}
+	if existing, ok := svc["environment"].(map[string]interface{}); ok {
+		for k, v := range existing {
+			env[k] = v
+		}
+	}
+	svc["environment"] = env
+	delete(svc, "env_file")
+
+	return nil
+}
+
+// composeNormaliseService converts the list forms of environment and labels into maps so they merge and diff by key
+func composeNormaliseService(svc map[string]interface{}) map[string]interface{} {
+	for _, key := range []string{"environment", "labels", "annotations", "sysctls"} {
+		list, ok := svc[key].([]interface{})
+		if !ok {
+			continue
+		}
+		m := map[string]interface{}{}
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				continue
+			}
+			k, v, hasValue := strings.Cut(s, "=")
+			if hasValue {
+				m[k] = v
+			} else {
+				m[k] = nil
+			}
+		}
+		svc[key] = m
+	}
+
+	return svc
+}
+
+// composeMerge merges override into base following the compose specification merge rules
+func composeMerge(base, override interface{}, key string) interface{} {
+	switch o := override.(type) {
+	case map[string]interface{}:
+		b, ok := base.(map[string]interface{})
+		if !ok {
+			return o
+		}
+		for k, v := range o {
+			if existing, ok := b[k]; ok {
+				b[k] = composeMerge(existing, v, k)
+			} else {
+				b[k] = v
+			}
+		}
+		return b
+	case []interface{}:
+		b, ok := base.([]interface{})
+		if !ok || !composeUnionSequences[key] {
+			return o
+		}
+		seen := map[string]bool{}
+		merged := []interface{}{}
+		for _, item := range append(append([]interface{}{}, b...), o...) {
+			id := fmt.Sprintf("%v", item)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			merged = append(merged, item)
+		}
+		return merged
+	}
+
+	return override
+}
+
+func composeInterpolate(v interface{}, vars map[string]string) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		return interpolateString(val, vars)
+	case map[string]interface{}:
+		for k, item := range val {
+			i, err := composeInterpolate(item, vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			val[k] = i
+		}
+		return val, nil
+	case []interface{}:
+		for idx, item := range val {
+			i, err := composeInterpolate(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			val[idx] = i
+		}
+		return val, nil
+	}
+
+	return v, nil
+}
+
+// interpolateString expands $VAR and ${VAR} references supporting the :-, -, :?, ?, :+ and + modifiers, $$ escapes a literal $
+func interpolateString(s string, vars map[string]string) (string, error) {
+	out := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i == len(s)-1 {
+			out.WriteByte(s[i])
+			continue
+		}
+		next := s[i+1]
+		if next == '$' {
+			out.WriteByte('$')
+			i++
+			continue
+		}
+		if next == '{' {
+			depth := 0
+			end := -1
+			for j := i + 1; j < len(s); j++ {
+				if s[j] == '{' {
+					depth++
+				} else if s[j] == '}' {
+					depth--
+					if depth == 0 {
+						end = j
+						break
+					}
+				}
+			}
+			if end == -1 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			expanded, err := interpolateExpression(s[i+2:end], vars)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(expanded)
+			i = end
+			continue
+		}
+		j := i + 1
+		for j < len(s) && (s[j] == '_' || (s[j] >= 'a' && s[j] <= 'z') || (s[j] >= 'A' && s[j] <= 'Z') || (j > i+1 && s[j] >= '0' && s[j] <= '9')) {
+			j++
+		}
+		if j == i+1 {
+			out.WriteByte('$')
+			continue
+		}
+		out.WriteString(vars[s[i+1:j]])
+		i = j - 1
+	}
+
+	return out.String(), nil
+}
+
+func interpolateExpression(expr string, vars map[string]string) (string, error) {
+	n := 0
+	for n < len(expr) && (expr[n] == '_' || (expr[n] >= 'a' && expr[n] <= 'z') || (expr[n] >= 'A' && expr[n] <= 'Z') || (n > 0 && expr[n] >= '0' && expr[n] <= '9')) {
+		n++
+	}
+	name, rest := expr[:n], expr[n:]
+	if rest == "" {
+		return vars[name], nil
+	}
+
+	value, set := vars[name]
+	empty := !set
+	if strings.HasPrefix(rest, ":") {
+		empty = empty || value == ""
+		rest = rest[1:]
+	}
+	if rest == "" {
+		return "", fmt.Errorf("invalid variable reference %q", expr)
+	}
+	op, arg := rest[0], rest[1:]
+	switch op {
+	case '-':
+		if empty {
+			return interpolateString(arg, vars)
+		}
+		return value, nil
+	case '?':
+		if empty {
+			return "", fmt.Errorf("required variable %q is missing a value: %s", name, arg)
+		}
+		return value, nil
+	case '+':
+		if empty {
+			return "", nil
+		}
+		return interpolateString(arg, vars)
+	}
+
+	return "", fmt.Errorf("invalid variable reference %q", expr)
+}
+
+// envFile reads a dotenv style file, decrypting it if it's SOPS encrypted
+func (cl *composeLoader) envFile(file string) (map[string]string, error) {
+	file = path.Join(cl.dir, file)
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := parseEnv(content)
+	if err != nil {
+		return nil, err
+	}
+	vars, decrypted, err := sopsOpenEnv(cl.ctx, file, vars)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range decrypted {
+		cl.addSecret(vars[k])
+	}
+	return vars, nil
+}
+
+func (cl *composeLoader) addSecret(v interface{}) {
+	if v == nil {
+		return
+	}
+	if s := fmt.Sprintf("%v", v); s != "" {
+		cl.secrets[s] = true
+	}
+}
+
+// secretPaths finds the values of each entry which hold a decrypted value. Values are matched rather than the
+// paths they were decrypted at, as they are interpolated and merged into other values while the project loads.
+func (cl *composeLoader) secretPaths(project *ComposeProject) map[string]map[string][][]string {
+	if len(cl.secrets) == 0 {
+		return nil
+	}
+	found := map[string]map[string][][]string{}
+	for section := range composeSections {
+		for name, spec := range project.section(section) {
+			paths := [][]string{}
+			var walk func(v interface{}, p []string)
+			walk = func(v interface{}, p []string) {
+				switch val := v.(type) {
+				case map[string]interface{}:
+					for k, child := range val {
+						walk(child, append(p, k))
+					}
+				case []interface{}:
+					for i, child := range val {
+						walk(child, append(p, strconv.Itoa(i)))
+					}
+				case nil:
+				default:
+					s := fmt.Sprintf("%v", val)
+					for secret := range cl.secrets {
+						if strings.Contains(s, secret) {
+							paths = append(paths, append([]string{}, p...))
+							return
+						}
+					}
+				}
+			}
+			walk(spec, []string{})
+			if len(paths) > 0 {
+				if found[section] == nil {
+					found[section] = map[string][][]string{}
+				}
+				found[section][name] = paths
+			}
+		}
+	}
+	return found
+}
+
+// parseEnv reads dotenv style KEY=VALUE lines
+func parseEnv(content []byte) (map[string]string, error) {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, _ := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		} else if idx := strings.Index(v, " #"); idx >= 0 {
+			v = strings.TrimSpace(v[:idx])
+		}
+		vars[k] = v
+	}
+
+	return vars, scanner.Err()
+}
+
+// contextStringList reads a context value which may be a list or a comma separated string
+func contextStringList(v interface{}) []string {
+	list := []string{}
+	switch val := v.(type) {
+	case string:
+		for _, s := range strings.Split(val, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	case []string:
+		list = append(list, val...)
+	case []interface{}:
+		for _, s := range val {
+			if str, ok := s.(string); ok {
+				list = append(list, str)
+			}
+		}
+	}
+	return list
+}
+
+type composeDiffer struct{}
+
+// renderVersion changes with the sops binary env and secret files are decrypted with
+func (cd *composeDiffer) renderVersion(ctx context.Context) string {
+	return toolVersion(SopsExecutable)
+}
+
+func (cd *composeDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
+	return diffConcurrent(ctx, ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (*ComposeProject, error) {
+		return RenderCompose(ctx, dir, ep.Context)
+	}, func(old, new *ComposeProject) ([]ResourceDiff, []Resource, []Resource, error) {
+		return doComposeDiff(ctx, old, new)
+	})
+}
+
+func doComposeDiff(ctx context.Context, old, new *ComposeProject) ([]ResourceDiff, []Resource, []Resource, error) {
+	if old == nil {
+		old = &ComposeProject{}
+	}
+	if new == nil {
+		new = &ComposeProject{}
+	}
+
+	sections := make([]string, 0, len(composeSections))
+	for section := range composeSections {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	diff := []ResourceDiff{}
+	allOld := []Resource{}
+	allNew := []Resource{}
+	for _, section := range sections {
+		kind := composeSections[section]
+		oldEntries := old.section(section)
+		newEntries := new.section(section)
+
+		names := []string{}
+		for name := range oldEntries {
+			names = append(names, name)
+		}
+		for name := range newEntries {
+			if _, ok := oldEntries[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			oldSpec, hasOld := oldEntries[name]
+			newSpec, hasNew := newEntries[name]
+			var pre, post Resource
+			if hasOld {
+				pre = &ComposeResource{Kind: kind, ResName: name, Spec: oldSpec, SopsEncrypted: old.SopsEncrypted[section][name]}
+				allOld = append(allOld, pre)
+			}
+			if hasNew {
+				post = &ComposeResource{Kind: kind, ResName: name, Spec: newSpec, SopsEncrypted: new.SopsEncrypted[section][name]}
+				allNew = append(allNew, post)
+			}
+
+			var a, b map[string]interface{}
+			if hasOld {
+				a = oldSpec
+			}
+			if hasNew {
+				b = newSpec
+			}
+			changelog, err := r3diff.Diff(a, b)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("unable to diff %s %q - %w", kind, name, err)
+			}
+
+			switch {
+			case !hasOld:
+				diff = append(diff, ResourceDiff{Type: DiffTypeCreate, Post: post, Diff: changelog})
+			case !hasNew:
+				diff = append(diff, ResourceDiff{Type: DiffTypeDelete, Pre: pre, Diff: changelog})
+			case len(changelog) > 0:
+				diff = append(diff, ResourceDiff{Type: DiffTypeUpdate, Pre: pre, Post: post, Diff: changelog})
+			}
+		}
+	}
+
+	return diff, allOld, allNew, nil
+}
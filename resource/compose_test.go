@@ -0,0 +1,204 @@
+package resource
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRenderCompose(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		epctx map[string]interface{}
+		// want are the services of the project
+		want map[string]map[string]interface{}
+	}{
+		{
+			name: "override merges ports and replaces image",
+			files: map[string]string{
+				"compose.yaml":          "services:\n  web:\n    image: nginx:1.24\n    ports: [\"80:80\"]\n",
+				"compose.override.yaml": "services:\n  web:\n    image: nginx:1.25\n    ports: [\"443:443\"]\n",
+			},
+			want: map[string]map[string]interface{}{
+				"web": {"image": "nginx:1.25", "ports": []interface{}{"80:80", "443:443"}},
+			},
+		},
+		{
+			name: "interpolation from .env and context",
+			files: map[string]string{
+				"compose.yaml": "services:\n  web:\n    image: nginx:${TAG:-latest}\n    command: [\"--env\", \"$ENV\"]\n",
+				".env":         "TAG=1.25\nENV=dev\n",
+			},
+			epctx: map[string]interface{}{ComposeContextEnv: map[string]interface{}{"ENV": "prod"}},
+			want: map[string]map[string]interface{}{
+				"web": {"image": "nginx:1.25", "command": []interface{}{"--env", "prod"}},
+			},
+		},
+		{
+			name: "extends and environment lists",
+			files: map[string]string{
+				"compose.yaml": `services:
+  base:
+    image: app
+    environment: [A=1, B=2]
+    depends_on: [db]
+  worker:
+    extends: base
+    environment:
+      B: "3"
+  db:
+    image: postgres
+`,
+			},
+			want: map[string]map[string]interface{}{
+				"base":   {"image": "app", "environment": map[string]interface{}{"A": "1", "B": "2"}, "depends_on": []interface{}{"db"}},
+				"worker": {"image": "app", "environment": map[string]interface{}{"A": "1", "B": "3"}},
+				"db":     {"image": "postgres"},
+			},
+		},
+		{
+			name: "profiles",
+			files: map[string]string{
+				"compose.yaml": "services:\n  web:\n    image: nginx\n  debug:\n    image: busybox\n    profiles: [debug]\n  tools:\n    image: alpine\n    profiles: [tools]\n",
+			},
+			epctx: map[string]interface{}{ComposeContextProfiles: "tools"},
+			want: map[string]map[string]interface{}{
+				"web":   {"image": "nginx"},
+				"tools": {"image": "alpine", "profiles": []interface{}{"tools"}},
+			},
+		},
+		{
+			name: "env_file folded into environment",
+			files: map[string]string{
+				"compose.yaml": "services:\n  web:\n    image: nginx\n    env_file: [web.env]\n    environment:\n      B: explicit\n",
+				"web.env":      "A=from-file\nB=from-file\n",
+			},
+			want: map[string]map[string]interface{}{
+				"web": {"image": "nginx", "environment": map[string]interface{}{"A": "from-file", "B": "explicit"}},
+			},
+		},
+		{
+			name: "configured files",
+			files: map[string]string{
+				"compose.yaml":   "services:\n  web:\n    image: nginx\n",
+				"deploy/ci.yaml": "services:\n  ci:\n    image: runner\n",
+			},
+			epctx: map[string]interface{}{ComposeContextFiles: []interface{}{"deploy/ci.yaml"}},
+			want: map[string]map[string]interface{}{
+				"ci": {"image": "runner"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFixture(t, dir, tt.files)
+			project, err := RenderCompose(context.Background(), dir, tt.epctx)
+			if err != nil {
+				t.Fatalf("unable to render - %s", err)
+			}
+			if !reflect.DeepEqual(project.Services, tt.want) {
+				t.Errorf("got services %v, expected %v", project.Services, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderComposeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{name: "no compose file", files: map[string]string{"README.md": ""}},
+		{name: "required variable", files: map[string]string{"compose.yaml": "services:\n  web:\n    image: ${IMAGE:?must be set}\n"}},
+		{name: "circular extends", files: map[string]string{"compose.yaml": "services:\n  a:\n    extends: b\n  b:\n    extends: a\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFixture(t, dir, tt.files)
+			if _, err := RenderCompose(context.Background(), dir, nil); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestInterpolateString(t *testing.T) {
+	vars := map[string]string{"SET": "value", "EMPTY": ""}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "$SET", want: "value"},
+		{in: "${SET}-suffix", want: "value-suffix"},
+		{in: "${MISSING-default}", want: "default"},
+		{in: "${EMPTY-default}", want: ""},
+		{in: "${EMPTY:-default}", want: "default"},
+		{in: "${SET:+alt}", want: "alt"},
+		{in: "${MISSING+alt}", want: ""},
+		{in: "${MISSING:-${SET}}", want: "value"},
+		{in: "$$SET", want: "$SET"},
+		{in: "cost $5", want: "cost $5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := interpolateString(tt.in, vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	vars, err := parseEnv([]byte(`# comment
+A=1
+export B = two
+C="quoted # not a comment"
+D='single'
+E=value # comment
+F=
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"A": "1", "B": "two", "C": "quoted # not a comment", "D": "single", "E": "value", "F": ""}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("got %v, expected %v", vars, want)
+	}
+}
+
+func TestDoComposeDiff(t *testing.T) {
+	old := &ComposeProject{
+		Services: map[string]map[string]interface{}{"web": {"image": "nginx:1.24"}, "old": {"image": "busybox"}},
+		Volumes:  map[string]map[string]interface{}{"data": {}},
+	}
+	new := &ComposeProject{
+		Services: map[string]map[string]interface{}{"web": {"image": "nginx:1.25"}, "new": {"image": "alpine"}},
+		Volumes:  map[string]map[string]interface{}{"data": {}},
+	}
+
+	diff, pre, post, err := doComposeDiff(context.Background(), old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]DiffType{}
+	for _, d := range diff {
+		got[d.Identifier()] = d.Type
+	}
+	want := map[string]DiffType{"service[new]": DiffTypeCreate, "service[old]": DiffTypeDelete, "service[web]": DiffTypeUpdate}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %v, expected %v", got, want)
+	}
+	if len(pre) != 3 || len(post) != 3 {
+		t.Errorf("got %d pre and %d post resources, expected 3 of each", len(pre), len(post))
+	}
+}
@@ -0,0 +1,408 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/git"
+	"github.com/pelletier/go-toml/v2"
+	r3diff "github.com/r3labs/diff/v3"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigContextKey is a JSONPath selecting the elements of each file which become resources, e.g. "$.groups[*].rules[*]"
+	ConfigContextKey = "configKey"
+	// ConfigContextIdentity is one or more comma separated JSONPaths, relative to each element, which identify it, e.g. "$.alert"
+	ConfigContextIdentity = "configIdentity"
+)
+
+// ConfigResource is a file, or an element within a file, from a structured config entrypoint
+type ConfigResource struct {
+	File    string      `json:"file"`
+	Key     string      `json:"key"`
+	Path    string      `json:"path"`
+	Content interface{} `json:"content"`
+	// SopsEncrypted are the paths in Content which hold values decrypted from SOPS files
+	SopsEncrypted [][]string `json:"-"`
+}
+
+func (cr *ConfigResource) Type() string {
+	return string(entrypoint.EntrypointTypeConfig)
+}
+
+func (cr *ConfigResource) Identifier() string {
+	return fmt.Sprintf("%s[%s]", cr.File, cr.Name())
+}
+
+func (cr *ConfigResource) Name() string {
+	return cr.Key
+}
+
+// RenderConfig loads the config file, or every config file in the directory, at configPath and splits
+// them into resources using the key and identity JSONPaths from the entrypoint context. SOPS encrypted files are
+// decrypted when a key is available.
+func RenderConfig(ctx context.Context, configPath string, epctx map[string]interface{}) (map[string]*ConfigResource, error) {
+	stat, err := os.Stat(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load config %q - %w", configPath, err)
+	}
+
+	files := map[string]string{}
+	if stat.IsDir() {
+		entries, err := os.ReadDir(configPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && entrypoint.IsConfigFile(entry.Name()) {
+				files[entry.Name()] = path.Join(configPath, entry.Name())
+			}
+		}
+	} else {
+		files[path.Base(configPath)] = configPath
+	}
+
+	key, _ := epctx[ConfigContextKey].(string)
+	identities := contextStringList(epctx[ConfigContextIdentity])
+
+	resources := map[string]*ConfigResource{}
+	for name, file := range files {
+		doc, encrypted, err := loadConfigFile(ctx, file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load config %q - %w", name, err)
+		}
+
+		matches := []jsonPathMatch{{Path: "$", Value: doc}}
+		if key != "" {
+			matches, err = jsonPathSelect(doc, key)
+			if err != nil {
+				return nil, fmt.Errorf("unable to select %q from %q - %w", key, name, err)
+			}
+		}
+
+		for _, match := range matches {
+			id := match.Path
+			if len(identities) > 0 {
+				parts := []string{}
+				for _, identity := range identities {
+					values, err := jsonPathSelect(match.Value, identity)
+					if err != nil {
+						return nil, fmt.Errorf("unable to select identity %q from %q - %w", identity, name, err)
+					}
+					for _, v := range values {
+						parts = append(parts, fmt.Sprintf("%v", v.Value))
+					}
+				}
+				if len(parts) > 0 {
+					id = strings.Join(parts, "/")
+				}
+			}
+
+			// Identities aren't guaranteed to be unique, suffix duplicates so neither is lost
+			uniqueId := id
+			for i := 1; resources[name+"#"+uniqueId] != nil; i++ {
+				uniqueId = fmt.Sprintf("%s#%d", id, i)
+			}
+			resources[name+"#"+uniqueId] = &ConfigResource{
+				File:          name,
+				Key:           uniqueId,
+				Path:          match.Path,
+				Content:       match.Value,
+				SopsEncrypted: configSopsPaths(encrypted, match.Path),
+			}
+		}
+	}
+
+	return resources, nil
+}
+
+// loadConfigFile parses file, along with the paths which were decrypted if it's SOPS encrypted
+func loadConfigFile(ctx context.Context, file string) (interface{}, [][]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !strings.HasSuffix(file, ".toml") {
+		sopsDocs, decrypted, err := sopsOpen(ctx, file, content)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sopsDocs != nil {
+			return configSopsDocument(sopsDocs, decrypted)
+		}
+	}
+
+	var doc interface{}
+	switch {
+	case strings.HasSuffix(file, ".json"):
+		if err := json.Unmarshal(content, &doc); err != nil {
+			return nil, nil, err
+		}
+	case strings.HasSuffix(file, ".toml"):
+		m := map[string]interface{}{}
+		if err := toml.Unmarshal(content, &m); err != nil {
+			return nil, nil, err
+		}
+		doc = m
+	default:
+		// Multi document YAML files are treated as a list of documents
+		docs := []interface{}{}
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		for {
+			var d interface{}
+			if err := dec.Decode(&d); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, nil, err
+			}
+			if d != nil {
+				docs = append(docs, d)
+			}
+		}
+		if len(docs) == 1 {
+			doc = docs[0]
+		} else {
+			doc = docs
+		}
+	}
+
+	return doc, nil, nil
+}
+
+// configSopsDocument is the document of a SOPS encrypted config file, a list when it has several like any other
+func configSopsDocument(sopsDocs []*sopsDocument, decrypted bool) (interface{}, [][]string, error) {
+	encrypted := [][]string{}
+	if len(sopsDocs) == 1 {
+		if decrypted {
+			encrypted = sopsDocs[0].paths
+		}
+		return sopsDocs[0].content, encrypted, nil
+	}
+	docs := []interface{}{}
+	for i, doc := range sopsDocs {
+		docs = append(docs, doc.content)
+		if !decrypted {
+			continue
+		}
+		for _, p := range doc.paths {
+			encrypted = append(encrypted, append([]string{strconv.Itoa(i)}, p...))
+		}
+	}
+	return docs, encrypted, nil
+}
+
+// configSopsPaths are the encrypted paths within the resource selected at matchPath
+func configSopsPaths(encrypted [][]string, matchPath string) [][]string {
+	if len(encrypted) == 0 {
+		return nil
+	}
+	prefix, err := jsonPathParse(matchPath)
+	if err != nil {
+		return nil
+	}
+	paths := [][]string{}
+	for _, p := range encrypted {
+		if len(p) < len(prefix) {
+			continue
+		}
+		matches := true
+		for i := range prefix {
+			if p[i] != prefix[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			paths = append(paths, p[len(prefix):])
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return paths
+}
+
+type jsonPathMatch struct {
+	Path  string
+	Value interface{}
+}
+
+// jsonPathSelect evaluates a subset of JSONPath against v, supporting child names (.name and ['name']),
+// indexes ([0], [-1]) and wildcards (.* and [*]). The leading $ is optional.
+func jsonPathSelect(v interface{}, expr string) ([]jsonPathMatch, error) {
+	segments, err := jsonPathParse(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []jsonPathMatch{{Path: "$", Value: v}}
+	for _, seg := range segments {
+		next := []jsonPathMatch{}
+		for _, m := range matches {
+			switch val := m.Value.(type) {
+			case map[string]interface{}:
+				if seg == "*" {
+					keys := make([]string, 0, len(val))
+					for k := range val {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						next = append(next, jsonPathMatch{Path: jsonPathChild(m.Path, k), Value: val[k]})
+					}
+				} else if child, ok := val[seg]; ok {
+					next = append(next, jsonPathMatch{Path: jsonPathChild(m.Path, seg), Value: child})
+				}
+			case []interface{}:
+				if seg == "*" {
+					for i, child := range val {
+						next = append(next, jsonPathMatch{Path: fmt.Sprintf("%s[%d]", m.Path, i), Value: child})
+					}
+				} else if idx, err := strconv.Atoi(seg); err == nil {
+					if idx < 0 {
+						idx += len(val)
+					}
+					if idx >= 0 && idx < len(val) {
+						next = append(next, jsonPathMatch{Path: fmt.Sprintf("%s[%d]", m.Path, idx), Value: val[idx]})
+					}
+				}
+			}
+		}
+		matches = next
+	}
+
+	return matches, nil
+}
+
+func jsonPathChild(parent, key string) string {
+	for _, r := range key {
+		if !(r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')) {
+			return fmt.Sprintf("%s[%q]", parent, key)
+		}
+	}
+	return parent + "." + key
+}
+
+func jsonPathParse(expr string) ([]string, error) {
+	expr = strings.TrimSpace(expr)
+	expr = strings.TrimPrefix(expr, "$")
+	segments := []string{}
+	for i := 0; i < len(expr); {
+		switch expr[i] {
+		case '.':
+			i++
+			if strings.HasPrefix(expr[i:], ".") {
+				return nil, fmt.Errorf("recursive descent is not supported in %q", expr)
+			}
+			j := i
+			for j < len(expr) && expr[j] != '.' && expr[j] != '[' {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("empty path segment in %q", expr)
+			}
+			segments = append(segments, expr[i:j])
+			i = j
+		case '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated [ in %q", expr)
+			}
+			seg := strings.TrimSpace(expr[i+1 : i+end])
+			if len(seg) >= 2 && (seg[0] == '\'' || seg[0] == '"') && seg[len(seg)-1] == seg[0] {
+				seg = seg[1 : len(seg)-1]
+			}
+			segments = append(segments, seg)
+			i += end + 1
+		default:
+			// Relative paths such as "metadata.name" are allowed for brevity
+			j := i
+			for j < len(expr) && expr[j] != '.' && expr[j] != '[' {
+				j++
+			}
+			segments = append(segments, expr[i:j])
+			i = j
+		}
+	}
+
+	return segments, nil
+}
+
+type configDiffer struct{}
+
+// renderVersion changes with the sops binary config files are decrypted with
+func (cd *configDiffer) renderVersion(ctx context.Context) string {
+	return toolVersion(SopsExecutable)
+}
+
+// renderInputs is the config file, or directory of them, at dir
+func (cd *configDiffer) renderInputs(ep entrypoint.Entrypoint, dir string) ([]string, bool) {
+	return []string{dir}, true
+}
+
+func (cd *configDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
+	return diffConcurrent(ctx, ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (map[string]*ConfigResource, error) {
+		return RenderConfig(ctx, dir, ep.Context)
+	}, func(old, new map[string]*ConfigResource) ([]ResourceDiff, []Resource, []Resource, error) {
+		return doConfigDiff(ctx, old, new)
+	})
+}
+
+func doConfigDiff(ctx context.Context, old, new map[string]*ConfigResource) ([]ResourceDiff, []Resource, []Resource, error) {
+	ids := []string{}
+	for id := range old {
+		ids = append(ids, id)
+	}
+	for id := range new {
+		if _, ok := old[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	diff := []ResourceDiff{}
+	allOld := []Resource{}
+	allNew := []Resource{}
+	for _, id := range ids {
+		pre, hasOld := old[id]
+		post, hasNew := new[id]
+		var a, b interface{}
+		if hasOld {
+			allOld = append(allOld, pre)
+			a = pre.Content
+		}
+		if hasNew {
+			allNew = append(allNew, post)
+			b = post.Content
+		}
+
+		changelog, err := r3diff.Diff(a, b)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to diff config %q - %w", id, err)
+		}
+
+		switch {
+		case !hasOld:
+			diff = append(diff, ResourceDiff{Type: DiffTypeCreate, Post: post, Diff: changelog})
+		case !hasNew:
+			diff = append(diff, ResourceDiff{Type: DiffTypeDelete, Pre: pre, Diff: changelog})
+		case len(changelog) > 0:
+			diff = append(diff, ResourceDiff{Type: DiffTypeUpdate, Pre: pre, Post: post, Diff: changelog})
+		}
+	}
+
+	return diff, allOld, allNew, nil
+}
@@ -0,0 +1,155 @@
+package resource
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const testRules = `groups:
+  - name: api
+    rules:
+      - alert: HighLatency
+        expr: latency > 1
+      - alert: HighErrors
+        expr: errors > 1
+  - name: db
+    rules:
+      - alert: HighLatency
+        expr: db_latency > 1
+`
+
+func TestRenderConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		path  string
+		epctx map[string]interface{}
+		// want maps the key of each resource to its content
+		want map[string]interface{}
+	}{
+		{
+			name:  "whole yaml file",
+			files: map[string]string{"app.yaml": "replicas: 2\n"},
+			path:  "app.yaml",
+			want:  map[string]interface{}{"app.yaml#$": map[string]interface{}{"replicas": 2}},
+		},
+		{
+			name:  "directory of json and toml",
+			files: map[string]string{"a.json": `{"enabled": true}`, "b.toml": "port = 80\n", "README.md": "ignored"},
+			path:  ".",
+			want: map[string]interface{}{
+				"a.json#$": map[string]interface{}{"enabled": true},
+				"b.toml#$": map[string]interface{}{"port": int64(80)},
+			},
+		},
+		{
+			name:  "multi document yaml",
+			files: map[string]string{"docs.yaml": "a: 1\n---\nb: 2\n"},
+			path:  "docs.yaml",
+			epctx: map[string]interface{}{ConfigContextKey: "$[*]"},
+			want: map[string]interface{}{
+				"docs.yaml#$[0]": map[string]interface{}{"a": 1},
+				"docs.yaml#$[1]": map[string]interface{}{"b": 2},
+			},
+		},
+		{
+			name:  "key and duplicate identities",
+			files: map[string]string{"rules.yaml": testRules},
+			path:  "rules.yaml",
+			epctx: map[string]interface{}{ConfigContextKey: "$.groups[*].rules[*]", ConfigContextIdentity: "$.alert"},
+			want: map[string]interface{}{
+				"rules.yaml#HighLatency":   map[string]interface{}{"alert": "HighLatency", "expr": "latency > 1"},
+				"rules.yaml#HighErrors":    map[string]interface{}{"alert": "HighErrors", "expr": "errors > 1"},
+				"rules.yaml#HighLatency#1": map[string]interface{}{"alert": "HighLatency", "expr": "db_latency > 1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFixture(t, dir, tt.files)
+			resources, err := RenderConfig(context.Background(), filepath.Join(dir, tt.path), tt.epctx)
+			if err != nil {
+				t.Fatalf("unable to render - %s", err)
+			}
+			got := map[string]interface{}{}
+			for id, r := range resources {
+				got[id] = r.Content
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJsonPathSelect(t *testing.T) {
+	doc := map[string]interface{}{
+		"items": []interface{}{"a", "b", "c"},
+		"meta":  map[string]interface{}{"name": "x", "the key": "y"},
+	}
+
+	tests := []struct {
+		expr  string
+		paths []string
+	}{
+		{expr: "$.items[*]", paths: []string{"$.items[0]", "$.items[1]", "$.items[2]"}},
+		{expr: "$.items[-1]", paths: []string{"$.items[2]"}},
+		{expr: "items[5]", paths: []string{}},
+		{expr: "$.meta.*", paths: []string{"$.meta.name", `$.meta["the key"]`}},
+		{expr: "$['meta']['the key']", paths: []string{`$.meta["the key"]`}},
+		{expr: "$.missing.name", paths: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			matches, err := jsonPathSelect(doc, tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths := []string{}
+			for _, m := range matches {
+				paths = append(paths, m.Path)
+			}
+			sort.Strings(paths)
+			if !reflect.DeepEqual(paths, tt.paths) {
+				t.Errorf("got %v, expected %v", paths, tt.paths)
+			}
+		})
+	}
+}
+
+func TestJsonPathParseErrors(t *testing.T) {
+	for _, expr := range []string{"$..name", "$.a[0", "$.a..b"} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := jsonPathParse(expr); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestConfigSopsPaths(t *testing.T) {
+	encrypted := [][]string{{"groups", "0", "password"}, {"groups", "1", "token"}, {"top"}}
+
+	tests := []struct {
+		matchPath string
+		want      [][]string
+	}{
+		{matchPath: "$", want: encrypted},
+		{matchPath: "$.groups[0]", want: [][]string{{"password"}}},
+		{matchPath: "$.groups[2]", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.matchPath, func(t *testing.T) {
+			if got := configSopsPaths(encrypted, tt.matchPath); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
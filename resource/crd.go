@@ -0,0 +1,609 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/codingninja/gitops-repo-api/util"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+type crdSchemaContextKey struct{}
+
+// crdVersion is a single version of a custom resource and the schema its instances must match
+type crdVersion struct {
+	Served bool
+	Schema map[string]interface{}
+}
+
+// crdDefinition is every known version of a single group and kind
+type crdDefinition struct {
+	Source   string
+	Versions map[string]*crdVersion
+}
+
+// CrdSchemas holds the structural schemas of the custom resources known in a single revision, keyed by group and kind
+type CrdSchemas struct {
+	definitions map[string]*crdDefinition
+}
+
+// CrdSchemaSet holds the schemas of both revisions, so each side of a diff is normalised and validated
+// against the CustomResourceDefinitions it would be applied alongside
+type CrdSchemaSet struct {
+	Pre  *CrdSchemas
+	Post *CrdSchemas
+}
+
+func (css *CrdSchemaSet) pre() *CrdSchemas {
+	if css == nil {
+		return nil
+	}
+	return css.Pre
+}
+
+func (css *CrdSchemaSet) post() *CrdSchemas {
+	if css == nil {
+		return nil
+	}
+	return css.Post
+}
+
+func NewCrdSchemas() *CrdSchemas {
+	return &CrdSchemas{definitions: map[string]*crdDefinition{}}
+}
+
+// WithCrdSchemas returns a context which makes the schemas available to the kubernetes differs
+func WithCrdSchemas(ctx context.Context, schemas *CrdSchemaSet) context.Context {
+	return context.WithValue(ctx, crdSchemaContextKey{}, schemas)
+}
+
+func crdSchemasFromContext(ctx context.Context) *CrdSchemaSet {
+	if ctx == nil {
+		return nil
+	}
+	schemas, _ := ctx.Value(crdSchemaContextKey{}).(*CrdSchemaSet)
+	return schemas
+}
+
+// CollectCrdSchemas builds the schemas for each revision from the CustomResourceDefinitions rendered by any entrypoint,
+// plus the CRDs and OpenAPI schemas in extraDir (if set). Nil is returned if there are no schemas at all.
+func CollectCrdSchemas(pre, post []Resource, extraDir string) (*CrdSchemaSet, error) {
+	set := &CrdSchemaSet{Pre: NewCrdSchemas(), Post: NewCrdSchemas()}
+	if extraDir != "" {
+		if err := set.Pre.LoadDir(extraDir); err != nil {
+			return nil, fmt.Errorf("unable to load crd schemas from %q - %w", extraDir, err)
+		}
+		if err := set.Post.LoadDir(extraDir); err != nil {
+			return nil, fmt.Errorf("unable to load crd schemas from %q - %w", extraDir, err)
+		}
+	}
+
+	// CRDs in the repository take precedence over the extra schemas
+	var errs error
+	if err := set.Pre.AddResources(pre); err != nil {
+		errs = errors.Join(errs, err)
+	}
+	if err := set.Post.AddResources(post); err != nil {
+		errs = errors.Join(errs, err)
+	}
+
+	if len(set.Pre.definitions) == 0 && len(set.Post.definitions) == 0 {
+		return nil, errs
+	}
+
+	return set, errs
+}
+
+// AddResources registers every CustomResourceDefinition found in resources
+func (cs *CrdSchemas) AddResources(resources []Resource) error {
+	var errs error
+	for _, r := range resources {
+		kr, ok := r.(*KubernetesResource)
+		if !ok || kr.Resource == nil || kr.Resource.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		obj, err := kubeResourceObject(kr.Resource)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("unable to read CustomResourceDefinition %q - %w", kr.Resource.GetName(), err))
+			continue
+		}
+		if err := cs.addCrd(obj, kr.Origin.Path); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// LoadDir registers the CustomResourceDefinitions and OpenAPI schemas in the YAML and JSON files under dir. OpenAPI
+// schemas are matched to resources using their x-kubernetes-group-version-kind extension.
+func (cs *CrdSchemas) LoadDir(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		isSchema := false
+		for _, ext := range util.KubeFileExtensions {
+			if strings.HasSuffix(p, ext) {
+				isSchema = true
+			}
+		}
+		if !isSchema {
+			return nil
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		for {
+			doc := map[string]interface{}{}
+			if err := dec.Decode(&doc); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return fmt.Errorf("unable to parse %q - %w", p, err)
+			}
+			if doc["kind"] == "CustomResourceDefinition" {
+				if err := cs.addCrd(doc, p); err != nil {
+					return err
+				}
+				continue
+			}
+			cs.addOpenAPI(doc, p)
+		}
+
+		return nil
+	})
+}
+
+func (cs *CrdSchemas) definition(group, kind, source string) *crdDefinition {
+	key := group + "/" + kind
+	def := &crdDefinition{Source: source, Versions: map[string]*crdVersion{}}
+	cs.definitions[key] = def
+	return def
+}
+
+func (cs *CrdSchemas) addCrd(obj map[string]interface{}, source string) error {
+	spec, _ := obj["spec"].(map[string]interface{})
+	names, _ := spec["names"].(map[string]interface{})
+	group, _ := spec["group"].(string)
+	kind, _ := names["kind"].(string)
+	if group == "" || kind == "" {
+		return fmt.Errorf("CustomResourceDefinition in %q has no group or kind", source)
+	}
+
+	def := cs.definition(group, kind, source)
+
+	// v1beta1 CRDs may declare a single schema for every version
+	var shared map[string]interface{}
+	if validation, ok := spec["validation"].(map[string]interface{}); ok {
+		shared, _ = validation["openAPIV3Schema"].(map[string]interface{})
+	}
+	if version, ok := spec["version"].(string); ok && version != "" {
+		def.Versions[version] = &crdVersion{Served: true, Schema: shared}
+	}
+
+	versions, _ := spec["versions"].([]interface{})
+	for _, v := range versions {
+		version, _ := v.(map[string]interface{})
+		name, _ := version["name"].(string)
+		if name == "" {
+			continue
+		}
+		served, ok := version["served"].(bool)
+		if !ok {
+			served = true
+		}
+		schema := shared
+		if s, ok := version["schema"].(map[string]interface{}); ok {
+			if openapi, ok := s["openAPIV3Schema"].(map[string]interface{}); ok {
+				schema = openapi
+			}
+		}
+		def.Versions[name] = &crdVersion{Served: served, Schema: schema}
+	}
+
+	return nil
+}
+
+func (cs *CrdSchemas) addOpenAPI(doc map[string]interface{}, source string) {
+	definitions, _ := doc["definitions"].(map[string]interface{})
+	if components, ok := doc["components"].(map[string]interface{}); ok {
+		definitions, _ = components["schemas"].(map[string]interface{})
+	}
+	for _, d := range definitions {
+		schema, ok := d.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		gvks, _ := schema["x-kubernetes-group-version-kind"].([]interface{})
+		for _, g := range gvks {
+			gvk, _ := g.(map[string]interface{})
+			group, _ := gvk["group"].(string)
+			version, _ := gvk["version"].(string)
+			kind, _ := gvk["kind"].(string)
+			if version == "" || kind == "" {
+				continue
+			}
+			def, ok := cs.definitions[group+"/"+kind]
+			if !ok {
+				def = cs.definition(group, kind, source)
+			}
+			def.Versions[version] = &crdVersion{Served: true, Schema: schema}
+		}
+	}
+}
+
+// lookup returns the definition for the group and kind of apiVersion and kind, and the version of it, if any
+func (cs *CrdSchemas) lookup(apiVersion, kind string) (*crdDefinition, *crdVersion) {
+	if cs == nil {
+		return nil, nil
+	}
+	group, version := "", apiVersion
+	if i := strings.LastIndex(apiVersion, "/"); i != -1 {
+		group, version = apiVersion[:i], apiVersion[i+1:]
+	}
+	def, ok := cs.definitions[group+"/"+kind]
+	if !ok {
+		return nil, nil
+	}
+
+	return def, def.Versions[version]
+}
+
+// Validate checks obj against the schema of its CustomResourceDefinition, returning a description of every violation.
+// Objects without a CustomResourceDefinition are not validated.
+func (cs *CrdSchemas) Validate(obj map[string]interface{}) []string {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	def, version := cs.lookup(apiVersion, kind)
+	if def == nil {
+		return nil
+	}
+	if version == nil {
+		return []string{fmt.Sprintf("%s is not a version of %s defined by %q", apiVersion, kind, def.Source)}
+	}
+	if !version.Served {
+		return []string{fmt.Sprintf("%s is not served for %s", apiVersion, kind)}
+	}
+	if version.Schema == nil {
+		return nil
+	}
+
+	errs := []string{}
+	crdValidate(version.Schema, obj, "", true, &errs)
+	return errs
+}
+
+// validateResource validates a rendered resource, returning nil when there are no schemas to validate against
+func (cs *CrdSchemas) validateResource(r *resource.Resource) []string {
+	if cs == nil || len(cs.definitions) == 0 {
+		return nil
+	}
+	obj, err := kubeResourceObject(r)
+	if err != nil {
+		return []string{fmt.Sprintf("unable to read resource - %s", err)}
+	}
+	return cs.Validate(obj)
+}
+
+// normalise applies the defaults and pruning the API server would to obj, and keys lists declared as
+// x-kubernetes-list-type map or set so their items are compared by identity. The values of pruned
+// fields are returned by path.
+func (cs *CrdSchemas) normalise(obj map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	_, version := cs.lookup(apiVersion, kind)
+	if version == nil || version.Schema == nil {
+		return obj, nil
+	}
+
+	pruned := map[string]interface{}{}
+	normalised, _ := crdNormalise(version.Schema, obj, "", true, pruned).(map[string]interface{})
+	return normalised, pruned
+}
+
+// crdPrunedNotes describes the changes hidden by pruning fields which aren't in the schema
+func crdPrunedNotes(pre, post map[string]interface{}) []string {
+	paths := map[string]bool{}
+	for p := range pre {
+		paths[p] = true
+	}
+	for p := range post {
+		paths[p] = true
+	}
+
+	notes := []string{}
+	for p := range paths {
+		if !reflect.DeepEqual(pre[p], post[p]) {
+			notes = append(notes, fmt.Sprintf("change to %s ignored as the field is not in the CustomResourceDefinition schema and will be pruned", p))
+		}
+	}
+	sort.Strings(notes)
+
+	return notes
+}
+
+func crdNormalise(schema map[string]interface{}, v interface{}, p string, root bool, pruned map[string]interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		preserve, _ := schema["x-kubernetes-preserve-unknown-fields"].(bool)
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		out := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			// The API server handles apiVersion, kind and metadata itself
+			if root && (k == "apiVersion" || k == "kind" || k == "metadata") {
+				out[k] = child
+				continue
+			}
+			childPath := crdChildPath(p, k)
+			if propSchema, ok := properties[k].(map[string]interface{}); ok {
+				out[k] = crdNormalise(propSchema, child, childPath, false, pruned)
+			} else if additional != nil {
+				out[k] = crdNormalise(additional, child, childPath, false, pruned)
+			} else if preserve || (properties == nil && schema["type"] != "object") {
+				out[k] = child
+			} else {
+				pruned[childPath] = child
+			}
+		}
+		for k, prop := range properties {
+			propSchema, _ := prop.(map[string]interface{})
+			if def, ok := propSchema["default"]; ok {
+				if _, set := out[k]; !set {
+					out[k] = crdNormalise(propSchema, deepCopyValue(def), crdChildPath(p, k), false, pruned)
+				}
+			}
+		}
+		return out
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		out := make([]interface{}, len(val))
+		for i, child := range val {
+			out[i] = crdNormalise(items, child, fmt.Sprintf("%s[%d]", p, i), false, pruned)
+		}
+		switch schema["x-kubernetes-list-type"] {
+		case "map":
+			if keyed, ok := crdKeyList(out, crdStringList(schema["x-kubernetes-list-map-keys"])); ok {
+				return keyed
+			}
+		case "set":
+			keyed := make(map[string]interface{}, len(out))
+			for _, item := range out {
+				keyed[fmt.Sprintf("[%v]", item)] = item
+			}
+			if len(keyed) == len(out) {
+				return keyed
+			}
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// crdKeyList keys list items by the combination of every key, e.g. "[name=web,protocol=TCP]"
+func crdKeyList(list []interface{}, keys []string) (map[string]interface{}, bool) {
+	if len(keys) == 0 {
+		return nil, false
+	}
+	keyed := make(map[string]interface{}, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		parts := make([]string, 0, len(keys))
+		for _, key := range keys {
+			parts = append(parts, fmt.Sprintf("%s=%v", key, m[key]))
+		}
+		name := "[" + strings.Join(parts, ",") + "]"
+		if _, dup := keyed[name]; dup {
+			return nil, false
+		}
+		keyed[name] = m
+	}
+
+	return keyed, true
+}
+
+func crdValidate(schema map[string]interface{}, v interface{}, p string, root bool, errs *[]string) {
+	field := p
+	if field == "" {
+		field = "<root>"
+	}
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && schema["type"] != nil {
+			fail("must not be null")
+		}
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) || fmt.Sprintf("%v", e) == fmt.Sprintf("%v", v) {
+				found = true
+			}
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+
+	intOrString, _ := schema["x-kubernetes-int-or-string"].(bool)
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, req := range crdStringList(schema["required"]) {
+			if _, ok := obj[req]; !ok {
+				fail("missing required field %q", req)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for _, k := range crdSortedKeys(obj) {
+			if root && (k == "apiVersion" || k == "kind" || k == "metadata") {
+				continue
+			}
+			if propSchema, ok := properties[k].(map[string]interface{}); ok {
+				crdValidate(propSchema, obj[k], crdChildPath(p, k), false, errs)
+			} else if additional != nil {
+				crdValidate(additional, obj[k], crdChildPath(p, k), false, errs)
+			}
+		}
+		crdValidateLength(schema, len(obj), "minProperties", "maxProperties", "properties", fail)
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range list {
+			if items != nil {
+				crdValidate(items, item, fmt.Sprintf("%s[%d]", p, i), false, errs)
+			}
+		}
+		crdValidateLength(schema, len(list), "minItems", "maxItems", "items", fail)
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			if _, isInt := v.(int); !(intOrString && isInt) {
+				fail("must be a string")
+			}
+			return
+		}
+		crdValidateLength(schema, len([]rune(s)), "minLength", "maxLength", "characters", fail)
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(s) {
+				fail("must match %q", pattern)
+			}
+		}
+	case "integer":
+		n, ok := crdNumber(v)
+		if !ok || n != float64(int64(n)) {
+			if _, isString := v.(string); !(intOrString && isString) {
+				fail("must be an integer")
+			}
+			return
+		}
+		crdValidateRange(schema, n, fail)
+	case "number":
+		n, ok := crdNumber(v)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		crdValidateRange(schema, n, fail)
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("must be a boolean")
+		}
+	case nil:
+		if intOrString {
+			switch v.(type) {
+			case int, string:
+			default:
+				fail("must be an integer or string")
+			}
+		}
+	}
+}
+
+func crdValidateLength(schema map[string]interface{}, length int, minKey, maxKey, unit string, fail func(string, ...interface{})) {
+	if min, ok := crdNumber(schema[minKey]); ok && float64(length) < min {
+		fail("must have at least %v %s", min, unit)
+	}
+	if max, ok := crdNumber(schema[maxKey]); ok && float64(length) > max {
+		fail("must have at most %v %s", max, unit)
+	}
+}
+
+func crdValidateRange(schema map[string]interface{}, n float64, fail func(string, ...interface{})) {
+	exclusiveMin, _ := schema["exclusiveMinimum"].(bool)
+	exclusiveMax, _ := schema["exclusiveMaximum"].(bool)
+	if min, ok := crdNumber(schema["minimum"]); ok && (n < min || (exclusiveMin && n == min)) {
+		fail("must be greater than %s%v", map[bool]string{true: "", false: "or equal to "}[exclusiveMin], min)
+	}
+	if max, ok := crdNumber(schema["maximum"]); ok && (n > max || (exclusiveMax && n == max)) {
+		fail("must be less than %s%v", map[bool]string{true: "", false: "or equal to "}[exclusiveMax], max)
+	}
+}
+
+func crdNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func crdStringList(v interface{}) []string {
+	list, _ := v.([]interface{})
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func crdSortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func crdChildPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// kubeResourceObject decodes a kustomize resource into plain maps and lists
+func kubeResourceObject(r *resource.Resource) (map[string]interface{}, error) {
+	y, err := r.AsYAML()
+	if err != nil {
+		return nil, err
+	}
+	obj := make(map[string]interface{})
+	if err := yaml.Unmarshal(y, &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
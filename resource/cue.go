@@ -0,0 +1,225 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/git"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/kustomize/api/provider"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+var CueExecutable = "cue"
+
+const (
+	// CueContextPackage selects the package to export when a directory contains more than one, e.g. ".:deploy"
+	CueContextPackage = "cuePackage"
+	// CueContextExpression limits the export to a single expression, e.g. "objects"
+	CueContextExpression = "cueExpression"
+)
+
+var cueTagRegex = regexp.MustCompile(`@tag\(\s*([A-Za-z_$][A-Za-z0-9_$]*)`)
+
+// CueEvaluationError is returned when cue is unable to evaluate or validate a package, it holds each
+// individual error cue reported so they can be surfaced per entrypoint
+type CueEvaluationError struct {
+	Directory string   `json:"directory"`
+	Errors    []string `json:"errors"`
+}
+
+func (ce *CueEvaluationError) Error() string {
+	return fmt.Sprintf("cue evaluation of %q failed - %s", ce.Directory, strings.Join(ce.Errors, "; "))
+}
+
+// RenderCue exports the cue package in cueDir and returns every Kubernetes object found in the output
+func RenderCue(ctx context.Context, cueDir string, epctx map[string]interface{}) (resmap.ResMap, error) {
+	cuePath, err := exec.LookPath(CueExecutable)
+	if err != nil {
+		return nil, toolMissingError(CueExecutable, err)
+	}
+
+	pkg := "."
+	if p, ok := epctx[CueContextPackage].(string); ok && p != "" {
+		pkg = p
+	}
+	args := []string{"export", pkg, "--out", "yaml"}
+	if e, ok := epctx[CueContextExpression].(string); ok && e != "" {
+		args = append(args, "-e", e)
+	}
+
+	tags, err := cueDeclaredTags(cueDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		if v, ok := epctx[tag]; ok {
+			switch v.(type) {
+			case string, bool, int, int64, float64:
+				args = append(args, "-t", fmt.Sprintf("%s=%v", tag, v))
+			}
+		}
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	exportCmd := renderCommand(ctx, cuePath, args...)
+	exportCmd.Dir = cueDir
+	exportCmd.Stdout = stdout
+	exportCmd.Stderr = stderr
+	if err := exportCmd.Run(); err != nil {
+		if stderr.Len() == 0 {
+			return nil, commandError(exportCmd, err, nil)
+		}
+		evalErr := &CueEvaluationError{
+			Directory: cueDir,
+			Errors:    cueSplitErrors(stderr.String()),
+		}
+		return nil, toolError(CueExecutable, evalErr.Error(), evalErr, stderr.String())
+	}
+
+	objects := []interface{}{}
+	dec := yaml.NewDecoder(stdout)
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("unable to parse cue export output - %w", err)
+		}
+		objects = cueCollectObjects(doc, objects)
+	}
+
+	manifests := [][]byte{}
+	for _, obj := range objects {
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal cue object - %w", err)
+		}
+		manifests = append(manifests, b)
+	}
+
+	rf := resmap.NewFactory(provider.NewDefaultDepProvider().GetResourceFactory())
+	rm, err := rf.NewResMapFromBytes(bytes.Join(manifests, []byte("---\n")))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load cue objects as kubernetes resources - %w", err)
+	}
+
+	return rm, nil
+}
+
+// cueDeclaredTags returns the names of every @tag() attribute in the package, cue refuses to
+// export when given a tag which isn't declared so we only inject context keys which are
+func cueDeclaredTags(cueDir string) ([]string, error) {
+	entries, err := os.ReadDir(cueDir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".cue") {
+			continue
+		}
+		content, err := os.ReadFile(path.Join(cueDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range cueTagRegex.FindAllSubmatch(content, -1) {
+			tag := string(match[1])
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+// cueCollectObjects walks the exported value and collects anything that looks like a Kubernetes object,
+// this lets packages lay out their objects as lists or nested maps keyed by kind and name
+func cueCollectObjects(v interface{}, objects []interface{}) []interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		apiVersion, hasAPIVersion := val["apiVersion"].(string)
+		kind, hasKind := val["kind"].(string)
+		if hasAPIVersion && hasKind && apiVersion != "" && kind != "" {
+			return append(objects, val)
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			objects = cueCollectObjects(val[k], objects)
+		}
+	case []interface{}:
+		for _, item := range val {
+			objects = cueCollectObjects(item, objects)
+		}
+	}
+
+	return objects
+}
+
+// cueSplitErrors splits cue's stderr into one entry per reported error, continuation lines
+// (file positions) are indented and kept with the error they belong to
+func cueSplitErrors(output string) []string {
+	errs := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(errs) > 0 {
+			errs[len(errs)-1] = errs[len(errs)-1] + " " + strings.TrimSpace(line)
+			continue
+		}
+		errs = append(errs, line)
+	}
+
+	return errs
+}
+
+type cueDiffer struct{}
+
+// renderVersion changes with the cue binary entrypoints are exported with
+func (cd *cueDiffer) renderVersion(ctx context.Context) string {
+	return toolVersion(CueExecutable)
+}
+
+// renderInputs is the cue module dir belongs to, found by its cue.mod directory, as packages may import any other
+// package of the module or one vendored in cue.mod. A package outside of a module is only dir.
+func (cd *cueDiffer) renderInputs(ep entrypoint.Entrypoint, dir string) ([]string, bool) {
+	for p := dir; ; {
+		if info, err := os.Stat(path.Join(p, "cue.mod")); err == nil && info.IsDir() {
+			return []string{p}, true
+		}
+		parent := path.Dir(p)
+		if parent == p {
+			return []string{dir}, true
+		}
+		p = parent
+	}
+}
+
+func (cd *cueDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
+	return diffConcurrent(ctx, ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (resmap.ResMap, error) {
+		return RenderCue(ctx, dir, ep.Context)
+	}, func(old, new resmap.ResMap) ([]ResourceDiff, []Resource, []Resource, error) {
+		return doResmapDiff(ctx, rs, ep, old, new)
+	})
+}
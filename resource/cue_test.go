@@ -0,0 +1,144 @@
+package resource
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestRenderCue(t *testing.T) {
+	if _, err := exec.LookPath(CueExecutable); err != nil {
+		t.Skipf("%s isn't installed", CueExecutable)
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		epctx map[string]interface{}
+		want  []string
+	}{
+		{
+			name: "objects nested by kind",
+			files: map[string]string{"main.cue": `package deploy
+
+configMap: web: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: name: "web"
+	data: key: "value"
+}
+`},
+			want: []string{"ConfigMap.v1.[noGrp]/web.[noNs]"},
+		},
+		{
+			name: "tag from context",
+			files: map[string]string{"main.cue": `package deploy
+
+env: string @tag(env)
+
+objects: [{
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: name: "web-\(env)"
+}]
+`},
+			epctx: map[string]interface{}{"env": "prod", CueContextExpression: "objects"},
+			want:  []string{"ConfigMap.v1.[noGrp]/web-prod.[noNs]"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFixture(t, dir, tt.files)
+			rm, err := RenderCue(context.Background(), dir, tt.epctx)
+			if err != nil {
+				t.Fatalf("unable to render - %s", err)
+			}
+			got := []string{}
+			for _, r := range rm.Resources() {
+				got = append(got, r.CurId().String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCueDeclaredTags(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, map[string]string{
+		"a.cue":     "env: string @tag(env)\nregion: string @tag( region, short=eu|us)\n",
+		"b.cue":     "other: string @tag(env)\n",
+		"c.yaml":    "ignored: string @tag(yaml)\n",
+		"sub/d.cue": "nested: string @tag(nested)\n",
+	})
+
+	tags, err := cueDeclaredTags(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"env", "region"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("got tags %v, expected %v", tags, want)
+	}
+}
+
+func TestCueCollectObjects(t *testing.T) {
+	cm := func(name string) map[string]interface{} {
+		return map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": name}}
+	}
+
+	tests := []struct {
+		name string
+		in   interface{}
+		want []interface{}
+	}{
+		{name: "single object", in: cm("a"), want: []interface{}{cm("a")}},
+		{name: "list", in: []interface{}{cm("a"), cm("b")}, want: []interface{}{cm("a"), cm("b")}},
+		{
+			name: "nested maps in key order",
+			in:   map[string]interface{}{"z": map[string]interface{}{"b": cm("b")}, "a": cm("a")},
+			want: []interface{}{cm("a"), cm("b")},
+		},
+		{
+			name: "empty kind isn't an object",
+			in:   map[string]interface{}{"apiVersion": "v1", "kind": ""},
+			want: []interface{}{},
+		},
+		{name: "scalars", in: "value", want: []interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cueCollectObjects(tt.in, []interface{}{})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCueSplitErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{
+			name:   "positions kept with their error",
+			output: "objects.a: conflicting values 1 and 2:\n    ./a.cue:1:4\n    ./b.cue:2:4\nobjects.b: incomplete value string\n",
+			want:   []string{"objects.a: conflicting values 1 and 2: ./a.cue:1:4 ./b.cue:2:4", "objects.b: incomplete value string"},
+		},
+		{name: "blank lines", output: "\nfirst\n\nsecond\n", want: []string{"first", "second"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cueSplitErrors(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
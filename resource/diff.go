@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/codingninja/gitops-repo-api/entrypoint"
 	"github.com/codingninja/gitops-repo-api/git"
@@ -19,6 +20,17 @@ const (
 	DiffTypeDelete  DiffType = r3diff.DELETE
 )
 
+// UpdateImpact is how applying an update affects the deployed resource
+type UpdateImpact string
+
+const (
+	UpdateImpactNoInterruption   UpdateImpact = "no-interruption"
+	UpdateImpactSomeInterruption UpdateImpact = "some-interruption"
+	UpdateImpactReplacement      UpdateImpact = "replacement"
+	// UpdateImpactUnknown is used when the differ can't tell whether the update replaces the resource
+	UpdateImpactUnknown UpdateImpact = "unknown"
+)
+
 type Resource interface {
 	Type() string
 	Identifier() string
@@ -30,6 +42,14 @@ type ResourceDiff struct {
 	Pre  Resource         `json:"pre"`
 	Post Resource         `json:"post"`
 	Diff r3diff.Changelog `json:"diff"`
+	// Notes explain changes which were collapsed or reinterpreted by the differ
+	Notes []string `json:"notes,omitempty"`
+	// Impact is how the change affects the deployed resource, where the differ can predict it
+	Impact UpdateImpact `json:"impact,omitempty"`
+	// ReplacedBy are the changed fields which cause the resource to be replaced
+	ReplacedBy []string `json:"replacedBy,omitempty"`
+	// Breaking marks changes which break dependents outside the entrypoint, such as stacks importing a removed export
+	Breaking bool `json:"breaking,omitempty"`
 }
 
 type fakeResourceDiff ResourceDiff
@@ -85,19 +105,44 @@ type ResourceDiffer interface {
 	Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error)
 }
 
+// EntrypointDiffer returns the differ for the entrypoint's type. Every differ's results are redacted as configured
+// by WithRedaction before they are returned.
 func EntrypointDiffer(ep entrypoint.Entrypoint) (ResourceDiffer, error) {
-	switch ep.Type {
-	case entrypoint.EntrypointTypeKubernetes:
-		return &kubeDiffer{}, nil
-	case entrypoint.EntrypointTypeKustomize:
-		return &kustomizeDiffer{}, nil
-	case entrypoint.EntrypointTypeTerraform:
-		return &tfDiffer{}, nil
-	case entrypoint.EntrypointTypeCloudformation:
-		return &cfnDiffer{}, nil
-	case entrypoint.EntrypointTypeCdk:
-		return &cdkDiffer{}, nil
-	default:
+	differ, err := entrypointDiffer(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redactingDiffer{differ: differ}, nil
+}
+
+// differRegistry holds the differ for each entrypoint type, the built in differs can be replaced with RegisterDiffer
+var differRegistry = map[entrypoint.EntrypointType]ResourceDiffer{
+	entrypoint.EntrypointTypeKubernetes:     &kubeDiffer{},
+	entrypoint.EntrypointTypeKustomize:      &kustomizeDiffer{},
+	entrypoint.EntrypointTypeTerraform:      &tfDiffer{},
+	entrypoint.EntrypointTypeCloudformation: &cfnDiffer{},
+	entrypoint.EntrypointTypeCdk:            &cdkDiffer{},
+	entrypoint.EntrypointTypeCue:            &cueDiffer{},
+	entrypoint.EntrypointTypeCompose:        &composeDiffer{},
+	entrypoint.EntrypointTypeConfig:         &configDiffer{},
+}
+var differRegistryLock sync.RWMutex
+
+// RegisterDiffer makes differ diff every entrypoint of epType, replacing any differ already registered for it.
+// The differ is shared between entrypoints and may be called concurrently.
+func RegisterDiffer(epType entrypoint.EntrypointType, differ ResourceDiffer) {
+	differRegistryLock.Lock()
+	defer differRegistryLock.Unlock()
+	differRegistry[epType] = differ
+}
+
+func entrypointDiffer(ep entrypoint.Entrypoint) (ResourceDiffer, error) {
+	differRegistryLock.RLock()
+	defer differRegistryLock.RUnlock()
+	differ, ok := differRegistry[ep.Type]
+	if !ok {
 		return nil, fmt.Errorf("entrypoint type %q is not supported", ep.Type)
 	}
+	return differ, nil
 }
@@ -0,0 +1,219 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrorKind is the stage at which an entrypoint failed
+type ErrorKind string
+
+const (
+	ErrorKindDiscovery   ErrorKind = "discovery"
+	ErrorKindCheckout    ErrorKind = "checkout"
+	ErrorKindRender      ErrorKind = "render"
+	ErrorKindDiff        ErrorKind = "diff"
+	ErrorKindToolMissing ErrorKind = "toolMissing"
+	ErrorKindTimeout     ErrorKind = "timeout"
+	ErrorKindCancelled   ErrorKind = "cancelled"
+)
+
+// Revision is the side of the change an error happened in
+type Revision string
+
+const (
+	RevisionPre  Revision = "pre"
+	RevisionPost Revision = "post"
+)
+
+// errorOutputLimit is how much of the end of a tool's output is kept in an EntrypointError
+const errorOutputLimit = 2048
+
+var errorLocationRegexes = []*regexp.Regexp{
+	// cue, yaml linters and most compilers, e.g. ./deploy/main.cue:12:3
+	regexp.MustCompile(`((?:[\w.\-]+/)*[\w.\-]+\.(?:ya?ml|json|cue|tf|hcl|ts|js|py)):(\d+)`),
+	// terraform, e.g. on main.tf line 12
+	regexp.MustCompile(`on ([^\s,]+) line (\d+)`),
+}
+
+// EntrypointError describes why an entrypoint, or one revision of it, couldn't be rendered or diffed. It
+// serialises with everything a consumer needs to report the failure and decide whether it should block a change.
+type EntrypointError struct {
+	Kind ErrorKind `json:"kind"`
+	// Entrypoint is the name of the entrypoint, it's empty for failures which stop every entrypoint being diffed
+	Entrypoint string `json:"entrypoint,omitempty"`
+	// Revision is set when only one side of the change failed
+	Revision Revision `json:"revision,omitempty"`
+	Message  string   `json:"message"`
+	// Tool is the executable which failed or couldn't be found
+	Tool string `json:"tool,omitempty"`
+	// Output is the end of what the tool reported
+	Output string `json:"output,omitempty"`
+	// File and Line locate the failure, when the tool reported where it was
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+
+	err error
+}
+
+// NewEntrypointError describes err as a failure of kind
+func NewEntrypointError(kind ErrorKind, err error) *EntrypointError {
+	return &EntrypointError{Kind: kind, Message: err.Error(), err: err}
+}
+
+func (ee *EntrypointError) Error() string {
+	return ee.Message
+}
+
+func (ee *EntrypointError) Unwrap() error {
+	return ee.err
+}
+
+// AsEntrypointErrors splits err into the EntrypointErrors it wraps. Branches of err which don't wrap one become
+// an EntrypointError of kind.
+func AsEntrypointErrors(err error, kind ErrorKind) []*EntrypointError {
+	if err == nil {
+		return nil
+	}
+	if ee, ok := err.(*EntrypointError); ok {
+		return []*EntrypointError{ee}
+	}
+	var ee *EntrypointError
+	if !errors.As(err, &ee) {
+		return []*EntrypointError{NewEntrypointError(kind, err)}
+	}
+
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		found := []*EntrypointError{}
+		for _, branch := range e.Unwrap() {
+			found = append(found, AsEntrypointErrors(branch, kind)...)
+		}
+		return found
+	case interface{ Unwrap() error }:
+		return AsEntrypointErrors(e.Unwrap(), kind)
+	}
+	return []*EntrypointError{NewEntrypointError(kind, err)}
+}
+
+// toolMissingError is returned when the executable an entrypoint is rendered with can't be found
+func toolMissingError(tool string, err error) error {
+	return &EntrypointError{
+		Kind:    ErrorKindToolMissing,
+		Tool:    tool,
+		Message: fmt.Sprintf("unable to locate %s executable - %s", tool, err),
+		err:     err,
+	}
+}
+
+// toolError is returned when a tool fails to render an entrypoint, output is what it reported
+func toolError(tool, message string, err error, output string) error {
+	ee := &EntrypointError{
+		Kind:    ErrorKindRender,
+		Tool:    tool,
+		Message: message,
+		Output:  errorOutputExcerpt(output),
+		err:     err,
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		ee.Kind = ErrorKindToolMissing
+	}
+	ee.File, ee.Line = errorLocation(output)
+	return ee
+}
+
+// commandError is returned when cmd fails, output is what it wrote to stderr
+func commandError(cmd *exec.Cmd, err error, output []byte) error {
+	message := fmt.Sprintf("unable to run `%s` - %s", cmd.String(), err)
+	if len(output) > 0 {
+		message = fmt.Sprintf("%s - %s", message, output)
+	}
+	return toolError(filepath.Base(cmd.Path), message, err, string(output))
+}
+
+func errorOutputExcerpt(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) <= errorOutputLimit {
+		return output
+	}
+	excerpt := output[len(output)-errorOutputLimit:]
+	if i := strings.IndexByte(excerpt, '\n'); i >= 0 {
+		excerpt = excerpt[i+1:]
+	}
+	return "..." + excerpt
+}
+
+// errorLocation finds the first file and line a tool reported in its output
+func errorLocation(output string) (string, int) {
+	for _, re := range errorLocationRegexes {
+		if m := re.FindStringSubmatch(output); m != nil {
+			line, _ := strconv.Atoi(m[2])
+			return m[1], line
+		}
+	}
+	return "", 0
+}
+
+// revisionError records that a revision of an entrypoint failed to render, keeping what the renderer knew about
+// the failure. Renders which fail once ctx is done were cut short rather than failing by themselves.
+func revisionError(ctx context.Context, rev Revision, err error) *EntrypointError {
+	ee := &EntrypointError{Kind: ErrorKindRender}
+	var cause *EntrypointError
+	if errors.As(err, &cause) {
+		*ee = *cause
+	}
+	ee.Revision = rev
+	ee.Message = err.Error()
+	ee.err = err
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		ee.Kind = ErrorKindTimeout
+	case context.Canceled:
+		ee.Kind = ErrorKindCancelled
+	}
+	return ee
+}
+
+type renderErrorsKey struct{}
+
+type renderErrors struct {
+	lock sync.Mutex
+	errs []*EntrypointError
+}
+
+// WithRenderErrors records each revision of an entrypoint which fails to render, the returned function lists the
+// failures once it has been diffed. Differs don't diff an entrypoint unless both revisions rendered.
+func WithRenderErrors(ctx context.Context) (context.Context, func() []*EntrypointError) {
+	re := &renderErrors{}
+	return context.WithValue(ctx, renderErrorsKey{}, re), func() []*EntrypointError {
+		re.lock.Lock()
+		defer re.lock.Unlock()
+		return append([]*EntrypointError{}, re.errs...)
+	}
+}
+
+// joinRevisionErrors records the revisions which failed to render and returns them as one error
+func joinRevisionErrors(ctx context.Context, revErrs ...*EntrypointError) error {
+	re, _ := ctx.Value(renderErrorsKey{}).(*renderErrors)
+	errs := []error{}
+	for _, ee := range revErrs {
+		if ee == nil {
+			continue
+		}
+		errs = append(errs, ee)
+		if re != nil {
+			re.lock.Lock()
+			re.errs = append(re.errs, ee)
+			re.lock.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,141 @@
+package resource
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	r3diff "github.com/r3labs/diff/v3"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+)
+
+// kubeGeneratedSuffix matches the content hash kustomize appends to generated names, the hash
+// is 10 characters of hex with some characters swapped to avoid producing words
+var kubeGeneratedSuffix = regexp.MustCompile(`^(.+)-[2456789bcdfghkmt]{10}$`)
+
+// kubeGeneratedKinds are the kinds kustomize generators produce with a hash suffix
+var kubeGeneratedKinds = map[string]bool{
+	"ConfigMap": true,
+	"Secret":    true,
+}
+
+// kubeGeneratedBaseName returns the name of a generated resource without its hash suffix
+func kubeGeneratedBaseName(r *resource.Resource) (string, bool) {
+	if !kubeGeneratedKinds[r.GetKind()] {
+		return "", false
+	}
+	match := kubeGeneratedSuffix.FindStringSubmatch(r.GetName())
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+type kubeGeneratedPairs struct {
+	// byNew maps the id of a new generated resource to the old resource it replaces
+	byNew map[resid.ResId]*resource.Resource
+	// pairedOld is the set of old resources which have been paired with a new resource
+	pairedOld map[resid.ResId]bool
+	// renames maps the old generated name to the new one, keyed by kind
+	renames map[string]map[string]string
+}
+
+// kubePairGenerated pairs hash suffixed resources which only exist on one side of the diff by their
+// kind, namespace and pre-suffix name. Resources are only paired when the match is unambiguous.
+func kubePairGenerated(old, new resmap.ResMap) *kubeGeneratedPairs {
+	pairs := &kubeGeneratedPairs{
+		byNew:     map[resid.ResId]*resource.Resource{},
+		pairedOld: map[resid.ResId]bool{},
+		renames:   map[string]map[string]string{},
+	}
+
+	key := func(r *resource.Resource, base string) string {
+		return fmt.Sprintf("%s|%s|%s", r.GetGvk().String(), r.GetNamespace(), base)
+	}
+
+	oldByBase := map[string][]*resource.Resource{}
+	for _, r := range old.Resources() {
+		if _, err := new.GetByCurrentId(r.CurId()); err == nil {
+			continue
+		}
+		if base, ok := kubeGeneratedBaseName(r); ok {
+			oldByBase[key(r, base)] = append(oldByBase[key(r, base)], r)
+		}
+	}
+	newByBase := map[string][]*resource.Resource{}
+	for _, r := range new.Resources() {
+		if _, err := old.GetByCurrentId(r.CurId()); err == nil {
+			continue
+		}
+		if base, ok := kubeGeneratedBaseName(r); ok {
+			newByBase[key(r, base)] = append(newByBase[key(r, base)], r)
+		}
+	}
+
+	for k, newRes := range newByBase {
+		oldRes := oldByBase[k]
+		if len(newRes) != 1 || len(oldRes) != 1 {
+			continue
+		}
+		pairs.byNew[newRes[0].CurId()] = oldRes[0]
+		pairs.pairedOld[oldRes[0].CurId()] = true
+		kind := newRes[0].GetKind()
+		if pairs.renames[kind] == nil {
+			pairs.renames[kind] = map[string]string{}
+		}
+		pairs.renames[kind][oldRes[0].GetName()] = newRes[0].GetName()
+	}
+
+	return pairs
+}
+
+// collapse removes the churn caused by generated names changing from a changelog. For the generated
+// resource itself the name change is dropped, for resources referring to it each change from the old
+// name to the new one is dropped and replaced with a note that a rollout will be triggered.
+func (gp *kubeGeneratedPairs) collapse(pre, post *resource.Resource, changelog r3diff.Changelog) (r3diff.Changelog, []string) {
+	notes := []string{}
+	if _, ok := gp.byNew[post.CurId()]; ok {
+		kept := r3diff.Changelog{}
+		for _, change := range changelog {
+			if strings.Join(change.Path, ".") == "metadata.name" {
+				continue
+			}
+			kept = append(kept, change)
+		}
+		notes = append(notes, fmt.Sprintf("generated name changed from %q to %q", pre.GetName(), post.GetName()))
+		return kept, notes
+	}
+
+	if len(gp.renames) == 0 {
+		return changelog, nil
+	}
+
+	kept := r3diff.Changelog{}
+	triggered := map[string]bool{}
+	for _, change := range changelog {
+		from, fromOk := change.From.(string)
+		to, toOk := change.To.(string)
+		renamed := false
+		if change.Type == r3diff.UPDATE && fromOk && toOk {
+			for kind, renames := range gp.renames {
+				if renames[from] == to {
+					triggered[fmt.Sprintf("%s %s", kind, to)] = true
+					renamed = true
+				}
+			}
+		}
+		if !renamed {
+			kept = append(kept, change)
+		}
+	}
+
+	for ref := range triggered {
+		notes = append(notes, fmt.Sprintf("rollout triggered by config change to %s", ref))
+	}
+	sort.Strings(notes)
+
+	return kept, notes
+}
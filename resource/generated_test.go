@@ -0,0 +1,190 @@
+package resource
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	r3diff "github.com/r3labs/diff/v3"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+func testGeneratedConfigMap(name string) string {
+	return fmt.Sprintf("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: %s\ndata:\n  key: value\n", name)
+}
+
+func testGeneratedDeployment(configMap string) string {
+	return fmt.Sprintf("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  template:\n    spec:\n      volumes:\n        - name: config\n          configMap:\n            name: %s\n", configMap)
+}
+
+func TestKubeGeneratedBaseName(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+		ok       bool
+	}{
+		{name: "generated configmap", manifest: testGeneratedConfigMap("app-config-5t8g9m2b4c"), want: "app-config", ok: true},
+		{name: "generated secret", manifest: "apiVersion: v1\nkind: Secret\nmetadata:\n  name: creds-k2h4g6c7d8\n", want: "creds", ok: true},
+		{name: "no suffix", manifest: testGeneratedConfigMap("app-config"), ok: false},
+		{name: "suffix with vowels", manifest: testGeneratedConfigMap("app-config-aeiouaeiou"), ok: false},
+		{name: "other kind", manifest: "apiVersion: v1\nkind: Service\nmetadata:\n  name: web-5t8g9m2b4c\n", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := renderTestResmap(t, map[string]string{"manifest.yaml": tt.manifest})
+			got, ok := kubeGeneratedBaseName(rm.Resources()[0])
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %q %t, expected %q %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestKubePairGenerated(t *testing.T) {
+	tests := []struct {
+		name string
+		old  []string
+		new  []string
+		// want maps the old names to the new names they were paired with
+		want map[string]string
+	}{
+		{
+			name: "renamed",
+			old:  []string{testGeneratedConfigMap("app-config-5t8g9m2b4c")},
+			new:  []string{testGeneratedConfigMap("app-config-k2h4g6c7d8")},
+			want: map[string]string{"app-config-5t8g9m2b4c": "app-config-k2h4g6c7d8"},
+		},
+		{
+			name: "unchanged",
+			old:  []string{testGeneratedConfigMap("app-config-5t8g9m2b4c")},
+			new:  []string{testGeneratedConfigMap("app-config-5t8g9m2b4c")},
+			want: map[string]string{},
+		},
+		{
+			name: "different base names",
+			old:  []string{testGeneratedConfigMap("app-config-5t8g9m2b4c")},
+			new:  []string{testGeneratedConfigMap("other-config-k2h4g6c7d8")},
+			want: map[string]string{},
+		},
+		{
+			name: "ambiguous",
+			old:  []string{testGeneratedConfigMap("app-config-5t8g9m2b4c")},
+			new:  []string{testGeneratedConfigMap("app-config-k2h4g6c7d8"), testGeneratedConfigMap("app-config-b4c5d6f7g8")},
+			want: map[string]string{},
+		},
+		{
+			name: "not generated",
+			old:  []string{testGeneratedConfigMap("app-config-v1")},
+			new:  []string{testGeneratedConfigMap("app-config-v2")},
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := renderTestResmap(t, testManifests(tt.old))
+			new := renderTestResmap(t, testManifests(tt.new))
+			pairs := kubePairGenerated(old, new)
+
+			got := map[string]string{}
+			for id, oldRes := range pairs.byNew {
+				got[oldRes.GetName()] = id.Name
+				if !pairs.pairedOld[oldRes.CurId()] {
+					t.Errorf("%s was paired but isn't marked as paired", oldRes.GetName())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got pairs %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubeGeneratedCollapse(t *testing.T) {
+	old := renderTestResmap(t, testManifests([]string{testGeneratedConfigMap("app-config-5t8g9m2b4c"), testGeneratedDeployment("app-config-5t8g9m2b4c")}))
+	new := renderTestResmap(t, testManifests([]string{testGeneratedConfigMap("app-config-k2h4g6c7d8"), testGeneratedDeployment("app-config-k2h4g6c7d8")}))
+	pairs := kubePairGenerated(old, new)
+
+	byKind := func(rm resmap.ResMap, kind string) *resource.Resource {
+		for _, r := range rm.Resources() {
+			if r.GetKind() == kind {
+				return r
+			}
+		}
+		t.Fatalf("no %s was rendered", kind)
+		return nil
+	}
+	oldConfig, newConfig := byKind(old, "ConfigMap"), byKind(new, "ConfigMap")
+	oldDeploy, newDeploy := byKind(old, "Deployment"), byKind(new, "Deployment")
+
+	tests := []struct {
+		name      string
+		changelog r3diff.Changelog
+		generated bool
+		kept      []string
+		notes     []string
+	}{
+		{
+			name: "generated resource drops its name change",
+			changelog: r3diff.Changelog{
+				{Type: r3diff.UPDATE, Path: []string{"metadata", "name"}, From: "app-config-5t8g9m2b4c", To: "app-config-k2h4g6c7d8"},
+				{Type: r3diff.UPDATE, Path: []string{"data", "key"}, From: "value", To: "changed"},
+			},
+			generated: true,
+			kept:      []string{"data.key"},
+			notes:     []string{`generated name changed from "app-config-5t8g9m2b4c" to "app-config-k2h4g6c7d8"`},
+		},
+		{
+			name: "reference to generated resource triggers a rollout",
+			changelog: r3diff.Changelog{
+				{Type: r3diff.UPDATE, Path: []string{"spec", "template", "spec", "volumes", "0", "configMap", "name"}, From: "app-config-5t8g9m2b4c", To: "app-config-k2h4g6c7d8"},
+				{Type: r3diff.UPDATE, Path: []string{"spec", "replicas"}, From: 1, To: 2},
+			},
+			kept:  []string{"spec.replicas"},
+			notes: []string{"rollout triggered by config change to ConfigMap app-config-k2h4g6c7d8"},
+		},
+		{
+			name: "unrelated string change is kept",
+			changelog: r3diff.Changelog{
+				{Type: r3diff.UPDATE, Path: []string{"spec", "template", "spec", "serviceAccountName"}, From: "web", To: "api"},
+			},
+			kept:  []string{"spec.template.spec.serviceAccountName"},
+			notes: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pre, post := oldDeploy, newDeploy
+			if tt.generated {
+				pre, post = oldConfig, newConfig
+			}
+			changelog, notes := pairs.collapse(pre, post, tt.changelog)
+			kept := []string{}
+			for _, change := range changelog {
+				kept = append(kept, strings.Join(change.Path, "."))
+			}
+			if !reflect.DeepEqual(kept, tt.kept) {
+				t.Errorf("kept %v, expected %v", kept, tt.kept)
+			}
+			sort.Strings(notes)
+			if !reflect.DeepEqual(notes, tt.notes) {
+				t.Errorf("got notes %v, expected %v", notes, tt.notes)
+			}
+		})
+	}
+}
+
+// testManifests writes each manifest to its own file
+func testManifests(manifests []string) map[string]string {
+	files := map[string]string{}
+	for i, m := range manifests {
+		files[fmt.Sprintf("%02d.yaml", i)] = m
+	}
+	return files
+}
@@ -0,0 +1,181 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	r3diff "github.com/r3labs/diff/v3"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// kubeMergeKeys are the fields holding lists whose items are identified by a key (the strategic merge
+// patch key) rather than their position. Where several keys are listed the first one every item has is used.
+var kubeMergeKeys = map[string][]string{
+	"containers":          {"name"},
+	"initContainers":      {"name"},
+	"ephemeralContainers": {"name"},
+	"env":                 {"name"},
+	"volumes":             {"name"},
+	"volumeMounts":        {"mountPath"},
+	"volumeDevices":       {"devicePath"},
+	"imagePullSecrets":    {"name"},
+	"ports":               {"containerPort", "port"},
+	"hostAliases":         {"ip"},
+	"conditions":          {"type"},
+	"subsets":             {"name"},
+}
+
+// kubeQuantityFields are the fields whose values are resource quantities, any value below them is compared as a quantity
+var kubeQuantityFields = map[string]bool{
+	"limits":      true,
+	"requests":    true,
+	"hard":        true,
+	"capacity":    true,
+	"allocatable": true,
+	"sizeLimit":   true,
+	"storage":     true,
+}
+
+// kubeSemanticDiff diffs two kubernetes objects, matching list items by their merge key and ignoring
+// changes between equivalent quantities, durations and int-or-string values. Items matched by key
+// appear in change paths as containers[name=web].
+func kubeSemanticDiff(a, b map[string]interface{}) (r3diff.Changelog, error) {
+	// Type mismatches are expected for int-or-string fields so are reported as updates rather than failing
+	changelog, err := r3diff.Diff(kubeKeyLists(a), kubeKeyLists(b), r3diff.AllowTypeMismatch(true))
+	if err != nil {
+		return nil, err
+	}
+
+	result := r3diff.Changelog{}
+	for _, change := range changelog {
+		if change.Type == r3diff.UPDATE && kubeEquivalent(change.Path, change.From, change.To) {
+			continue
+		}
+		change.Path = kubeJoinKeyedPath(change.Path)
+		result = append(result, change)
+	}
+
+	return result, nil
+}
+
+// kubeKeyLists returns a copy of v with every list that has a merge key converted to a map keyed by
+// "[key=value]", so items are compared by identity rather than position
+func kubeKeyLists(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			if list, ok := child.([]interface{}); ok {
+				if keyed, ok := kubeKeyList(list, kubeMergeKeys[k]); ok {
+					out[k] = keyed
+					continue
+				}
+			}
+			out[k] = kubeKeyLists(child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, child := range val {
+			out[i] = kubeKeyLists(child)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// kubeKeyList keys the items of list by the first of keys which every item has, the list is left alone
+// if no key is present on every item or the key values aren't unique
+func kubeKeyList(list []interface{}, keys []string) (map[string]interface{}, bool) {
+	if len(list) == 0 {
+		return nil, false
+	}
+	for _, key := range keys {
+		keyed := make(map[string]interface{}, len(list))
+		for _, item := range list {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			id, ok := m[key]
+			if !ok || id == nil {
+				break
+			}
+			name := fmt.Sprintf("[%s=%v]", key, id)
+			if _, dup := keyed[name]; dup {
+				break
+			}
+			keyed[name] = kubeKeyLists(m)
+		}
+		if len(keyed) == len(list) {
+			return keyed, true
+		}
+	}
+	return nil, false
+}
+
+// kubeJoinKeyedPath attaches keyed list elements to their field so ["containers", "[name=web]", "image"]
+// becomes ["containers[name=web]", "image"]
+func kubeJoinKeyedPath(p []string) []string {
+	out := make([]string, 0, len(p))
+	for _, el := range p {
+		if strings.HasPrefix(el, "[") && len(out) > 0 {
+			out[len(out)-1] += el
+			continue
+		}
+		out = append(out, el)
+	}
+	return out
+}
+
+// kubeIntOrStringFields are the fields which accept either a number or a string, such as targetPort: 8080
+// and targetPort: "8080"
+var kubeIntOrStringFields = map[string]bool{
+	"port":           true,
+	"targetPort":     true,
+	"maxSurge":       true,
+	"maxUnavailable": true,
+	"minAvailable":   true,
+}
+
+// kubeDurationFields are the fields holding durations, which are compared by their length so 1m matches 60s
+var kubeDurationFields = map[string]bool{
+	"interval":      true,
+	"retryInterval": true,
+	"timeout":       true,
+	"duration":      true,
+	"renewBefore":   true,
+}
+
+// kubeEquivalent reports whether from and to are different spellings of the same value at path, values
+// outside of the quantity, duration and int-or-string fields are only equivalent when they are equal
+func kubeEquivalent(p []string, from, to interface{}) bool {
+	if from == nil || to == nil || len(p) == 0 {
+		return false
+	}
+	field := p[len(p)-1]
+
+	fromStr, fromIsStr := from.(string)
+	toStr, toIsStr := to.(string)
+	if kubeIntOrStringFields[field] && fromIsStr != toIsStr {
+		return fmt.Sprintf("%v", from) == fmt.Sprintf("%v", to)
+	}
+
+	if kubeDurationFields[field] && fromIsStr && toIsStr {
+		a, aErr := time.ParseDuration(fromStr)
+		b, bErr := time.ParseDuration(toStr)
+		return aErr == nil && bErr == nil && a == b
+	}
+
+	for _, el := range p {
+		if kubeQuantityFields[el] {
+			a, aErr := k8sresource.ParseQuantity(fmt.Sprintf("%v", from))
+			b, bErr := k8sresource.ParseQuantity(fmt.Sprintf("%v", to))
+			return aErr == nil && bErr == nil && a.Cmp(b) == 0
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package resource
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestKubeSemanticDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		// want are the paths of the changes
+		want []string
+	}{
+		{
+			name: "reordered containers",
+			a:    "containers:\n  - name: web\n    image: web:1\n  - name: sidecar\n    image: proxy:1\n",
+			b:    "containers:\n  - name: sidecar\n    image: proxy:1\n  - name: web\n    image: web:1\n",
+			want: []string{},
+		},
+		{
+			name: "container changed by name",
+			a:    "containers:\n  - name: web\n    image: web:1\n  - name: sidecar\n    image: proxy:1\n",
+			b:    "containers:\n  - name: sidecar\n    image: proxy:1\n  - name: web\n    image: web:2\n",
+			want: []string{"containers[name=web].image"},
+		},
+		{
+			name: "reordered env",
+			a:    "env:\n  - name: A\n    value: a\n  - name: B\n    value: b\n",
+			b:    "env:\n  - name: B\n    value: b\n  - name: A\n    value: a\n",
+			want: []string{},
+		},
+		{
+			name: "ports keyed by containerPort",
+			a:    "ports:\n  - containerPort: 80\n    protocol: TCP\n",
+			b:    "ports:\n  - containerPort: 80\n    protocol: UDP\n",
+			want: []string{"ports[containerPort=80].protocol"},
+		},
+		{
+			name: "equivalent quantities",
+			a:    "resources:\n  limits:\n    cpu: 1000m\n    memory: 1Gi\n",
+			b:    "resources:\n  limits:\n    cpu: 1\n    memory: 1024Mi\n",
+			want: []string{},
+		},
+		{
+			name: "changed quantity",
+			a:    "resources:\n  requests:\n    cpu: 500m\n",
+			b:    "resources:\n  requests:\n    cpu: 1\n",
+			want: []string{"resources.requests.cpu"},
+		},
+		{
+			name: "int-or-string port",
+			a:    "targetPort: 8080\nport: 80\n",
+			b:    "targetPort: \"8080\"\nport: \"80\"\n",
+			want: []string{},
+		},
+		{
+			name: "named port",
+			a:    "targetPort: 8080\n",
+			b:    "targetPort: http\n",
+			want: []string{"targetPort"},
+		},
+		{
+			name: "equivalent durations",
+			a:    "interval: 1m\ntimeout: 90s\n",
+			b:    "interval: 60s\ntimeout: 1m30s\n",
+			want: []string{},
+		},
+		{
+			name: "numbers and strings outside of int-or-string fields",
+			a:    "replicas: 1\nenv:\n  - name: DEBUG\n    value: \"true\"\n",
+			b:    "replicas: \"1\"\nenv:\n  - name: DEBUG\n    value: true\n",
+			want: []string{"env[name=DEBUG].value", "replicas"},
+		},
+		{
+			name: "durations and quantities outside of their fields",
+			a:    "annotations:\n  wait: 1m\n  size: 1Gi\n",
+			b:    "annotations:\n  wait: 60s\n  size: 1024Mi\n",
+			want: []string{"annotations.size", "annotations.wait"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := map[string]interface{}{}, map[string]interface{}{}
+			if err := yaml.Unmarshal([]byte(tt.a), &a); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.b), &b); err != nil {
+				t.Fatal(err)
+			}
+			changelog, err := kubeSemanticDiff(a, b)
+			if err != nil {
+				t.Fatalf("unable to diff - %s", err)
+			}
+			got := []string{}
+			for _, change := range changelog {
+				got = append(got, strings.Join(change.Path, "."))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got changes %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/events"
 	"github.com/codingninja/gitops-repo-api/git"
 	"github.com/codingninja/gitops-repo-api/util"
 	r3diff "github.com/r3labs/diff/v3"
@@ -21,38 +23,64 @@ import (
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
-func RenderKubernetes(manifestDir string) (resmap.ResMap, error) {
+// KubernetesContextRecursive enables rendering manifests in subdirectories of a kubernetes entrypoint
+const KubernetesContextRecursive = "recursive"
+
+// SkippedFile records a file in a kubernetes entrypoint which wasn't rendered and why
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func RenderKubernetes(ctx context.Context, manifestDir string, recursive bool) (resmap.ResMap, []SkippedFile, error) {
 	opts := krusty.MakeDefaultOptions()
 	pc := types.EnabledPluginConfig(types.BploLoadFromFileSys)
 	pc.HelmConfig.Command = "helm"
 
 	opts.PluginConfig = pc
 	k := krusty.MakeKustomizer(opts)
-	recursive := false
+
+	manifestDir, err := resolveRenderDir(manifestDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to resolve manifest directory - %w", err)
+	}
 
 	resources := []string{}
+	skipped := []SkippedFile{}
+	checkFile := func(relPath string) {
+		if ok, reason := util.CheckKubeFile(path.Join(manifestDir, relPath)); ok {
+			resources = append(resources, relPath)
+		} else {
+			skipped = append(skipped, SkippedFile{Path: relPath, Reason: reason})
+		}
+	}
 	if recursive {
-		filepath.WalkDir(manifestDir, func(path string, d fs.DirEntry, err error) error {
+		err := filepath.WalkDir(manifestDir, func(p string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
-			if util.IsValidKubeFile(path) {
-				resources = append(resources, path[len(manifestDir)+1:])
+			if d.IsDir() {
+				if p != manifestDir && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
 			}
+			checkFile(p[len(manifestDir)+1:])
 			return nil
 		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to walk manifest directory - %w", err)
+		}
 	} else {
 		entries, err := os.ReadDir(manifestDir)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		for _, entry := range entries {
-			manifestAbsPath := path.Join(manifestDir, entry.Name())
-			if util.IsValidKubeFile(manifestAbsPath) {
-				resources = append(resources, entry.Name())
-			}else{
-				fmt.Printf("File %q is not a valid kubernetes manifest\n", manifestAbsPath)
+			if entry.IsDir() {
+				continue
 			}
+			checkFile(entry.Name())
 		}
 	}
 
@@ -62,24 +90,34 @@ func RenderKubernetes(manifestDir string) (resmap.ResMap, error) {
 	kust.BuildMetadata = append(kust.BuildMetadata, "originAnnotations")
 	kustomization, err := yaml.Marshal(kust)
 	if err != nil {
-		return nil, fmt.Errorf("unable to marshal - %w", err)
+		return nil, nil, fmt.Errorf("unable to marshal - %w", err)
 	}
+
+	// The synthetic kustomization only exists in memory so the checkout is never modified
+	fSys := newOverlayFs(filesys.MakeFsOnDisk())
+	fSys.readHook = sopsReadHook(ctx)
 	kustfile := path.Join(manifestDir, KustomizationFileSuffix)
-	if err := os.WriteFile(kustfile, kustomization, 0o777); err != nil {
-		return nil, fmt.Errorf("unable to write new kustomization - %w", err)
+	if err := fSys.WriteFile(kustfile, kustomization); err != nil {
+		return nil, nil, fmt.Errorf("unable to write new kustomization - %w", err)
 	}
 
-	resmap, err := k.Run(filesys.MakeFsOnDisk(), filepath.Dir(kustfile))
+	resmap, err := k.Run(fSys, manifestDir)
 	if err != nil {
-		return nil, fmt.Errorf("unable to build entrypoint with  kustomize - %w", err)
+		return nil, nil, fmt.Errorf("unable to build entrypoint with  kustomize - %w", err)
 	}
 
-	return resmap, nil
+	return resmap, skipped, nil
 }
 
 type KubernetesResource struct {
 	Resource *resource.Resource `json:"resource"`
 	Origin   resource.Origin    `json:"origin"`
+	// SchemaErrors are the ways the resource fails to match the schema of its CustomResourceDefinition
+	SchemaErrors []string `json:"schemaErrors,omitempty"`
+	// SopsEncrypted are the paths which were encrypted with SOPS in the source manifest
+	SopsEncrypted [][]string `json:"-"`
+	// unredacted is the resource this one was redacted from, so it can be diffed again without comparing hashes
+	unredacted *KubernetesResource
 }
 
 func (kr *KubernetesResource) Type() string {
@@ -102,15 +140,39 @@ func (kr *KubernetesResource) Name() string {
 
 type kubeDiffer struct{}
 
+// renderVersion changes with the helm and sops binaries manifests are inflated and decrypted with
+func (kd *kubeDiffer) renderVersion(ctx context.Context) string {
+	return toolVersion("helm", SopsExecutable)
+}
+
+// renderInputs are the manifests in dir, kubernetes entrypoints never read outside of it
+func (kd *kubeDiffer) renderInputs(ep entrypoint.Entrypoint, dir string) ([]string, bool) {
+	return []string{dir}, true
+}
+
 func (kd *kubeDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
-	old, new, err := extractConcurrent(ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (resmap.ResMap, error) {
-		return RenderKubernetes(dir)
-	})
-	if err != nil {
-		return nil, nil, nil, err
+	recursive := false
+	switch r := ep.Context[KubernetesContextRecursive].(type) {
+	case bool:
+		recursive = r
+	case string:
+		recursive = r == "true"
 	}
-
-	return doResmapDiff(ctx, rs, ep, old, new)
+	return diffConcurrent(ctx, ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (resmap.ResMap, error) {
+		rm, skipped, err := RenderKubernetes(ctx, dir, recursive)
+		for _, s := range skipped {
+			events.Emit(ctx, events.Event{
+				Type:           events.Warning,
+				Entrypoint:     ep.Name,
+				EntrypointType: string(ep.Type),
+				File:           s.Path,
+				Message:        fmt.Sprintf("file was skipped - %s", s.Reason),
+			})
+		}
+		return rm, err
+	}, func(old, new resmap.ResMap) ([]ResourceDiff, []Resource, []Resource, error) {
+		return doResmapDiff(ctx, rs, ep, old, new)
+	})
 }
 
 func doResmapDiff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, old resmap.ResMap, new resmap.ResMap) ([]ResourceDiff, []Resource, []Resource, error) {
@@ -136,19 +198,30 @@ func doResmapDiff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoin
 		return nil, nil, nil, fmt.Errorf("unable to clean old resmap - %w", err)
 	}
 
+	// Generated ConfigMaps and Secrets get a new hash suffix whenever their content changes, so we
+	// pair them by their pre-suffix name to report an update rather than a delete and a create
+	generated := kubePairGenerated(old, new)
+	schemas := crdSchemasFromContext(ctx)
+
 	var errs error
 	allNew := []Resource{}
 	for _, newRes := range new.Resources() {
 		// We explicitly ignore errors here as they are only returned when there is a YAML
 		// decoding error parsing the origin field
 		newResOrigin, _ := newRes.GetOrigin()
+		schemaErrors := schemas.post().validateResource(newRes)
 		allNew = append(allNew, &KubernetesResource{
-			Resource: newRes,
-			Origin:   kubeEntrypointOrigin(rs, ep, newResOrigin),
+			Resource:      newRes,
+			Origin:        kubeEntrypointOrigin(rs, ep, newResOrigin),
+			SchemaErrors:  schemaErrors,
+			SopsEncrypted: kubeSopsPaths(newRes),
 		})
 		// Match objects which we have no "old" version of
 		// which indicates they are being created
 		origRes, err := old.GetByCurrentId(newRes.CurId())
+		if paired, ok := generated.byNew[newRes.CurId()]; ok && err != nil {
+			origRes, err = paired, nil
+		}
 		if err != nil {
 			cleanedPost, err := cleanedNew.GetByCurrentId(newRes.CurId())
 			if err != nil {
@@ -159,8 +232,10 @@ func doResmapDiff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoin
 				Type: DiffTypeCreate,
 				Pre:  nil,
 				Post: &KubernetesResource{
-					Resource: cleanedPost,
-					Origin:   kubeEntrypointOrigin(rs, ep, newResOrigin),
+					Resource:      cleanedPost,
+					Origin:        kubeEntrypointOrigin(rs, ep, newResOrigin),
+					SchemaErrors:  schemaErrors,
+					SopsEncrypted: kubeSopsPaths(newRes),
 				},
 				Diff: r3diff.Changelog{},
 			}
@@ -168,14 +243,17 @@ func doResmapDiff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoin
 			continue
 		}
 
-		changelog, err := kubeDiffResmap(origRes, newRes)
+		changelog, schemaNotes, err := kubeDiffResmap(origRes, newRes, schemas)
 		if err != nil {
 			errs = errors.Join(errs, err)
 			continue
 		}
+		changelog, notes := generated.collapse(origRes, newRes, changelog)
+		changelog, sopsNotes := sopsCollapse(origRes, newRes, changelog)
+		notes = append(append(notes, schemaNotes...), sopsNotes...)
 
 		// Catch objects which have been modified
-		if len(changelog) > 0 {
+		if len(changelog) > 0 || len(notes) > 0 {
 			cleanedPre, err := cleanedOld.GetByCurrentId(origRes.CurId())
 			if err != nil {
 				return nil, nil, nil, fmt.Errorf("unable to get cleaned copy of resource - %W", err)
@@ -191,14 +269,19 @@ func doResmapDiff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoin
 			diff = append(diff, ResourceDiff{
 				Type: DiffTypeUpdate,
 				Pre: &KubernetesResource{
-					Resource: cleanedPre,
-					Origin:   kubeEntrypointOrigin(rs, ep, preOrigin),
+					Resource:      cleanedPre,
+					Origin:        kubeEntrypointOrigin(rs, ep, preOrigin),
+					SchemaErrors:  schemas.pre().validateResource(origRes),
+					SopsEncrypted: kubeSopsPaths(origRes),
 				},
 				Post: &KubernetesResource{
-					Resource: cleanedPost,
-					Origin:   kubeEntrypointOrigin(rs, ep, postOrigin),
+					Resource:      cleanedPost,
+					Origin:        kubeEntrypointOrigin(rs, ep, postOrigin),
+					SchemaErrors:  schemaErrors,
+					SopsEncrypted: kubeSopsPaths(newRes),
 				},
-				Diff: changelog,
+				Diff:  changelog,
+				Notes: notes,
 			})
 		}
 	}
@@ -208,20 +291,23 @@ func doResmapDiff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoin
 	for _, r := range old.Resources() {
 		newResOrigin, _ := r.GetOrigin()
 		allOld = append(allOld, &KubernetesResource{
-			Resource: r,
-			Origin:   kubeEntrypointOrigin(rs, ep, newResOrigin),
+			Resource:      r,
+			Origin:        kubeEntrypointOrigin(rs, ep, newResOrigin),
+			SchemaErrors:  schemas.pre().validateResource(r),
+			SopsEncrypted: kubeSopsPaths(r),
 		})
-		if _, err := new.GetByCurrentId(r.CurId()); err != nil {
+		if _, err := new.GetByCurrentId(r.CurId()); err != nil && !generated.pairedOld[r.CurId()] {
 			cleanedPre, err := cleanedOld.GetByCurrentId(r.CurId())
 			if err != nil {
 				return nil, nil, nil, fmt.Errorf("unable to get cleaned copy of resource - %W", err)
 			}
 			origin, _ := r.GetOrigin()
 			diff = append(diff, ResourceDiff{
-				Type: DiffTypeUpdate,
+				Type: DiffTypeDelete,
 				Pre: &KubernetesResource{
-					Resource: cleanedPre,
-					Origin:   kubeEntrypointOrigin(rs, ep, origin),
+					Resource:      cleanedPre,
+					Origin:        kubeEntrypointOrigin(rs, ep, origin),
+					SopsEncrypted: kubeSopsPaths(r),
 				},
 				Post: nil,
 				Diff: r3diff.Changelog{},
@@ -232,6 +318,48 @@ func doResmapDiff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoin
 	return diff, allOld, allNew, errs
 }
 
+// RediffKubernetes diffs the resources previously rendered from a kubernetes, kustomize or cue entrypoint again without
+// rendering them, so the diff can take the CustomResourceDefinitions rendered by every entrypoint into account. Redacted
+// resources are diffed as they were rendered and redacted again. The final result reports whether the entrypoint renders
+// kubernetes resources at all.
+func RediffKubernetes(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, pre, post []Resource) ([]ResourceDiff, []Resource, []Resource, bool, error) {
+	switch ep.Type {
+	case entrypoint.EntrypointTypeKubernetes, entrypoint.EntrypointTypeKustomize, entrypoint.EntrypointTypeCue:
+	default:
+		return nil, nil, nil, false, nil
+	}
+
+	toResmap := func(resources []Resource) (resmap.ResMap, error) {
+		rm := resmap.New()
+		for _, r := range resources {
+			kr, ok := r.(*KubernetesResource)
+			if !ok {
+				return nil, fmt.Errorf("resource %q is not a kubernetes resource", r.Identifier())
+			}
+			if kr.unredacted != nil {
+				kr = kr.unredacted
+			}
+			if err := rm.Append(kr.Resource); err != nil {
+				return nil, err
+			}
+		}
+		return rm, nil
+	}
+
+	old, err := toResmap(pre)
+	if err != nil {
+		return nil, nil, nil, true, fmt.Errorf("unable to rebuild old resmap - %w", err)
+	}
+	new, err := toResmap(post)
+	if err != nil {
+		return nil, nil, nil, true, fmt.Errorf("unable to rebuild new resmap - %w", err)
+	}
+
+	diff, allOld, allNew, err := doResmapDiff(ctx, rs, ep, old, new)
+	diff, allOld, allNew, err = redactResults(ctx, ep, diff, allOld, allNew, err)
+	return diff, allOld, allNew, true, err
+}
+
 func kubeCleanResmap(rm resmap.ResMap) (resmap.ResMap, error) {
 	crm := rm.DeepCopy()
 
@@ -245,6 +373,12 @@ func kubeCleanResmap(rm resmap.ResMap) (resmap.ResMap, error) {
 		return nil, fmt.Errorf("unable to remove origin annotations - %w", err)
 	}
 
+	for _, r := range crm.Resources() {
+		if err := kubeRemoveSopsAnnotations(r); err != nil {
+			return nil, fmt.Errorf("unable to remove sops annotations - %w", err)
+		}
+	}
+
 	return crm, nil
 }
 
@@ -277,23 +411,25 @@ func kubeEntrypointOrigin(rs *git.RepoSpec, ep entrypoint.Entrypoint, o *resourc
 	return origin
 }
 
-func kubeDiffResmap(aRes, bRes *resource.Resource) (r3diff.Changelog, error) {
-	aYaml, err := aRes.AsYAML()
+func kubeDiffResmap(aRes, bRes *resource.Resource, schemas *CrdSchemaSet) (r3diff.Changelog, []string, error) {
+	aObj, err := kubeResourceObject(aRes)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("unable to parse yaml for item a - %w", err)
 	}
-	bYaml, err := bRes.AsYAML()
+	bObj, err := kubeResourceObject(bRes)
 	if err != nil {
-		return nil, err
-	}
-	aObj := make(map[string]interface{})
-	bObj := make(map[string]interface{})
-	if err := yaml.Unmarshal([]byte(aYaml), &aObj); err != nil {
-		return nil, fmt.Errorf("unable to parse yaml for item a - %w", err)
+		return nil, nil, fmt.Errorf("unable to parse yaml for item b - %w", err)
 	}
-	if err := yaml.Unmarshal([]byte(bYaml), &bObj); err != nil {
-		return nil, fmt.Errorf("unable to parse yaml for item b - %w", err)
+
+	// Custom resources are normalised against the schema of their own revision so defaulted
+	// and pruned fields don't show up as changes
+	aObj, aPruned := schemas.pre().normalise(aObj)
+	bObj, bPruned := schemas.post().normalise(bObj)
+
+	changelog, err := kubeSemanticDiff(aObj, bObj)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return r3diff.Diff(aObj, bObj)
+	return changelog, crdPrunedNotes(aPruned, bPruned), nil
 }
@@ -0,0 +1,258 @@
+package resource
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/git"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+func TestRenderKubernetes(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		recursive bool
+		// want are the names of the rendered resources
+		want    []string
+		skipped []string
+	}{
+		{
+			name: "multi document file",
+			files: map[string]string{
+				"all.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n",
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "list and json",
+			files: map[string]string{
+				"list.yaml": "apiVersion: v1\nkind: List\nitems:\n  - apiVersion: v1\n    kind: ConfigMap\n    metadata:\n      name: a\n",
+				"b.json":    `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "b"}}`,
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "subdirectories only when recursive",
+			files: map[string]string{
+				"a.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n",
+				"sub/b.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n",
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "recursive skips hidden directories",
+			files: map[string]string{
+				"a.yaml":         "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n",
+				"sub/b.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n",
+				".github/c.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: c\n",
+			},
+			recursive: true,
+			want:      []string{"a", "b"},
+		},
+		{
+			name: "files which aren't manifests are skipped",
+			files: map[string]string{
+				"a.yaml":      "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n",
+				"values.yaml": "replicas: 2\n",
+				"README.md":   "# readme\n",
+			},
+			want:    []string{"a"},
+			skipped: []string{"README.md", "values.yaml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFixture(t, dir, tt.files)
+			rm, skipped, err := RenderKubernetes(context.Background(), dir, tt.recursive)
+			if err != nil {
+				t.Fatalf("unable to render - %s", err)
+			}
+			names := []string{}
+			for _, r := range rm.Resources() {
+				names = append(names, r.GetName())
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("got resources %v, expected %v", names, tt.want)
+			}
+			skippedPaths := []string{}
+			for _, s := range skipped {
+				skippedPaths = append(skippedPaths, s.Path)
+			}
+			sort.Strings(skippedPaths)
+			if len(skippedPaths) > 0 || len(tt.skipped) > 0 {
+				if !reflect.DeepEqual(skippedPaths, tt.skipped) {
+					t.Errorf("got skipped %v, expected %v", skippedPaths, tt.skipped)
+				}
+			}
+		})
+	}
+}
+
+// renderTestResmap renders manifests written to a temporary directory
+func renderTestResmap(t *testing.T, files map[string]string) resmap.ResMap {
+	t.Helper()
+	dir := t.TempDir()
+	writeFixture(t, dir, files)
+	rm, _, err := RenderKubernetes(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("unable to render - %s", err)
+	}
+	return rm
+}
+
+func TestRediffKubernetes(t *testing.T) {
+	secret := func(value string) string {
+		return "apiVersion: v1\nkind: Secret\nmetadata:\n  name: creds\ndata:\n  password: " + value + "\n"
+	}
+	configMap := func(value string) string {
+		return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  token: " + value + "\n  level: info\n"
+	}
+	widget := func(token string) string {
+		return "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: gadget\nspec:\n  token: " + token + "\n  size: 2\n"
+	}
+	encrypted := func(value, lastModified string) string {
+		return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: encrypted\ndata:\n  key: ENC[AES256_GCM,data:" + value + ",type:str]\n" +
+			"sops:\n  mac: ENC[AES256_GCM,data:mac,type:str]\n  lastmodified: \"" + lastModified + "\"\n"
+	}
+	tests := []struct {
+		name string
+		pre  []string
+		post []string
+		// want are the changes of each changed resource
+		want  map[string][]string
+		notes []string
+	}{
+		{
+			name: "unchanged",
+			pre:  []string{secret("YQ=="), configMap("a")},
+			post: []string{secret("YQ=="), configMap("a")},
+			want: map[string][]string{},
+		},
+		{
+			name: "changed secret",
+			pre:  []string{secret("YQ=="), configMap("a")},
+			post: []string{secret("Yg=="), configMap("a")},
+			want: map[string][]string{"creds": {"data.password"}},
+		},
+		{
+			name: "changed redacted path",
+			pre:  []string{secret("YQ=="), configMap("a")},
+			post: []string{secret("YQ=="), configMap("b")},
+			want: map[string][]string{"settings": {"data.token"}},
+		},
+		{
+			name: "redacted custom resource",
+			pre:  []string{testWidgetCrd, widget("abc")},
+			post: []string{testWidgetCrd, widget("abd")},
+			want: map[string][]string{"gadget": {"spec.token"}},
+		},
+		{
+			name:  "re-encrypted without a key",
+			pre:   []string{encrypted("YQ==", "2024-01-01T00:00:00Z")},
+			post:  []string{encrypted("Yg==", "2024-02-01T00:00:00Z")},
+			want:  map[string][]string{},
+			notes: []string{"SOPS encrypted values were re-encrypted and can't be compared without a key, only added and removed keys are shown"},
+		},
+	}
+
+	// Without a key encrypted values are replaced with placeholders
+	t.Setenv("SOPS_AGE_KEY", "")
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	ctx := WithRedaction(context.Background(), RedactionOptions{Salt: "test"})
+	rs := git.NewRepoSpec("https://example.com/repo.git", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preDir, postDir := t.TempDir(), t.TempDir()
+			writeFixture(t, preDir, testManifests(tt.pre))
+			writeFixture(t, postDir, testManifests(tt.post))
+			ep := entrypoint.Entrypoint{
+				Name:    "manifests",
+				Type:    entrypoint.EntrypointTypeKubernetes,
+				Context: map[string]interface{}{RedactionContextPaths: "$.data.token,$.spec.token"},
+			}
+			differ, err := EntrypointDiffer(ep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			diff, pre, post, err := differ.Diff(ctx, rs, ep, preDir, postDir)
+			if err != nil {
+				t.Fatalf("unable to diff - %s", err)
+			}
+
+			schemas, err := CollectCrdSchemas(pre, post, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rediff, _, rediffPost, ok, err := RediffKubernetes(WithCrdSchemas(ctx, schemas), rs, ep, pre, post)
+			if !ok || err != nil {
+				t.Fatalf("unable to rediff - %t %v", ok, err)
+			}
+			for _, r := range rediffPost {
+				if errs := r.(*KubernetesResource).SchemaErrors; len(errs) > 0 {
+					t.Errorf("%s doesn't match its schema - %v", r.Name(), errs)
+				}
+			}
+			for name, d := range map[string][]ResourceDiff{"diff": diff, "rediff": rediff} {
+				got := map[string][]string{}
+				notes := []string{}
+				for _, rd := range d {
+					notes = append(notes, rd.Notes...)
+					for _, change := range rd.Diff {
+						got[rd.Name()] = append(got[rd.Name()], strings.Join(change.Path, "."))
+						for _, v := range []interface{}{change.From, change.To} {
+							if s, ok := v.(string); ok && !strings.HasPrefix(s, redactedPrefix) {
+								t.Errorf("%s of %s has unredacted value %q", name, rd.Name(), s)
+							}
+						}
+					}
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s got changes %v, expected %v", name, got, tt.want)
+				}
+				if len(notes) > 0 || len(tt.notes) > 0 {
+					if !reflect.DeepEqual(notes, tt.notes) {
+						t.Errorf("%s got notes %v, expected %v", name, notes, tt.notes)
+					}
+				}
+			}
+		})
+	}
+}
+
+const testWidgetCrd = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+  versions:
+    - name: v1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              properties:
+                token:
+                  type: string
+                  pattern: "^[a-z]+$"
+                size:
+                  type: integer
+`
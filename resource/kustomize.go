@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
 
 	"github.com/codingninja/gitops-repo-api/entrypoint"
@@ -19,7 +18,7 @@ import (
 
 const KustomizationFileSuffix = "kustomization.yaml"
 
-func RenderKustomize(kustomizeDir string) (resmap.ResMap, error) {
+func RenderKustomize(ctx context.Context, kustomizeDir string) (resmap.ResMap, error) {
 	opts := krusty.MakeDefaultOptions()
 	pc := types.EnabledPluginConfig(types.BploLoadFromFileSys)
 	pc.HelmConfig.Command = "helm"
@@ -35,20 +34,39 @@ func RenderKustomize(kustomizeDir string) (resmap.ResMap, error) {
 	if err != nil {
 		return nil, nil
 	}
+	kustomizeDir, err = resolveRenderDir(path.Dir(kustfile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve kustomization directory - %w", err)
+	}
+	kustfile = path.Join(kustomizeDir, KustomizationFileSuffix)
 	kust := &types.Kustomization{}
 	if err := yaml.Unmarshal(kustomization, kust); err != nil {
 		return nil, fmt.Errorf("unable to parse kustomization - %w", err)
 	}
+	// Helm pulls charts missing from the chart home into it, so kustomizations which inflate charts are built from
+	// a working copy. Charts inflated by bases are still pulled into the chart home of the base.
+	if len(kust.HelmCharts) > 0 || len(kust.HelmChartInflationGenerator) > 0 {
+		copyDir, cleanup, err := renderWorkingCopy(kustomizeDir, true)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		kustomizeDir = copyDir
+		kustfile = path.Join(kustomizeDir, KustomizationFileSuffix)
+	}
 	kust.BuildMetadata = append(kust.BuildMetadata, "originAnnotations")
 	kustomization, err = yaml.Marshal(kust)
 	if err != nil {
 		return nil, fmt.Errorf("unable to marshal - %w", err)
 	}
-	if err := os.WriteFile(kustfile, kustomization, 0777); err != nil {
+	// Origin annotations are enabled in an in-memory copy of the kustomization so the checkout is never modified
+	fSys := newOverlayFs(filesys.MakeFsOnDisk())
+	fSys.readHook = sopsReadHook(ctx)
+	if err := fSys.WriteFile(kustfile, kustomization); err != nil {
 		return nil, fmt.Errorf("unable to write new kustomization - %w", err)
 	}
 
-	resmap, err := k.Run(filesys.MakeFsOnDisk(), filepath.Dir(kustfile))
+	resmap, err := k.Run(fSys, kustomizeDir)
 
 	if err != nil {
 		return nil, fmt.Errorf("unable to build entrypoint with  kustomize - %w", err)
@@ -60,14 +78,143 @@ func RenderKustomize(kustomizeDir string) (resmap.ResMap, error) {
 type kustomizeDiffer struct {
 }
 
-func (kd *kustomizeDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
-	old, new, err := extractConcurrent(ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (resmap.ResMap, error) {
-		return RenderKustomize(dir)
-	})
+// renderVersion changes with the helm and sops binaries kustomizations are inflated and decrypted with
+func (kd *kustomizeDiffer) renderVersion(ctx context.Context) string {
+	return toolVersion("helm", SopsExecutable)
+}
 
-	if err != nil {
-		return nil, nil, nil, err
+// renderInputs are the kustomization at dir and every local file and kustomization it builds from
+func (kd *kustomizeDiffer) renderInputs(ep entrypoint.Entrypoint, dir string) ([]string, bool) {
+	if strings.HasSuffix(dir, KustomizationFileSuffix) {
+		dir = path.Dir(dir)
+	}
+	inputs := []string{}
+	if !kustomizeInputs(dir, map[string]bool{}, &inputs) {
+		return nil, false
+	}
+	return inputs, true
+}
+
+// kustomizeInputs adds dir and the files referenced by its kustomization to inputs, following kustomizations it
+// includes. It's false if any of them are remote, can't be found or are charts pulled without a version.
+func kustomizeInputs(dir string, seen map[string]bool, inputs *[]string) bool {
+	if seen[dir] {
+		return true
+	}
+	seen[dir] = true
+	*inputs = append(*inputs, dir)
+
+	var content []byte
+	for _, name := range []string{KustomizationFileSuffix, "kustomization.yml", "Kustomization"} {
+		if b, err := os.ReadFile(path.Join(dir, name)); err == nil {
+			content = b
+			break
+		}
+	}
+	if content == nil {
+		return true
+	}
+	kust := &types.Kustomization{}
+	if err := yaml.Unmarshal(content, kust); err != nil {
+		return false
 	}
+	if len(kust.HelmChartInflationGenerator) > 0 {
+		return false
+	}
+
+	refs := append([]string{}, kust.Resources...)
+	refs = append(refs, kust.Bases...)
+	refs = append(refs, kust.Components...)
+	refs = append(refs, kust.Crds...)
+	refs = append(refs, kust.Configurations...)
+	// Generators, transformers, validators and strategic merge patches may be inline rather than files
+	inline := append(append(append([]string{}, kust.Generators...), kust.Transformers...), kust.Validators...)
+	for _, ref := range inline {
+		if !strings.Contains(ref, "\n") {
+			refs = append(refs, ref)
+		}
+	}
+	for _, patch := range kust.PatchesStrategicMerge {
+		if !strings.Contains(string(patch), "\n") {
+			refs = append(refs, string(patch))
+		}
+	}
+	for _, patch := range append(append([]types.Patch{}, kust.Patches...), kust.PatchesJson6902...) {
+		if patch.Path != "" {
+			refs = append(refs, patch.Path)
+		}
+	}
+	for _, replacement := range kust.Replacements {
+		if replacement.Path != "" {
+			refs = append(refs, replacement.Path)
+		}
+	}
+	sources := []types.KvPairSources{}
+	for _, gen := range kust.ConfigMapGenerator {
+		sources = append(sources, gen.KvPairSources)
+	}
+	for _, gen := range kust.SecretGenerator {
+		sources = append(sources, gen.KvPairSources)
+	}
+	for _, source := range sources {
+		for _, file := range source.FileSources {
+			// Files are named [key=]path
+			if _, p, ok := strings.Cut(file, "="); ok {
+				file = p
+			}
+			refs = append(refs, file)
+		}
+		refs = append(refs, source.EnvSources...)
+		if source.EnvSource != "" {
+			refs = append(refs, source.EnvSource)
+		}
+	}
+	if p := kust.OpenAPI["path"]; p != "" {
+		refs = append(refs, p)
+	}
+	if len(kust.HelmCharts) > 0 {
+		chartHome := "charts"
+		if kust.HelmGlobals != nil && kust.HelmGlobals.ChartHome != "" {
+			chartHome = kust.HelmGlobals.ChartHome
+		}
+		*inputs = append(*inputs, path.Join(dir, chartHome))
+		for _, chart := range kust.HelmCharts {
+			// Charts missing from the chart home are pulled, which only gives the same chart for a fixed version
+			if chart.Repo != "" && chart.Version == "" {
+				return false
+			}
+			if chart.ValuesFile != "" {
+				refs = append(refs, chart.ValuesFile)
+			}
+			refs = append(refs, chart.AdditionalValuesFiles...)
+		}
+	}
+
+	for _, ref := range refs {
+		p := ref
+		if !path.IsAbs(p) {
+			p = path.Join(dir, ref)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			// Remote bases and resources aren't files
+			return false
+		}
+		if !info.IsDir() {
+			*inputs = append(*inputs, p)
+			continue
+		}
+		if !kustomizeInputs(p, seen, inputs) {
+			return false
+		}
+	}
+	return true
+}
 
-	return doResmapDiff(ctx, rs, ep, old, new)
+func (kd *kustomizeDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
+	return diffConcurrent(ctx, ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (resmap.ResMap, error) {
+		return RenderKustomize(ctx, dir)
+	}, func(old, new resmap.ResMap) ([]ResourceDiff, []Resource, []Resource, error) {
+		return doResmapDiff(ctx, rs, ep, old, new)
+	})
 }
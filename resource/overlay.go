@@ -0,0 +1,228 @@
+package resource
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// overlayFs layers an in-memory filesystem over a read only lower filesystem (normally the checkout on disk).
+// Writes and removals only ever touch the in-memory layer, so renderers can add or rewrite files such as
+// kustomization.yaml without modifying the source tree.
+type overlayFs struct {
+	lower   filesys.FileSystem
+	upper   filesys.FileSystem
+	removed map[string]bool
+	// readHook, if set, may replace the content of files read from the lower layer
+	readHook func(path string, content []byte) ([]byte, error)
+}
+
+var _ filesys.FileSystem = &overlayFs{}
+
+func newOverlayFs(lower filesys.FileSystem) *overlayFs {
+	return &overlayFs{
+		lower:   lower,
+		upper:   filesys.MakeFsInMemory(),
+		removed: map[string]bool{},
+	}
+}
+
+// resolveRenderDir returns the absolute, symlink free path of dir so paths written to the in-memory layer
+// match the paths kustomize resolves when reading from the lower layer
+func resolveRenderDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+func (ofs *overlayFs) isRemoved(path string) bool {
+	path = filepath.Clean(path)
+	for p := range ofs.removed {
+		if path == p || strings.HasPrefix(path, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ofs *overlayFs) inLower(path string) bool {
+	return !ofs.isRemoved(path) && ofs.lower.Exists(path)
+}
+
+func (ofs *overlayFs) Create(path string) (filesys.File, error) {
+	delete(ofs.removed, filepath.Clean(path))
+	return ofs.upper.Create(path)
+}
+
+func (ofs *overlayFs) Mkdir(path string) error {
+	delete(ofs.removed, filepath.Clean(path))
+	return ofs.upper.Mkdir(path)
+}
+
+func (ofs *overlayFs) MkdirAll(path string) error {
+	delete(ofs.removed, filepath.Clean(path))
+	return ofs.upper.MkdirAll(path)
+}
+
+func (ofs *overlayFs) RemoveAll(path string) error {
+	if ofs.upper.Exists(path) {
+		if err := ofs.upper.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	ofs.removed[filepath.Clean(path)] = true
+	return nil
+}
+
+func (ofs *overlayFs) Open(path string) (filesys.File, error) {
+	if ofs.upper.Exists(path) {
+		return ofs.upper.Open(path)
+	}
+	if ofs.isRemoved(path) {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return ofs.lower.Open(path)
+}
+
+func (ofs *overlayFs) IsDir(path string) bool {
+	return ofs.upper.IsDir(path) || (ofs.inLower(path) && ofs.lower.IsDir(path))
+}
+
+func (ofs *overlayFs) ReadDir(path string) ([]string, error) {
+	entries := map[string]bool{}
+	upperEntries, upperErr := ofs.upper.ReadDir(path)
+	for _, e := range upperEntries {
+		entries[e] = true
+	}
+	var lowerErr error
+	if ofs.inLower(path) {
+		var lowerEntries []string
+		lowerEntries, lowerErr = ofs.lower.ReadDir(path)
+		for _, e := range lowerEntries {
+			if !ofs.isRemoved(filepath.Join(path, e)) {
+				entries[e] = true
+			}
+		}
+	}
+	if upperErr != nil && (lowerErr != nil || !ofs.inLower(path)) {
+		if lowerErr != nil {
+			return nil, lowerErr
+		}
+		return nil, upperErr
+	}
+
+	result := make([]string, 0, len(entries))
+	for e := range entries {
+		result = append(result, e)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+func (ofs *overlayFs) CleanedAbs(path string) (filesys.ConfirmedDir, string, error) {
+	if ofs.inLower(path) {
+		return ofs.lower.CleanedAbs(path)
+	}
+	if ofs.upper.Exists(path) {
+		if ofs.upper.IsDir(path) {
+			return ofs.upper.CleanedAbs(path)
+		}
+		d, _, err := ofs.CleanedAbs(filepath.Dir(path))
+		return d, filepath.Base(path), err
+	}
+	return ofs.lower.CleanedAbs(path)
+}
+
+func (ofs *overlayFs) Exists(path string) bool {
+	return ofs.upper.Exists(path) || ofs.inLower(path)
+}
+
+func (ofs *overlayFs) Glob(pattern string) ([]string, error) {
+	matches := map[string]bool{}
+	upperMatches, err := ofs.upper.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range upperMatches {
+		matches[m] = true
+	}
+	lowerMatches, err := ofs.lower.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range lowerMatches {
+		if !ofs.isRemoved(m) {
+			matches[m] = true
+		}
+	}
+
+	result := make([]string, 0, len(matches))
+	for m := range matches {
+		result = append(result, m)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+func (ofs *overlayFs) ReadFile(path string) ([]byte, error) {
+	if ofs.upper.Exists(path) {
+		return ofs.upper.ReadFile(path)
+	}
+	if ofs.isRemoved(path) {
+		return nil, &os.PathError{Op: "read", Path: path, Err: os.ErrNotExist}
+	}
+	content, err := ofs.lower.ReadFile(path)
+	if err != nil || ofs.readHook == nil {
+		return content, err
+	}
+	return ofs.readHook(path, content)
+}
+
+func (ofs *overlayFs) WriteFile(path string, data []byte) error {
+	delete(ofs.removed, filepath.Clean(path))
+	return ofs.upper.WriteFile(path, data)
+}
+
+func (ofs *overlayFs) Walk(path string, walkFn filepath.WalkFunc) error {
+	seen := map[string]bool{}
+	if ofs.inLower(path) {
+		err := ofs.lower.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if ofs.isRemoved(p) {
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			seen[p] = true
+			// Files shadowed by the upper layer are reported with the upper content's info
+			if ofs.upper.Exists(p) && info != nil && !info.IsDir() {
+				if f, openErr := ofs.upper.Open(p); openErr == nil {
+					if upperInfo, statErr := f.Stat(); statErr == nil {
+						info = upperInfo
+					}
+					f.Close()
+				}
+			}
+			return walkFn(p, info, err)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !ofs.upper.Exists(path) {
+		return nil
+	}
+	return ofs.upper.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if seen[p] {
+			return nil
+		}
+		return walkFn(p, info, err)
+	})
+}
@@ -0,0 +1,188 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/git"
+	r3diff "github.com/r3labs/diff/v3"
+)
+
+// PluginRequest is written as JSON to the stdin of a renderer plugin
+type PluginRequest struct {
+	Entrypoint entrypoint.Entrypoint `json:"entrypoint"`
+	// Directory is the absolute path of the entrypoint in the checkout being rendered, the plugin is also run there
+	Directory string                 `json:"directory"`
+	Context   map[string]interface{} `json:"context"`
+}
+
+// PluginResponse is read as JSON from the stdout of a renderer plugin
+type PluginResponse struct {
+	Resources []*PluginResource `json:"resources"`
+}
+
+// PluginResource is a resource rendered by a plugin. Resources are matched between revisions by kind and id, and
+// their spec is diffed.
+type PluginResource struct {
+	Kind    string      `json:"kind,omitempty"`
+	Id      string      `json:"id"`
+	ResName string      `json:"name,omitempty"`
+	Spec    interface{} `json:"spec"`
+	// Sensitive are paths within the spec which are always redacted
+	Sensitive [][]string `json:"sensitive,omitempty"`
+
+	epType entrypoint.EntrypointType
+}
+
+func (pr *PluginResource) Type() string {
+	return string(pr.epType)
+}
+
+func (pr *PluginResource) Identifier() string {
+	if pr.Kind == "" {
+		return pr.Id
+	}
+	return fmt.Sprintf("%s[%s]", pr.Kind, pr.Id)
+}
+
+func (pr *PluginResource) Name() string {
+	if pr.ResName == "" {
+		return pr.Id
+	}
+	return pr.ResName
+}
+
+// NewPluginDiffer returns a differ which renders entrypoints by running executable with a PluginRequest on stdin
+// and expecting a PluginResponse on stdout. A non-zero exit fails the render, stderr is included in the error.
+func NewPluginDiffer(executable string, args ...string) ResourceDiffer {
+	return &pluginDiffer{executable: executable, args: args}
+}
+
+type pluginDiffer struct {
+	executable string
+	args       []string
+}
+
+func (pd *pluginDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
+	return diffConcurrent(ctx, ep, oldPath, newPath, func(dir string, ep entrypoint.Entrypoint) (map[string]*PluginResource, error) {
+		return RenderPlugin(ctx, pd.executable, pd.args, ep, dir)
+	}, func(old, new map[string]*PluginResource) ([]ResourceDiff, []Resource, []Resource, error) {
+		// Resources read from the render cache only have what the plugin returned
+		for _, resources := range []map[string]*PluginResource{old, new} {
+			for _, pr := range resources {
+				pr.epType = ep.Type
+			}
+		}
+		return doPluginDiff(ctx, old, new)
+	})
+}
+
+// renderVersion changes whenever the plugin executable is replaced
+func (pd *pluginDiffer) renderVersion(ctx context.Context) string {
+	version := fmt.Sprintf("%s %q", pd.executable, pd.args)
+	if info, err := os.Stat(pd.executable); err == nil {
+		version += fmt.Sprintf(" %d %d", info.Size(), info.ModTime().UnixNano())
+	}
+	return version
+}
+
+// RenderPlugin runs the plugin against the entrypoint at dir, returning its resources keyed by identifier
+func RenderPlugin(ctx context.Context, executable string, args []string, ep entrypoint.Entrypoint, dir string) (map[string]*PluginResource, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to locate entrypoint %q - %w", dir, err)
+	}
+	workDir := dir
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		workDir = filepath.Dir(dir)
+	}
+
+	req, err := json.Marshal(PluginRequest{Entrypoint: ep, Directory: dir, Context: ep.Context})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode plugin request - %w", err)
+	}
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+	cmd := renderCommand(ctx, executable, args...)
+	cmd.Dir = workDir
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, commandError(cmd, err, stderr.Bytes())
+	}
+
+	res := PluginResponse{}
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("unable to parse output of `%s` - %w", cmd.String(), err)
+	}
+	resources := map[string]*PluginResource{}
+	for i, pr := range res.Resources {
+		if pr == nil || pr.Id == "" {
+			return nil, fmt.Errorf("resource %d from `%s` has no id", i, cmd.String())
+		}
+		pr.epType = ep.Type
+		if _, ok := resources[pr.Identifier()]; ok {
+			return nil, fmt.Errorf("`%s` returned %s more than once", cmd.String(), pr.Identifier())
+		}
+		resources[pr.Identifier()] = pr
+	}
+
+	return resources, nil
+}
+
+func doPluginDiff(ctx context.Context, old, new map[string]*PluginResource) ([]ResourceDiff, []Resource, []Resource, error) {
+	ids := []string{}
+	for id := range old {
+		ids = append(ids, id)
+	}
+	for id := range new {
+		if _, ok := old[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	diff := []ResourceDiff{}
+	allOld := []Resource{}
+	allNew := []Resource{}
+	for _, id := range ids {
+		pre, hasOld := old[id]
+		post, hasNew := new[id]
+		var a, b interface{}
+		if hasOld {
+			allOld = append(allOld, pre)
+			a = pre.Spec
+		}
+		if hasNew {
+			allNew = append(allNew, post)
+			b = post.Spec
+		}
+		// Without the new revision, nothing can be said to have been deleted
+		if new == nil {
+			continue
+		}
+
+		changelog, err := r3diff.Diff(a, b, r3diff.AllowTypeMismatch(true))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to diff resource %q - %w", id, err)
+		}
+
+		switch {
+		case !hasOld:
+			diff = append(diff, ResourceDiff{Type: DiffTypeCreate, Post: post, Diff: changelog})
+		case !hasNew:
+			diff = append(diff, ResourceDiff{Type: DiffTypeDelete, Pre: pre, Diff: changelog})
+		case len(changelog) > 0:
+			diff = append(diff, ResourceDiff{Type: DiffTypeUpdate, Pre: pre, Post: post, Diff: changelog})
+		}
+	}
+
+	return diff, allOld, allNew, nil
+}
@@ -0,0 +1,450 @@
+package resource
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/git"
+	tfjson "github.com/hashicorp/terraform-json"
+	r3diff "github.com/r3labs/diff/v3"
+	"sigs.k8s.io/kustomize/api/provider"
+)
+
+// RedactionContextPaths is one or more comma separated JSONPaths redacted in every resource of an entrypoint,
+// in addition to the paths in RedactionOptions
+const RedactionContextPaths = "redact"
+
+// redactedPrefix marks a value which has been replaced by its salted hash
+const redactedPrefix = "redacted:sha256:"
+
+// RedactionOptions controls how sensitive values are hidden before diffs leave the resource package. Redaction is
+// on by default, with a random salt per process.
+type RedactionOptions struct {
+	// Disabled turns redaction off entirely, it should only be used for trusted local use
+	Disabled bool
+	// Salt is mixed into every hash, set it to compare hashes between runs
+	Salt string
+	// Paths are JSONPaths redacted in every resource. They are evaluated against the kubernetes object, the
+	// CloudFormation resource, the Terraform attributes, the compose spec or the config content.
+	Paths []string
+}
+
+type redactionContextKey struct{}
+
+var defaultRedactionSaltOnce sync.Once
+var defaultRedactionSaltValue string
+
+func defaultRedactionSalt() string {
+	defaultRedactionSaltOnce.Do(func() {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			panic(fmt.Errorf("unable to generate redaction salt - %w", err))
+		}
+		defaultRedactionSaltValue = hex.EncodeToString(salt)
+	})
+	return defaultRedactionSaltValue
+}
+
+// WithRedaction returns a context which configures how the differs redact sensitive values
+func WithRedaction(ctx context.Context, opts RedactionOptions) context.Context {
+	return context.WithValue(ctx, redactionContextKey{}, opts)
+}
+
+func redactionFromContext(ctx context.Context) RedactionOptions {
+	if ctx != nil {
+		if opts, ok := ctx.Value(redactionContextKey{}).(RedactionOptions); ok {
+			return opts
+		}
+	}
+	return RedactionOptions{}
+}
+
+type redactor struct {
+	salt  string
+	paths [][]string
+}
+
+// newRedactor builds the redactor for an entrypoint, nil is returned when redaction is disabled
+func newRedactor(ctx context.Context, ep entrypoint.Entrypoint) (*redactor, error) {
+	opts := redactionFromContext(ctx)
+	if opts.Disabled {
+		return nil, nil
+	}
+
+	r := &redactor{salt: opts.Salt}
+	if r.salt == "" {
+		r.salt = defaultRedactionSalt()
+	}
+	for _, p := range append(append([]string{}, opts.Paths...), contextStringList(ep.Context[RedactionContextPaths])...) {
+		segments, err := jsonPathParse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction path %q - %w", p, err)
+		}
+		r.paths = append(r.paths, segments)
+	}
+
+	return r, nil
+}
+
+// hash replaces a value with a salted hash, so changes are still visible without exposing the value
+func (r *redactor) hash(v interface{}) string {
+	if s, ok := v.(string); ok && strings.HasPrefix(s, redactedPrefix) {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b = []byte(fmt.Sprintf("%v", v))
+	}
+	sum := sha256.Sum256(append([]byte(r.salt), b...))
+	return redactedPrefix + hex.EncodeToString(sum[:12])
+}
+
+// redactTree returns a copy of v with every value matched by pattern replaced by its hash. Maps and lists
+// matched by pattern have each of their values hashed, so individual changes remain visible.
+func (r *redactor) redactTree(v interface{}, pattern []string) interface{} {
+	if v == nil {
+		return nil
+	}
+	if len(pattern) == 0 {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			out := make(map[string]interface{}, len(val))
+			for k, child := range val {
+				out[k] = r.redactTree(child, nil)
+			}
+			return out
+		case []interface{}:
+			out := make([]interface{}, len(val))
+			for i, child := range val {
+				out[i] = r.redactTree(child, nil)
+			}
+			return out
+		default:
+			return r.hash(v)
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			if redactSegmentMatches(pattern[0], k) {
+				out[k] = r.redactTree(child, pattern[1:])
+			} else {
+				out[k] = child
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, child := range val {
+			if redactSegmentMatches(pattern[0], strconv.Itoa(i)) {
+				out[i] = r.redactTree(child, pattern[1:])
+			} else {
+				out[i] = child
+			}
+		}
+		return out
+	}
+
+	return v
+}
+
+// redactValue applies every pattern to v
+func (r *redactor) redactValue(v interface{}, patterns [][]string) interface{} {
+	for _, pattern := range patterns {
+		v = r.redactTree(v, pattern)
+	}
+	return v
+}
+
+func redactSegmentMatches(segment, key string) bool {
+	if segment == "*" || segment == key {
+		return true
+	}
+	// Items of keyed lists can't be matched to their index, so any index is assumed to match rather than risk a leak
+	if strings.HasPrefix(key, "[") {
+		_, err := strconv.Atoi(segment)
+		return err == nil
+	}
+	return false
+}
+
+// redactChangelog hashes the values of every change at or below a pattern, and the matching parts of
+// changes above one
+func (r *redactor) redactChangelog(changelog r3diff.Changelog, patterns [][]string) r3diff.Changelog {
+	if len(patterns) == 0 {
+		return changelog
+	}
+
+	redacted := make(r3diff.Changelog, 0, len(changelog))
+	for _, change := range changelog {
+		p := redactSplitKeyedPath(change.Path)
+		for _, pattern := range patterns {
+			n := len(pattern)
+			if len(p) < n {
+				n = len(p)
+			}
+			matches := true
+			for i := 0; i < n; i++ {
+				if !redactSegmentMatches(pattern[i], p[i]) {
+					matches = false
+					break
+				}
+			}
+			if !matches {
+				continue
+			}
+			change.From = r.redactTree(change.From, pattern[n:])
+			change.To = r.redactTree(change.To, pattern[n:])
+		}
+		redacted = append(redacted, change)
+	}
+
+	return redacted
+}
+
+// redactSplitKeyedPath splits keyed list elements from their field, so containers[name=web] can be matched by containers.*
+func redactSplitKeyedPath(p []string) []string {
+	out := make([]string, 0, len(p))
+	for _, el := range p {
+		if i := strings.Index(el, "["); i > 0 && strings.HasSuffix(el, "]") {
+			out = append(out, el[:i], el[i:])
+			continue
+		}
+		out = append(out, el)
+	}
+	return out
+}
+
+// redactableResource is implemented by resources which know which of their values are sensitive
+type redactableResource interface {
+	Resource
+	// redacted returns a copy of the resource with its sensitive values and the values at paths hashed,
+	// along with every pattern which was applied so the same values can be hidden in changelogs
+	redacted(r *redactor, paths [][]string) (Resource, [][]string)
+}
+
+func (r *redactor) resource(res Resource) (Resource, [][]string) {
+	if res == nil {
+		return nil, nil
+	}
+	rr, ok := res.(redactableResource)
+	if !ok {
+		return res, nil
+	}
+	return rr.redacted(r, r.paths)
+}
+
+func (r *redactor) resources(resources []Resource) []Resource {
+	if resources == nil {
+		return nil
+	}
+	out := make([]Resource, len(resources))
+	for i, res := range resources {
+		out[i], _ = r.resource(res)
+	}
+	return out
+}
+
+func (r *redactor) diffs(diffs []ResourceDiff) []ResourceDiff {
+	if diffs == nil {
+		return nil
+	}
+	out := make([]ResourceDiff, len(diffs))
+	for i, rd := range diffs {
+		var prePatterns, postPatterns [][]string
+		rd.Pre, prePatterns = r.resource(rd.Pre)
+		rd.Post, postPatterns = r.resource(rd.Post)
+		rd.Diff = r.redactChangelog(rd.Diff, append(prePatterns, postPatterns...))
+		out[i] = rd
+	}
+	return out
+}
+
+// redactingDiffer redacts the results of another differ, so sensitive values never leave the resource package
+type redactingDiffer struct {
+	differ ResourceDiffer
+}
+
+func (rd *redactingDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldPath, newPath string) ([]ResourceDiff, []Resource, []Resource, error) {
+	diff, pre, post, err := rd.differ.Diff(ctx, rs, ep, oldPath, newPath)
+	return redactResults(ctx, ep, diff, pre, post, err)
+}
+
+func redactResults(ctx context.Context, ep entrypoint.Entrypoint, diff []ResourceDiff, pre, post []Resource, err error) ([]ResourceDiff, []Resource, []Resource, error) {
+	r, rErr := newRedactor(ctx, ep)
+	if rErr != nil {
+		// Nothing is returned rather than risk returning unredacted values
+		return nil, nil, nil, rErr
+	}
+	if r == nil {
+		return diff, pre, post, err
+	}
+
+	return r.diffs(diff), r.resources(pre), r.resources(post), err
+}
+
+func (kr *KubernetesResource) redacted(r *redactor, paths [][]string) (Resource, [][]string) {
+	if kr.Resource == nil {
+		return kr, nil
+	}
+	patterns := append(append([][]string{}, paths...), kr.SopsEncrypted...)
+	if kr.Resource.GetKind() == "Secret" {
+		patterns = append(patterns, []string{"data", "*"}, []string{"stringData", "*"})
+	}
+	if len(patterns) == 0 {
+		return kr, nil
+	}
+
+	obj, err := kubeResourceObject(kr.Resource)
+	if err != nil {
+		// Without the object we can't tell what's safe, so nothing is kept
+		obj = map[string]interface{}{}
+	}
+	obj, _ = r.redactValue(obj, patterns).(map[string]interface{})
+
+	unredacted := kr
+	if kr.unredacted != nil {
+		unredacted = kr.unredacted
+	}
+	return &KubernetesResource{
+		Resource:      provider.NewDefaultDepProvider().GetResourceFactory().FromMap(obj),
+		Origin:        kr.Origin,
+		SchemaErrors:  kr.SchemaErrors,
+		SopsEncrypted: kr.SopsEncrypted,
+		unredacted:    unredacted,
+	}, patterns
+}
+
+func (tr *TerraformResource) redacted(r *redactor, paths [][]string) (Resource, [][]string) {
+	patterns := append(append([][]string{}, paths...), tfSensitivePatterns(tr.Sensitive)...)
+	if len(patterns) == 0 {
+		return tr, nil
+	}
+
+	out := *tr
+	switch res := tr.Resource.(type) {
+	case *tfjson.StateResource:
+		sr := *res
+		sr.AttributeValues, _ = r.redactValue(res.AttributeValues, patterns).(map[string]interface{})
+		out.Resource = &sr
+	default:
+		out.Resource = r.redactValue(tr.Resource, patterns)
+	}
+
+	if tr.Change != nil && tr.Change.Change != nil {
+		rc := *tr.Change
+		change := *tr.Change.Change
+		change.Before = r.redactValue(change.Before, append(append([][]string{}, paths...), tfSensitivePatterns(change.BeforeSensitive)...))
+		change.After = r.redactValue(change.After, append(append([][]string{}, paths...), tfSensitivePatterns(change.AfterSensitive)...))
+		rc.Change = &change
+		out.Change = &rc
+	}
+
+	return &out, patterns
+}
+
+// tfSensitivePatterns converts Terraform's sensitive value markers, which mirror the structure of the
+// value with true at every sensitive attribute, into redaction patterns
+func tfSensitivePatterns(sensitive interface{}) [][]string {
+	if raw, ok := sensitive.(json.RawMessage); ok {
+		var decoded interface{}
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			return nil
+		}
+		sensitive = decoded
+	}
+
+	patterns := [][]string{}
+	var walk func(v interface{}, p []string)
+	walk = func(v interface{}, p []string) {
+		switch val := v.(type) {
+		case bool:
+			if val {
+				patterns = append(patterns, append([]string{}, p...))
+			}
+		case map[string]interface{}:
+			for k, child := range val {
+				walk(child, append(p, k))
+			}
+		case []interface{}:
+			for i, child := range val {
+				walk(child, append(p, strconv.Itoa(i)))
+			}
+		}
+	}
+	walk(sensitive, []string{})
+
+	return patterns
+}
+
+func (cr *CloudformationResource) redacted(r *redactor, paths [][]string) (Resource, [][]string) {
+	if len(paths) == 0 && len(cr.NoEcho) == 0 {
+		return cr, nil
+	}
+
+	var obj map[string]interface{}
+	b, err := json.Marshal(cr.Resource)
+	if err == nil {
+		err = json.Unmarshal(b, &obj)
+	}
+	if err != nil {
+		// Without the properties we can't tell what's safe, so nothing is kept
+		return &CloudformationResource{ResName: cr.ResName, Resource: cfnResource{Type: cr.Resource.Type}}, nil
+	}
+	patterns := append([][]string{}, paths...)
+	// References to NoEcho parameters only name the parameter, the value is hidden once it has been resolved
+	for _, pattern := range cr.NoEcho {
+		if !cfnIsRef(cfnValueAt(obj, pattern)) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	obj, _ = r.redactValue(obj, patterns).(map[string]interface{})
+
+	out := *cr
+	out.Resource = cfnResource{}
+	if b, err := json.Marshal(obj); err == nil {
+		json.Unmarshal(b, &out.Resource)
+	}
+
+	return &out, patterns
+}
+
+func (cr *ComposeResource) redacted(r *redactor, paths [][]string) (Resource, [][]string) {
+	patterns := append(append([][]string{}, paths...), cr.SopsEncrypted...)
+	if len(patterns) == 0 {
+		return cr, nil
+	}
+	out := *cr
+	out.Spec, _ = r.redactValue(cr.Spec, patterns).(map[string]interface{})
+	return &out, patterns
+}
+
+func (cr *ConfigResource) redacted(r *redactor, paths [][]string) (Resource, [][]string) {
+	patterns := append(append([][]string{}, paths...), cr.SopsEncrypted...)
+	if len(patterns) == 0 {
+		return cr, nil
+	}
+	out := *cr
+	out.Content = r.redactValue(cr.Content, patterns)
+	return &out, patterns
+}
+
+func (pr *PluginResource) redacted(r *redactor, paths [][]string) (Resource, [][]string) {
+	patterns := append(append([][]string{}, paths...), pr.Sensitive...)
+	if len(patterns) == 0 {
+		return pr, nil
+	}
+	out := *pr
+	out.Spec = r.redactValue(pr.Spec, patterns)
+	return &out, patterns
+}
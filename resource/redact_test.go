@@ -0,0 +1,289 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	tfjson "github.com/hashicorp/terraform-json"
+	r3diff "github.com/r3labs/diff/v3"
+	"sigs.k8s.io/kustomize/api/provider"
+)
+
+func isRedacted(v interface{}) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, redactedPrefix)
+}
+
+func TestRedactTree(t *testing.T) {
+	r := &redactor{salt: "test"}
+	h := r.hash
+	tests := []struct {
+		name    string
+		v       interface{}
+		pattern []string
+		want    interface{}
+	}{
+		{
+			name:    "single value",
+			v:       map[string]interface{}{"password": "hunter2", "user": "admin"},
+			pattern: []string{"password"},
+			want:    map[string]interface{}{"password": h("hunter2"), "user": "admin"},
+		},
+		{
+			name:    "wildcard",
+			v:       map[string]interface{}{"data": map[string]interface{}{"a": "1", "b": "2"}, "kind": "Secret"},
+			pattern: []string{"data", "*"},
+			want:    map[string]interface{}{"data": map[string]interface{}{"a": h("1"), "b": h("2")}, "kind": "Secret"},
+		},
+		{
+			name:    "map values are hashed individually",
+			v:       map[string]interface{}{"creds": map[string]interface{}{"user": "admin", "keys": []interface{}{"a", 1}}},
+			pattern: []string{"creds"},
+			want:    map[string]interface{}{"creds": map[string]interface{}{"user": h("admin"), "keys": []interface{}{h("a"), h(1)}}},
+		},
+		{
+			name:    "list index",
+			v:       map[string]interface{}{"args": []interface{}{"--token", "secret"}},
+			pattern: []string{"args", "1"},
+			want:    map[string]interface{}{"args": []interface{}{"--token", h("secret")}},
+		},
+		{
+			name:    "missing path",
+			v:       map[string]interface{}{"user": "admin"},
+			pattern: []string{"password"},
+			want:    map[string]interface{}{"user": "admin"},
+		},
+		{
+			name:    "already redacted",
+			v:       map[string]interface{}{"password": h("hunter2")},
+			pattern: []string{"password"},
+			want:    map[string]interface{}{"password": h("hunter2")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.redactTree(tt.v, tt.pattern)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+
+	if h("a") == (&redactor{salt: "other"}).hash("a") {
+		t.Errorf("hashes with different salts match")
+	}
+	if h("a") == h("b") {
+		t.Errorf("hashes of different values match")
+	}
+}
+
+func TestRedactChangelog(t *testing.T) {
+	r := &redactor{salt: "test"}
+	tests := []struct {
+		name     string
+		change   r3diff.Change
+		patterns [][]string
+		// redacted is whether the values are hashed, check is where to look within values above the pattern
+		redacted bool
+		check    []string
+	}{
+		{
+			name:     "change at pattern",
+			change:   r3diff.Change{Type: r3diff.UPDATE, Path: []string{"data", "password"}, From: "a", To: "b"},
+			patterns: [][]string{{"data", "*"}},
+			redacted: true,
+		},
+		{
+			name:     "change above pattern",
+			change:   r3diff.Change{Type: r3diff.CREATE, Path: []string{"data"}, To: map[string]interface{}{"password": "b"}},
+			patterns: [][]string{{"data", "*"}},
+			redacted: true,
+			check:    []string{"password"},
+		},
+		{
+			name:     "keyed list item",
+			change:   r3diff.Change{Type: r3diff.UPDATE, Path: []string{"spec", "containers[name=web]", "args"}, From: "a", To: "b"},
+			patterns: [][]string{{"spec", "containers", "0", "args"}},
+			redacted: true,
+		},
+		{
+			name:     "unrelated change",
+			change:   r3diff.Change{Type: r3diff.UPDATE, Path: []string{"metadata", "name"}, From: "a", To: "b"},
+			patterns: [][]string{{"data", "*"}},
+			redacted: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.redactChangelog(r3diff.Changelog{tt.change}, tt.patterns)[0]
+			for _, v := range []interface{}{got.From, got.To} {
+				if v == nil {
+					continue
+				}
+				if len(tt.check) > 0 {
+					v = cfnValueAt(v, tt.check)
+				}
+				if isRedacted(v) != tt.redacted {
+					t.Errorf("got %v, expected it to be redacted: %t", v, tt.redacted)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactResults(t *testing.T) {
+	secret := &KubernetesResource{Resource: provider.NewDefaultDepProvider().GetResourceFactory().FromMap(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "creds"},
+		"data":       map[string]interface{}{"password": "aHVudGVyMg=="},
+		"stringData": map[string]interface{}{"token": "abc"},
+	})}
+	tfResource := &TerraformResource{
+		Resource:  map[string]interface{}{"password": "hunter2", "name": "db"},
+		Sensitive: json.RawMessage(`{"password": true}`),
+		Change: &tfjson.ResourceChange{Address: "aws_db_instance.db", Change: &tfjson.Change{
+			Before:          map[string]interface{}{"password": "hunter1", "name": "db"},
+			After:           map[string]interface{}{"password": "hunter2", "name": "db"},
+			BeforeSensitive: map[string]interface{}{"password": true},
+			AfterSensitive:  map[string]interface{}{"password": true},
+		}},
+	}
+	cfn := &CloudformationResource{
+		ResName: "Db",
+		Resource: cfnResource{Type: "AWS::RDS::DBInstance", Properties: map[string]interface{}{
+			"MasterUserPassword": "hunter2",
+			"MasterUsername":     map[string]interface{}{"Ref": "Username"},
+			"DBName":             "app",
+		}},
+		NoEcho: [][]string{{"Properties", "MasterUserPassword"}, {"Properties", "MasterUsername"}},
+	}
+	compose := &ComposeResource{Kind: "service", ResName: "web", Spec: map[string]interface{}{
+		"environment": map[string]interface{}{"TOKEN": "abc", "LEVEL": "info"},
+	}, SopsEncrypted: [][]string{{"environment", "TOKEN"}}}
+	config := &ConfigResource{File: "app.yaml", Content: map[string]interface{}{"token": "abc", "level": "info"}, SopsEncrypted: [][]string{{"token"}}}
+	plugin := &PluginResource{Id: "app", Spec: map[string]interface{}{"token": "abc", "level": "info"}, Sensitive: [][]string{{"token"}}}
+
+	value := func(r Resource) map[string]interface{} {
+		var v interface{}
+		switch res := r.(type) {
+		case *KubernetesResource:
+			v, _ = kubeResourceObject(res.Resource)
+		case *TerraformResource:
+			v = map[string]interface{}{"resource": res.Resource, "before": res.Change.Change.Before, "after": res.Change.Change.After}
+		case *CloudformationResource:
+			v = res.Resource.Properties
+		case *ComposeResource:
+			v = res.Spec
+		case *ConfigResource:
+			v = res.Content
+		case *PluginResource:
+			v = res.Spec
+		}
+		m, _ := v.(map[string]interface{})
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		resource Resource
+		opts     RedactionOptions
+		context  map[string]interface{}
+		redacted [][]string
+		kept     [][]string
+	}{
+		{
+			name:     "kubernetes secret",
+			resource: secret,
+			redacted: [][]string{{"data", "password"}, {"stringData", "token"}},
+			kept:     [][]string{{"metadata", "name"}},
+		},
+		{
+			name:     "redaction disabled",
+			resource: secret,
+			opts:     RedactionOptions{Disabled: true},
+			kept:     [][]string{{"data", "password"}, {"stringData", "token"}},
+		},
+		{
+			name:     "terraform sensitive values",
+			resource: tfResource,
+			redacted: [][]string{{"resource", "password"}, {"before", "password"}, {"after", "password"}},
+			kept:     [][]string{{"resource", "name"}, {"before", "name"}, {"after", "name"}},
+		},
+		{
+			name:     "cloudformation noecho",
+			resource: cfn,
+			redacted: [][]string{{"MasterUserPassword"}},
+			kept:     [][]string{{"MasterUsername", "Ref"}, {"DBName"}},
+		},
+		{
+			name:     "configured path",
+			resource: cfn,
+			opts:     RedactionOptions{Paths: []string{"$.Properties.DBName"}},
+			redacted: [][]string{{"MasterUserPassword"}, {"DBName"}},
+		},
+		{
+			name:     "entrypoint path",
+			resource: config,
+			context:  map[string]interface{}{RedactionContextPaths: "$.level"},
+			redacted: [][]string{{"token"}, {"level"}},
+		},
+		{
+			name:     "compose sops values",
+			resource: compose,
+			redacted: [][]string{{"environment", "TOKEN"}},
+			kept:     [][]string{{"environment", "LEVEL"}},
+		},
+		{
+			name:     "plugin sensitive values",
+			resource: plugin,
+			redacted: [][]string{{"token"}},
+			kept:     [][]string{{"level"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithRedaction(context.Background(), tt.opts)
+			ep := entrypoint.Entrypoint{Name: "app", Context: tt.context}
+			diff := []ResourceDiff{{Type: DiffTypeUpdate, Pre: tt.resource, Post: tt.resource}}
+			gotDiff, gotPre, gotPost, err := redactResults(ctx, ep, diff, []Resource{tt.resource}, []Resource{tt.resource}, nil)
+			if err != nil {
+				t.Fatalf("unable to redact - %s", err)
+			}
+			for _, r := range []Resource{gotDiff[0].Pre, gotDiff[0].Post, gotPre[0], gotPost[0]} {
+				v := value(r)
+				for _, p := range tt.redacted {
+					if got := cfnValueAt(v, p); !isRedacted(got) {
+						t.Errorf("%v is %v, expected it to be redacted", p, got)
+					}
+				}
+				for _, p := range tt.kept {
+					if got := cfnValueAt(v, p); got == nil || isRedacted(got) {
+						t.Errorf("%v is %v, expected it to be kept", p, got)
+					}
+				}
+			}
+			// The original resource is never modified
+			for _, p := range tt.kept {
+				if got := cfnValueAt(value(tt.resource), p); isRedacted(got) {
+					t.Errorf("%v of the original resource was redacted", p)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid path", func(t *testing.T) {
+		ctx := WithRedaction(context.Background(), RedactionOptions{Paths: []string{"$["}})
+		diff, pre, post, err := redactResults(ctx, entrypoint.Entrypoint{}, []ResourceDiff{{Post: secret}}, nil, []Resource{secret}, nil)
+		if err == nil || diff != nil || pre != nil || post != nil {
+			t.Errorf("expected only an error, got %v %v %v %v", diff, pre, post, err)
+		}
+	})
+}
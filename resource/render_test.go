@@ -0,0 +1,220 @@
+package resource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.25
+`
+
+const testConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  key: value
+`
+
+const testKustomization = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - deployment.yaml
+  - configmap.yaml
+`
+
+const testHelmKustomization = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+helmCharts:
+  - name: web
+    repo: https://charts.example.com
+    releaseName: web
+`
+
+// testHelm stands in for helm, pulling an empty chart and templating it into two ConfigMaps
+const testHelm = `#!/bin/sh
+case "$1" in
+version) echo v3.12.0 ;;
+pull) mkdir -p "$4/$7" && echo "{}" > "$4/$7/values.yaml" ;;
+template) printf 'apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n' ;;
+*) exit 1 ;;
+esac
+`
+
+// writeFixture writes files, keyed by their path relative to dir
+func writeFixture(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// hashTree hashes the path, mode and content of everything under dir
+func hashTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	hashes := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		h.Write([]byte(info.Mode().String()))
+		if !d.IsDir() {
+			content, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			h.Write(content)
+		}
+		rel, _ := filepath.Rel(dir, p)
+		hashes[rel] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashes
+}
+
+func assertTreeUnchanged(t *testing.T, before, after map[string]string) {
+	t.Helper()
+	for p, h := range before {
+		if after[p] != h {
+			t.Errorf("%s was changed or removed by rendering", p)
+		}
+	}
+	for p := range after {
+		if _, ok := before[p]; !ok {
+			t.Errorf("%s was created by rendering", p)
+		}
+	}
+}
+
+func TestRenderDoesNotModifyCheckout(t *testing.T) {
+	manifests := map[string]string{
+		"deployment.yaml": testDeployment,
+		"configmap.yaml":  testConfigMap,
+	}
+	withKustomization := map[string]string{
+		"deployment.yaml":    testDeployment,
+		"configmap.yaml":     testConfigMap,
+		"kustomization.yaml": testKustomization,
+	}
+
+	tests := []struct {
+		name   string
+		files  map[string]string
+		render func(ctx context.Context, dir string) (int, error)
+	}{
+		{
+			name:  "kubernetes",
+			files: manifests,
+			render: func(ctx context.Context, dir string) (int, error) {
+				rm, _, err := RenderKubernetes(ctx, dir, false)
+				if err != nil {
+					return 0, err
+				}
+				return rm.Size(), nil
+			},
+		},
+		{
+			name:  "kubernetes recursive",
+			files: map[string]string{"deployment.yaml": testDeployment, "nested/configmap.yaml": testConfigMap},
+			render: func(ctx context.Context, dir string) (int, error) {
+				rm, _, err := RenderKubernetes(ctx, dir, true)
+				if err != nil {
+					return 0, err
+				}
+				return rm.Size(), nil
+			},
+		},
+		{
+			name:  "kubernetes with existing kustomization",
+			files: withKustomization,
+			render: func(ctx context.Context, dir string) (int, error) {
+				rm, _, err := RenderKubernetes(ctx, dir, false)
+				if err != nil {
+					return 0, err
+				}
+				return rm.Size(), nil
+			},
+		},
+		{
+			name:  "kustomize with helm chart",
+			files: map[string]string{"kustomization.yaml": testHelmKustomization},
+			render: func(ctx context.Context, dir string) (int, error) {
+				rm, err := RenderKustomize(ctx, dir)
+				if err != nil {
+					return 0, err
+				}
+				return rm.Size(), nil
+			},
+		},
+		{
+			name:  "kustomize",
+			files: withKustomization,
+			render: func(ctx context.Context, dir string) (int, error) {
+				rm, err := RenderKustomize(ctx, dir)
+				if err != nil {
+					return 0, err
+				}
+				return rm.Size(), nil
+			},
+		},
+	}
+
+	helmDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(helmDir, "helm"), []byte(testHelm), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", helmDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFixture(t, dir, tt.files)
+			before := hashTree(t, dir)
+
+			size, err := tt.render(context.Background(), dir)
+			if err != nil {
+				t.Fatalf("unable to render - %s", err)
+			}
+			if size != 2 {
+				t.Errorf("rendered %d resources, expected 2", size)
+			}
+
+			assertTreeUnchanged(t, before, hashTree(t, dir))
+		})
+	}
+}
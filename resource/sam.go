@@ -0,0 +1,1078 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	samTransform       = "AWS::Serverless-2016-10-31"
+	samResourcePrefix  = "AWS::Serverless::"
+	samImplicitRestApi = "ServerlessRestApi"
+	samImplicitHttpApi = "ServerlessHttpApi"
+)
+
+// samGlobalsSections maps each section of Globals to the serverless resource type it applies to
+var samGlobalsSections = map[string]string{
+	"Function":     "AWS::Serverless::Function",
+	"Api":          "AWS::Serverless::Api",
+	"HttpApi":      "AWS::Serverless::HttpApi",
+	"SimpleTable":  "AWS::Serverless::SimpleTable",
+	"StateMachine": "AWS::Serverless::StateMachine",
+	"LayerVersion": "AWS::Serverless::LayerVersion",
+}
+
+// samFunctionPassthrough are AWS::Serverless::Function properties copied verbatim onto the generated AWS::Lambda::Function
+var samFunctionPassthrough = []string{
+	"Architectures", "Description", "Environment", "EphemeralStorage", "FileSystemConfigs", "FunctionName",
+	"Handler", "ImageConfig", "KmsKeyArn", "Layers", "MemorySize", "PackageType", "ReservedConcurrentExecutions",
+	"Runtime", "RuntimeManagementConfig", "SnapStartConfig", "Timeout", "VpcConfig", "LoggingConfig",
+}
+
+// IsServerlessTemplate reports whether the template needs the SAM transform applied before it reflects
+// what CloudFormation will actually deploy
+func IsServerlessTemplate(tpl *CloudformationTemplate) bool {
+	if tpl == nil {
+		return false
+	}
+	if tpl.Transform != nil {
+		if b, err := json.Marshal(tpl.Transform); err == nil && strings.Contains(string(b), samTransform) {
+			return true
+		}
+	}
+	for _, res := range tpl.Resources {
+		if strings.HasPrefix(res.Type, samResourcePrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExpandServerless applies an offline approximation of the AWS::Serverless transform, replacing every
+// AWS::Serverless::* resource with the CloudFormation resources SAM would generate for it. Generated
+// resources are recorded in GeneratedFrom against the logical id of the serverless resource they came from.
+// Templates which don't use SAM are returned untouched.
+func ExpandServerless(tpl *CloudformationTemplate) (*CloudformationTemplate, error) {
+	if !IsServerlessTemplate(tpl) {
+		return tpl, nil
+	}
+
+	se := &samExpander{
+		in: tpl,
+		out: &CloudformationTemplate{
+			AWSTemplateFormatVersion: tpl.AWSTemplateFormatVersion,
+			Transform:                tpl.Transform,
+			Description:              tpl.Description,
+			Metadata:                 tpl.Metadata,
+			Parameters:               tpl.Parameters,
+			Mappings:                 tpl.Mappings,
+			Conditions:               tpl.Conditions,
+			Resources:                map[string]cfnResource{},
+			Outputs:                  tpl.Outputs,
+			GeneratedFrom:            map[string]string{},
+		},
+		restApis: map[string]map[string]interface{}{},
+		httpApis: map[string]map[string]interface{}{},
+	}
+
+	if err := se.expand(); err != nil {
+		return nil, err
+	}
+
+	return se.out, nil
+}
+
+type samExpander struct {
+	in  *CloudformationTemplate
+	out *CloudformationTemplate
+	// restApis and httpApis collect the paths contributed by function events, keyed by API logical id
+	restApis map[string]map[string]interface{}
+	httpApis map[string]map[string]interface{}
+}
+
+func (se *samExpander) expand() error {
+	names := make([]string, 0, len(se.in.Resources))
+	for name := range se.in.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Functions go first because their events contribute paths to both implicit and explicit APIs
+	for _, name := range names {
+		res := se.in.Resources[name]
+		if res.Type != "AWS::Serverless::Function" {
+			continue
+		}
+		if err := se.expandFunction(name, se.withGlobals(res)); err != nil {
+			return fmt.Errorf("unable to expand serverless function %q - %w", name, err)
+		}
+	}
+
+	for _, name := range names {
+		res := se.in.Resources[name]
+		if !strings.HasPrefix(res.Type, samResourcePrefix) {
+			if _, exists := se.out.Resources[name]; !exists {
+				se.out.Resources[name] = res
+			}
+			continue
+		}
+		res = se.withGlobals(res)
+		switch res.Type {
+		case "AWS::Serverless::Function":
+		case "AWS::Serverless::Api":
+			se.expandRestApi(name, res)
+		case "AWS::Serverless::HttpApi":
+			se.expandHttpApi(name, res)
+		case "AWS::Serverless::SimpleTable":
+			se.expandSimpleTable(name, res)
+		case "AWS::Serverless::LayerVersion":
+			se.expandLayerVersion(name, res)
+		case "AWS::Serverless::StateMachine":
+			se.expandStateMachine(name, res)
+		case "AWS::Serverless::Application":
+			se.expandApplication(name, res)
+		default:
+			// Anything we don't know how to expand is diffed as written rather than dropped
+			se.add(name, name, res)
+		}
+	}
+
+	// Events without an explicit RestApiId/ApiId are attached to the implicit APIs SAM creates
+	if _, ok := se.restApis[samImplicitRestApi]; ok {
+		if _, explicit := se.in.Resources[samImplicitRestApi]; !explicit {
+			se.expandRestApi(samImplicitRestApi, se.withGlobals(cfnResource{
+				Type:       "AWS::Serverless::Api",
+				Properties: map[string]interface{}{},
+			}))
+		}
+	}
+	if _, ok := se.httpApis[samImplicitHttpApi]; ok {
+		if _, explicit := se.in.Resources[samImplicitHttpApi]; !explicit {
+			se.expandHttpApi(samImplicitHttpApi, se.withGlobals(cfnResource{
+				Type:       "AWS::Serverless::HttpApi",
+				Properties: map[string]interface{}{},
+			}))
+		}
+	}
+
+	return nil
+}
+
+func (se *samExpander) add(source, name string, res cfnResource) {
+	// Generated resources are only created when the serverless resource is
+	if res.Condition == "" {
+		res.Condition = se.in.Resources[source].Condition
+	}
+	se.out.Resources[name] = res
+	se.out.GeneratedFrom[name] = source
+}
+
+// withGlobals merges the matching Globals section into the resource following SAM's rules,
+// maps are merged, lists are appended and scalars on the resource win
+func (se *samExpander) withGlobals(res cfnResource) cfnResource {
+	for section, resType := range samGlobalsSections {
+		if resType != res.Type {
+			continue
+		}
+		globals, ok := se.in.Globals[section].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		merged, _ := samMergeGlobals(globals, res.Properties).(map[string]interface{})
+		res.Properties = merged
+	}
+
+	return res
+}
+
+func samMergeGlobals(global, local interface{}) interface{} {
+	if local == nil {
+		return deepCopyValue(global)
+	}
+	switch g := global.(type) {
+	case map[string]interface{}:
+		l, ok := local.(map[string]interface{})
+		if !ok {
+			return local
+		}
+		merged := map[string]interface{}{}
+		for k, v := range g {
+			merged[k] = deepCopyValue(v)
+		}
+		for k, v := range l {
+			merged[k] = samMergeGlobals(merged[k], v)
+		}
+		return merged
+	case []interface{}:
+		l, ok := local.([]interface{})
+		if !ok {
+			return local
+		}
+		merged := append([]interface{}{}, deepCopyValue(g).([]interface{})...)
+		return append(merged, l...)
+	}
+
+	return local
+}
+
+// deepCopyValue copies a decoded YAML or JSON value so it can be modified without affecting the original
+func deepCopyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			c[k] = deepCopyValue(v)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(val))
+		for i, v := range val {
+			c[i] = deepCopyValue(v)
+		}
+		return c
+	}
+
+	return v
+}
+
+func (se *samExpander) expandFunction(name string, res cfnResource) error {
+	props := res.Properties
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+
+	fnProps := map[string]interface{}{}
+	for _, key := range samFunctionPassthrough {
+		if v, ok := props[key]; ok {
+			fnProps[key] = v
+		}
+	}
+
+	code, err := samFunctionCode(props)
+	if err != nil {
+		return err
+	}
+	fnProps["Code"] = code
+
+	if tracing, ok := props["Tracing"]; ok {
+		fnProps["TracingConfig"] = map[string]interface{}{"Mode": tracing}
+	}
+	if dlq, ok := props["DeadLetterQueue"].(map[string]interface{}); ok {
+		fnProps["DeadLetterConfig"] = map[string]interface{}{"TargetArn": dlq["TargetArn"]}
+	}
+	fnProps["Tags"] = samTags(props["Tags"])
+
+	roleName := name + "Role"
+	if role, ok := props["Role"]; ok {
+		fnProps["Role"] = role
+	} else {
+		fnProps["Role"] = samGetAtt(roleName, "Arn")
+	}
+
+	se.add(name, name, cfnResource{
+		Type:       "AWS::Lambda::Function",
+		Properties: fnProps,
+		Metadata:   res.Metadata,
+	})
+
+	managedPolicies := []interface{}{
+		samManagedPolicy("service-role/AWSLambdaBasicExecutionRole"),
+	}
+	if _, ok := props["VpcConfig"]; ok {
+		managedPolicies = append(managedPolicies, samManagedPolicy("service-role/AWSLambdaVPCAccessExecutionRole"))
+	}
+	if props["Tracing"] == "Active" {
+		managedPolicies = append(managedPolicies, samManagedPolicy("AWSXrayWriteOnlyAccess"))
+	}
+	inlinePolicies := []interface{}{}
+	for i, policy := range samList(props["Policies"]) {
+		switch p := policy.(type) {
+		case string:
+			managedPolicies = append(managedPolicies, samManagedPolicy(p))
+		case map[string]interface{}:
+			if _, isDocument := p["Statement"]; isDocument {
+				inlinePolicies = append(inlinePolicies, map[string]interface{}{
+					"PolicyName":     fmt.Sprintf("%sPolicy%d", roleName, i),
+					"PolicyDocument": p,
+				})
+			} else {
+				// SAM policy templates are expanded by the service from a library we don't bundle, keep
+				// the template and its parameters so changes to either are still visible
+				inlinePolicies = append(inlinePolicies, map[string]interface{}{
+					"PolicyName":        fmt.Sprintf("%sPolicy%d", roleName, i),
+					"SamPolicyTemplate": p,
+				})
+			}
+		default:
+			// Intrinsics such as !Ref to a managed policy parameter
+			managedPolicies = append(managedPolicies, p)
+		}
+	}
+
+	events, _ := props["Events"].(map[string]interface{})
+	eventNames := make([]string, 0, len(events))
+	for eventName := range events {
+		eventNames = append(eventNames, eventName)
+	}
+	sort.Strings(eventNames)
+	for _, eventName := range eventNames {
+		event, ok := events[eventName].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		policy := se.expandEvent(name, eventName, event)
+		if policy != "" {
+			managedPolicies = append(managedPolicies, samManagedPolicy(policy))
+		}
+	}
+
+	if _, ok := props["Role"]; !ok {
+		roleProps := map[string]interface{}{
+			"AssumeRolePolicyDocument": samAssumeRolePolicy("lambda.amazonaws.com"),
+			"ManagedPolicyArns":        managedPolicies,
+			"Tags":                     samTags(props["Tags"]),
+		}
+		if len(inlinePolicies) > 0 {
+			roleProps["Policies"] = inlinePolicies
+		}
+		if boundary, ok := props["PermissionsBoundary"]; ok {
+			roleProps["PermissionsBoundary"] = boundary
+		}
+		se.add(name, roleName, cfnResource{
+			Type:       "AWS::IAM::Role",
+			Properties: roleProps,
+		})
+	}
+
+	if alias, ok := props["AutoPublishAlias"]; ok {
+		versionName := name + "Version"
+		versionProps := map[string]interface{}{
+			"FunctionName": samRef(name),
+		}
+		if desc, ok := props["VersionDescription"]; ok {
+			versionProps["Description"] = desc
+		}
+		se.add(name, versionName, cfnResource{
+			Type:       "AWS::Lambda::Version",
+			Properties: versionProps,
+		})
+		aliasProps := map[string]interface{}{
+			"Name":            alias,
+			"FunctionName":    samRef(name),
+			"FunctionVersion": samGetAtt(versionName, "Version"),
+		}
+		if pc, ok := props["ProvisionedConcurrencyConfig"]; ok {
+			aliasProps["ProvisionedConcurrencyConfig"] = pc
+		}
+		se.add(name, fmt.Sprintf("%sAlias%v", name, alias), cfnResource{
+			Type:       "AWS::Lambda::Alias",
+			Properties: aliasProps,
+		})
+	}
+
+	if url, ok := props["FunctionUrlConfig"].(map[string]interface{}); ok {
+		urlProps := deepCopyValue(url).(map[string]interface{})
+		urlProps["TargetFunctionArn"] = samRef(name)
+		se.add(name, name+"Url", cfnResource{
+			Type:       "AWS::Lambda::Url",
+			Properties: urlProps,
+		})
+		se.add(name, name+"UrlPublicPermissions", cfnResource{
+			Type: "AWS::Lambda::Permission",
+			Properties: map[string]interface{}{
+				"Action":              "lambda:InvokeFunctionUrl",
+				"FunctionName":        samRef(name),
+				"Principal":           "*",
+				"FunctionUrlAuthType": url["AuthType"],
+			},
+		})
+	}
+
+	return nil
+}
+
+// expandEvent generates the resources for a single function event source, returning the name of any
+// managed policy the function role needs to consume it
+func (se *samExpander) expandEvent(fnName, eventName string, event map[string]interface{}) string {
+	eventType, _ := event["Type"].(string)
+	props, _ := event["Properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+	id := fnName + eventName
+
+	permission := func(principal string, sourceArn interface{}) {
+		permProps := map[string]interface{}{
+			"Action":       "lambda:InvokeFunction",
+			"FunctionName": samRef(fnName),
+			"Principal":    principal,
+		}
+		if sourceArn != nil {
+			permProps["SourceArn"] = sourceArn
+		}
+		se.add(fnName, id+"Permission", cfnResource{
+			Type:       "AWS::Lambda::Permission",
+			Properties: permProps,
+		})
+	}
+
+	switch eventType {
+	case "Api":
+		apiId := samImplicitRestApi
+		if ref, ok := samRefTarget(props["RestApiId"]); ok {
+			apiId = ref
+		}
+		samAddPath(se.restApis, apiId, props["Path"], props["Method"], map[string]interface{}{
+			"x-amazon-apigateway-integration": map[string]interface{}{
+				"type":       "aws_proxy",
+				"httpMethod": "POST",
+				"uri":        samSub(fmt.Sprintf("arn:${AWS::Partition}:apigateway:${AWS::Region}:lambda:path/2015-03-31/functions/${%s.Arn}/invocations", fnName)),
+			},
+		})
+		permission("apigateway.amazonaws.com", samSub(fmt.Sprintf("arn:${AWS::Partition}:execute-api:${AWS::Region}:${AWS::AccountId}:${%s}/*", apiId)))
+	case "HttpApi":
+		apiId := samImplicitHttpApi
+		if ref, ok := samRefTarget(props["ApiId"]); ok {
+			apiId = ref
+		}
+		path, method := props["Path"], props["Method"]
+		if path == nil {
+			path, method = "$default", "x-amazon-apigateway-any-method"
+		}
+		samAddPath(se.httpApis, apiId, path, method, map[string]interface{}{
+			"x-amazon-apigateway-integration": map[string]interface{}{
+				"type":                 "aws_proxy",
+				"httpMethod":           "POST",
+				"payloadFormatVersion": samDefault(props["PayloadFormatVersion"], "2.0"),
+				"uri":                  samGetAtt(fnName, "Arn"),
+			},
+		})
+		permission("apigateway.amazonaws.com", samSub(fmt.Sprintf("arn:${AWS::Partition}:execute-api:${AWS::Region}:${AWS::AccountId}:${%s}/*", apiId)))
+	case "S3":
+		bucket, _ := samRefTarget(props["Bucket"])
+		permission("s3.amazonaws.com", nil)
+		if b, ok := se.out.Resources[bucket]; ok || se.in.Resources[bucket].Type == "AWS::S3::Bucket" {
+			if !ok {
+				b = se.in.Resources[bucket]
+			}
+			bucketProps := deepCopyValue(b.Properties)
+			bp, _ := bucketProps.(map[string]interface{})
+			if bp == nil {
+				bp = map[string]interface{}{}
+			}
+			nc, _ := bp["NotificationConfiguration"].(map[string]interface{})
+			if nc == nil {
+				nc = map[string]interface{}{}
+			}
+			lc := samList(nc["LambdaConfigurations"])
+			for _, e := range samList(props["Events"]) {
+				cfg := map[string]interface{}{
+					"Event":    e,
+					"Function": samGetAtt(fnName, "Arn"),
+				}
+				if filter, ok := props["Filter"]; ok {
+					cfg["Filter"] = filter
+				}
+				lc = append(lc, cfg)
+			}
+			nc["LambdaConfigurations"] = lc
+			bp["NotificationConfiguration"] = nc
+			b.Properties = bp
+			se.out.Resources[bucket] = b
+		}
+	case "SNS":
+		subProps := map[string]interface{}{
+			"Protocol": "lambda",
+			"Endpoint": samGetAtt(fnName, "Arn"),
+			"TopicArn": props["Topic"],
+		}
+		for _, key := range []string{"FilterPolicy", "FilterPolicyScope", "Region", "RedrivePolicy"} {
+			if v, ok := props[key]; ok {
+				subProps[key] = v
+			}
+		}
+		se.add(fnName, id, cfnResource{
+			Type:       "AWS::SNS::Subscription",
+			Properties: subProps,
+		})
+		permission("sns.amazonaws.com", props["Topic"])
+	case "SQS", "Kinesis", "DynamoDB", "MSK", "MQ", "SelfManagedKafka", "DocumentDB":
+		esmProps := deepCopyValue(props).(map[string]interface{})
+		esmProps["FunctionName"] = samRef(fnName)
+		if q, ok := esmProps["Queue"]; ok {
+			delete(esmProps, "Queue")
+			esmProps["EventSourceArn"] = q
+		}
+		if s, ok := esmProps["Stream"]; ok {
+			delete(esmProps, "Stream")
+			esmProps["EventSourceArn"] = s
+		}
+		if b, ok := esmProps["Broker"]; ok {
+			delete(esmProps, "Broker")
+			esmProps["EventSourceArn"] = b
+		}
+		if c, ok := esmProps["Cluster"]; ok {
+			delete(esmProps, "Cluster")
+			esmProps["EventSourceArn"] = c
+		}
+		se.add(fnName, id, cfnResource{
+			Type:       "AWS::Lambda::EventSourceMapping",
+			Properties: esmProps,
+		})
+		switch eventType {
+		case "SQS":
+			return "service-role/AWSLambdaSQSQueueExecutionRole"
+		case "Kinesis":
+			return "service-role/AWSLambdaKinesisExecutionRole"
+		case "DynamoDB":
+			return "service-role/AWSLambdaDynamoDBExecutionRole"
+		case "MSK":
+			return "service-role/AWSLambdaMSKExecutionRole"
+		}
+	case "Schedule", "CloudWatchEvent", "EventBridgeRule":
+		ruleProps := map[string]interface{}{
+			"Targets": []interface{}{
+				map[string]interface{}{
+					"Id":  id + "LambdaTarget",
+					"Arn": samGetAtt(fnName, "Arn"),
+				},
+			},
+		}
+		if input, ok := props["Input"]; ok {
+			ruleProps["Targets"].([]interface{})[0].(map[string]interface{})["Input"] = input
+		}
+		for _, key := range []string{"Schedule", "Pattern", "EventBusName", "Name", "Description", "State"} {
+			if v, ok := props[key]; ok {
+				target := key
+				switch key {
+				case "Schedule":
+					target = "ScheduleExpression"
+				case "Pattern":
+					target = "EventPattern"
+				}
+				ruleProps[target] = v
+			}
+		}
+		if enabled, ok := props["Enabled"].(bool); ok && !enabled {
+			ruleProps["State"] = "DISABLED"
+		}
+		se.add(fnName, id, cfnResource{
+			Type:       "AWS::Events::Rule",
+			Properties: ruleProps,
+		})
+		permission("events.amazonaws.com", samGetAtt(id, "Arn"))
+	case "CloudWatchLogs":
+		se.add(fnName, id, cfnResource{
+			Type: "AWS::Logs::SubscriptionFilter",
+			Properties: map[string]interface{}{
+				"DestinationArn": samGetAtt(fnName, "Arn"),
+				"FilterPattern":  props["FilterPattern"],
+				"LogGroupName":   props["LogGroupName"],
+			},
+		})
+		permission("logs.amazonaws.com", nil)
+	case "IoTRule":
+		se.add(fnName, id, cfnResource{
+			Type: "AWS::IoT::TopicRule",
+			Properties: map[string]interface{}{
+				"TopicRulePayload": map[string]interface{}{
+					"AwsIotSqlVersion": props["AwsIotSqlVersion"],
+					"Sql":              props["Sql"],
+					"RuleDisabled":     false,
+					"Actions": []interface{}{
+						map[string]interface{}{
+							"Lambda": map[string]interface{}{"FunctionArn": samGetAtt(fnName, "Arn")},
+						},
+					},
+				},
+			},
+		})
+		permission("iot.amazonaws.com", nil)
+	default:
+		// Unknown event types are kept as a pseudo resource so changes to them are still reported
+		se.add(fnName, id, cfnResource{
+			Type:       samResourcePrefix + "Function.Event." + eventType,
+			Properties: props,
+		})
+	}
+
+	return ""
+}
+
+func (se *samExpander) expandRestApi(name string, res cfnResource) {
+	props := res.Properties
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+
+	apiProps := map[string]interface{}{}
+	for _, key := range []string{"BinaryMediaTypes", "Description", "DisableExecuteApiEndpoint", "EndpointConfiguration", "MinimumCompressionSize", "Mode", "Policy"} {
+		if v, ok := props[key]; ok {
+			apiProps[key] = v
+		}
+	}
+	if n, ok := props["Name"]; ok {
+		apiProps["Name"] = n
+	}
+	if ec, ok := props["EndpointConfiguration"].(string); ok {
+		apiProps["EndpointConfiguration"] = map[string]interface{}{"Types": []interface{}{ec}}
+	}
+
+	body, hasBody := props["DefinitionBody"].(map[string]interface{})
+	if uri, ok := props["DefinitionUri"]; ok && !hasBody {
+		apiProps["BodyS3Location"] = uri
+	} else {
+		if !hasBody {
+			body = map[string]interface{}{
+				"swagger": "2.0",
+				"info": map[string]interface{}{
+					"version": "1.0",
+					"title":   samRef("AWS::StackName"),
+				},
+			}
+		}
+		body = deepCopyValue(body).(map[string]interface{})
+		samMergePaths(body, se.restApis[name])
+		apiProps["Body"] = body
+	}
+
+	se.add(name, name, cfnResource{
+		Type:       "AWS::ApiGateway::RestApi",
+		Properties: apiProps,
+		Metadata:   res.Metadata,
+	})
+
+	deploymentName := name + "Deployment"
+	se.add(name, deploymentName, cfnResource{
+		Type: "AWS::ApiGateway::Deployment",
+		Properties: map[string]interface{}{
+			"RestApiId":   samRef(name),
+			"Description": fmt.Sprintf("RestApi deployment for %s", name),
+		},
+	})
+
+	stageName := samDefault(props["StageName"], "Prod")
+	stageProps := map[string]interface{}{
+		"RestApiId":    samRef(name),
+		"DeploymentId": samRef(deploymentName),
+		"StageName":    stageName,
+	}
+	for _, key := range []string{"AccessLogSetting", "CacheClusterEnabled", "CacheClusterSize", "MethodSettings", "TracingEnabled", "Variables", "CanarySetting"} {
+		if v, ok := props[key]; ok {
+			stageProps[key] = v
+		}
+	}
+	if tags, ok := props["Tags"]; ok {
+		stageProps["Tags"] = samTags(tags)
+	}
+	se.add(name, fmt.Sprintf("%s%vStage", name, stageName), cfnResource{
+		Type:       "AWS::ApiGateway::Stage",
+		Properties: stageProps,
+	})
+
+	if domain, ok := props["Domain"].(map[string]interface{}); ok {
+		se.add(name, name+"DomainName", cfnResource{
+			Type:       "AWS::ApiGateway::DomainName",
+			Properties: domain,
+		})
+	}
+}
+
+func (se *samExpander) expandHttpApi(name string, res cfnResource) {
+	props := res.Properties
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+
+	apiProps := map[string]interface{}{}
+	for _, key := range []string{"Description", "DisableExecuteApiEndpoint", "FailOnWarnings", "CorsConfiguration"} {
+		if v, ok := props[key]; ok {
+			apiProps[key] = v
+		}
+	}
+
+	body, hasBody := props["DefinitionBody"].(map[string]interface{})
+	if uri, ok := props["DefinitionUri"]; ok && !hasBody {
+		apiProps["BodyS3Location"] = uri
+	} else {
+		if !hasBody {
+			body = map[string]interface{}{
+				"openapi": "3.0.1",
+				"info": map[string]interface{}{
+					"version": "1.0",
+					"title":   samRef("AWS::StackName"),
+				},
+			}
+		}
+		body = deepCopyValue(body).(map[string]interface{})
+		samMergePaths(body, se.httpApis[name])
+		if auth, ok := props["Auth"]; ok {
+			body["x-sam-auth"] = auth
+		}
+		apiProps["Body"] = body
+	}
+	apiProps["Tags"] = samTagMap(props["Tags"])
+
+	se.add(name, name, cfnResource{
+		Type:       "AWS::ApiGatewayV2::Api",
+		Properties: apiProps,
+		Metadata:   res.Metadata,
+	})
+
+	stageName := samDefault(props["StageName"], "$default")
+	stageProps := map[string]interface{}{
+		"ApiId":      samRef(name),
+		"StageName":  stageName,
+		"AutoDeploy": true,
+	}
+	for _, key := range []string{"AccessLogSettings", "DefaultRouteSettings", "RouteSettings", "StageVariables"} {
+		if v, ok := props[key]; ok {
+			stageProps[key] = v
+		}
+	}
+	stageId := fmt.Sprintf("%s%vStage", name, stageName)
+	if stageName == "$default" {
+		stageId = name + "ApiGatewayDefaultStage"
+	}
+	se.add(name, stageId, cfnResource{
+		Type:       "AWS::ApiGatewayV2::Stage",
+		Properties: stageProps,
+	})
+}
+
+func (se *samExpander) expandSimpleTable(name string, res cfnResource) {
+	props := res.Properties
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+
+	keyName, keyType := "id", "String"
+	if pk, ok := props["PrimaryKey"].(map[string]interface{}); ok {
+		if n, ok := pk["Name"].(string); ok {
+			keyName = n
+		}
+		if t, ok := pk["Type"].(string); ok {
+			keyType = t
+		}
+	}
+	attrType := map[string]string{"String": "S", "Number": "N", "Binary": "B"}[keyType]
+
+	tableProps := map[string]interface{}{
+		"KeySchema": []interface{}{
+			map[string]interface{}{"AttributeName": keyName, "KeyType": "HASH"},
+		},
+		"AttributeDefinitions": []interface{}{
+			map[string]interface{}{"AttributeName": keyName, "AttributeType": attrType},
+		},
+	}
+	if pt, ok := props["ProvisionedThroughput"]; ok {
+		tableProps["ProvisionedThroughput"] = pt
+	} else {
+		tableProps["BillingMode"] = "PAY_PER_REQUEST"
+	}
+	for _, key := range []string{"TableName", "SSESpecification", "PointInTimeRecoverySpecification"} {
+		if v, ok := props[key]; ok {
+			tableProps[key] = v
+		}
+	}
+	if tags, ok := props["Tags"]; ok {
+		tableProps["Tags"] = samTags(tags)
+	}
+
+	se.add(name, name, cfnResource{
+		Type:       "AWS::DynamoDB::Table",
+		Properties: tableProps,
+		Metadata:   res.Metadata,
+	})
+}
+
+func (se *samExpander) expandLayerVersion(name string, res cfnResource) {
+	props := deepCopyValue(res.Properties)
+	layerProps, _ := props.(map[string]interface{})
+	if layerProps == nil {
+		layerProps = map[string]interface{}{}
+	}
+	if uri, ok := layerProps["ContentUri"]; ok {
+		delete(layerProps, "ContentUri")
+		layerProps["Content"] = samS3Location(uri, "S3Bucket", "S3Key", "S3ObjectVersion")
+	}
+	if n, ok := layerProps["LayerName"]; !ok || n == nil {
+		layerProps["LayerName"] = name
+	}
+	delete(layerProps, "RetentionPolicy")
+
+	se.add(name, name, cfnResource{
+		Type:       "AWS::Lambda::LayerVersion",
+		Properties: layerProps,
+		Metadata:   res.Metadata,
+	})
+}
+
+func (se *samExpander) expandStateMachine(name string, res cfnResource) {
+	props := res.Properties
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+
+	smProps := map[string]interface{}{}
+	for _, key := range []string{"Definition", "DefinitionSubstitutions", "Logging", "Name", "Tracing", "Type"} {
+		if v, ok := props[key]; ok {
+			target := key
+			switch key {
+			case "Name":
+				target = "StateMachineName"
+			case "Type":
+				target = "StateMachineType"
+			case "Logging":
+				target = "LoggingConfiguration"
+			case "Tracing":
+				target = "TracingConfiguration"
+			}
+			smProps[target] = v
+		}
+	}
+	if uri, ok := props["DefinitionUri"]; ok {
+		smProps["DefinitionS3Location"] = samS3Location(uri, "Bucket", "Key", "Version")
+	}
+	smProps["Tags"] = samTags(props["Tags"])
+
+	roleName := name + "Role"
+	if role, ok := props["Role"]; ok {
+		smProps["RoleArn"] = role
+	} else {
+		smProps["RoleArn"] = samGetAtt(roleName, "Arn")
+		roleProps := map[string]interface{}{
+			"AssumeRolePolicyDocument": samAssumeRolePolicy("states.amazonaws.com"),
+			"ManagedPolicyArns":        []interface{}{},
+			"Tags":                     samTags(props["Tags"]),
+		}
+		inline := []interface{}{}
+		for i, policy := range samList(props["Policies"]) {
+			switch p := policy.(type) {
+			case string:
+				roleProps["ManagedPolicyArns"] = append(roleProps["ManagedPolicyArns"].([]interface{}), samManagedPolicy(p))
+			default:
+				inline = append(inline, map[string]interface{}{
+					"PolicyName":     fmt.Sprintf("%sPolicy%d", roleName, i),
+					"PolicyDocument": p,
+				})
+			}
+		}
+		if len(inline) > 0 {
+			roleProps["Policies"] = inline
+		}
+		se.add(name, roleName, cfnResource{
+			Type:       "AWS::IAM::Role",
+			Properties: roleProps,
+		})
+	}
+
+	se.add(name, name, cfnResource{
+		Type:       "AWS::StepFunctions::StateMachine",
+		Properties: smProps,
+		Metadata:   res.Metadata,
+	})
+}
+
+func (se *samExpander) expandApplication(name string, res cfnResource) {
+	props := res.Properties
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+
+	stackProps := map[string]interface{}{}
+	if loc, ok := props["Location"]; ok {
+		stackProps["TemplateURL"] = loc
+	}
+	for _, key := range []string{"Parameters", "NotificationARNs", "TimeoutInMinutes"} {
+		if v, ok := props[key]; ok {
+			stackProps[key] = v
+		}
+	}
+	stackProps["Tags"] = samTags(props["Tags"])
+
+	se.add(name, name, cfnResource{
+		Type:       "AWS::CloudFormation::Stack",
+		Properties: stackProps,
+		Metadata:   res.Metadata,
+	})
+}
+
+func samFunctionCode(props map[string]interface{}) (map[string]interface{}, error) {
+	if inline, ok := props["InlineCode"]; ok {
+		return map[string]interface{}{"ZipFile": inline}, nil
+	}
+	if image, ok := props["ImageUri"]; ok {
+		return map[string]interface{}{"ImageUri": image}, nil
+	}
+	if uri, ok := props["CodeUri"]; ok {
+		return samS3Location(uri, "S3Bucket", "S3Key", "S3ObjectVersion"), nil
+	}
+	if props["PackageType"] == "Image" {
+		return map[string]interface{}{}, nil
+	}
+
+	return nil, fmt.Errorf("function has no CodeUri, InlineCode or ImageUri")
+}
+
+// samS3Location converts a SAM code location into the CloudFormation shape, local paths haven't been
+// packaged yet so they are kept as-is to make changes to them visible
+func samS3Location(uri interface{}, bucketKey, keyKey, versionKey string) map[string]interface{} {
+	switch u := uri.(type) {
+	case string:
+		if strings.HasPrefix(u, "s3://") {
+			bucket, key, _ := strings.Cut(strings.TrimPrefix(u, "s3://"), "/")
+			return map[string]interface{}{bucketKey: bucket, keyKey: key}
+		}
+		return map[string]interface{}{"LocalPath": u}
+	case map[string]interface{}:
+		loc := map[string]interface{}{}
+		if b, ok := u["Bucket"]; ok {
+			loc[bucketKey] = b
+		}
+		if k, ok := u["Key"]; ok {
+			loc[keyKey] = k
+		}
+		if v, ok := u["Version"]; ok {
+			loc[versionKey] = v
+		}
+		return loc
+	}
+
+	return map[string]interface{}{"Location": uri}
+}
+
+// samAddPath records an API path and method contributed by a function event against the API it targets
+func samAddPath(apis map[string]map[string]interface{}, apiId string, path, method interface{}, operation map[string]interface{}) {
+	p, _ := path.(string)
+	m, _ := method.(string)
+	m = strings.ToLower(m)
+	if m == "any" {
+		m = "x-amazon-apigateway-any-method"
+	}
+	if apis[apiId] == nil {
+		apis[apiId] = map[string]interface{}{}
+	}
+	methods, _ := apis[apiId][p].(map[string]interface{})
+	if methods == nil {
+		methods = map[string]interface{}{}
+	}
+	methods[m] = operation
+	apis[apiId][p] = methods
+}
+
+func samMergePaths(body map[string]interface{}, paths map[string]interface{}) {
+	if len(paths) == 0 {
+		return
+	}
+	existing, _ := body["paths"].(map[string]interface{})
+	if existing == nil {
+		existing = map[string]interface{}{}
+	}
+	for p, methods := range paths {
+		current, _ := existing[p].(map[string]interface{})
+		if current == nil {
+			current = map[string]interface{}{}
+		}
+		for m, op := range methods.(map[string]interface{}) {
+			current[m] = op
+		}
+		existing[p] = current
+	}
+	body["paths"] = existing
+}
+
+func samAssumeRolePolicy(service string) map[string]interface{} {
+	return map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []interface{}{
+			map[string]interface{}{
+				"Effect":    "Allow",
+				"Action":    []interface{}{"sts:AssumeRole"},
+				"Principal": map[string]interface{}{"Service": []interface{}{service}},
+			},
+		},
+	}
+}
+
+func samManagedPolicy(name string) interface{} {
+	if strings.HasPrefix(name, "arn:") {
+		return name
+	}
+	return samSub("arn:${AWS::Partition}:iam::aws:policy/" + name)
+}
+
+// samTags converts SAM's map style tags into a CloudFormation tag list, SAM always adds lambda:createdBy
+func samTags(tags interface{}) []interface{} {
+	tagMap := samTagMap(tags)
+	keys := make([]string, 0, len(tagMap))
+	for k := range tagMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := []interface{}{}
+	for _, k := range keys {
+		list = append(list, map[string]interface{}{"Key": k, "Value": tagMap[k]})
+	}
+
+	return list
+}
+
+func samTagMap(tags interface{}) map[string]interface{} {
+	tagMap := map[string]interface{}{
+		"lambda:createdBy": "SAM",
+	}
+	if m, ok := tags.(map[string]interface{}); ok {
+		for k, v := range m {
+			tagMap[k] = v
+		}
+	}
+
+	return tagMap
+}
+
+func samList(v interface{}) []interface{} {
+	switch val := v.(type) {
+	case nil:
+		return []interface{}{}
+	case []interface{}:
+		return val
+	}
+
+	return []interface{}{v}
+}
+
+func samDefault(v interface{}, def interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	return v
+}
+
+func samRefTarget(v interface{}) (string, bool) {
+	if m, ok := v.(map[string]interface{}); ok {
+		if ref, ok := m["Ref"].(string); ok {
+			return ref, true
+		}
+	}
+	if s, ok := v.(string); ok && s != "" {
+		return s, true
+	}
+
+	return "", false
+}
+
+func samRef(name string) map[string]interface{} {
+	return map[string]interface{}{"Ref": name}
+}
+
+func samGetAtt(name, attr string) map[string]interface{} {
+	return map[string]interface{}{"Fn::GetAtt": []interface{}{name, attr}}
+}
+
+func samSub(s string) map[string]interface{} {
+	return map[string]interface{}{"Fn::Sub": s}
+}
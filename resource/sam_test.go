@@ -0,0 +1,249 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// loadTestTemplate parses a CloudFormation template from its YAML source
+func loadTestTemplate(t *testing.T, source string) *CloudformationTemplate {
+	t.Helper()
+	dir := t.TempDir()
+	writeFixture(t, dir, map[string]string{"template.yaml": source})
+	tpl, err := RenderCloudformation(filepath.Join(dir, "template.yaml"))
+	if err != nil {
+		t.Fatalf("unable to parse template - %s", err)
+	}
+	return tpl
+}
+
+func TestExpandServerless(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		// want maps every resource of the expanded template to its type
+		want map[string]string
+		// generatedFrom maps generated resources to the serverless resource they came from
+		generatedFrom map[string]string
+	}{
+		{
+			name: "function with implicit role",
+			template: `Transform: AWS::Serverless-2016-10-31
+Resources:
+  Fn:
+    Type: AWS::Serverless::Function
+    Properties:
+      Handler: index.handler
+      Runtime: nodejs18.x
+      CodeUri: s3://bucket/key.zip
+`,
+			want:          map[string]string{"Fn": "AWS::Lambda::Function", "FnRole": "AWS::IAM::Role"},
+			generatedFrom: map[string]string{"Fn": "Fn", "FnRole": "Fn"},
+		},
+		{
+			name: "function with role and alias",
+			template: `Transform: AWS::Serverless-2016-10-31
+Resources:
+  Fn:
+    Type: AWS::Serverless::Function
+    Properties:
+      Handler: index.handler
+      Runtime: nodejs18.x
+      CodeUri: s3://bucket/key.zip
+      Role: arn:aws:iam::123456789012:role/fn
+      AutoPublishAlias: live
+`,
+			want: map[string]string{
+				"Fn":          "AWS::Lambda::Function",
+				"FnVersion":   "AWS::Lambda::Version",
+				"FnAliaslive": "AWS::Lambda::Alias",
+			},
+			generatedFrom: map[string]string{"Fn": "Fn", "FnVersion": "Fn", "FnAliaslive": "Fn"},
+		},
+		{
+			name: "simple table and plain resources",
+			template: `Transform: AWS::Serverless-2016-10-31
+Resources:
+  Table:
+    Type: AWS::Serverless::SimpleTable
+  Bucket:
+    Type: AWS::S3::Bucket
+`,
+			want:          map[string]string{"Table": "AWS::DynamoDB::Table", "Bucket": "AWS::S3::Bucket"},
+			generatedFrom: map[string]string{"Table": "Table"},
+		},
+		{
+			name: "template without SAM",
+			template: `Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+`,
+			want: map[string]string{"Bucket": "AWS::S3::Bucket"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := ExpandServerless(loadTestTemplate(t, tt.template))
+			if err != nil {
+				t.Fatalf("unable to expand - %s", err)
+			}
+			got := map[string]string{}
+			for name, res := range out.Resources {
+				got[name] = res.Type
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got resources %v, expected %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(out.GeneratedFrom, tt.generatedFrom) {
+				t.Errorf("got generated from %v, expected %v", out.GeneratedFrom, tt.generatedFrom)
+			}
+		})
+	}
+}
+
+func TestExpandServerlessGlobals(t *testing.T) {
+	out, err := ExpandServerless(loadTestTemplate(t, `Transform: AWS::Serverless-2016-10-31
+Globals:
+  Function:
+    Runtime: python3.12
+    Timeout: 30
+    Environment:
+      Variables:
+        STAGE: prod
+Resources:
+  Fn:
+    Type: AWS::Serverless::Function
+    Properties:
+      Handler: app.handler
+      CodeUri: s3://bucket/key.zip
+      Timeout: 10
+      Environment:
+        Variables:
+          TABLE: items
+`))
+	if err != nil {
+		t.Fatalf("unable to expand - %s", err)
+	}
+
+	props := out.Resources["Fn"].Properties
+	if props["Runtime"] != "python3.12" {
+		t.Errorf("got runtime %v, expected it from globals", props["Runtime"])
+	}
+	if props["Timeout"] != 10 {
+		t.Errorf("got timeout %v, expected the function's own", props["Timeout"])
+	}
+	env, _ := props["Environment"].(map[string]interface{})
+	vars, _ := env["Variables"].(map[string]interface{})
+	keys := []string{}
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if want := []string{"STAGE", "TABLE"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got environment %v, expected globals merged with the function's", keys)
+	}
+}
+
+func TestExpandServerlessApiEvent(t *testing.T) {
+	out, err := ExpandServerless(loadTestTemplate(t, `Transform: AWS::Serverless-2016-10-31
+Resources:
+  Fn:
+    Type: AWS::Serverless::Function
+    Properties:
+      Handler: index.handler
+      Runtime: nodejs18.x
+      CodeUri: s3://bucket/key.zip
+      Events:
+        Get:
+          Type: Api
+          Properties:
+            Path: /items
+            Method: get
+`))
+	if err != nil {
+		t.Fatalf("unable to expand - %s", err)
+	}
+
+	if out.Resources["FnGetPermission"].Type != "AWS::Lambda::Permission" {
+		t.Errorf("expected a permission for the event, got %v", out.Resources["FnGetPermission"])
+	}
+	api, ok := out.Resources[samImplicitRestApi]
+	if !ok || api.Type != "AWS::ApiGateway::RestApi" {
+		t.Fatalf("expected the implicit REST API, got %v", api)
+	}
+	body, _ := api.Properties["Body"].(map[string]interface{})
+	paths, _ := body["paths"].(map[string]interface{})
+	if _, ok := paths["/items"]; !ok {
+		t.Errorf("expected the event's path in the API, got %v", paths)
+	}
+}
+
+func TestExpandServerlessTransformDiff(t *testing.T) {
+	template := `Transform: %s
+Resources:
+  Fn:
+    Type: AWS::Serverless::Function
+    Properties:
+      Runtime: python3.12
+      Handler: app.handler
+      CodeUri: src/
+`
+	tests := []struct {
+		name      string
+		pre       string
+		post      string
+		transform interface{}
+		changed   bool
+	}{
+		{
+			name:      "unchanged",
+			pre:       "AWS::Serverless-2016-10-31",
+			post:      "AWS::Serverless-2016-10-31",
+			transform: "AWS::Serverless-2016-10-31",
+		},
+		{
+			name:      "transform added",
+			pre:       "AWS::Serverless-2016-10-31",
+			post:      "[AWS::LanguageExtensions, AWS::Serverless-2016-10-31]",
+			transform: []interface{}{"AWS::LanguageExtensions", "AWS::Serverless-2016-10-31"},
+			changed:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, err := ExpandServerless(loadTestTemplate(t, fmt.Sprintf(template, tt.pre)))
+			if err != nil {
+				t.Fatalf("unable to expand - %s", err)
+			}
+			new, err := ExpandServerless(loadTestTemplate(t, fmt.Sprintf(template, tt.post)))
+			if err != nil {
+				t.Fatalf("unable to expand - %s", err)
+			}
+			if !reflect.DeepEqual(new.Transform, tt.transform) {
+				t.Errorf("got transform %v, expected %v", new.Transform, tt.transform)
+			}
+
+			diff, _, _, err := doCfnDiff(context.Background(), old, new)
+			if err != nil {
+				t.Fatalf("unable to diff - %s", err)
+			}
+			changed := false
+			for _, rd := range diff {
+				if rd.Name() != cfnSectionTransform {
+					t.Errorf("unexpected change to %s", rd.Name())
+					continue
+				}
+				changed = rd.Type == DiffTypeUpdate
+			}
+			if changed != tt.changed {
+				t.Errorf("transform changed: %t, expected %t", changed, tt.changed)
+			}
+		})
+	}
+}
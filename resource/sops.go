@@ -0,0 +1,439 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	r3diff "github.com/r3labs/diff/v3"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/kustomize/api/resource"
+)
+
+// SopsExecutable is the sops binary used to decrypt manifests when an age key is available
+var SopsExecutable = "sops"
+
+const (
+	// sopsEncryptedAnnotation records the paths which were encrypted in the source file, as a JSON list of paths
+	sopsEncryptedAnnotation = "gitops-repo-api/sops-encrypted"
+	// sopsDecryptedAnnotation records whether the values could be decrypted
+	sopsDecryptedAnnotation = "gitops-repo-api/sops-decrypted"
+	// sopsLastModifiedAnnotation records when the file was last encrypted
+	sopsLastModifiedAnnotation = "gitops-repo-api/sops-lastmodified"
+	// sopsPlaceholder replaces encrypted values which couldn't be decrypted, so re-encryption doesn't show as a change
+	sopsPlaceholder = "sops-encrypted"
+)
+
+var sopsEncryptedValue = regexp.MustCompile(`^ENC\[[A-Z0-9_]+,data:.*\]$`)
+
+// SopsOptions configures how SOPS encrypted files are decrypted
+type SopsOptions struct {
+	// AgeKeyFile is the age key file files are decrypted with, instead of the key sops finds itself
+	AgeKeyFile string
+}
+
+type sopsContextKey struct{}
+
+// WithSops returns a context which configures how SOPS encrypted files are decrypted
+func WithSops(ctx context.Context, opts SopsOptions) context.Context {
+	return context.WithValue(ctx, sopsContextKey{}, opts)
+}
+
+func sopsFromContext(ctx context.Context) SopsOptions {
+	if ctx != nil {
+		if opts, ok := ctx.Value(sopsContextKey{}).(SopsOptions); ok {
+			return opts
+		}
+	}
+	return SopsOptions{}
+}
+
+// sopsKeyAvailable reports whether an age key has been supplied, either through SopsOptions, the environment
+// variables sops reads or its default key file
+func sopsKeyAvailable(ctx context.Context) bool {
+	if keyFile := sopsFromContext(ctx).AgeKeyFile; keyFile != "" {
+		_, err := os.Stat(keyFile)
+		return err == nil
+	}
+	if os.Getenv("SOPS_AGE_KEY") != "" || os.Getenv("SOPS_AGE_KEY_FILE") != "" {
+		return true
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(configDir, "sops", "age", "keys.txt"))
+	return err == nil
+}
+
+// sopsReadHook is an overlayFs read hook which replaces SOPS encrypted manifests with their decrypted content
+// when a key is available, or with placeholders for every encrypted value when it isn't. Either way the
+// encrypted paths are recorded in an annotation so they are redacted and reported without their values.
+// Decryption is cancelled with ctx.
+func sopsReadHook(ctx context.Context) func(file string, content []byte) ([]byte, error) {
+	return func(file string, content []byte) ([]byte, error) {
+		return sopsReadFile(ctx, file, content)
+	}
+}
+
+func sopsReadFile(ctx context.Context, file string, content []byte) ([]byte, error) {
+	isManifest := false
+	for _, ext := range []string{".yaml", ".yml", ".json"} {
+		if strings.HasSuffix(file, ext) {
+			isManifest = true
+		}
+	}
+	if !isManifest {
+		return content, nil
+	}
+
+	docs, decrypted, err := sopsOpen(ctx, file, content)
+	if err != nil || docs == nil {
+		return content, err
+	}
+
+	out := bytes.Buffer{}
+	enc := yaml.NewEncoder(&out)
+	for _, doc := range docs {
+		if doc.encrypted {
+			sopsAnnotate(doc.content, doc.paths, decrypted, doc.lastModified)
+		}
+		if err := enc.Encode(doc.content); err != nil {
+			return nil, fmt.Errorf("unable to encode %q - %w", file, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// sopsDocument is a document of a SOPS encrypted file, with the sops metadata removed
+type sopsDocument struct {
+	content map[string]interface{}
+	// encrypted is set for documents which had sops metadata, paths are those of the values which were encrypted
+	encrypted    bool
+	paths        [][]string
+	lastModified string
+}
+
+// sopsOpen decrypts the YAML or JSON documents in content when a key is available, or replaces their encrypted
+// values with placeholders when it isn't. docs is nil when content isn't SOPS encrypted, or can't be parsed so
+// that whatever reads it can report why.
+func sopsOpen(ctx context.Context, file string, content []byte) ([]*sopsDocument, bool, error) {
+	if !bytes.Contains(content, []byte("sops")) {
+		return nil, false, nil
+	}
+	decoded, err := sopsDecodeDocuments(content)
+	if err != nil || !sopsIsEncrypted(decoded) {
+		return nil, false, nil
+	}
+
+	docs := make([]*sopsDocument, len(decoded))
+	for i, content := range decoded {
+		docs[i] = &sopsDocument{content: content}
+		if _, ok := content["sops"]; ok {
+			docs[i].encrypted = true
+			docs[i].paths = sopsEncryptedPaths(content)
+			docs[i].lastModified = sopsLastModified(content)
+		}
+	}
+
+	inputType := "yaml"
+	if strings.HasSuffix(file, ".json") {
+		inputType = "json"
+	}
+	plain, decrypted, err := sopsDecryptFile(ctx, file, inputType, "yaml")
+	if err != nil {
+		return nil, false, err
+	}
+	if decrypted {
+		plainDocs, err := sopsDecodeDocuments(plain)
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to parse decrypted %q - %w", file, err)
+		}
+		if len(plainDocs) != len(docs) {
+			decrypted = false
+		} else {
+			for i := range docs {
+				docs[i].content = plainDocs[i]
+			}
+		}
+	}
+
+	for _, doc := range docs {
+		delete(doc.content, "sops")
+		if decrypted {
+			continue
+		}
+		for _, p := range doc.paths {
+			sopsSetPath(doc.content, p, sopsPlaceholder)
+		}
+	}
+
+	return docs, decrypted, nil
+}
+
+// sopsOpenEnv decrypts the variables of a SOPS encrypted dotenv file when a key is available, or replaces their
+// encrypted values with placeholders when it isn't. The names of the variables which were decrypted are
+// returned along with them.
+func sopsOpenEnv(ctx context.Context, file string, vars map[string]string) (map[string]string, []string, error) {
+	if _, ok := vars["sops_mac"]; !ok {
+		return vars, nil, nil
+	}
+
+	encrypted := []string{}
+	for k, v := range vars {
+		if sopsEncryptedValue.MatchString(v) {
+			encrypted = append(encrypted, k)
+		}
+	}
+	sort.Strings(encrypted)
+
+	plain, decrypted, err := sopsDecryptFile(ctx, file, "dotenv", "dotenv")
+	if err != nil {
+		return nil, nil, err
+	}
+	if decrypted {
+		vars, err = parseEnv(plain)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to parse decrypted %q - %w", file, err)
+		}
+	}
+
+	out := map[string]string{}
+	for k, v := range vars {
+		if strings.HasPrefix(k, "sops_") {
+			continue
+		}
+		if !decrypted && sopsEncryptedValue.MatchString(v) {
+			v = sopsPlaceholder
+		}
+		out[k] = v
+	}
+	if !decrypted {
+		return out, nil, nil
+	}
+	return out, encrypted, nil
+}
+
+// sopsDecryptFile decrypts file with sops, decrypted is false when there's no key or sops isn't installed
+func sopsDecryptFile(ctx context.Context, file, inputType, outputType string) ([]byte, bool, error) {
+	if !sopsKeyAvailable(ctx) {
+		return nil, false, nil
+	}
+	sopsPath, err := exec.LookPath(SopsExecutable)
+	if err != nil {
+		return nil, false, nil
+	}
+	plain, err := sopsDecrypt(ctx, sopsPath, file, inputType, outputType)
+	if err != nil {
+		return nil, false, err
+	}
+	return plain, true, nil
+}
+
+func sopsDecrypt(ctx context.Context, sopsPath, file, inputType, outputType string) ([]byte, error) {
+	cmd := renderCommand(ctx, sopsPath, "--decrypt", "--input-type", inputType, "--output-type", outputType, file)
+	if keyFile := sopsFromContext(ctx).AgeKeyFile; keyFile != "" {
+		cmd.Env = append(os.Environ(), "SOPS_AGE_KEY_FILE="+keyFile)
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	plain, err := cmd.Output()
+	if err != nil {
+		return nil, toolError(SopsExecutable, fmt.Sprintf("unable to decrypt %q with sops - %s - %s", file, err, strings.TrimSpace(stderr.String())), err, stderr.String())
+	}
+	return plain, nil
+}
+
+func sopsDecodeDocuments(content []byte) ([]map[string]interface{}, error) {
+	docs := []map[string]interface{}{}
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		doc := map[string]interface{}{}
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		if len(doc) > 0 {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func sopsIsEncrypted(docs []map[string]interface{}) bool {
+	for _, doc := range docs {
+		if meta, ok := doc["sops"].(map[string]interface{}); ok {
+			if _, ok := meta["mac"]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sopsLastModified(doc map[string]interface{}) string {
+	meta, _ := doc["sops"].(map[string]interface{})
+	return fmt.Sprintf("%v", meta["lastmodified"])
+}
+
+// sopsEncryptedPaths finds the path of every encrypted value in the document
+func sopsEncryptedPaths(doc map[string]interface{}) [][]string {
+	paths := [][]string{}
+	var walk func(v interface{}, p []string)
+	walk = func(v interface{}, p []string) {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			for k, child := range val {
+				if len(p) == 0 && k == "sops" {
+					continue
+				}
+				walk(child, append(p, k))
+			}
+		case []interface{}:
+			for i, child := range val {
+				walk(child, append(p, strconv.Itoa(i)))
+			}
+		case string:
+			if sopsEncryptedValue.MatchString(val) {
+				paths = append(paths, append([]string{}, p...))
+			}
+		}
+	}
+	walk(doc, []string{})
+
+	return paths
+}
+
+func sopsGetPath(v interface{}, p []string) interface{} {
+	for _, el := range p {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			v = val[el]
+		case []interface{}:
+			idx, err := strconv.Atoi(el)
+			if err != nil || idx < 0 || idx >= len(val) {
+				return nil
+			}
+			v = val[idx]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func sopsSetPath(v interface{}, p []string, value interface{}) {
+	for i, el := range p {
+		last := i == len(p)-1
+		switch val := v.(type) {
+		case map[string]interface{}:
+			if last {
+				val[el] = value
+				return
+			}
+			v = val[el]
+		case []interface{}:
+			idx, err := strconv.Atoi(el)
+			if err != nil || idx < 0 || idx >= len(val) {
+				return
+			}
+			if last {
+				val[idx] = value
+				return
+			}
+			v = val[idx]
+		default:
+			return
+		}
+	}
+}
+
+func sopsAnnotate(doc map[string]interface{}, paths [][]string, decrypted bool, lastModified string) {
+	metadata, ok := doc["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		doc["metadata"] = metadata
+	}
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		annotations = map[string]interface{}{}
+		metadata["annotations"] = annotations
+	}
+	encoded, _ := json.Marshal(paths)
+	annotations[sopsEncryptedAnnotation] = string(encoded)
+	annotations[sopsDecryptedAnnotation] = strconv.FormatBool(decrypted)
+	annotations[sopsLastModifiedAnnotation] = lastModified
+}
+
+// kubeSopsPaths returns the paths which were encrypted in the manifest the resource was rendered from
+func kubeSopsPaths(r *resource.Resource) [][]string {
+	encoded, ok := r.GetAnnotations()[sopsEncryptedAnnotation]
+	if !ok {
+		return nil
+	}
+	paths := [][]string{}
+	if err := json.Unmarshal([]byte(encoded), &paths); err != nil {
+		return nil
+	}
+	return paths
+}
+
+// kubeRemoveSopsAnnotations removes the annotations added while loading SOPS files, so they aren't part of the returned resources
+func kubeRemoveSopsAnnotations(r *resource.Resource) error {
+	annotations := r.GetAnnotations()
+	if _, ok := annotations[sopsEncryptedAnnotation]; !ok {
+		return nil
+	}
+	delete(annotations, sopsEncryptedAnnotation)
+	delete(annotations, sopsDecryptedAnnotation)
+	delete(annotations, sopsLastModifiedAnnotation)
+	return r.SetAnnotations(annotations)
+}
+
+// sopsCollapse replaces changes to the SOPS annotations with notes, and warns when encrypted values couldn't
+// be compared because there was no key to decrypt them
+func sopsCollapse(pre, post *resource.Resource, changelog r3diff.Changelog) (r3diff.Changelog, []string) {
+	preAnnotations := pre.GetAnnotations()
+	postAnnotations := post.GetAnnotations()
+	if _, ok := postAnnotations[sopsEncryptedAnnotation]; !ok {
+		if _, ok := preAnnotations[sopsEncryptedAnnotation]; !ok {
+			return changelog, nil
+		}
+	}
+
+	kept := r3diff.Changelog{}
+	for _, change := range changelog {
+		if len(change.Path) == 3 && change.Path[0] == "metadata" && change.Path[1] == "annotations" && strings.HasPrefix(change.Path[2], "gitops-repo-api/sops-") {
+			continue
+		}
+		kept = append(kept, change)
+	}
+
+	notes := []string{}
+	if preAnnotations[sopsDecryptedAnnotation] == "false" || postAnnotations[sopsDecryptedAnnotation] == "false" {
+		if preAnnotations[sopsLastModifiedAnnotation] != postAnnotations[sopsLastModifiedAnnotation] {
+			notes = append(notes, "SOPS encrypted values were re-encrypted and can't be compared without a key, only added and removed keys are shown")
+		}
+	}
+	if preAnnotations[sopsEncryptedAnnotation] != postAnnotations[sopsEncryptedAnnotation] {
+		notes = append(notes, "the set of SOPS encrypted keys changed")
+	}
+
+	return kept, notes
+}
@@ -0,0 +1,209 @@
+package resource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/git"
+	"sigs.k8s.io/kustomize/api/resmap"
+)
+
+// testSops stands in for sops, printing the plaintext of the file being decrypted from $FAKE_SOPS_PLAIN and
+// recording its arguments in $FAKE_SOPS_ARGS
+const testSops = `#!/bin/sh
+for last; do :; done
+echo "$@" >> "$FAKE_SOPS_ARGS"
+cat "$FAKE_SOPS_PLAIN/$(basename "$last")"
+`
+
+func testSopsConfigMap(value, lastModified string) string {
+	return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  token: ENC[AES256_GCM,data:" + value + ",type:str]\n  level: info\n" +
+		"sops:\n  mac: ENC[AES256_GCM,data:mac,type:str]\n  lastmodified: \"" + lastModified + "\"\n"
+}
+
+func testPlainConfigMap(token string) string {
+	return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  token: " + token + "\n  level: info\n"
+}
+
+func TestSopsKubernetesDiff(t *testing.T) {
+	reEncrypted := "SOPS encrypted values were re-encrypted and can't be compared without a key, only added and removed keys are shown"
+	tests := []struct {
+		name string
+		key  bool
+		file string
+		pre  string
+		post string
+		// prePlain and postPlain are what sops decrypts each revision to
+		prePlain  string
+		postPlain string
+		// want are the paths of the changes to the ConfigMap
+		want      []string
+		notes     []string
+		decrypted string
+		inputType string
+	}{
+		{
+			name:      "re-encrypted without a key",
+			file:      "settings.yaml",
+			pre:       testSopsConfigMap("YQ==", "2024-01-01T00:00:00Z"),
+			post:      testSopsConfigMap("Yg==", "2024-02-01T00:00:00Z"),
+			want:      []string{},
+			notes:     []string{reEncrypted},
+			decrypted: "false",
+		},
+		{
+			name:      "changed with a key",
+			key:       true,
+			file:      "settings.yaml",
+			pre:       testSopsConfigMap("YQ==", "2024-01-01T00:00:00Z"),
+			post:      testSopsConfigMap("Yg==", "2024-02-01T00:00:00Z"),
+			prePlain:  testPlainConfigMap("abc"),
+			postPlain: testPlainConfigMap("abd"),
+			want:      []string{"data.token"},
+			decrypted: "true",
+			inputType: "yaml",
+		},
+		{
+			name:      "re-encrypted with a key",
+			key:       true,
+			file:      "settings.yaml",
+			pre:       testSopsConfigMap("YQ==", "2024-01-01T00:00:00Z"),
+			post:      testSopsConfigMap("Yg==", "2024-02-01T00:00:00Z"),
+			prePlain:  testPlainConfigMap("abc"),
+			postPlain: testPlainConfigMap("abc"),
+			want:      []string{},
+			decrypted: "true",
+			inputType: "yaml",
+		},
+		{
+			name:      "decrypted document count doesn't match",
+			key:       true,
+			file:      "settings.yaml",
+			pre:       testSopsConfigMap("YQ==", "2024-01-01T00:00:00Z") + "---\n" + strings.Replace(testSopsConfigMap("YQ==", "2024-01-01T00:00:00Z"), "name: settings", "name: other", 1),
+			post:      testSopsConfigMap("Yg==", "2024-02-01T00:00:00Z") + "---\n" + strings.Replace(testSopsConfigMap("Yg==", "2024-02-01T00:00:00Z"), "name: settings", "name: other", 1),
+			prePlain:  testPlainConfigMap("abc"),
+			postPlain: testPlainConfigMap("abd"),
+			want:      []string{},
+			notes:     []string{reEncrypted},
+			decrypted: "false",
+			inputType: "yaml",
+		},
+		{
+			name:      "json",
+			key:       true,
+			file:      "settings.json",
+			pre:       `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings"}, "data": {"token": "ENC[AES256_GCM,data:YQ==,type:str]", "level": "info"}, "sops": {"mac": "ENC[AES256_GCM,data:mac,type:str]", "lastmodified": "2024-01-01T00:00:00Z"}}`,
+			post:      `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings"}, "data": {"token": "ENC[AES256_GCM,data:Yg==,type:str]", "level": "info"}, "sops": {"mac": "ENC[AES256_GCM,data:mac,type:str]", "lastmodified": "2024-02-01T00:00:00Z"}}`,
+			prePlain:  testPlainConfigMap("abc"),
+			postPlain: testPlainConfigMap("abd"),
+			want:      []string{"data.token"},
+			decrypted: "true",
+			inputType: "json",
+		},
+	}
+
+	binDir := t.TempDir()
+	sopsPath := filepath.Join(binDir, "sops")
+	if err := os.WriteFile(sopsPath, []byte(testSops), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig string) { SopsExecutable = orig }(SopsExecutable)
+	SopsExecutable = sopsPath
+	// Only the key passed through SopsOptions is used
+	t.Setenv("SOPS_AGE_KEY", "")
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	rs := git.NewRepoSpec("https://example.com/repo.git", nil)
+	ep := entrypoint.Entrypoint{Name: "manifests", Type: entrypoint.EntrypointTypeKubernetes}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preDir, postDir, prePlainDir, postPlainDir := t.TempDir(), t.TempDir(), t.TempDir(), t.TempDir()
+			writeFixture(t, preDir, map[string]string{tt.file: tt.pre})
+			writeFixture(t, postDir, map[string]string{tt.file: tt.post})
+			writeFixture(t, prePlainDir, map[string]string{tt.file: tt.prePlain})
+			writeFixture(t, postPlainDir, map[string]string{tt.file: tt.postPlain})
+			argsFile := filepath.Join(t.TempDir(), "args")
+			t.Setenv("FAKE_SOPS_ARGS", argsFile)
+
+			ctx := WithRedaction(context.Background(), RedactionOptions{Salt: "test"})
+			if tt.key {
+				keyFile := filepath.Join(t.TempDir(), "keys.txt")
+				if err := os.WriteFile(keyFile, []byte("AGE-SECRET-KEY-TEST\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				ctx = WithSops(ctx, SopsOptions{AgeKeyFile: keyFile})
+			}
+
+			// Each revision is rendered separately so sops decrypts it to its own plaintext
+			render := func(dir, plainDir string) resmap.ResMap {
+				t.Setenv("FAKE_SOPS_PLAIN", plainDir)
+				rm, _, err := RenderKubernetes(ctx, dir, false)
+				if err != nil {
+					t.Fatalf("unable to render - %s", err)
+				}
+				return rm
+			}
+			old, new := render(preDir, prePlainDir), render(postDir, postPlainDir)
+			diff, pre, post, err := doResmapDiff(ctx, rs, ep, old, new)
+			diff, _, post, err = redactResults(ctx, ep, diff, pre, post, err)
+			if err != nil {
+				t.Fatalf("unable to diff - %s", err)
+			}
+
+			got := []string{}
+			notes := []string{}
+			for _, rd := range diff {
+				if rd.Name() != "settings" {
+					continue
+				}
+				notes = append(notes, rd.Notes...)
+				for _, change := range rd.Diff {
+					got = append(got, strings.Join(change.Path, "."))
+					if !isRedacted(change.From) || !isRedacted(change.To) {
+						t.Errorf("change to %v isn't redacted, from %v to %v", change.Path, change.From, change.To)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got changes %v, expected %v", got, tt.want)
+			}
+			if len(notes) > 0 || len(tt.notes) > 0 {
+				if !reflect.DeepEqual(notes, tt.notes) {
+					t.Errorf("got notes %v, expected %v", notes, tt.notes)
+				}
+			}
+
+			for _, r := range post {
+				kr := r.(*KubernetesResource)
+				obj, err := kubeResourceObject(kr.Resource)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if token := cfnValueAt(obj, []string{"data", "token"}); !isRedacted(token) {
+					t.Errorf("token of %s is %v, expected it to be redacted", kr.Resource.GetName(), token)
+				}
+				if level := cfnValueAt(obj, []string{"data", "level"}); level != "info" {
+					t.Errorf("level of %s is %v, expected it to be kept", kr.Resource.GetName(), level)
+				}
+				if decrypted := kr.Resource.GetAnnotations()[sopsDecryptedAnnotation]; decrypted != tt.decrypted {
+					t.Errorf("%s was decrypted: %q, expected %q", kr.Resource.GetName(), decrypted, tt.decrypted)
+				}
+			}
+
+			args, _ := os.ReadFile(argsFile)
+			if tt.inputType == "" && len(args) > 0 {
+				t.Errorf("sops was run without a key - %s", args)
+			}
+			if tt.inputType != "" && !strings.Contains(string(args), "--input-type "+tt.inputType) {
+				t.Errorf("sops wasn't run with input type %s - %s", tt.inputType, args)
+			}
+		})
+	}
+}
@@ -2,8 +2,13 @@ package resource
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/codingninja/gitops-repo-api/entrypoint"
 	"github.com/codingninja/gitops-repo-api/git"
@@ -12,31 +17,112 @@ import (
 	"github.com/hashicorp/hc-install/releases"
 	"github.com/hashicorp/terraform-exec/tfexec"
 	tfjson "github.com/hashicorp/terraform-json"
+	r3diff "github.com/r3labs/diff/v3"
+	"github.com/zclconf/go-cty/cty"
 )
 
-var tfExecPath = ""
-var tfLoaded = make(chan struct{})
+// TerraformOptions configures how terraform is run in TerraformModePlan
+type TerraformOptions struct {
+	// ExecPath is a locally installed terraform binary, when empty terraform is downloaded the first time it's needed
+	ExecPath string
+	// ProviderMirror is a directory laid out as a terraform filesystem mirror, providers are installed from it
+	// instead of being downloaded into a temporary plugin cache
+	ProviderMirror string
+}
 
-func init() {
-	tmpDir, err := os.MkdirTemp("", "tfinit-*")
-	if err != nil {
-		panic("unable to create temp dir for terraform plugins")
+type terraformContextKey struct{}
+
+// WithTerraform returns a context which configures how the terraform differ runs terraform
+func WithTerraform(ctx context.Context, opts TerraformOptions) context.Context {
+	return context.WithValue(ctx, terraformContextKey{}, opts)
+}
+
+func terraformFromContext(ctx context.Context) TerraformOptions {
+	if ctx != nil {
+		if opts, ok := ctx.Value(terraformContextKey{}).(TerraformOptions); ok {
+			return opts
+		}
 	}
-	os.Setenv("TF_PLUGIN_CACHE_DIR", tmpDir)
-	go func() {
+	return TerraformOptions{}
+}
+
+var tfInstallOnce sync.Once
+var tfInstalledPath string
+var tfInstallErr error
+
+var tfPluginCacheOnce sync.Once
+var tfPluginCacheDir string
+var tfPluginCacheErr error
+
+// tfVersion is the terraform release installed when no binary is given
+const tfVersion = "1.0.6"
+
+// terraformExecPath installs terraform the first time it's needed, so runs which don't plan never touch the network
+func terraformExecPath(ctx context.Context, opts TerraformOptions) (string, error) {
+	if opts.ExecPath != "" {
+		return opts.ExecPath, nil
+	}
+
+	tfInstallOnce.Do(func() {
 		installer := &releases.ExactVersion{
 			Product:    product.Terraform,
-			Version:    version.Must(version.NewVersion("1.0.6")),
-			InstallDir: "/tmp",
+			Version:    version.Must(version.NewVersion(tfVersion)),
+			InstallDir: os.TempDir(),
 		}
 
-		path, err := installer.Install(context.Background())
+		var err error
+		tfInstalledPath, err = installer.Install(ctx)
 		if err != nil {
-			panic(fmt.Errorf("error installing Terraform: %s", err))
+			tfInstallErr = toolMissingError("terraform", fmt.Errorf("error installing Terraform - %w", err))
 		}
-		tfExecPath = path
-		close(tfLoaded)
-	}()
+	})
+
+	return tfInstalledPath, tfInstallErr
+}
+
+// terraformEnv is the environment terraform runs with, providers come from the mirror when one is configured and
+// are otherwise shared between runs through a plugin cache. The returned function removes any files it created.
+func terraformEnv(opts TerraformOptions) (map[string]string, func(), error) {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	// tfexec manages some variables itself and refuses to run if they're set
+	env = tfexec.CleanEnv(env)
+
+	if opts.ProviderMirror == "" {
+		tfPluginCacheOnce.Do(func() {
+			tfPluginCacheDir, tfPluginCacheErr = os.MkdirTemp("", "tfinit-*")
+		})
+		if tfPluginCacheErr != nil {
+			return nil, nil, fmt.Errorf("unable to create temp dir for terraform plugins - %w", tfPluginCacheErr)
+		}
+		env["TF_PLUGIN_CACHE_DIR"] = tfPluginCacheDir
+		return env, func() {}, nil
+	}
+
+	mirror, err := filepath.Abs(opts.ProviderMirror)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := os.CreateTemp("", "*.tfrc")
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create terraform cli config - %w", err)
+	}
+	cleanup := func() { os.Remove(cfg.Name()) }
+	_, err = fmt.Fprintf(cfg, "provider_installation {\n  filesystem_mirror {\n    path = %q\n  }\n}\n", mirror)
+	if closeErr := cfg.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("unable to write terraform cli config - %w", err)
+	}
+	env["TF_CLI_CONFIG_FILE"] = cfg.Name()
+
+	return env, cleanup, nil
 }
 
 type TerraformResource struct {
@@ -53,7 +139,12 @@ func (kr *TerraformResource) Type() string {
 func (kr *TerraformResource) Identifier() string {
 	addr := kr.Change.Address
 
-	if after, ok := kr.Change.Change.After.(map[string]interface{}); ok {
+	// Statically rendered resources have no planned change, only their values
+	values := kr.Resource
+	if kr.Change.Change != nil {
+		values = kr.Change.Change.After
+	}
+	if after, ok := values.(map[string]interface{}); ok {
 		if ns, ok := after["namespace"].(string); ok {
 			addr = fmt.Sprintf("%s/%s", addr, ns)
 		}
@@ -66,87 +157,315 @@ func (kr *TerraformResource) Name() string {
 	return kr.Change.Address
 }
 
-func RenderTerraform(workingDir string) (*tfjson.Plan, error) {
-	<-tfLoaded
+// tfBackendOverrideFile is written alongside the configuration while planning, so the real backend is never contacted
+const tfBackendOverrideFile = "zz_gitops_repo_api_override.tf"
+
+// RenderTerraform plans the configuration in moduleDir, in the workspace and with the var files and vars in the
+// entrypoint context. The backend is overridden with a local one, holding a copy of statePath if it's set. Plans
+// made against state don't refresh it, so plans of different revisions are compared against the same starting point.
+// Terraform is run in a working copy of moduleDir, as it writes the override, its lock file and .terraform there.
+func RenderTerraform(ctx context.Context, moduleDir string, epctx map[string]interface{}, statePath string) (*tfjson.Plan, error) {
+	opts := terraformFromContext(ctx)
+	tfExecPath, err := terraformExecPath(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	workingDir, cleanupCopy, err := renderWorkingCopy(moduleDir, false, ".terraform", tfBackendOverrideFile)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupCopy()
 	tf, err := tfexec.NewTerraform(workingDir, tfExecPath)
 	if err != nil {
-		return nil, fmt.Errorf("error running NewTerraform: %s", err)
+		return nil, toolMissingError("terraform", err)
+	}
+	env, cleanup, err := terraformEnv(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if err := tf.SetEnv(env); err != nil {
+		return nil, err
 	}
 
-	fmt.Println("Running init ", tfExecPath)
+	workspace := terraformWorkspace(epctx)
+	stateDir, err := os.MkdirTemp("", "tfstate-*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temp dir for terraform state - %w", err)
+	}
+	defer os.RemoveAll(stateDir)
+	if statePath != "" {
+		if err := tfCopyState(statePath, stateDir, workspace); err != nil {
+			return nil, err
+		}
+	}
+	override := filepath.Join(workingDir, tfBackendOverrideFile)
+	backend := fmt.Sprintf("terraform {\n  backend \"local\" {\n    path          = %q\n    workspace_dir = %q\n  }\n}\n", filepath.Join(stateDir, "terraform.tfstate"), stateDir)
+	if err := os.WriteFile(override, []byte(backend), 0o644); err != nil {
+		return nil, fmt.Errorf("unable to override terraform backend - %w", err)
+	}
 
-	err = tf.Init(context.Background(), tfexec.Upgrade(true))
+	err = tf.Init(ctx, tfexec.Upgrade(true), tfexec.Reconfigure(true))
 	if err != nil {
-		return nil, fmt.Errorf("error running Init: %s", err)
+		return nil, toolError("terraform", fmt.Sprintf("error running Init - %s", err), err, err.Error())
+	}
+
+	if workspace != "default" {
+		if statePath != "" {
+			err = tf.WorkspaceSelect(ctx, workspace)
+		} else {
+			err = tf.WorkspaceNew(ctx, workspace)
+		}
+		if err != nil {
+			return nil, toolError("terraform", fmt.Sprintf("unable to select workspace %q - %s", workspace, err), err, err.Error())
+		}
 	}
-	fmt.Println("Init completed ", tfExecPath)
 
 	tfpf, err := os.CreateTemp("", "*.tfplan")
 	if err != nil {
-		return nil, fmt.Errorf("error creating tfplan file: %s", err)
+		return nil, fmt.Errorf("error creating tfplan file - %w", err)
+	}
+	tfpf.Close()
+	defer os.Remove(tfpf.Name())
+
+	planOpts := []tfexec.PlanOption{tfexec.Out(tfpf.Name()), tfexec.Lock(false)}
+	if statePath != "" {
+		planOpts = append(planOpts, tfexec.Refresh(false))
 	}
-	changes, err := tf.Plan(context.Background(), tfexec.Out(tfpf.Name()))
+	for _, f := range contextStringList(epctx[TerraformContextVarFiles]) {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(workingDir, f)
+		}
+		planOpts = append(planOpts, tfexec.VarFile(f))
+	}
+	vars, err := tfVarAssignments(epctx)
 	if err != nil {
 		return nil, err
 	}
+	for _, v := range vars {
+		planOpts = append(planOpts, tfexec.Var(v))
+	}
+	if _, err := tf.Plan(ctx, planOpts...); err != nil {
+		return nil, toolError("terraform", fmt.Sprintf("error running Plan - %s", err), err, err.Error())
+	}
+
+	// The plan is read even when there are no changes, as its planned values are still needed
+	plan, err := tf.ShowPlanFile(ctx, tfpf.Name())
+	if err != nil {
+		return nil, toolError("terraform", fmt.Sprintf("error reading plan - %s", err), err, err.Error())
+	}
 
-	if !changes {
-		return nil, nil
+	return plan, nil
+}
+
+// terraformWorkspace is the workspace selected by the entrypoint context
+func terraformWorkspace(epctx map[string]interface{}) string {
+	if workspace, ok := epctx[TerraformContextWorkspace].(string); ok && workspace != "" {
+		return workspace
 	}
+	return "default"
+}
 
-	state, err := tf.ShowPlanFile(context.Background(), tfpf.Name())
+// tfCopyState copies the state file to where the local backend expects the state of workspace to be
+func tfCopyState(statePath, stateDir, workspace string) error {
+	state, err := os.ReadFile(statePath)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("unable to read terraform state %q - %w", statePath, err)
 	}
+	dest := filepath.Join(stateDir, "terraform.tfstate")
+	if workspace != "default" {
+		dest = filepath.Join(stateDir, workspace, "terraform.tfstate")
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(dest, state, 0o600)
+}
 
-	return state, nil
+// tfVarAssignments converts the vars in the entrypoint context to -var assignments, complex values are written as
+// JSON which terraform accepts as HCL
+func tfVarAssignments(epctx map[string]interface{}) ([]string, error) {
+	vars, _ := epctx[TerraformContextVars].(map[string]interface{})
+	names := []string{}
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
+	assignments := []string{}
+	for _, name := range names {
+		switch v := vars[name].(type) {
+		case string:
+			assignments = append(assignments, fmt.Sprintf("%s=%s", name, v))
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("unable to encode variable %q - %w", name, err)
+			}
+			assignments = append(assignments, fmt.Sprintf("%s=%s", name, b))
+		}
+	}
+	return assignments, nil
 }
 
 type tfDiffer struct {
 }
 
-func (td *tfDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldDir, newDir string) ([]ResourceDiff, []Resource, []Resource, error) {
-	tfplan, err := RenderTerraform(newDir)
+// renderVersion changes with the terraform binary and providers plans are made with
+func (td *tfDiffer) renderVersion(ctx context.Context) string {
+	opts := terraformFromContext(ctx)
+	version := fmt.Sprintf("%s %s", tfVersion, opts.ProviderMirror)
+	if opts.ExecPath != "" {
+		version = fmt.Sprintf("%s %s", toolVersion(opts.ExecPath), opts.ProviderMirror)
+	}
+	return version
+}
+
+// renderInputs are the module at dir, the local modules it calls and its var files. Plans against state aren't
+// listed, as both revisions are planned against the state of the newest.
+func (td *tfDiffer) renderInputs(ep entrypoint.Entrypoint, dir string) ([]string, bool) {
+	if statePath, _ := ep.Context[TerraformContextState].(string); statePath != "" {
+		return nil, false
+	}
+	inputs := []string{}
+	if !tfModuleInputs(dir, map[string]bool{}, &inputs) {
+		return nil, false
+	}
+	for _, f := range contextStringList(ep.Context[TerraformContextVarFiles]) {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(dir, f)
+		}
+		inputs = append(inputs, f)
+	}
+	return inputs, true
+}
+
+// tfModuleInputs adds dir and the local modules it calls to inputs, it's false if any module comes from elsewhere
+func tfModuleInputs(dir string, seen map[string]bool, inputs *[]string) bool {
+	if seen[dir] {
+		return true
+	}
+	seen[dir] = true
+	*inputs = append(*inputs, dir)
+
+	// Modules declared in JSON configuration aren't parsed
+	if files, err := filepath.Glob(filepath.Join(dir, "*.tf.json")); err != nil || len(files) > 0 {
+		return false
+	}
+	tr := &tfStaticRenderer{modules: map[string]*tfModule{}}
+	mod, err := tr.loadModule(dir)
 	if err != nil {
-		return nil, nil, nil, err
+		return false
 	}
-	diff := []ResourceDiff{}
-	allResources := []Resource{}
-	for _, rc := range tfplan.PlannedValues.RootModule.Resources {
-		allResources = append(allResources, &TerraformResource{
-			Resource:  rc,
-			Sensitive: rc.SensitiveValues,
-		})
+	for _, block := range mod.Modules {
+		attr, ok := block.Body.Attributes["source"]
+		if !ok {
+			return false
+		}
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() || val.Type() != cty.String || !val.IsKnown() || val.IsNull() {
+			return false
+		}
+		source := val.AsString()
+		if !strings.HasPrefix(source, "./") && !strings.HasPrefix(source, "../") {
+			return false
+		}
+		if !tfModuleInputs(filepath.Join(dir, source), seen, inputs) {
+			return false
+		}
+	}
+	return true
+}
+
+func (td *tfDiffer) Diff(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldDir, newDir string) ([]ResourceDiff, []Resource, []Resource, error) {
+	if mode, _ := ep.Context[TerraformContextMode].(string); mode == TerraformModeStatic {
+		return td.diffStatic(ctx, rs, ep, oldDir, newDir)
 	}
-	for _, rc := range tfplan.ResourceChanges {
-		rd := ResourceDiff{
-			Pre: &TerraformResource{
-				Resource:  rc.Change.Before,
-				Sensitive: rc.Change.BeforeSensitive,
-				Unknown:   nil,
-				Change:    rc,
-			},
-			Post: &TerraformResource{
-				Resource:  rc.Change.After,
-				Sensitive: rc.Change.AfterSensitive,
-				Unknown:   rc.Change.AfterUnknown,
-				Change:    rc,
-			},
+
+	// Both revisions are planned against the same state, a relative path is found in the newest revision
+	statePath, _ := ep.Context[TerraformContextState].(string)
+	if statePath != "" && !filepath.IsAbs(statePath) {
+		base := newDir
+		if base == "" {
+			base = oldDir
 		}
+		statePath = filepath.Join(base, statePath)
+	}
+
+	return diffConcurrent(ctx, ep, oldDir, newDir, func(dir string, ep entrypoint.Entrypoint) (*tfjson.Plan, error) {
+		return RenderTerraform(ctx, dir, ep.Context, statePath)
+	}, doTfPlanDiff)
+}
 
-		if rc.Change.Actions.Replace() {
-			rd.Type = DiffTypeReplace
-		} else if rc.Change.Actions.Create() {
-			rd.Type = DiffTypeCreate
-		} else if rc.Change.Actions.Delete() {
-			rd.Type = DiffTypeDelete
-		} else if rc.Change.Actions.Update() {
-			rd.Type = DiffTypeUpdate
+// tfPlannedResources returns the resources which will exist once the plan is applied, keyed by address
+func tfPlannedResources(plan *tfjson.Plan) map[string]*TerraformResource {
+	resources := map[string]*TerraformResource{}
+	if plan == nil {
+		return resources
+	}
+	for _, rc := range plan.ResourceChanges {
+		if rc.Change == nil || rc.Change.Actions.Delete() {
+			continue
+		}
+		resources[rc.Address] = &TerraformResource{
+			Resource:  rc.Change.After,
+			Sensitive: rc.Change.AfterSensitive,
+			Unknown:   rc.Change.AfterUnknown,
+			Change:    rc,
+		}
+	}
+	return resources
+}
+
+// doTfPlanDiff compares the outcome of applying each revision's plan, so only the changes introduced by the new
+// revision are reported rather than drift which both plans would correct
+func doTfPlanDiff(oldPlan, newPlan *tfjson.Plan) ([]ResourceDiff, []Resource, []Resource, error) {
+	old := tfPlannedResources(oldPlan)
+	new := tfPlannedResources(newPlan)
+
+	addresses := []string{}
+	for addr := range old {
+		addresses = append(addresses, addr)
+	}
+	for addr := range new {
+		if _, ok := old[addr]; !ok {
+			addresses = append(addresses, addr)
+		}
+	}
+	sort.Strings(addresses)
+
+	diff := []ResourceDiff{}
+	allOld := []Resource{}
+	allNew := []Resource{}
+	for _, addr := range addresses {
+		pre, hasOld := old[addr]
+		post, hasNew := new[addr]
+		var a, b interface{}
+		if hasOld {
+			allOld = append(allOld, pre)
+			a = pre.Resource
+		}
+		if hasNew {
+			allNew = append(allNew, post)
+			b = post.Resource
 		}
 
-		diff = append(diff, rd)
+		changelog, err := r3diff.Diff(a, b)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to diff terraform resource %q - %w", addr, err)
+		}
+
+		switch {
+		case !hasOld:
+			diff = append(diff, ResourceDiff{Type: DiffTypeCreate, Post: post, Diff: changelog})
+		case !hasNew:
+			diff = append(diff, ResourceDiff{Type: DiffTypeDelete, Pre: pre, Diff: changelog})
+		case post.Change.Change.Actions.Replace() && !pre.Change.Change.Actions.Replace():
+			diff = append(diff, ResourceDiff{Type: DiffTypeReplace, Pre: pre, Post: post, Diff: changelog})
+		case len(changelog) > 0:
+			diff = append(diff, ResourceDiff{Type: DiffTypeUpdate, Pre: pre, Post: post, Diff: changelog})
+		}
 	}
 
-	return diff, allResources, allResources, nil
+	return diff, allOld, allNew, nil
 }
@@ -0,0 +1,104 @@
+package resource
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTerraformWorkspace(t *testing.T) {
+	tests := []struct {
+		name  string
+		epctx map[string]interface{}
+		want  string
+	}{
+		{name: "no context", epctx: nil, want: "default"},
+		{name: "empty workspace", epctx: map[string]interface{}{TerraformContextWorkspace: ""}, want: "default"},
+		{name: "workspace", epctx: map[string]interface{}{TerraformContextWorkspace: "prod"}, want: "prod"},
+		{name: "not a string", epctx: map[string]interface{}{TerraformContextWorkspace: 1}, want: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := terraformWorkspace(tt.epctx); got != tt.want {
+				t.Errorf("got %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTfVarAssignments(t *testing.T) {
+	tests := []struct {
+		name  string
+		epctx map[string]interface{}
+		want  []string
+	}{
+		{name: "no vars", epctx: nil, want: []string{}},
+		{
+			name:  "sorted by name",
+			epctx: map[string]interface{}{TerraformContextVars: map[string]interface{}{"region": "eu-west-1", "env": "prod"}},
+			want:  []string{"env=prod", "region=eu-west-1"},
+		},
+		{
+			name: "complex values as json",
+			epctx: map[string]interface{}{TerraformContextVars: map[string]interface{}{
+				"count": 3,
+				"tags":  map[string]interface{}{"team": "web"},
+				"zones": []interface{}{"a", "b"},
+			}},
+			want: []string{"count=3", `tags={"team":"web"}`, `zones=["a","b"]`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tfVarAssignments(tt.epctx)
+			if err != nil {
+				t.Fatalf("unable to convert vars - %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := tfVarAssignments(map[string]interface{}{TerraformContextVars: map[string]interface{}{"bad": func() {}}}); err == nil {
+		t.Errorf("expected an error for a value which can't be encoded")
+	}
+}
+
+func TestTfCopyState(t *testing.T) {
+	tests := []struct {
+		name      string
+		workspace string
+		want      string
+	}{
+		{name: "default workspace", workspace: "default", want: "terraform.tfstate"},
+		{name: "named workspace", workspace: "prod", want: "prod/terraform.tfstate"},
+	}
+
+	state := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(state, []byte(`{"version": 4}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			if err := tfCopyState(state, stateDir, tt.workspace); err != nil {
+				t.Fatalf("unable to copy state - %s", err)
+			}
+			got, err := os.ReadFile(filepath.Join(stateDir, tt.want))
+			if err != nil {
+				t.Fatalf("state wasn't copied to %s - %s", tt.want, err)
+			}
+			if string(got) != `{"version": 4}` {
+				t.Errorf("got state %q", got)
+			}
+		})
+	}
+
+	if err := tfCopyState(filepath.Join(t.TempDir(), "missing.json"), t.TempDir(), "default"); err == nil {
+		t.Errorf("expected an error for missing state")
+	}
+}
@@ -0,0 +1,804 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+	"github.com/codingninja/gitops-repo-api/git"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	tfjson "github.com/hashicorp/terraform-json"
+	r3diff "github.com/r3labs/diff/v3"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+const (
+	// TerraformContextMode selects how terraform entrypoints are diffed, either TerraformModePlan (the default)
+	// or TerraformModeStatic
+	TerraformContextMode = "terraformMode"
+	// TerraformContextVarFiles is one or more comma separated tfvars files, relative to the entrypoint
+	TerraformContextVarFiles = "varFiles"
+	// TerraformContextVars is a map of variable values, which take precedence over the var files
+	TerraformContextVars = "vars"
+	// TerraformContextWorkspace is the workspace the entrypoint is planned in, terraform.workspace in static mode
+	TerraformContextWorkspace = "workspace"
+	// TerraformContextState is a local state file both revisions are planned against in TerraformModePlan, relative
+	// to the entrypoint in the newest revision
+	TerraformContextState = "state"
+)
+
+const (
+	// TerraformModeStatic parses the configuration of both revisions without running terraform, so it works with no
+	// network, providers or state
+	TerraformModeStatic = "static"
+	// TerraformModePlan runs terraform plan
+	TerraformModePlan = "plan"
+)
+
+// tfStaticMaxDepth bounds how deeply local modules are followed, protecting against modules which call themselves
+const tfStaticMaxDepth = 32
+
+// tfStaticMaxCount bounds how many instances a count is expanded to, larger counts are diffed as one [*] instance
+const tfStaticMaxCount = 1000
+
+// tfMetaArguments are handled by the static renderer rather than being diffed as values
+var tfMetaArguments = map[string]bool{
+	"count":    true,
+	"for_each": true,
+	"provider": true,
+}
+
+type tfVariable struct {
+	Default   cty.Value
+	Sensitive bool
+}
+
+// tfModule is the parsed, but not evaluated, configuration in a single directory
+type tfModule struct {
+	Dir       string
+	Sources   map[string][]byte
+	Variables map[string]*tfVariable
+	Locals    map[string]hcl.Expression
+	Resources []*hclsyntax.Block
+	Modules   []*hclsyntax.Block
+	Providers map[string]string
+}
+
+type tfStaticRenderer struct {
+	root      string
+	workspace string
+	modules   map[string]*tfModule
+	resources map[string]*TerraformResource
+}
+
+// RenderTerraformStatic evaluates the terraform configuration in dir without terraform. Variables come from their
+// defaults, terraform.tfvars, *.auto.tfvars and the var files and vars in the entrypoint context. Expressions which
+// can't be known without a plan, such as references to other resources, are kept as their source text. Resources
+// are keyed by address, count and for_each are expanded where they are statically known.
+func RenderTerraformStatic(dir string, epctx map[string]interface{}) (map[string]*TerraformResource, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := tfLoadVars(dir, epctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &tfStaticRenderer{
+		root:      dir,
+		workspace: terraformWorkspace(epctx),
+		modules:   map[string]*tfModule{},
+		resources: map[string]*TerraformResource{},
+	}
+	if err := tr.renderModule(dir, "", inputs, 0); err != nil {
+		return nil, err
+	}
+
+	return tr.resources, nil
+}
+
+func (tr *tfStaticRenderer) loadModule(dir string) (*tfModule, error) {
+	if mod, ok := tr.modules[dir]; ok {
+		return mod, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	mod := &tfModule{
+		Dir:       dir,
+		Sources:   map[string][]byte{},
+		Variables: map[string]*tfVariable{},
+		Locals:    map[string]hcl.Expression{},
+		Providers: map[string]string{},
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		mod.Sources[file] = src
+		f, diags := hclsyntax.ParseConfig(src, file, hcl.InitialPos)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("unable to parse %q - %w", file, diags)
+		}
+		body, ok := f.Body.(*hclsyntax.Body)
+		if !ok {
+			continue
+		}
+		for _, block := range body.Blocks {
+			switch block.Type {
+			case "variable":
+				v := &tfVariable{Default: cty.DynamicVal}
+				if attr, ok := block.Body.Attributes["default"]; ok {
+					if val, diags := attr.Expr.Value(nil); !diags.HasErrors() {
+						v.Default = val
+					}
+				}
+				if attr, ok := block.Body.Attributes["sensitive"]; ok {
+					if val, diags := attr.Expr.Value(nil); !diags.HasErrors() && val.Type() == cty.Bool && val.IsKnown() && !val.IsNull() {
+						v.Sensitive = val.True()
+					}
+				}
+				mod.Variables[block.Labels[0]] = v
+			case "locals":
+				for name, attr := range block.Body.Attributes {
+					mod.Locals[name] = attr.Expr
+				}
+			case "resource", "data":
+				mod.Resources = append(mod.Resources, block)
+			case "module":
+				mod.Modules = append(mod.Modules, block)
+			case "terraform":
+				for _, inner := range block.Body.Blocks {
+					if inner.Type != "required_providers" {
+						continue
+					}
+					for name, attr := range inner.Body.Attributes {
+						val, diags := attr.Expr.Value(nil)
+						if diags.HasErrors() || !val.Type().IsObjectType() || !val.Type().HasAttribute("source") {
+							continue
+						}
+						if source := val.GetAttr("source"); source.Type() == cty.String && source.IsKnown() && !source.IsNull() {
+							mod.Providers[name] = source.AsString()
+						}
+					}
+				}
+			}
+		}
+	}
+
+	tr.modules[dir] = mod
+	return mod, nil
+}
+
+func (tr *tfStaticRenderer) renderModule(dir, address string, inputs map[string]cty.Value, depth int) error {
+	if depth > tfStaticMaxDepth {
+		return fmt.Errorf("modules are nested more than %d deep at %q", tfStaticMaxDepth, address)
+	}
+	mod, err := tr.loadModule(dir)
+	if err != nil {
+		return err
+	}
+
+	vars := map[string]cty.Value{}
+	sensitive := map[string]bool{}
+	for name, v := range mod.Variables {
+		vars[name] = v.Default
+		if val, ok := inputs[name]; ok {
+			vars[name] = val
+		}
+		sensitive[name] = v.Sensitive
+	}
+
+	rel, err := filepath.Rel(tr.root, dir)
+	if err != nil {
+		rel = dir
+	}
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var": cty.ObjectVal(vars),
+			"path": cty.ObjectVal(map[string]cty.Value{
+				"module": cty.StringVal(rel),
+				"root":   cty.StringVal("."),
+				"cwd":    cty.StringVal("."),
+			}),
+			"terraform": cty.ObjectVal(map[string]cty.Value{
+				"workspace": cty.StringVal(tr.workspace),
+			}),
+		},
+		Functions: tfFunctions(),
+	}
+
+	// Locals may refer to each other, so they're evaluated until no more become known
+	locals := map[string]cty.Value{}
+	for name := range mod.Locals {
+		locals[name] = cty.DynamicVal
+	}
+	for pass := 0; pass <= len(mod.Locals); pass++ {
+		ctx.Variables["local"] = cty.ObjectVal(locals)
+		progress := false
+		for name, expr := range mod.Locals {
+			if locals[name].IsWhollyKnown() {
+				continue
+			}
+			if val, ok := tfEvaluate(ctx, expr); ok {
+				locals[name] = val
+				progress = true
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+	ctx.Variables["local"] = cty.ObjectVal(locals)
+
+	for _, block := range mod.Resources {
+		mode := tfjson.ManagedResourceMode
+		prefix := ""
+		if block.Type == "data" {
+			mode = tfjson.DataResourceMode
+			prefix = "data."
+		}
+		resType, name := block.Labels[0], block.Labels[1]
+		providerName := strings.SplitN(resType, "_", 2)[0]
+		if attr, ok := block.Body.Attributes["provider"]; ok {
+			providerName = strings.SplitN(tfSourceText(mod, attr.Expr), ".", 2)[0]
+		}
+
+		for _, inst := range tfInstances(ctx, block.Body) {
+			values, sensitiveValues := tr.bodyValue(mod, inst.ctx, block.Body, sensitive)
+			addr := fmt.Sprintf("%s%s%s.%s%s", tfModulePrefix(address), prefix, resType, name, inst.suffix)
+			tr.resources[addr] = &TerraformResource{
+				Resource:  values,
+				Sensitive: sensitiveValues,
+				Change: &tfjson.ResourceChange{
+					Address:       addr,
+					ModuleAddress: address,
+					Mode:          mode,
+					Type:          resType,
+					Name:          name,
+					Index:         inst.index,
+					ProviderName:  tfProviderAddress(mod, providerName),
+				},
+			}
+		}
+	}
+
+	for _, block := range mod.Modules {
+		name := block.Labels[0]
+		source := ""
+		if attr, ok := block.Body.Attributes["source"]; ok {
+			if val, ok := tfEvaluate(ctx, attr.Expr); ok && val.Type() == cty.String {
+				source = val.AsString()
+			}
+		}
+
+		for _, inst := range tfInstances(ctx, block.Body) {
+			moduleAddress := fmt.Sprintf("%smodule.%s%s", tfModulePrefix(address), name, inst.suffix)
+
+			// Only local modules can be followed without a network, others are diffed by their source and inputs
+			if !strings.HasPrefix(source, "./") && !strings.HasPrefix(source, "../") {
+				values, sensitiveValues := tr.bodyValue(mod, inst.ctx, block.Body, sensitive)
+				tr.resources[moduleAddress] = &TerraformResource{
+					Resource:  values,
+					Sensitive: sensitiveValues,
+					Change: &tfjson.ResourceChange{
+						Address:       moduleAddress,
+						ModuleAddress: address,
+						Mode:          "module",
+						Type:          "module",
+						Name:          name,
+						Index:         inst.index,
+						ProviderName:  source,
+					},
+				}
+				continue
+			}
+
+			childInputs := map[string]cty.Value{}
+			for attrName, attr := range block.Body.Attributes {
+				switch attrName {
+				case "source", "version", "count", "for_each", "providers", "depends_on":
+					continue
+				}
+				if val, ok := tfEvaluate(inst.ctx, attr.Expr); ok {
+					childInputs[attrName] = val
+				} else {
+					childInputs[attrName] = cty.DynamicVal
+				}
+			}
+			if err := tr.renderModule(filepath.Join(dir, source), moduleAddress, childInputs, depth+1); err != nil {
+				return fmt.Errorf("unable to render module %q - %w", moduleAddress, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+type tfInstance struct {
+	suffix string
+	index  interface{}
+	ctx    *hcl.EvalContext
+}
+
+// tfInstances expands count and for_each. When they can't be known statically, or the count is too large to
+// expand, a single instance with the index [*] stands in for all of them.
+func tfInstances(ctx *hcl.EvalContext, body *hclsyntax.Body) []tfInstance {
+	if attr, ok := body.Attributes["count"]; ok {
+		placeholder := []tfInstance{{suffix: "[*]", index: "*", ctx: tfChildContext(ctx, "count", cty.ObjectVal(map[string]cty.Value{"index": cty.UnknownVal(cty.Number)}))}}
+		val, ok := tfEvaluate(ctx, attr.Expr)
+		if !ok || val.IsNull() || val.Type() != cty.Number {
+			return placeholder
+		}
+		n, accuracy := val.AsBigFloat().Int64()
+		if accuracy != big.Exact || n > tfStaticMaxCount {
+			return placeholder
+		}
+		instances := []tfInstance{}
+		for i := int64(0); i < n; i++ {
+			instances = append(instances, tfInstance{
+				suffix: fmt.Sprintf("[%d]", i),
+				index:  i,
+				ctx:    tfChildContext(ctx, "count", cty.ObjectVal(map[string]cty.Value{"index": cty.NumberIntVal(i)})),
+			})
+		}
+		return instances
+	}
+
+	if attr, ok := body.Attributes["for_each"]; ok {
+		val, ok := tfEvaluate(ctx, attr.Expr)
+		if !ok || val.IsNull() || !val.CanIterateElements() {
+			return []tfInstance{{suffix: "[*]", index: "*", ctx: tfChildContext(ctx, "each", cty.ObjectVal(map[string]cty.Value{"key": cty.UnknownVal(cty.String), "value": cty.DynamicVal}))}}
+		}
+		instances := []tfInstance{}
+		for it := val.ElementIterator(); it.Next(); {
+			k, v := it.Element()
+			// Sets are keyed by their values
+			if val.Type().IsSetType() {
+				k = v
+			}
+			key, err := convert.Convert(k, cty.String)
+			if err != nil || !key.IsKnown() {
+				continue
+			}
+			instances = append(instances, tfInstance{
+				suffix: fmt.Sprintf("[%q]", key.AsString()),
+				index:  key.AsString(),
+				ctx:    tfChildContext(ctx, "each", cty.ObjectVal(map[string]cty.Value{"key": key, "value": v})),
+			})
+		}
+		return instances
+	}
+
+	return []tfInstance{{ctx: ctx}}
+}
+
+func tfChildContext(ctx *hcl.EvalContext, name string, val cty.Value) *hcl.EvalContext {
+	child := ctx.NewChild()
+	child.Variables = map[string]cty.Value{name: val}
+	return child
+}
+
+// bodyValue converts a block body into plain values. Nested blocks become lists of objects under their type and
+// dynamic blocks are expanded when their for_each is known. Attributes which refer to sensitive variables are
+// reported in the returned sensitive map, mirroring the structure terraform uses in plans.
+func (tr *tfStaticRenderer) bodyValue(mod *tfModule, ctx *hcl.EvalContext, body *hclsyntax.Body, sensitiveVars map[string]bool) (map[string]interface{}, map[string]interface{}) {
+	values := map[string]interface{}{}
+	sensitive := map[string]interface{}{}
+	for name, attr := range body.Attributes {
+		if tfMetaArguments[name] {
+			continue
+		}
+		values[name] = tfExpressionValue(mod, ctx, attr.Expr)
+		for _, traversal := range attr.Expr.Variables() {
+			if traversal.RootName() != "var" || len(traversal) < 2 {
+				continue
+			}
+			if ta, ok := traversal[1].(hcl.TraverseAttr); ok && sensitiveVars[ta.Name] {
+				sensitive[name] = true
+			}
+		}
+	}
+
+	for _, block := range body.Blocks {
+		blockType := block.Type
+		contents := []*hclsyntax.Body{block.Body}
+		contexts := []*hcl.EvalContext{ctx}
+		if block.Type == "dynamic" && len(block.Labels) > 0 {
+			blockType = block.Labels[0]
+			contents, contexts = tfExpandDynamic(ctx, block)
+			if contents == nil {
+				values[blockType] = tfSourceText(mod, block)
+				continue
+			}
+		}
+		list, _ := values[blockType].([]interface{})
+		sensitiveList, _ := sensitive[blockType].([]interface{})
+		for i, content := range contents {
+			v, s := tr.bodyValue(mod, contexts[i], content, sensitiveVars)
+			list = append(list, v)
+			sensitiveList = append(sensitiveList, s)
+		}
+		values[blockType] = list
+		for _, s := range sensitiveList {
+			if len(s.(map[string]interface{})) > 0 {
+				sensitive[blockType] = sensitiveList
+				break
+			}
+		}
+	}
+
+	return values, sensitive
+}
+
+// tfExpandDynamic returns the content of a dynamic block once per element of its for_each, or nil if for_each isn't known
+func tfExpandDynamic(ctx *hcl.EvalContext, block *hclsyntax.Block) ([]*hclsyntax.Body, []*hcl.EvalContext) {
+	var content *hclsyntax.Body
+	for _, inner := range block.Body.Blocks {
+		if inner.Type == "content" {
+			content = inner.Body
+		}
+	}
+	attr, ok := block.Body.Attributes["for_each"]
+	if content == nil || !ok {
+		return nil, nil
+	}
+	val, ok := tfEvaluate(ctx, attr.Expr)
+	if !ok || val.IsNull() || !val.CanIterateElements() {
+		return nil, nil
+	}
+
+	iterator := block.Labels[0]
+	if attr, ok := block.Body.Attributes["iterator"]; ok {
+		if name := hcl.ExprAsKeyword(attr.Expr); name != "" {
+			iterator = name
+		}
+	}
+
+	bodies := []*hclsyntax.Body{}
+	contexts := []*hcl.EvalContext{}
+	for it := val.ElementIterator(); it.Next(); {
+		k, v := it.Element()
+		bodies = append(bodies, content)
+		contexts = append(contexts, tfChildContext(ctx, iterator, cty.ObjectVal(map[string]cty.Value{"key": k, "value": v})))
+	}
+	return bodies, contexts
+}
+
+// tfEvaluate evaluates expr, treating references to anything the static renderer can't know (resources, data
+// sources, module outputs) as unknown. ok is only true if the result is wholly known.
+func tfEvaluate(ctx *hcl.EvalContext, expr hcl.Expression) (cty.Value, bool) {
+	unknown := map[string]cty.Value{}
+	for _, traversal := range expr.Variables() {
+		root := traversal.RootName()
+		if !tfContextHas(ctx, root) {
+			unknown[root] = cty.DynamicVal
+		}
+	}
+	if len(unknown) > 0 {
+		child := ctx.NewChild()
+		child.Variables = unknown
+		ctx = child
+	}
+
+	val, diags := expr.Value(ctx)
+	if diags.HasErrors() || !val.IsWhollyKnown() {
+		return val, false
+	}
+	return val, true
+}
+
+func tfContextHas(ctx *hcl.EvalContext, name string) bool {
+	for c := ctx; c != nil; c = c.Parent() {
+		if _, ok := c.Variables[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tfExpressionValue is the value of expr if it's known, otherwise its source as an interpolation
+func tfExpressionValue(mod *tfModule, ctx *hcl.EvalContext, expr hcl.Expression) interface{} {
+	val, ok := tfEvaluate(ctx, expr)
+	if !ok {
+		return "${" + tfSourceText(mod, expr) + "}"
+	}
+	b, err := ctyjson.Marshal(val, val.Type())
+	if err != nil {
+		return "${" + tfSourceText(mod, expr) + "}"
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return "${" + tfSourceText(mod, expr) + "}"
+	}
+	return v
+}
+
+type tfRanged interface {
+	Range() hcl.Range
+}
+
+func tfSourceText(mod *tfModule, node tfRanged) string {
+	rng := node.Range()
+	src, ok := mod.Sources[rng.Filename]
+	if !ok || rng.End.Byte > len(src) || rng.Start.Byte > rng.End.Byte {
+		return ""
+	}
+	return strings.TrimSpace(string(src[rng.Start.Byte:rng.End.Byte]))
+}
+
+func tfModulePrefix(address string) string {
+	if address == "" {
+		return ""
+	}
+	return address + "."
+}
+
+// tfProviderAddress returns the full provider address, as it appears in plans, for a provider's local name
+func tfProviderAddress(mod *tfModule, name string) string {
+	source, ok := mod.Providers[name]
+	if !ok {
+		source = "hashicorp/" + name
+	}
+	if strings.Count(source, "/") == 1 {
+		source = "registry.terraform.io/" + source
+	}
+	return source
+}
+
+// tfLoadVars collects variable values in the order terraform applies them, later sources taking precedence
+func tfLoadVars(dir string, epctx map[string]interface{}) (map[string]cty.Value, error) {
+	// Var files are loaded in the same order as terraform, each overriding the last
+	files := []string{}
+	for _, name := range []string{"terraform.tfvars", "terraform.tfvars.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	auto := []string{}
+	for _, pattern := range []string{"*.auto.tfvars", "*.auto.tfvars.json"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		auto = append(auto, matches...)
+	}
+	sort.Strings(auto)
+	files = append(files, auto...)
+	for _, f := range contextStringList(epctx[TerraformContextVarFiles]) {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(dir, f)
+		}
+		files = append(files, f)
+	}
+
+	vars := map[string]cty.Value{}
+	parser := hclparse.NewParser()
+	for _, f := range files {
+		var file *hcl.File
+		var diags hcl.Diagnostics
+		if strings.HasSuffix(f, ".json") {
+			file, diags = parser.ParseJSONFile(f)
+		} else {
+			file, diags = parser.ParseHCLFile(f)
+		}
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("unable to parse var file %q - %w", f, diags)
+		}
+		attrs, diags := file.Body.JustAttributes()
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("unable to read var file %q - %w", f, diags)
+		}
+		for name, attr := range attrs {
+			val, diags := attr.Expr.Value(nil)
+			if diags.HasErrors() {
+				return nil, fmt.Errorf("unable to evaluate %q in var file %q - %w", name, f, diags)
+			}
+			vars[name] = val
+		}
+	}
+
+	if ctxVars, ok := epctx[TerraformContextVars].(map[string]interface{}); ok {
+		for name, v := range ctxVars {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read variable %q - %w", name, err)
+			}
+			ty, err := ctyjson.ImpliedType(b)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read variable %q - %w", name, err)
+			}
+			val, err := ctyjson.Unmarshal(b, ty)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read variable %q - %w", name, err)
+			}
+			vars[name] = val
+		}
+	}
+
+	return vars, nil
+}
+
+// tfFunctions are the terraform functions which can be evaluated without touching the filesystem or network
+func tfFunctions() map[string]function.Function {
+	return map[string]function.Function{
+		"abs":             stdlib.AbsoluteFunc,
+		"ceil":            stdlib.CeilFunc,
+		"chomp":           stdlib.ChompFunc,
+		"chunklist":       stdlib.ChunklistFunc,
+		"coalesce":        stdlib.CoalesceFunc,
+		"coalescelist":    stdlib.CoalesceListFunc,
+		"compact":         stdlib.CompactFunc,
+		"concat":          stdlib.ConcatFunc,
+		"contains":        stdlib.ContainsFunc,
+		"csvdecode":       stdlib.CSVDecodeFunc,
+		"distinct":        stdlib.DistinctFunc,
+		"element":         stdlib.ElementFunc,
+		"flatten":         stdlib.FlattenFunc,
+		"floor":           stdlib.FloorFunc,
+		"format":          stdlib.FormatFunc,
+		"formatdate":      stdlib.FormatDateFunc,
+		"formatlist":      stdlib.FormatListFunc,
+		"indent":          stdlib.IndentFunc,
+		"index":           stdlib.IndexFunc,
+		"join":            stdlib.JoinFunc,
+		"jsondecode":      stdlib.JSONDecodeFunc,
+		"jsonencode":      stdlib.JSONEncodeFunc,
+		"keys":            stdlib.KeysFunc,
+		"length":          stdlib.LengthFunc,
+		"log":             stdlib.LogFunc,
+		"lookup":          stdlib.LookupFunc,
+		"lower":           stdlib.LowerFunc,
+		"max":             stdlib.MaxFunc,
+		"merge":           stdlib.MergeFunc,
+		"min":             stdlib.MinFunc,
+		"parseint":        stdlib.ParseIntFunc,
+		"pow":             stdlib.PowFunc,
+		"range":           stdlib.RangeFunc,
+		"regex":           stdlib.RegexFunc,
+		"regexall":        stdlib.RegexAllFunc,
+		"replace":         stdlib.ReplaceFunc,
+		"reverse":         stdlib.ReverseListFunc,
+		"setintersection": stdlib.SetIntersectionFunc,
+		"setproduct":      stdlib.SetProductFunc,
+		"setsubtract":     stdlib.SetSubtractFunc,
+		"setunion":        stdlib.SetUnionFunc,
+		"signum":          stdlib.SignumFunc,
+		"slice":           stdlib.SliceFunc,
+		"sort":            stdlib.SortFunc,
+		"split":           stdlib.SplitFunc,
+		"strrev":          stdlib.ReverseFunc,
+		"substr":          stdlib.SubstrFunc,
+		"timeadd":         stdlib.TimeAddFunc,
+		"title":           stdlib.TitleFunc,
+		"trim":            stdlib.TrimFunc,
+		"trimprefix":      stdlib.TrimPrefixFunc,
+		"trimspace":       stdlib.TrimSpaceFunc,
+		"trimsuffix":      stdlib.TrimSuffixFunc,
+		"upper":           stdlib.UpperFunc,
+		"values":          stdlib.ValuesFunc,
+		"zipmap":          stdlib.ZipmapFunc,
+		"tobool":          tfConvertFunc(cty.Bool),
+		"tolist":          tfConvertFunc(cty.List(cty.DynamicPseudoType)),
+		"tomap":           tfConvertFunc(cty.Map(cty.DynamicPseudoType)),
+		"tonumber":        tfConvertFunc(cty.Number),
+		"toset":           tfConvertFunc(cty.Set(cty.DynamicPseudoType)),
+		"tostring":        tfConvertFunc(cty.String),
+	}
+}
+
+func tfConvertFunc(want cty.Type) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{{
+			Name:             "v",
+			Type:             cty.DynamicPseudoType,
+			AllowNull:        true,
+			AllowDynamicType: true,
+		}},
+		Type: func(args []cty.Value) (cty.Type, error) {
+			if want.HasDynamicTypes() {
+				val, err := convert.Convert(args[0], want)
+				if err != nil {
+					return cty.NilType, err
+				}
+				return val.Type(), nil
+			}
+			return want, nil
+		},
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return convert.Convert(args[0], retType)
+		},
+	})
+}
+
+func (td *tfDiffer) diffStatic(ctx context.Context, rs *git.RepoSpec, ep entrypoint.Entrypoint, oldDir, newDir string) ([]ResourceDiff, []Resource, []Resource, error) {
+	return diffConcurrent(ctx, ep, oldDir, newDir, func(dir string, ep entrypoint.Entrypoint) (map[string]*TerraformResource, error) {
+		return RenderTerraformStatic(dir, ep.Context)
+	}, doTfStaticDiff)
+}
+
+func doTfStaticDiff(old, new map[string]*TerraformResource) ([]ResourceDiff, []Resource, []Resource, error) {
+	addresses := []string{}
+	for addr := range old {
+		addresses = append(addresses, addr)
+	}
+	for addr := range new {
+		if _, ok := old[addr]; !ok {
+			addresses = append(addresses, addr)
+		}
+	}
+	sort.Strings(addresses)
+
+	diff := []ResourceDiff{}
+	allOld := []Resource{}
+	allNew := []Resource{}
+	for _, addr := range addresses {
+		pre, hasOld := old[addr]
+		post, hasNew := new[addr]
+
+		change := &tfjson.Change{}
+		var rc tfjson.ResourceChange
+		if hasOld {
+			allOld = append(allOld, pre)
+			rc = *pre.Change
+			change.Before = pre.Resource
+			change.BeforeSensitive = pre.Sensitive
+		}
+		if hasNew {
+			allNew = append(allNew, post)
+			rc = *post.Change
+			change.After = post.Resource
+			change.AfterSensitive = post.Sensitive
+		}
+
+		changelog, err := r3diff.Diff(change.Before, change.After)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to diff terraform resource %q - %w", addr, err)
+		}
+
+		rd := ResourceDiff{Diff: changelog}
+		switch {
+		case !hasOld:
+			rd.Type = DiffTypeCreate
+			change.Actions = tfjson.Actions{tfjson.ActionCreate}
+		case !hasNew:
+			rd.Type = DiffTypeDelete
+			change.Actions = tfjson.Actions{tfjson.ActionDelete}
+		case len(changelog) > 0:
+			rd.Type = DiffTypeUpdate
+			change.Actions = tfjson.Actions{tfjson.ActionUpdate}
+		default:
+			continue
+		}
+
+		rc.Change = change
+		if hasOld {
+			rd.Pre = &TerraformResource{Resource: pre.Resource, Sensitive: pre.Sensitive, Change: &rc}
+		}
+		if hasNew {
+			rd.Post = &TerraformResource{Resource: post.Resource, Sensitive: post.Sensitive, Change: &rc}
+		}
+		diff = append(diff, rd)
+	}
+
+	return diff, allOld, allNew, nil
+}
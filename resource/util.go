@@ -1,43 +1,71 @@
 package resource
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/codingninja/gitops-repo-api/entrypoint"
 )
 
 type ResourceExtractor[T any] func(dir string, ep entrypoint.Entrypoint) (T, error)
 
-func extractConcurrent[T any](ep entrypoint.Entrypoint, preDir string, postDir string, extract ResourceExtractor[T]) (T, T, error) {
+// diffConcurrent renders both revisions of an entrypoint with extractConcurrent and diffs them. Nothing is diffed
+// when either revision fails to render, as every resource of the other would be reported as added or removed. A
+// revision is only diffed against nothing when the entrypoint doesn't exist in it.
+func diffConcurrent[T any](ctx context.Context, ep entrypoint.Entrypoint, preDir, postDir string, extract ResourceExtractor[T], diff func(old, new T) ([]ResourceDiff, []Resource, []Resource, error)) ([]ResourceDiff, []Resource, []Resource, error) {
+	old, new, err := extractConcurrent(ctx, ep, preDir, postDir, extract)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return diff(old, new)
+}
+
+// extractConcurrent renders the pre and post entrypoints, reading them from the render cache configured by
+// WithRenderCache where possible. When the cache is enabled and both revisions have the same inputs only one is
+// rendered, and the other is a copy of it, so the entrypoint diffs as unchanged. A revision which fails to render is returned as an
+// EntrypointError and recorded by WithRenderErrors, and neither revision is returned.
+func extractConcurrent[T any](ctx context.Context, ep entrypoint.Entrypoint, preDir string, postDir string, extract ResourceExtractor[T]) (T, T, error) {
+	preKey := renderInputKey[T](ctx, ep, preDir)
+	postKey := renderInputKey[T](ctx, ep, postDir)
+	if preKey != "" && preKey == postKey {
+		if pre, post, ok := extractIdentical(ctx, preKey, ep, postDir, extract); ok {
+			return pre, post, nil
+		}
+	}
 
 	ewg := sync.WaitGroup{}
 	ewg.Add(1)
 	var preResources T
 	var postResources T
-	var buildErrs error
+	// Each revision reports its own error, so they're joined in the same order however the renders finish
+	var preErr, postErr *EntrypointError
 	go func() {
 		defer ewg.Done()
 		if preDir != "" {
-
-			pr, err := extract(preDir, ep)
+			pr, err := extractCached(ctx, preKey, preDir, ep, extract)
 			if err != nil {
-				buildErrs = errors.Join(buildErrs, fmt.Errorf("unable to build pre-entrypoint %q - %w", preDir, err))
+				preErr = revisionError(ctx, RevisionPre, fmt.Errorf("unable to build pre-entrypoint %q - %w", preDir, err))
 				return
 			}
 			preResources = pr
 		}
-
 	}()
 
 	ewg.Add(1)
 	go func() {
 		defer ewg.Done()
 		if postDir != "" {
-			pr, err := extract(postDir, ep)
+			pr, err := extractCached(ctx, postKey, postDir, ep, extract)
 			if err != nil {
-				buildErrs = errors.Join(buildErrs, fmt.Errorf("unable to build post-entrypoint %q - %w", postDir, err))
+				postErr = revisionError(ctx, RevisionPost, fmt.Errorf("unable to build post-entrypoint %q - %w", postDir, err))
 				return
 			}
 			postResources = pr
@@ -46,5 +74,179 @@ func extractConcurrent[T any](ep entrypoint.Entrypoint, preDir string, postDir s
 
 	ewg.Wait()
 
-	return preResources, postResources, buildErrs
+	if err := joinRevisionErrors(ctx, preErr, postErr); err != nil {
+		var zero T
+		return zero, zero, err
+	}
+	return preResources, postResources, nil
+}
+
+// extractIdentical renders the post entrypoint once for both revisions, ok is false if it couldn't be copied or
+// failed to render so that each revision is rendered and reported on separately
+func extractIdentical[T any](ctx context.Context, key string, ep entrypoint.Entrypoint, dir string, extract ResourceExtractor[T]) (T, T, bool) {
+	var zero T
+	post, err := extractCached(ctx, key, dir, ep, extract)
+	if err != nil {
+		return zero, zero, false
+	}
+	b, err := encodeRender(post)
+	if err != nil {
+		return zero, zero, false
+	}
+	pre, err := decodeRender[T](b)
+	if err != nil {
+		return zero, zero, false
+	}
+	return pre, post, true
+}
+
+// renderWaitDelay is how long a cancelled render command may take to exit, after which its output is abandoned
+// even if processes it started are still holding it open
+const renderWaitDelay = time.Second
+
+// renderCommand is exec.CommandContext for the tools entrypoints are rendered with
+func renderCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = renderWaitDelay
+	return cmd
+}
+
+// renderWorkingCopy makes a temporary directory for tools which write to the directory they render, so the checkout
+// is never modified and renders of the same directory can't interfere with each other. The files directly in dir
+// are copied, along with its directories when deep is set, while everything else in the repository is linked so
+// relative paths out of dir still resolve. Entries of dir named in exclude are left out. The returned function
+// removes the copy.
+func renderWorkingCopy(dir string, deep bool, exclude ...string) (string, func(), error) {
+	dir, err := resolveRenderDir(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to resolve render directory - %w", err)
+	}
+	root := renderRepositoryRoot(dir)
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "gitops-repo-api-render-")
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create working copy - %w", err)
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+
+	src, dst := root, tmp
+	if rel != "." {
+		for _, el := range strings.Split(rel, string(filepath.Separator)) {
+			entries, err := os.ReadDir(src)
+			if err != nil {
+				cleanup()
+				return "", nil, err
+			}
+			for _, entry := range entries {
+				if entry.Name() == el {
+					continue
+				}
+				if err := os.Symlink(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+					cleanup()
+					return "", nil, fmt.Errorf("unable to link %q into working copy - %w", entry.Name(), err)
+				}
+			}
+			src, dst = filepath.Join(src, el), filepath.Join(dst, el)
+			if err := os.Mkdir(dst, 0o755); err != nil {
+				cleanup()
+				return "", nil, err
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	excluded := map[string]bool{}
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+	for _, entry := range entries {
+		if excluded[entry.Name()] {
+			continue
+		}
+		from, to := filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())
+		switch {
+		case entry.Type().IsRegular():
+			err = copyFile(from, to)
+		case deep && entry.IsDir():
+			err = copyTree(from, to)
+		default:
+			err = os.Symlink(from, to)
+		}
+		if err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("unable to add %q to working copy - %w", entry.Name(), err)
+		}
+	}
+
+	return dst, cleanup, nil
+}
+
+// renderRepositoryRoot is the root of the checkout dir is in, or dir itself when it isn't in one
+func renderRepositoryRoot(dir string) string {
+	for p := dir; ; {
+		if _, err := os.Stat(filepath.Join(p, ".git")); err == nil {
+			return p
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return dir
+		}
+		p = parent
+	}
+}
+
+// copyTree copies the directory from to to, symlinks are copied as they are
+func copyTree(from, to string) error {
+	return filepath.WalkDir(from, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(p, target)
+		}
+		return nil
+	})
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
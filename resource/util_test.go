@@ -0,0 +1,163 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codingninja/gitops-repo-api/entrypoint"
+)
+
+func TestDiffConcurrent(t *testing.T) {
+	// The extractor renders the name of the directory, or fails for a directory named "broken"
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{"a/file": "", "b/file": "", "broken/file": ""})
+	extract := func(dir string, ep entrypoint.Entrypoint) (string, error) {
+		if filepath.Base(dir) == "broken" {
+			return "", errors.New("unable to render")
+		}
+		return filepath.Base(dir), nil
+	}
+	revisionDir := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return filepath.Join(root, name)
+	}
+
+	tests := []struct {
+		name     string
+		preDir   string
+		postDir  string
+		diffed   bool
+		old, new string
+		failed   []Revision
+	}{
+		{name: "both revisions render", preDir: "a", postDir: "b", diffed: true, old: "a", new: "b"},
+		{name: "added entrypoint", preDir: "", postDir: "b", diffed: true, old: "", new: "b"},
+		{name: "removed entrypoint", preDir: "a", postDir: "", diffed: true, old: "a", new: ""},
+		{name: "pre fails", preDir: "broken", postDir: "b", failed: []Revision{RevisionPre}},
+		{name: "post fails", preDir: "a", postDir: "broken", failed: []Revision{RevisionPost}},
+		{name: "both fail", preDir: "broken", postDir: "broken", failed: []Revision{RevisionPre, RevisionPost}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffed := false
+			var old, new string
+			_, _, _, err := diffConcurrent(context.Background(), entrypoint.Entrypoint{Name: "test"}, revisionDir(tt.preDir), revisionDir(tt.postDir), extract, func(o, n string) ([]ResourceDiff, []Resource, []Resource, error) {
+				diffed, old, new = true, o, n
+				return nil, nil, nil, nil
+			})
+
+			if diffed != tt.diffed {
+				t.Fatalf("diffed is %t, expected %t", diffed, tt.diffed)
+			}
+			if old != tt.old || new != tt.new {
+				t.Errorf("diffed %q against %q, expected %q against %q", old, new, tt.old, tt.new)
+			}
+			failed := []Revision{}
+			for _, epErr := range AsEntrypointErrors(err, ErrorKindDiff) {
+				failed = append(failed, epErr.Revision)
+			}
+			if len(failed) != len(tt.failed) {
+				t.Fatalf("got failed revisions %v, expected %v", failed, tt.failed)
+			}
+			for i := range failed {
+				if failed[i] != tt.failed[i] {
+					t.Errorf("got failed revisions %v, expected %v", failed, tt.failed)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderWorkingCopy(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     string
+		deep    bool
+		exclude []string
+		// copied are files which must be copies, linked are paths which must be links to the checkout
+		copied []string
+		linked []string
+		absent []string
+	}{
+		{
+			name:   "files are copied and directories linked",
+			dir:    "envs/prod",
+			copied: []string{"envs/prod/main.tf"},
+			linked: []string{"envs/prod/files", "envs/dev", "modules"},
+		},
+		{
+			name:   "deep copies directories",
+			dir:    "envs/prod",
+			deep:   true,
+			copied: []string{"envs/prod/main.tf", "envs/prod/files/data.txt"},
+			linked: []string{"envs/dev", "modules"},
+		},
+		{
+			name:    "excluded entries",
+			dir:     "envs/prod",
+			exclude: []string{".terraform", "override.tf"},
+			copied:  []string{"envs/prod/main.tf"},
+			absent:  []string{"envs/prod/.terraform", "envs/prod/override.tf"},
+		},
+	}
+
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		".git/HEAD":                 "ref: refs/heads/main\n",
+		"envs/prod/main.tf":         "module \"app\" {\n  source = \"../../modules/app\"\n}\n",
+		"envs/prod/override.tf":     "",
+		"envs/prod/.terraform/lock": "",
+		"envs/prod/files/data.txt":  "data",
+		"envs/dev/main.tf":          "",
+		"modules/app/main.tf":       "",
+	})
+	before := hashTree(t, root)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copyDir, cleanup, err := renderWorkingCopy(filepath.Join(root, tt.dir), tt.deep, tt.exclude...)
+			if err != nil {
+				t.Fatalf("unable to make working copy - %s", err)
+			}
+			copyRoot := strings.TrimSuffix(copyDir, tt.dir)
+
+			for _, p := range tt.copied {
+				info, err := os.Lstat(filepath.Join(copyRoot, p))
+				if err != nil || !info.Mode().IsRegular() {
+					t.Errorf("%s isn't a copy - %v", p, err)
+				}
+			}
+			for _, p := range tt.linked {
+				info, err := os.Lstat(filepath.Join(copyRoot, p))
+				if err != nil || info.Mode()&os.ModeSymlink == 0 {
+					t.Errorf("%s isn't a link - %v", p, err)
+				}
+			}
+			for _, p := range tt.absent {
+				if _, err := os.Lstat(filepath.Join(copyRoot, p)); err == nil {
+					t.Errorf("%s was copied", p)
+				}
+			}
+			// Relative paths out of the directory still resolve
+			if _, err := os.Stat(filepath.Join(copyDir, "../../modules/app/main.tf")); err != nil {
+				t.Errorf("relative path out of the working copy doesn't resolve - %s", err)
+			}
+
+			if err := os.WriteFile(filepath.Join(copyDir, "main.tf"), []byte("changed"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cleanup()
+			if _, err := os.Stat(copyDir); err == nil {
+				t.Errorf("working copy wasn't removed")
+			}
+			assertTreeUnchanged(t, before, hashTree(t, root))
+		})
+	}
+}
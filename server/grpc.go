@@ -47,12 +47,9 @@ type diffApiServer struct {
 // 	if err != nil {
 // 		fmt.Printf("Got errors diffing resources:\n\n%s\n", err.Error())
 // 	}
-// 	result := api.DiffResponse(api.DiffResponse{
-// 		Diffs: []*api.DiffResponse_Diff{
-// 			&api.DiffResponse_Diff{
-// 				Entrypoint: &api.Entrypoint{},
-// 			},
-// 		},
-// 	})
+// 	result := api.DiffResponse{}
+// 	for _, epDiff := range diff {
+// 		result.Diffs = append(result.Diffs, epDiff.Proto())
+// 	}
 // 	return &result, nil
 // }
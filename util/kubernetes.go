@@ -1,6 +1,10 @@
 package util
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -8,23 +12,68 @@ import (
 )
 
 type basicKubeResource struct {
-	APIVersion string `json:"apiVersion" yaml:"apiVersion"`
-	Kind       string `json:"kind" yaml:"kind"`
+	APIVersion string        `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string        `json:"kind" yaml:"kind"`
+	Items      []interface{} `json:"items" yaml:"items"`
 }
 
+// KubeFileExtensions are the file extensions which may hold kubernetes manifests
+var KubeFileExtensions = []string{".yaml", ".yml", ".json"}
+
 func IsValidKubeFile(file string) bool {
-	if strings.HasSuffix(file, ".yaml") || strings.HasSuffix(file, ".yml") {
-		content, err := os.ReadFile(file)
-		if err != nil {
-			return false
+	ok, _ := CheckKubeFile(file)
+	return ok
+}
+
+// CheckKubeFile reports whether file holds at least one kubernetes object, and if not the reason why.
+// Every document in a multi document file is inspected and List kinds count when they have items.
+func CheckKubeFile(file string) (bool, string) {
+	hasExt := false
+	for _, ext := range KubeFileExtensions {
+		if strings.HasSuffix(file, ext) {
+			hasExt = true
 		}
+	}
+	if !hasExt {
+		return false, fmt.Sprintf("file extension is not one of %s", strings.Join(KubeFileExtensions, ", "))
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return false, fmt.Sprintf("unable to read file - %s", err)
+	}
+
+	// JSON is valid YAML so a single decoder handles both
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	docs := 0
+	reason := "no documents found"
+	for {
 		kr := &basicKubeResource{}
-		if err := yaml.Unmarshal(content, kr); err != nil {
-			return false
+		if err := dec.Decode(kr); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return false, fmt.Sprintf("unable to parse document %d - %s", docs+1, err)
 		}
-		if kr.APIVersion != "" && kr.Kind != "" {
-			return true
+		docs++
+		switch {
+		case kr.APIVersion == "" && kr.Kind == "":
+			reason = "no document has an apiVersion and kind"
+		case kr.APIVersion == "" || kr.Kind == "":
+			reason = fmt.Sprintf("document %d is missing an apiVersion or kind", docs)
+		case IsKustomizationKind(kr.APIVersion, kr.Kind):
+			reason = "file is a kustomization"
+		case strings.HasSuffix(kr.Kind, "List") && len(kr.Items) == 0:
+			reason = fmt.Sprintf("document %d is an empty %s", docs, kr.Kind)
+		default:
+			return true, ""
 		}
 	}
-	return false
+
+	return false, reason
+}
+
+// IsKustomizationKind reports whether the apiVersion and kind belong to a kustomization rather than a kubernetes object
+func IsKustomizationKind(apiVersion, kind string) bool {
+	return strings.HasPrefix(apiVersion, "kustomize.config.k8s.io/") && (kind == "Kustomization" || kind == "Component")
 }
@@ -0,0 +1,41 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckKubeFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    bool
+		reason  string
+	}{
+		{name: "single object", file: "cm.yaml", content: "apiVersion: v1\nkind: ConfigMap\n", want: true},
+		{name: "json object", file: "cm.json", content: `{"apiVersion": "v1", "kind": "ConfigMap"}`, want: true},
+		{name: "object after values", file: "mixed.yml", content: "replicas: 2\n---\napiVersion: v1\nkind: ConfigMap\n", want: true},
+		{name: "list with items", file: "list.yaml", content: "apiVersion: v1\nkind: List\nitems:\n  - apiVersion: v1\n    kind: ConfigMap\n", want: true},
+		{name: "empty list", file: "list.yaml", content: "apiVersion: v1\nkind: ConfigMapList\nitems: []\n", reason: "document 1 is an empty ConfigMapList"},
+		{name: "kustomization", file: "kustomization.yaml", content: "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\n", reason: "file is a kustomization"},
+		{name: "missing kind", file: "cm.yaml", content: "apiVersion: v1\n", reason: "document 1 is missing an apiVersion or kind"},
+		{name: "plain values", file: "values.yaml", content: "replicas: 2\n", reason: "no document has an apiVersion and kind"},
+		{name: "empty file", file: "empty.yaml", content: "", reason: "no documents found"},
+		{name: "other extension", file: "README.md", content: "apiVersion: v1\nkind: ConfigMap\n", reason: "file extension is not one of .yaml, .yml, .json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(file, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			ok, reason := CheckKubeFile(file)
+			if ok != tt.want || reason != tt.reason {
+				t.Errorf("got %t %q, expected %t %q", ok, reason, tt.want, tt.reason)
+			}
+		})
+	}
+}